@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/janyksteenbeek/updati/internal/audit"
+	"github.com/janyksteenbeek/updati/internal/cleanup"
+	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/logging"
+	"github.com/urfave/cli/v2"
+)
+
+func cleanupCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "cleanup",
+		Usage:  "Delete update branches whose pull request was merged (use --dry-run to only list them)",
+		Action: runCleanup,
+	}
+}
+
+func runCleanup(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	slog.SetDefault(logger)
+
+	opts := github.Options{
+		Retry:    cfg.Retry,
+		CacheDir: cfg.CacheDir,
+		Audit:    audit.New(cfg.AuditLog),
+		Tokens:   cfg.GitHubTokens,
+	}
+	client := github.NewClient(cfg.GitHubToken, cfg.Owner, opts)
+	reader := client
+	if cfg.ReadToken != "" {
+		opts.Tokens = nil // The read token doesn't rotate with the others
+		reader = github.NewClient(cfg.ReadToken, cfg.Owner, opts)
+	}
+
+	branches, err := cleanup.Run(c.Context, cfg, client, reader, logger)
+	if err != nil && len(branches) == 0 {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("🧹 Cleanup")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	deleted := 0
+	for _, branch := range branches {
+		switch {
+		case branch.Deleted:
+			deleted++
+			fmt.Printf("   🗑  %s: %s (#%d merged)\n", branch.Repository, branch.Name, branch.PRNumber)
+		case cfg.DryRun.Enabled():
+			fmt.Printf("   -  %s: %s (#%d merged, would delete)\n", branch.Repository, branch.Name, branch.PRNumber)
+		default:
+			fmt.Printf("   ❌ %s: %s (#%d merged, could not delete)\n", branch.Repository, branch.Name, branch.PRNumber)
+		}
+	}
+	if len(branches) == 0 {
+		fmt.Println("   No merged update branches found.")
+	}
+	fmt.Println()
+
+	if err != nil {
+		return err
+	}
+	if !cfg.DryRun.Enabled() && deleted < len(branches) {
+		return fmt.Errorf("%d branches could not be deleted", len(branches)-deleted)
+	}
+
+	return nil
+}
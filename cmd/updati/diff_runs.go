@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/janyksteenbeek/updati/internal/report"
+	"github.com/urfave/cli/v2"
+)
+
+func diffRunsCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "diff-runs",
+		Usage:     "Compare the reports of two runs",
+		ArgsUsage: "<run-a> <run-b>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "fail-on-regression",
+				Usage: "Exit with an error if repositories started failing in the second run",
+			},
+		},
+		Action: diffRuns,
+	}
+}
+
+func diffRuns(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("expected two report files, got %d", c.NArg())
+	}
+
+	before, err := report.Load(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	after, err := report.Load(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	cmp := report.Compare(before, after)
+	printComparison(cmp)
+
+	if c.Bool("fail-on-regression") && cmp.HasRegressions() {
+		return fmt.Errorf("%d repositories started failing", len(cmp.NewlyFailing))
+	}
+
+	return nil
+}
+
+func printComparison(cmp *report.Comparison) {
+	fmt.Printf("🔍 Comparing run %s%s with run %s%s\n", cmp.Before.ID, formatTags(cmp.Before.Tags), cmp.After.ID, formatTags(cmp.After.Tags))
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("   %-12s %8s %8s\n", "", "Before", "After")
+	fmt.Printf("   %-12s %8d %8d\n", "Total", cmp.Before.Total, cmp.After.Total)
+	fmt.Printf("   %-12s %8d %8d\n", "Updated", cmp.Before.Updated, cmp.After.Updated)
+	fmt.Printf("   %-12s %8d %8d\n", "Skipped", cmp.Before.Skipped, cmp.After.Skipped)
+	fmt.Printf("   %-12s %8d %8d\n", "Failed", cmp.Before.Failed, cmp.After.Failed)
+	fmt.Println()
+
+	if len(cmp.NewlyFailing) > 0 {
+		fmt.Println("❌ Newly failing:")
+		for _, ch := range cmp.NewlyFailing {
+			fmt.Printf("   - %s: %s\n", ch.Repository, ch.After.Error)
+		}
+		fmt.Println()
+	}
+
+	if len(cmp.Fixed) > 0 {
+		fmt.Println("✅ No longer failing:")
+		for _, ch := range cmp.Fixed {
+			fmt.Printf("   - %s (now %s)\n", ch.Repository, ch.After.Status)
+		}
+		fmt.Println()
+	}
+
+	if len(cmp.NewlyUpdated) > 0 {
+		fmt.Println("⬆️  Newly updated:")
+		for _, ch := range cmp.NewlyUpdated {
+			fmt.Printf("   - %s\n", ch.Repository)
+		}
+		fmt.Println()
+	}
+
+	if len(cmp.Added) > 0 {
+		fmt.Println("➕ Only in second run:")
+		for _, ch := range cmp.Added {
+			fmt.Printf("   - %s (%s)\n", ch.Repository, ch.After.Status)
+		}
+		fmt.Println()
+	}
+
+	if len(cmp.Removed) > 0 {
+		fmt.Println("➖ Only in first run:")
+		for _, ch := range cmp.Removed {
+			fmt.Printf("   - %s (%s)\n", ch.Repository, ch.Before.Status)
+		}
+		fmt.Println()
+	}
+
+	if len(cmp.Toolchain) > 0 {
+		fmt.Println("🧰 Toolchain changes:")
+		for _, tc := range cmp.Toolchain {
+			fmt.Printf("   - %s: %s → %s\n", tc.Name, versionOrNone(tc.Before), versionOrNone(tc.After))
+		}
+		fmt.Println()
+	}
+
+	if len(cmp.NewlyFailing)+len(cmp.Fixed)+len(cmp.NewlyUpdated)+len(cmp.Added)+len(cmp.Removed)+len(cmp.Toolchain) == 0 {
+		fmt.Println("No differences between the runs.")
+	}
+}
+
+func versionOrNone(v string) string {
+	if v == "" {
+		return "(none)"
+	}
+	return v
+}
+
+func formatTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(tags, ", ") + "]"
+}
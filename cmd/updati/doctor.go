@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/janyksteenbeek/updati/internal/doctor"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+	"github.com/urfave/cli/v2"
+)
+
+func doctorCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "doctor",
+		Usage:  "Check the environment for required tools, network access and token validity",
+		Action: runDoctor,
+	}
+}
+
+func runDoctor(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	toolchain.SetPaths(cfg.Tools)
+
+	fmt.Println("🩺 Updati doctor")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	checks := doctor.Run(c.Context, cfg)
+	for _, check := range checks {
+		icon := "✅"
+		switch {
+		case !check.OK && check.Warn:
+			icon = "⚠️ "
+		case !check.OK:
+			icon = "❌"
+		}
+
+		fmt.Printf("%s %-14s %s\n", icon, check.Name, check.Detail)
+		if check.Fix != "" {
+			fmt.Printf("   %-14s → %s\n", "", check.Fix)
+		}
+	}
+	fmt.Println()
+
+	if doctor.Failed(checks) {
+		return fmt.Errorf("environment is not ready, see the fixes above")
+	}
+
+	fmt.Println("Environment looks good.")
+	return nil
+}
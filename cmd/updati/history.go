@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/history"
+	"github.com/urfave/cli/v2"
+)
+
+func historyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "history",
+		Usage: "Show past runs, success trends per repository and when each was last updated",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "db",
+				Usage: "History database (default: history_file from the config)",
+			},
+			&cli.IntFlag{
+				Name:  "limit",
+				Value: 10,
+				Usage: "Number of runs to show",
+			},
+			&cli.StringFlag{
+				Name:  "repo",
+				Usage: "Only show repositories whose name contains this",
+			},
+			&cli.StringFlag{
+				Name:  "tag",
+				Usage: "Only show runs tagged with this, and count only them in the trends",
+			},
+		},
+		Action: runHistory,
+	}
+}
+
+func runHistory(c *cli.Context) error {
+	path := c.String("db")
+	if path == "" {
+		cfg, err := loadConfig(c)
+		if err != nil {
+			return err
+		}
+		path = cfg.HistoryFile
+	}
+	if path == "" {
+		return fmt.Errorf("no history database: set history_file or pass --db")
+	}
+
+	h, err := history.Open(path)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	runs, err := h.Runs(c.Context, c.Int("limit"), c.String("tag"))
+	if err != nil {
+		return err
+	}
+	trends, err := h.Trends(c.Context, c.String("repo"), c.String("tag"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("📜 Runs")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	for _, run := range runs {
+		fmt.Printf("   %s  %s  %-12s %d updated, %d skipped, %d failed of %d (%s)%s\n",
+			run.ID, run.StartedAt.Local().Format("2006-01-02 15:04"), run.Mode,
+			run.Updated, run.Skipped+run.Deferred, run.Failed, run.Total,
+			run.FinishedAt.Sub(run.StartedAt).Round(time.Second), formatTags(run.Tags))
+	}
+	if len(runs) == 0 {
+		fmt.Println("   No runs recorded yet.")
+	}
+	fmt.Println()
+
+	fmt.Println("📈 Repositories")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	width := 0
+	for _, trend := range trends {
+		width = max(width, len(trend.Name))
+	}
+	for _, trend := range trends {
+		updated := "never updated"
+		if !trend.LastUpdated.IsZero() {
+			updated = "updated " + trend.LastUpdated.Local().Format("2006-01-02")
+		}
+		fmt.Printf("   %-*s  %3.0f%% of %d runs succeeded, last %s, %s\n",
+			width, trend.Name, trend.SuccessRate()*100, trend.Runs, trend.LastStatus, updated)
+	}
+	if len(trends) == 0 {
+		fmt.Println("   No repositories recorded yet.")
+	}
+	fmt.Println()
+
+	return nil
+}
@@ -3,12 +3,21 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 
 	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/logging"
+	"github.com/janyksteenbeek/updati/internal/progress"
 	"github.com/janyksteenbeek/updati/internal/runner"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+	"github.com/janyksteenbeek/updati/internal/updater"
 	"github.com/urfave/cli/v2"
 )
 
@@ -45,6 +54,11 @@ func main() {
 				Usage:   "GitHub personal access token",
 				EnvVars: []string{"GITHUB_TOKEN", "INPUT_GITHUB_TOKEN"},
 			},
+			&cli.StringFlag{
+				Name:    "read-token",
+				Usage:   "Separate read-only token for discovery, detection and cloning; --token is then only used to push and manage pull requests",
+				EnvVars: []string{"UPDATI_READ_TOKEN", "INPUT_READ_TOKEN"},
+			},
 			&cli.StringSliceFlag{
 				Name:    "pattern",
 				Aliases: []string{"p"},
@@ -58,10 +72,11 @@ func main() {
 				Value:   5,
 				EnvVars: []string{"UPDATI_WORKERS", "INPUT_WORKERS"},
 			},
-			&cli.BoolFlag{
+			&cli.GenericFlag{
 				Name:    "dry-run",
 				Aliases: []string{"n"},
-				Usage:   "Perform a dry run without making changes",
+				Usage:   "Perform a dry run without making changes: --dry-run=detect (no clone), resolve (update locally, the default) or push (commit locally)",
+				Value:   &dryRunValue{},
 				EnvVars: []string{"UPDATI_DRY_RUN", "INPUT_DRY_RUN"},
 			},
 			&cli.BoolFlag{
@@ -69,6 +84,15 @@ func main() {
 				Usage:   "Push directly to base branch instead of creating PR",
 				EnvVars: []string{"UPDATI_PUSH"},
 			},
+			&cli.StringSliceFlag{
+				Name:    "only",
+				Aliases: []string{"plugin"},
+				Usage:   "Only run this plugin (composer, npm or an external plugin), regardless of the config (can be specified multiple times)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "skip",
+				Usage: "Don't run this plugin, regardless of the config (can be specified multiple times)",
+			},
 			&cli.StringFlag{
 				Name:    "base-branch",
 				Aliases: []string{"b"},
@@ -76,6 +100,119 @@ func main() {
 				Value:   "main",
 				EnvVars: []string{"UPDATI_BASE_BRANCH", "INPUT_BASE_BRANCH"},
 			},
+			&cli.StringFlag{
+				Name:    "report",
+				Aliases: []string{"r"},
+				Usage:   "Write a JSON report of the run to this file",
+				EnvVars: []string{"UPDATI_REPORT_FILE", "INPUT_REPORT_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "metrics-file",
+				Usage:   "Write Prometheus metrics of the run to this file (for the node exporter textfile collector)",
+				EnvVars: []string{"UPDATI_METRICS_FILE", "INPUT_METRICS_FILE"},
+			},
+			&cli.BoolFlag{
+				Name:    "freshness",
+				Usage:   "Measure how far direct dependencies lag behind their latest releases",
+				EnvVars: []string{"UPDATI_FRESHNESS", "INPUT_FRESHNESS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "tag",
+				Usage:   "Tag the run in its report, e.g. monthly or security-hotfix (can be specified multiple times)",
+				EnvVars: []string{"UPDATI_TAGS", "INPUT_TAGS"},
+			},
+			&cli.StringFlag{
+				Name:    "log-level",
+				Usage:   "Log level (debug, info, warn, error)",
+				EnvVars: []string{"UPDATI_LOG_LEVEL", "INPUT_LOG_LEVEL"},
+			},
+			&cli.StringFlag{
+				Name:    "log-format",
+				Usage:   "Log format (text, json)",
+				EnvVars: []string{"UPDATI_LOG_FORMAT", "INPUT_LOG_FORMAT"},
+			},
+			&cli.BoolFlag{
+				Name:    "verbose",
+				Usage:   "Stream composer and npm output",
+				EnvVars: []string{"UPDATI_VERBOSE", "INPUT_VERBOSE"},
+			},
+			&cli.BoolFlag{
+				Name:    "no-progress",
+				Usage:   "Only log, without the live progress display on a terminal",
+				EnvVars: []string{"UPDATI_NO_PROGRESS"},
+			},
+			&cli.StringFlag{
+				Name:    "log-dir",
+				Usage:   "Write streamed output to one log file per repository in this directory",
+				EnvVars: []string{"UPDATI_LOG_DIR", "INPUT_LOG_DIR"},
+			},
+			&cli.StringFlag{
+				Name:    "cache-dir",
+				Usage:   "Cache GitHub API responses in this directory and revalidate them on later runs",
+				EnvVars: []string{"UPDATI_CACHE_DIR", "INPUT_CACHE_DIR"},
+			},
+			&cli.DurationFlag{
+				Name:    "repo-timeout",
+				Usage:   "Fail a repository that takes longer than this (e.g. 15m) and move on",
+				EnvVars: []string{"UPDATI_REPO_TIMEOUT", "INPUT_REPO_TIMEOUT"},
+			},
+			&cli.DurationFlag{
+				Name:    "max-duration",
+				Usage:   "Stop starting repositories after this long (e.g. 30m) and continue with the rest on the next run",
+				EnvVars: []string{"UPDATI_MAX_DURATION", "INPUT_MAX_DURATION"},
+			},
+			&cli.StringFlag{
+				Name:    "state-file",
+				Usage:   "File updati remembers the queue of time-boxed runs in (default: .updati-state.json)",
+				EnvVars: []string{"UPDATI_STATE_FILE", "INPUT_STATE_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "checkpoint-file",
+				Usage:   "Record the outcome of every repository in this JSON lines file as it completes (default: .updati-checkpoint.jsonl)",
+				EnvVars: []string{"UPDATI_CHECKPOINT_FILE", "INPUT_CHECKPOINT_FILE"},
+			},
+			&cli.BoolFlag{
+				Name:  "resume",
+				Usage: "Skip the repositories an interrupted run already processed successfully, according to the checkpoint file",
+			},
+			&cli.BoolFlag{
+				Name:  "estimate",
+				Usage: "Only estimate the API requests and duration of the run and check them against the rate limit, without running it",
+			},
+			&cli.StringFlag{
+				Name:  "retry-failed",
+				Usage: "Only process the repositories that failed according to this checkpoint file or JSON report of a previous run",
+			},
+			&cli.StringFlag{
+				Name:    "workdir",
+				Usage:   "Create checkouts and temporary files in this directory instead of the system temp directory",
+				EnvVars: []string{"UPDATI_WORKDIR", "INPUT_WORKDIR"},
+			},
+			&cli.StringFlag{
+				Name:    "mirror-dir",
+				Usage:   "Keep bare mirrors of the repositories in this directory so clones only fetch new objects",
+				EnvVars: []string{"UPDATI_MIRROR_DIR", "INPUT_MIRROR_DIR"},
+			},
+			&cli.IntFlag{
+				Name:    "clone-depth",
+				Usage:   "Clone only this many commits of history (default: full history)",
+				EnvVars: []string{"UPDATI_CLONE_DEPTH", "INPUT_CLONE_DEPTH"},
+			},
+			&cli.BoolFlag{
+				Name:    "partial-clone",
+				Usage:   "Clone without blobs and fetch file contents only as they are checked out",
+				EnvVars: []string{"UPDATI_PARTIAL_CLONE", "INPUT_PARTIAL_CLONE"},
+			},
+		},
+		Commands: []*cli.Command{
+			cleanupCommand(),
+			diffRunsCommand(),
+			doctorCommand(),
+			selfUpdateCommand(),
+			serveCommand(),
+			historyCommand(),
+			reportCommand(),
+			promoteCommand(),
 		},
 		Action: run,
 	}
@@ -104,9 +241,29 @@ func run(c *cli.Context) error {
 	if err := cfg.Validate(); err != nil {
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
+	toolchain.SetPaths(cfg.Tools)
+
+	// Show live progress on a terminal, unless output is streamed to it or parsed.
+	// Logs are written through the display, so they scroll above it.
+	var display *progress.Display
+	var logOutput io.Writer = os.Stderr
+	if !c.Bool("no-progress") && progress.IsTerminal(os.Stderr) && (!cfg.Verbose || cfg.LogDir != "") && !strings.EqualFold(cfg.LogFormat, logging.FormatJSON) {
+		display = progress.New(os.Stderr)
+		logOutput = display
+	}
+
+	// Set up logging
+	logger, err := logging.New(logOutput, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	slog.SetDefault(logger)
 
 	// Run the updater
-	r := runner.New(cfg)
+	r := runner.New(cfg, logger)
+	if display != nil {
+		r.ShowProgress(display)
+	}
 	return r.Run(ctx)
 }
 
@@ -131,6 +288,9 @@ func loadConfig(c *cli.Context) (*config.Config, error) {
 	if token := c.String("token"); token != "" {
 		cfg.GitHubToken = token
 	}
+	if token := c.String("read-token"); token != "" {
+		cfg.ReadToken = token
+	}
 	if owner := c.String("owner"); owner != "" {
 		cfg.Owner = owner
 	}
@@ -146,8 +306,104 @@ func loadConfig(c *cli.Context) (*config.Config, error) {
 	if c.IsSet("base-branch") {
 		cfg.BaseBranch = c.String("base-branch")
 	}
-	if c.Bool("dry-run") {
-		cfg.DryRun = true
+	if report := c.String("report"); report != "" {
+		cfg.ReportFile = report
+	}
+	if metrics := c.String("metrics-file"); metrics != "" {
+		cfg.MetricsFile = metrics
+	}
+	if c.Bool("freshness") {
+		cfg.Freshness = true
+	}
+	if tags := c.StringSlice("tag"); len(tags) > 0 {
+		cfg.Tags = tags
+	}
+	if level := c.String("log-level"); level != "" {
+		cfg.LogLevel = level
+	}
+	if format := c.String("log-format"); format != "" {
+		cfg.LogFormat = format
+	}
+	if c.Bool("verbose") {
+		cfg.Verbose = true
+	}
+	if logDir := c.String("log-dir"); logDir != "" {
+		cfg.LogDir = logDir
+	}
+	if cacheDir := c.String("cache-dir"); cacheDir != "" {
+		cfg.CacheDir = cacheDir
+	}
+	if c.IsSet("repo-timeout") {
+		cfg.RepoTimeout = c.Duration("repo-timeout")
+	}
+	if c.IsSet("max-duration") {
+		cfg.MaxDuration = c.Duration("max-duration")
+	}
+	if stateFile := c.String("state-file"); stateFile != "" {
+		cfg.StateFile = stateFile
+	}
+	if checkpoint := c.String("checkpoint-file"); checkpoint != "" {
+		cfg.CheckpointFile = checkpoint
+	}
+	if c.Bool("resume") {
+		cfg.Resume = true
+	}
+	if retryFailed := c.String("retry-failed"); retryFailed != "" {
+		cfg.RetryFailed = retryFailed
+	}
+	if c.Bool("estimate") {
+		cfg.Estimate = true
+	}
+	if workDir := c.String("workdir"); workDir != "" {
+		cfg.WorkDir = workDir
+	}
+	if cfg.WorkDir != "" {
+		// Checkouts are mounted at their own path in container images
+		workDir, err := filepath.Abs(cfg.WorkDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid workdir: %w", err)
+		}
+		cfg.WorkDir = workDir
+	}
+	if mirrorDir := c.String("mirror-dir"); mirrorDir != "" {
+		cfg.MirrorDir = mirrorDir
+	}
+	if c.IsSet("clone-depth") {
+		cfg.CloneDepth = c.Int("clone-depth")
+	}
+	if c.Bool("partial-clone") {
+		cfg.PartialClone = true
+	}
+	if err := updater.LoadExternalPlugins(cfg); err != nil {
+		return nil, err
+	}
+	if _, err := updater.OrderedPlugins(cfg); err != nil {
+		return nil, err
+	}
+	for name := range cfg.Plugins {
+		if !slices.Contains(updater.PluginNames(), name) {
+			return nil, fmt.Errorf("unknown plugin %q in plugins, available: %s", name, strings.Join(updater.PluginNames(), ", "))
+		}
+	}
+	for _, flag := range []string{"only", "skip"} {
+		for _, name := range c.StringSlice(flag) {
+			if !slices.Contains(updater.PluginNames(), name) {
+				return nil, fmt.Errorf("unknown plugin %q in --%s, available: %s", name, flag, strings.Join(updater.PluginNames(), ", "))
+			}
+		}
+	}
+	if plugins := c.StringSlice("only"); len(plugins) > 0 {
+		cfg.OnlyPlugins = plugins
+	}
+	if plugins := c.StringSlice("skip"); len(plugins) > 0 {
+		cfg.SkipPlugins = plugins
+	}
+	if c.IsSet("dry-run") {
+		level, err := config.ParseDryRun(c.Generic("dry-run").(*dryRunValue).value)
+		if err != nil {
+			return nil, err
+		}
+		cfg.DryRun = level
 	}
 	if c.Bool("push") {
 		cfg.CreatePR = false
@@ -160,6 +416,26 @@ func handleSignals(cancel context.CancelFunc) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
-	fmt.Println("\nReceived interrupt signal, shutting down...")
+	slog.Warn("received interrupt signal, shutting down")
 	cancel()
 }
+
+// dryRunValue holds the --dry-run level. Like a boolean flag it can be given without a
+// value, which selects the default level.
+type dryRunValue struct {
+	value string
+}
+
+func (v *dryRunValue) Set(s string) error {
+	v.value = s
+	return nil
+}
+
+func (v *dryRunValue) String() string {
+	return v.value
+}
+
+// IsBoolFlag lets --dry-run be used without a value
+func (v *dryRunValue) IsBoolFlag() bool {
+	return true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/janyksteenbeek/updati/internal/audit"
+	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/logging"
+	"github.com/janyksteenbeek/updati/internal/promote"
+	"github.com/urfave/cli/v2"
+)
+
+func promoteCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "promote",
+		Usage:  "Mark draft update pull requests ready for review once their CI passed (use --dry-run to only list them)",
+		Action: runPromote,
+	}
+}
+
+func runPromote(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	slog.SetDefault(logger)
+
+	opts := github.Options{
+		Retry:    cfg.Retry,
+		CacheDir: cfg.CacheDir,
+		Audit:    audit.New(cfg.AuditLog),
+		Tokens:   cfg.GitHubTokens,
+	}
+	client := github.NewClient(cfg.GitHubToken, cfg.Owner, opts)
+	reader := client
+	if cfg.ReadToken != "" {
+		opts.Tokens = nil // The read token doesn't rotate with the others
+		reader = github.NewClient(cfg.ReadToken, cfg.Owner, opts)
+	}
+
+	promotions, err := promote.Run(c.Context, cfg, client, reader, logger)
+	if err != nil && len(promotions) == 0 {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("🚦 Promote")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	for _, p := range promotions {
+		switch {
+		case p.Ready && p.AutoMergeMethod != "":
+			fmt.Printf("   ✅ %s#%d: ready for review, auto-merge (%s)\n", p.Repository, p.PRNumber, p.AutoMergeMethod)
+		case p.Ready:
+			fmt.Printf("   ✅ %s#%d: ready for review\n", p.Repository, p.PRNumber)
+		case p.CI == github.CIPassing:
+			fmt.Printf("   -  %s#%d: CI passed, would mark ready\n", p.Repository, p.PRNumber)
+		case p.CI == github.CIFailing:
+			fmt.Printf("   ❌ %s#%d: CI failing (%s)\n", p.Repository, p.PRNumber, strings.Join(p.Failing, ", "))
+		default:
+			fmt.Printf("   ⏳ %s#%d: CI %s\n", p.Repository, p.PRNumber, p.CI)
+		}
+	}
+	if len(promotions) == 0 {
+		fmt.Println("   No draft update pull requests found.")
+	}
+	fmt.Println()
+
+	return err
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/janyksteenbeek/updati/internal/audit"
+	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/logging"
+	"github.com/janyksteenbeek/updati/internal/matrix"
+	"github.com/urfave/cli/v2"
+)
+
+func reportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "report",
+		Usage: "Report on the matched repositories",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "matrix",
+				Usage: "Show which version of the given packages each repository locks, read through the API without cloning",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:     "package",
+						Usage:    "Composer or npm package to look up, e.g. laravel/framework, php or vue (comma separated or specified multiple times)",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "table",
+						Usage: "Output format: table or csv",
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "Write the matrix to this file instead of stdout",
+					},
+				},
+				Action: runReportMatrix,
+			},
+		},
+	}
+}
+
+func runReportMatrix(c *cli.Context) error {
+	format := c.String("format")
+	if format != "table" && format != "csv" {
+		return fmt.Errorf("invalid format %q: use table or csv", format)
+	}
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	slog.SetDefault(logger)
+
+	opts := github.Options{
+		Retry:    cfg.Retry,
+		CacheDir: cfg.CacheDir,
+		Audit:    audit.New(cfg.AuditLog),
+		Tokens:   cfg.GitHubTokens,
+	}
+	token := cfg.GitHubToken
+	if cfg.ReadToken != "" {
+		token, opts.Tokens = cfg.ReadToken, nil
+	}
+	client := github.NewClient(token, cfg.Owner, opts)
+
+	packages := c.StringSlice("package")
+	rows, err := matrix.Run(c.Context, cfg, client, packages, logger)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if path := c.String("output"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to write matrix: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if format == "csv" {
+		return writeMatrixCSV(out, rows, packages)
+	}
+	writeMatrixTable(out, rows, packages)
+	return nil
+}
+
+// writeMatrixTable prints the matrix as an aligned table, followed by how many
+// repositories are on each major version of every package
+func writeMatrixTable(out io.Writer, rows []matrix.Row, packages []string) {
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "🧮 Dependency matrix")
+	fmt.Fprintln(out, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "   Repository\t%s\n", strings.Join(packages, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(packages))
+		for i, name := range packages {
+			cells[i] = "—"
+			if version, ok := row.Versions[name]; ok {
+				cells[i] = version
+			}
+		}
+		if row.Error != "" {
+			cells = append(cells, "(could not be read)")
+		}
+		fmt.Fprintf(w, "   %s\t%s\n", row.Repository, strings.Join(cells, "\t"))
+	}
+	w.Flush()
+	if len(rows) == 0 {
+		fmt.Fprintln(out, "   No matching repositories.")
+	}
+	fmt.Fprintln(out)
+
+	for _, name := range packages {
+		var counts []string
+		for _, count := range matrix.Distribution(rows, name) {
+			counts = append(counts, fmt.Sprintf("%s: %d", count.Version, count.Repositories))
+		}
+		if len(counts) == 0 {
+			counts = []string{"not locked anywhere"}
+		}
+		fmt.Fprintf(out, "   %s — %s\n", name, strings.Join(counts, ", "))
+	}
+	fmt.Fprintln(out)
+}
+
+// writeMatrixCSV writes the matrix as CSV with a column per package, empty where a
+// repository doesn't lock it, and a last column with read errors
+func writeMatrixCSV(out io.Writer, rows []matrix.Row, packages []string) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(append(append([]string{"repository"}, packages...), "error")); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{row.Repository}
+		for _, name := range packages {
+			record = append(record, row.Versions[name])
+		}
+		if err := w.Write(append(record, row.Error)); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
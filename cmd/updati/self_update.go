@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/janyksteenbeek/updati/internal/selfupdate"
+	"github.com/urfave/cli/v2"
+)
+
+func selfUpdateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "self-update",
+		Usage: "Replace this binary with the latest release",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "check",
+				Usage: "Only check whether a newer release is available",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Reinstall even if the latest release is already installed",
+			},
+		},
+		Action: selfUpdate,
+	}
+}
+
+func selfUpdate(c *cli.Context) error {
+	release, err := selfupdate.Latest(c.Context, c.String("token"))
+	if err != nil {
+		return err
+	}
+
+	if !release.IsNewer(version) && !c.Bool("force") {
+		fmt.Printf("✅ updati %s is up to date\n", version)
+		return nil
+	}
+
+	if c.Bool("check") {
+		fmt.Printf("⬆️  updati %s is available (installed: %s)\n", release.Version, version)
+		fmt.Printf("   %s\n", release.URL)
+		return nil
+	}
+
+	fmt.Printf("⬇️  Installing updati %s (%s)...\n", release.Version, selfupdate.AssetName())
+	if err := release.Install(c.Context); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Updated from %s to %s\n", version, release.Version)
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/janyksteenbeek/updati/internal/logging"
+	"github.com/janyksteenbeek/updati/internal/server"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+	"github.com/urfave/cli/v2"
+)
+
+func serveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Listen for webhooks and API requests that trigger updates of single repositories",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "addr",
+				Usage: "Address to listen on (default: :8080)",
+			},
+		},
+		Action: runServe,
+	}
+}
+
+func runServe(c *cli.Context) error {
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+
+	go handleSignals(cancel)
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if addr := c.String("addr"); addr != "" {
+		cfg.ServeAddr = addr
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if cfg.WebhookSecret == "" && cfg.ServeToken == "" {
+		return fmt.Errorf("invalid configuration: serve needs a webhook_secret or serve_token to accept requests")
+	}
+	toolchain.SetPaths(cfg.Tools)
+
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	slog.SetDefault(logger)
+
+	return server.New(cfg, logger).Run(ctx)
+}
@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	BranchCreate   = "branch.create"
+	BranchUpdate   = "branch.update"
+	BranchDelete   = "branch.delete"
+	CommitPush     = "commit.push"
+	PROpen         = "pr.open"
+	PREdit         = "pr.edit"
+	PRClose        = "pr.close"
+	PRAutoMerge    = "pr.auto_merge"
+	PRReady        = "pr.ready"
+	PRDraft        = "pr.draft"
+	LabelCreate    = "label.create"
+	LabelAdd       = "label.add"
+	LabelRemove    = "label.remove"
+	IssueOpen      = "issue.open"
+	IssueEdit      = "issue.edit"
+	IssueClose     = "issue.close"
+	CheckRunCreate = "check_run.create"
+)
+
+// Entry is a single mutating action
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"` // One of the action constants
+	Repository string    `json:"repository"`
+	Branch     string    `json:"branch,omitempty"`
+	Commit     string    `json:"commit,omitempty"`
+	Number     int       `json:"number,omitempty"` // Pull request or issue
+	Labels     []string  `json:"labels,omitempty"`
+	Force      bool      `json:"force,omitempty"` // The push replaced the branch's history
+	URL        string    `json:"url,omitempty"`
+	Actor      string    `json:"actor,omitempty"` // Login of the token's user, empty when it can't be looked up, e.g. for app tokens
+	Token      string    `json:"token"`           // Fingerprint of the token that performed the action
+}
+
+// Log appends entries to a JSON lines file. A nil Log records nothing.
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// New returns a Log appending to path, or nil when path is empty. The file is created
+// on the first entry.
+func New(path string) *Log {
+	if path == "" {
+		return nil
+	}
+	return &Log{path: path}
+}
+
+// Record appends the entry, stamping it with the current time if it has none. The file
+// is opened for every entry in append mode, so concurrent processes sharing a log
+// don't overwrite each other.
+func (l *Log) Record(entry Entry) error {
+	if l == nil {
+		return nil
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if dir := filepath.Dir(l.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to write audit log: %w", err)
+		}
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return f.Close()
+}
+
+// Fingerprint identifies a token in the log without revealing it: the first 12 hex
+// characters of its SHA-256
+func Fingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,103 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/github"
+)
+
+// Branch is an update branch whose pull request was merged
+type Branch struct {
+	Repository string
+	Name       string
+	PRNumber   int
+	Deleted    bool // False in dry runs or when deleting failed
+}
+
+// Run finds the update branches of the matching repositories whose latest pull request
+// was merged and deletes them, unless the configuration is a dry run. Branches with an
+// open pull request, that never had one, or with commits the merged pull request
+// didn't have, are left alone. Branches are looked up
+// through reader and deleted through client.
+func Run(ctx context.Context, cfg *config.Config, client, reader *github.Client, logger *slog.Logger) ([]Branch, error) {
+	repos, err := reader.ListRepositories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	var branches []Branch
+	for _, repo := range repos {
+		if !cfg.MatchesRepo(repo.Name) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return branches, ctx.Err()
+		}
+
+		log := logger.With("repo", repo.FullName)
+
+		merged, err := mergedBranches(ctx, reader, repo, cfg.BranchPrefix())
+		if err != nil {
+			log.Warn("could not look for merged branches", "error", err)
+			continue
+		}
+
+		for _, branch := range merged {
+			if !cfg.DryRun.Enabled() {
+				if err := client.DeleteBranch(ctx, repo, branch.Name); err != nil {
+					log.Warn("could not delete branch", "branch", branch.Name, "error", err)
+				} else {
+					branch.Deleted = true
+					log.Info("deleted merged branch", "branch", branch.Name, "pr", branch.PRNumber)
+				}
+			}
+			branches = append(branches, branch)
+		}
+	}
+
+	return branches, nil
+}
+
+// mergedBranches returns the repository's update branches whose latest pull request
+// was merged with the branch's current head
+func mergedBranches(ctx context.Context, client *github.Client, repo *github.Repository, prefix string) ([]Branch, error) {
+	names, err := client.ListBranches(ctx, repo, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged []Branch
+	for _, name := range names {
+		prs, err := client.BranchPullRequests(ctx, repo, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(prs) == 0 || !prs[0].Merged || hasOpen(prs) {
+			continue
+		}
+
+		// Commits pushed after the merge, whose pull request wasn't opened yet, aren't merged
+		head, err := client.BranchHead(ctx, repo, name)
+		if err != nil {
+			return nil, err
+		}
+		if head != prs[0].SHA {
+			continue
+		}
+		merged = append(merged, Branch{Repository: repo.FullName, Name: name, PRNumber: prs[0].Number})
+	}
+
+	return merged, nil
+}
+
+func hasOpen(prs []github.PullRequestInfo) bool {
+	for _, pr := range prs {
+		if pr.State == "open" {
+			return true
+		}
+	}
+	return false
+}
@@ -1,56 +1,571 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
+	"github.com/janyksteenbeek/updati/internal/retry"
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds the application configuration
 type Config struct {
 	// GitHub authentication
-	GitHubToken string `yaml:"github_token"`
+	GitHubToken  string   `yaml:"github_token"`
+	GitHubTokens []string `yaml:"github_tokens"` // Further tokens API requests rotate over together with github_token, to spread the rate limits
+	ReadToken    string   `yaml:"read_token"`    // Token for discovery, detection and cloning, github_token is then only used to push and manage pull requests
 
 	// Repository matching
-	RepoPatterns []string `yaml:"repo_patterns"` // Regex patterns for matching repos
-	Owner        string   `yaml:"owner"`         // GitHub owner (user or org)
+	RepoPatterns    []string `yaml:"repo_patterns"`    // Regex patterns for matching repos
+	Owner           string   `yaml:"owner"`            // GitHub owner (user or org)
+	UpdateTemplates bool     `yaml:"update_templates"` // Update template repositories too instead of excluding them
 
 	// Concurrency settings
-	Workers int `yaml:"workers"` // Number of concurrent workers
+	Workers               int           `yaml:"workers"`                 // Number of concurrent workers
+	MinWorkers            int           `yaml:"min_workers"`             // Scale between this and workers by rate limit and host load, 0 for a fixed number
+	PluginConcurrency     int           `yaml:"plugin_concurrency"`      // Plugins updating a repository at the same time when they touch disjoint files
+	MaxConcurrentInstalls int           `yaml:"max_concurrent_installs"` // Package manager updates running at once across all workers, 0 for no limit
+	RepoTimeout           time.Duration `yaml:"repo_timeout"`            // Give up on a repository after this long and fail it, 0 for no limit
 
 	// Update settings
-	UpdateComposer bool     `yaml:"update_composer"` // Update composer dependencies
-	UpdateNPM      bool     `yaml:"update_npm"`      // Update npm dependencies
-	CreatePR       bool     `yaml:"create_pr"`       // Create pull request instead of direct push
-	BaseBranch     string   `yaml:"base_branch"`     // Branch to base updates on
-	PRBranch       string   `yaml:"pr_branch"`       // Branch name for PRs
-	CommitMessage  string   `yaml:"commit_message"`  // Custom commit message
-	PRTitle        string   `yaml:"pr_title"`        // Custom PR title
-	PRBody         string   `yaml:"pr_body"`         // Custom PR body
-	DryRun         bool     `yaml:"dry_run"`         // Don't actually make changes
-	Labels         []string `yaml:"labels"`          // Labels to add to PRs
+	Plugins                  Plugins           `yaml:"plugins"`                    // Settings per plugin by name, e.g. npm: {enabled: false}; plugins not listed run
+	UpdateComposer           bool              `yaml:"update_composer"`            // Update composer dependencies, same as plugins.composer.enabled
+	UpdateNPM                bool              `yaml:"update_npm"`                 // Update npm dependencies, same as plugins.npm.enabled
+	MinReleaseAge            Age               `yaml:"min_release_age"`            // Skip package versions released more recently than this, e.g. 3d; 0 adopts releases right away
+	CreatePR                 bool              `yaml:"create_pr"`                  // Create pull request instead of direct push
+	BaseBranch               string            `yaml:"base_branch"`                // Branch to base updates on
+	RequireGreenCI           bool              `yaml:"require_green_ci"`           // Skip base branches whose latest commit has failing statuses or check runs
+	Branches                 []string          `yaml:"branches"`                   // Update several branches per repository, each with its own PR (overrides base_branch)
+	StaleBase                string            `yaml:"stale_base"`                 // What to do when the base branch is far behind the default branch: warn or default
+	StaleBaseAfter           int               `yaml:"stale_base_after"`           // Commits the base branch may lag behind the default branch before it counts as stale
+	PRBranch                 string            `yaml:"pr_branch"`                  // Branch name for PRs, or a template like updati/{{ .Plugins }}/{{ .Date }}
+	CommitMessage            string            `yaml:"commit_message"`             // Custom commit message
+	PRTitle                  string            `yaml:"pr_title"`                   // Custom PR title
+	PRBody                   string            `yaml:"pr_body"`                    // Custom PR body, a template like pr_body_file
+	PRBodyFile               string            `yaml:"pr_body_file"`               // Template file the PR body is rendered from instead of pr_body, looked up in the checkout first
+	ChangelogLinks           bool              `yaml:"changelog_links"`            // Add a table of the updated packages with links to their release notes to the PR body
+	SecurityAnnotations      bool              `yaml:"security_annotations"`       // Audit before and after the update and mention fixed advisories in the PR
+	SecurityLabel            string            `yaml:"security_label"`             // Label added to PRs that fix security advisories, empty to add none
+	RiskScore                bool              `yaml:"risk_score"`                 // Score how risky each update is, shown as a risk:low|medium|high label and a PR body badge
+	CheckRun                 bool              `yaml:"check_run"`                  // Report the package changes, audit results and output in a check run on the PR head
+	DryRun                   DryRun            `yaml:"dry_run"`                    // How much of the update to perform without making changes
+	Labels                   []string          `yaml:"labels"`                     // Labels to add to PRs
+	CreateMissingLabels      bool              `yaml:"create_missing_labels"`      // Create labels that don't exist in a repository before adding them
+	LabelColor               string            `yaml:"label_color"`                // Color of created labels, hex without #
+	LabelDescription         string            `yaml:"label_description"`          // Description of created labels
+	AutoMerge                bool              `yaml:"auto_merge"`                 // Enable auto-merge on created PRs
+	MergeMethod              string            `yaml:"merge_method"`               // Preferred auto-merge method: squash, merge or rebase
+	ReadyAfterCI             bool              `yaml:"ready_after_ci"`             // Open PRs as drafts, marked ready (and auto-merged) by updati promote or serve once CI passes
+	CommitMode               string            `yaml:"commit_mode"`                // How to commit: git (local commit and push) or api (verified commit through the Git Data API)
+	CommitPerPlugin          bool              `yaml:"commit_per_plugin"`          // Commit each plugin's changes separately, e.g. composer and npm updates as two commits
+	CommitMessages           map[string]string `yaml:"commit_messages"`            // Commit message template per plugin, e.g. composer: "build({{ .Scope }}): update composer dependencies"
+	CommitAuthorName         string            `yaml:"commit_author_name"`         // Name updati commits with
+	CommitAuthorEmail        string            `yaml:"commit_author_email"`        // Email updati commits with, also how its commits on update branches are recognized
+	SignOff                  bool              `yaml:"sign_off"`                   // Add a Signed-off-by trailer for the commit author, for repositories enforcing the DCO
+	CommitPaths              []string          `yaml:"commit_paths"`               // Paths committed with the update besides the files the plugins changed, e.g. a generated file
+	BranchUpdateStrategy     string            `yaml:"branch_update_strategy"`     // How to update an existing update branch: force-push, recreate or append
+	ForeignCommits           string            `yaml:"foreign_commits"`            // What to do when the update branch has commits not made by updati: skip or append
+	CloseSuperseded          bool              `yaml:"close_superseded"`           // Close other open updati pull requests against the same base when opening one
+	DeleteSupersededBranches bool              `yaml:"delete_superseded_branches"` // Also delete the branches of closed superseded pull requests
+	MaxPRsPerRun             int               `yaml:"max_prs_per_run"`            // Open at most this many new pull requests per run and defer the rest, 0 for no limit
+	MaxOpenPRsPerRepo        int               `yaml:"max_open_prs_per_repo"`      // Defer a repository's update while it has this many open update pull requests, 0 for no limit
+	SigningKey               string            `yaml:"signing_key"`                // GPG key id or SSH key path to sign commits with, passphrase in UPDATI_SIGNING_PASSPHRASE
+	TestCommand              string            `yaml:"test_command"`               // Shell command run after the update, a failure blocks it, e.g. php artisan test
+	BuildCommand             string            `yaml:"build_command"`              // Shell command rebuilding frontend assets after npm updated packages, e.g. npm run build
+	BuildPaths               []string          `yaml:"build_paths"`                // Built asset paths committed with the update, even when git ignores them
+
+	// Plugins to run or skip for this invocation only, regardless of plugins
+	OnlyPlugins []string `yaml:"-"`
+	SkipPlugins []string `yaml:"-"`
+
+	// Third-party plugins speaking the external plugin protocol
+	PluginDir       string           `yaml:"plugin_dir"`       // Every executable in this directory is a plugin named after the file
+	ExternalPlugins []ExternalPlugin `yaml:"external_plugins"` // Plugins configured individually
+
+	// Order plugins run in, for plugins reading what others generate
+	PluginOrder []string            `yaml:"plugin_order"` // Plugins to run first, in this order; the rest follow in registration order
+	PluginAfter map[string][]string `yaml:"plugin_after"` // Plugins each plugin runs after, e.g. npm: [composer]
+
+	// Per-repository overrides, applied in order to repositories matching their pattern
+	Overrides []Override `yaml:"overrides"`
+
+	// Restrictions on the updates of packages, every matching rule applies
+	Rules []Rule `yaml:"rules"`
+
+	// Whether updates cover development dependencies: true, false for production ones only, or only
+	IncludeDev IncludeDev `yaml:"include_dev"`
+
+	// Paths of external tools by name, e.g. composer: /opt/composer.phar; tools not listed are looked up on PATH
+	Tools map[string]string `yaml:"tools"`
+
+	// Composer settings
+	MissingExtensions string            `yaml:"missing_extensions"` // What to do when no PHP binary has the required extensions: ignore or skip
+	ComposerPlatform  map[string]string `yaml:"composer_platform"`  // Platform packages to pin during resolution, e.g. php: 8.2.27
+	AuditGate         bool              `yaml:"audit_gate"`         // Fail the update when it locks a version with a security advisory the base didn't have
+	ComposerValidate  bool              `yaml:"composer_validate"`  // Run composer validate after the update and fail it on an inconsistent lock file
+	ComposerFlags     []string          `yaml:"composer_flags"`     // Flags passed to composer upgrade, e.g. --prefer-lowest
+	PHPBinaries       map[string]string `yaml:"php_binaries"`       // PHP binaries by version, e.g. "8.3": /usr/bin/php8.3; empty finds them on PATH
+	ComposerAuth      string            `yaml:"composer_auth"`      // auth.json content with credentials for private repositories, merged with the real auth.json
+	ComposerMirror    string            `yaml:"composer_mirror"`    // Packagist mirror composer resolves packages from instead of packagist.org
+
+	// Node settings
+	NodeBinaries map[string]string `yaml:"node_binaries"` // Node binaries by version, e.g. "20": /opt/node20/bin/node; empty uses node on PATH
+	NodeManager  string            `yaml:"node_manager"`  // Run npm through a version manager instead: fnm or volta
+	NPMRC        string            `yaml:"npmrc"`         // .npmrc lines with registries and credentials for private packages, added to the user config
+	NPMRegistry  string            `yaml:"npm_registry"`  // Registry mirror npm installs from instead of registry.npmjs.org
+
+	// Container isolation
+	PluginImages     map[string]string `yaml:"plugin_images"`     // Run a plugin's package manager in a container image instead of on the host, e.g. composer: composer:2, or tools for updati's image
+	ContainerRuntime string            `yaml:"container_runtime"` // Container CLI plugin images run with: docker or podman
+
+	// Retries of transient failures in git, GitHub API calls and plugin commands
+	Retry retry.Policy `yaml:"retry"`
+
+	// Time-boxed runs
+	MaxDuration time.Duration `yaml:"max_duration"` // Stop starting repositories after this long and continue with the rest next run
+	StateFile   string        `yaml:"state_file"`   // Where updati remembers things between runs, such as the queue of a time-boxed run
+
+	// Locks keeping two runs against the owner from racing each other
+	LockFile       string        `yaml:"lock_file"`       // Local lock file, empty to disable
+	LockRepository string        `yaml:"lock_repository"` // Repository of the owner holding a lock branch shared by all machines, empty to disable
+	LockTTL        time.Duration `yaml:"lock_ttl"`        // Take over a lock branch left behind by a crashed run after this long
+
+	// Checkouts and temporary files
+	WorkDir        string        `yaml:"workdir"`          // Directory for checkouts and temporary files, the system temp directory if empty
+	StaleTempAfter time.Duration `yaml:"stale_temp_after"` // Remove updati-* leftovers of crashed runs in workdir older than this at startup, 0 to keep them
+	MinFreeDiskMB  int           `yaml:"min_free_disk_mb"` // Megabytes to keep free in the work directory besides a checkout, 0 to not check
+
+	// Run history
+	HistoryFile string `yaml:"history_file"` // SQLite database every run's results are recorded in for updati history, empty to disable
+
+	// Append-only record of every branch, commit, pull request, label and issue change
+	AuditLog string `yaml:"audit_log"` // JSON lines file, empty to disable
+
+	// Resuming interrupted runs
+	CheckpointFile string `yaml:"checkpoint_file"` // Append the outcome of every repository to this JSON lines file as it completes, empty to disable
+	Resume         bool   `yaml:"-"`               // Skip the repositories the checkpoint file records as completed
+	RetryFailed    string `yaml:"-"`               // Only process the repositories this checkpoint file or JSON report records as failed
+
+	// Rate limit handling
+	RateLimitBudget string `yaml:"rate_limit_budget"` // What to do when the estimated requests exceed the remaining rate limit: warn, stagger or abort
+	Estimate        bool   `yaml:"-"`                 // Only estimate the API requests and duration of the run, without running it
+	CacheDir        string `yaml:"cache_dir"`         // Cache GitHub responses here and revalidate them with ETags on later runs
+	MirrorDir       string `yaml:"mirror_dir"`        // Keep bare mirrors of the repositories here so clones only fetch new objects
+	CloneDepth      int    `yaml:"clone_depth"`       // Clone only this many commits of history, 0 for the full history
+	PartialClone    bool   `yaml:"partial_clone"`     // Clone without blobs (--filter=blob:none), fetching file contents only as they are checked out
+
+	// Reporting
+	ReportFile  string   `yaml:"report_file"`  // Write a JSON report of the run to this path
+	Tags        []string `yaml:"tags"`         // Tags describing the purpose of the run, recorded in reports, history and metrics
+	Freshness   bool     `yaml:"freshness"`    // Measure how far direct dependencies lag behind their latest releases
+	MetricsFile string   `yaml:"metrics_file"` // Write Prometheus metrics of the run to this path (textfile collector format)
+
+	FailureIssueAfter int  `yaml:"failure_issue_after"` // Open an issue in repositories that failed this many runs in a row, 0 to never
+	AdvisoryIssue     bool `yaml:"advisory_issue"`      // Keep an issue listing the security advisories updates don't fix, closed once none are left
+
+	// Serve mode
+	ServeAddr     string `yaml:"serve_addr"`     // Address updati serve listens on
+	WebhookSecret string `yaml:"webhook_secret"` // Secret GitHub signs webhook deliveries with, empty disables the GitHub webhook endpoint
+	ServeToken    string `yaml:"serve_token"`    // Bearer token of the update endpoint, empty disables it
+
+	// Chat channels the summary of each run is posted to
+	Notifications Notifications `yaml:"notifications"`
+
+	// Logging
+	LogLevel  string `yaml:"log_level"`  // debug, info, warn or error
+	LogFormat string `yaml:"log_format"` // text or json
+	Verbose   bool   `yaml:"verbose"`    // Stream package manager output
+	LogDir    string `yaml:"log_dir"`    // Write streamed output to a file per repository in this directory instead of the console
 
 	// Compiled patterns (not from config file)
 	compiledPatterns []*regexp.Regexp
 }
 
+// Override changes settings for repositories whose name matches Pattern
+type Override struct {
+	Pattern              string            `yaml:"pattern"`
+	AutoMerge            *bool             `yaml:"auto_merge"`
+	MergeMethod          string            `yaml:"merge_method"`
+	ComposerPlatform     map[string]string `yaml:"composer_platform"` // Merged into the global platform config
+	BranchUpdateStrategy string            `yaml:"branch_update_strategy"`
+	TestCommand          string            `yaml:"test_command"`
+	BuildCommand         string            `yaml:"build_command"`
+	BuildPaths           []string          `yaml:"build_paths"`
+	ComposerFlags        []string          `yaml:"composer_flags"` // Replace the global flags
+	PRBodyFile           string            `yaml:"pr_body_file"`
+
+	compiled *regexp.Regexp
+}
+
+// apply copies the settings of the override onto the config
+func (o *Override) apply(c *Config) {
+	if o.AutoMerge != nil {
+		c.AutoMerge = *o.AutoMerge
+	}
+	if o.MergeMethod != "" {
+		c.MergeMethod = o.MergeMethod
+	}
+	if o.BranchUpdateStrategy != "" {
+		c.BranchUpdateStrategy = o.BranchUpdateStrategy
+	}
+	if o.TestCommand != "" {
+		c.TestCommand = o.TestCommand
+	}
+	if o.BuildCommand != "" {
+		c.BuildCommand = o.BuildCommand
+	}
+	if len(o.BuildPaths) > 0 {
+		c.BuildPaths = o.BuildPaths
+	}
+	if o.ComposerFlags != nil {
+		c.ComposerFlags = o.ComposerFlags
+	}
+	if o.PRBodyFile != "" {
+		c.PRBodyFile = o.PRBodyFile
+	}
+	if len(o.ComposerPlatform) > 0 {
+		// Copy before merging so the global map is left untouched
+		platform := make(map[string]string, len(c.ComposerPlatform)+len(o.ComposerPlatform))
+		maps.Copy(platform, c.ComposerPlatform)
+		maps.Copy(platform, o.ComposerPlatform)
+		c.ComposerPlatform = platform
+	}
+}
+
+// Plugins configures plugins by name
+type Plugins map[string]PluginConfig
+
+// setEnabled enables or disables the plugin, keeping its other settings
+func (p *Plugins) setEnabled(name string, enabled bool) {
+	if *p == nil {
+		*p = make(Plugins)
+	}
+	plugin := (*p)[name]
+	plugin.Enabled = &enabled
+	(*p)[name] = plugin
+}
+
+// PluginConfig configures a plugin, built-in or external. A plain boolean in place of
+// the section sets Enabled.
+type PluginConfig struct {
+	Enabled  *bool          `yaml:"enabled"`  // Nil leaves the plugin enabled
+	Flags    []string       `yaml:"flags"`    // Added to the package manager's update command
+	Image    string         `yaml:"image"`    // Container image to run the package manager in, like plugin_images
+	Settings map[string]any `yaml:"settings"` // Passed to external plugins on top of their external_plugins settings
+
+	Timeout       time.Duration `yaml:"timeout"`         // Kill the plugin's update after this long and fail the repository, 0 for no limit
+	MemoryLimitMB int           `yaml:"memory_limit_mb"` // Megabytes of memory composer, Node or the container may use, 0 for no limit
+}
+
+// UnmarshalYAML accepts a section or a boolean
+func (p *PluginConfig) UnmarshalYAML(value *yaml.Node) error {
+	var enabled bool
+	if value.Kind == yaml.ScalarNode && value.Decode(&enabled) == nil {
+		*p = PluginConfig{Enabled: &enabled}
+		return nil
+	}
+
+	type plain PluginConfig
+	return value.Decode((*plain)(p))
+}
+
+// ExternalPlugin configures a plugin implemented by an executable
+type ExternalPlugin struct {
+	Name     string         `yaml:"name"`
+	Command  string         `yaml:"command"`  // Path of the executable
+	Args     []string       `yaml:"args"`     // Arguments passed to the executable
+	After    []string       `yaml:"after"`    // Plugins to run first
+	Settings map[string]any `yaml:"settings"` // Passed to the plugin with every update
+}
+
+// Notifications lists the webhooks run summaries are sent to
+type Notifications struct {
+	Teams   []string        `yaml:"teams"`   // Microsoft Teams incoming webhook or Workflows webhook URLs
+	Discord []string        `yaml:"discord"` // Discord webhook URLs
+	Webhook WebhookReceiver `yaml:"webhook"` // Receivers of the JSON report of each run
+}
+
+// WebhookReceiver lists the URLs the JSON report of each run is posted to
+type WebhookReceiver struct {
+	URLs   []string `yaml:"urls"`
+	Secret string   `yaml:"secret"` // Signs the body in the X-Updati-Signature-256 header, empty to not sign
+}
+
+// Rule restricts how far the packages it matches may be updated. A rule holds exactly
+// one of Allow, Pin and Block.
+type Rule struct {
+	Packages  []string `yaml:"packages"`  // Package name globs like aws/* or @types/*, empty for all packages
+	Ecosystem string   `yaml:"ecosystem"` // composer or npm, empty for both
+	Versions  []string `yaml:"versions"`  // Only apply to updates to versions matching these globs, e.g. *-beta*
+	Allow     string   `yaml:"allow"`     // Largest allowed update: patch or minor
+	Pin       string   `yaml:"pin"`       // Version constraint the package has to stay within, e.g. ^2.4
+	Block     bool     `yaml:"block"`     // Don't update the package at all
+
+	packages []*regexp.Regexp
+	versions []*regexp.Regexp
+}
+
+// Matches reports whether the rule applies to the update of a package to a version
+func (r *Rule) Matches(ecosystem, name, version string) bool {
+	if r.Ecosystem != "" && r.Ecosystem != ecosystem {
+		return false
+	}
+	return matchesAny(r.packages, name) && matchesAny(r.versions, version)
+}
+
+// matchesAny reports whether s matches one of the patterns, or there are no patterns
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileGlobs compiles globs in which * matches any text, slashes included
+func compileGlobs(globs []string) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0, len(globs))
+	for _, glob := range globs {
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(glob), `\*`, ".*")
+		patterns = append(patterns, regexp.MustCompile("(?i)^"+pattern+"$"))
+	}
+	return patterns
+}
+
+// Rule update levels
+const (
+	AllowPatch = "patch"
+	AllowMinor = "minor"
+)
+
+// Merge methods
+const (
+	MergeMethodSquash = "squash"
+	MergeMethodMerge  = "merge"
+	MergeMethodRebase = "rebase"
+)
+
+// DryRun is how far a dry run goes. The zero value is a real run.
+type DryRun string
+
+// Dry run levels, from least to most work done
+const (
+	DryRunOff     DryRun = ""
+	DryRunDetect  DryRun = "detect"  // Detect manifests only, no clone
+	DryRunResolve DryRun = "resolve" // Clone and update locally, no commit
+	DryRunPush    DryRun = "push"    // Commit locally, no push or pull request
+)
+
+// ParseDryRun parses a dry run level. true selects resolve, the behavior of the former
+// boolean dry run, and false or an empty string disables the dry run.
+func ParseDryRun(s string) (DryRun, error) {
+	switch level := DryRun(strings.ToLower(strings.TrimSpace(s))); level {
+	case "true":
+		return DryRunResolve, nil
+	case "false", DryRunOff:
+		return DryRunOff, nil
+	case DryRunDetect, DryRunResolve, DryRunPush:
+		return level, nil
+	default:
+		return DryRunOff, fmt.Errorf("dry_run must be one of detect, resolve or push, got %q", s)
+	}
+}
+
+// Enabled reports whether this is a dry run at all
+func (d DryRun) Enabled() bool {
+	return d != DryRunOff
+}
+
+// UnmarshalYAML accepts a level as well as the former boolean
+func (d *DryRun) UnmarshalYAML(value *yaml.Node) error {
+	level, err := ParseDryRun(value.Value)
+	if err != nil {
+		return err
+	}
+	*d = level
+	return nil
+}
+
+// IncludeDev selects whether updates cover development dependencies. The zero value
+// covers production and development dependencies.
+type IncludeDev string
+
+// Development dependency scopes
+const (
+	IncludeDevAll  IncludeDev = ""      // Production and development dependencies
+	IncludeDevNone IncludeDev = "false" // Production dependencies only
+	IncludeDevOnly IncludeDev = "only"  // Development dependencies only
+)
+
+// ParseIncludeDev parses a development dependency scope: true or an empty string for
+// all dependencies, false for production dependencies only, or only
+func ParseIncludeDev(s string) (IncludeDev, error) {
+	switch scope := strings.ToLower(strings.TrimSpace(s)); scope {
+	case "true", "":
+		return IncludeDevAll, nil
+	case "false":
+		return IncludeDevNone, nil
+	case "only":
+		return IncludeDevOnly, nil
+	default:
+		return IncludeDevAll, fmt.Errorf("include_dev must be true, false or only, got %q", s)
+	}
+}
+
+// UnmarshalYAML accepts a boolean as well as only
+func (i *IncludeDev) UnmarshalYAML(value *yaml.Node) error {
+	scope, err := ParseIncludeDev(value.Value)
+	if err != nil {
+		return err
+	}
+	*i = scope
+	return nil
+}
+
+// Age is a duration that can also be written in days or weeks, like 3d or 2w
+type Age time.Duration
+
+// ParseAge parses a Go duration like 36h or a whole number of days or weeks like 3d
+// or 2w. An empty string is no age.
+func ParseAge(s string) (Age, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit > 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q, use a duration like 36h or a number of days or weeks like 3d", s)
+		}
+		return Age(time.Duration(n) * unit), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q, use a duration like 36h or a number of days or weeks like 3d", s)
+	}
+	return Age(d), nil
+}
+
+// UnmarshalYAML accepts days and weeks besides Go durations
+func (a *Age) UnmarshalYAML(value *yaml.Node) error {
+	age, err := ParseAge(value.Value)
+	if err != nil {
+		return err
+	}
+	*a = age
+	return nil
+}
+
+// Commit modes
+const (
+	CommitModeGit = "git"
+	CommitModeAPI = "api"
+)
+
+// Branch update strategies
+const (
+	BranchUpdateForcePush = "force-push" // Force-push over the existing branch
+	BranchUpdateRecreate  = "recreate"   // Delete the branch and push it anew, replacing its pull request
+	BranchUpdateAppend    = "append"     // Add a commit on top of the existing branch
+)
+
+// What to do with update branches that have commits not made by updati
+const (
+	ForeignCommitsSkip   = "skip"   // Leave the branch alone and skip the repository
+	ForeignCommitsAppend = "append" // Add the update on top, keeping the commits
+)
+
+// Stale base branch strategies
+const (
+	StaleBaseWarn    = "warn"    // Keep updating the configured base branch, with a warning
+	StaleBaseDefault = "default" // Update the repository's default branch instead
+)
+
+// Missing PHP extension strategies
+const (
+	MissingExtensionsIgnore = "ignore" // Ignore only the missing extensions' platform requirements
+	MissingExtensionsSkip   = "skip"   // Skip the repository with the missing extensions as reason
+)
+
+// Node version managers
+const (
+	NodeManagerFnm   = "fnm"   // fnm exec --using <version>
+	NodeManagerVolta = "volta" // volta run --node <version>
+)
+
+// Rate limit budget strategies
+const (
+	RateLimitBudgetWarn    = "warn"    // Log a warning and run anyway
+	RateLimitBudgetStagger = "stagger" // Process repositories in batches, waiting for the limit to reset in between
+	RateLimitBudgetAbort   = "abort"   // Refuse to start the run
+)
+
 // DefaultConfig returns a config with sensible defaults
 func DefaultConfig() *Config {
 	return &Config{
-		Workers:        5,
-		UpdateComposer: true,
-		UpdateNPM:      true,
-		CreatePR:       true,
-		BaseBranch:     "main",
-		PRBranch:       "updati/dependencies",
-		CommitMessage:  "chore(deps): update dependencies",
-		PRTitle:        "⬆️ Update dependencies",
-		PRBody:         "This PR was automatically created by [Updati](https://github.com/janyksteenbeek/updati) to update project dependencies.",
-		Labels:         []string{"dependencies", "automated"},
+		Workers:           5,
+		PluginConcurrency: 1,
+		UpdateComposer:    true,
+		UpdateNPM:         true,
+		CreatePR:          true,
+		BaseBranch:        "main",
+		StaleBase:         StaleBaseWarn,
+		StaleBaseAfter:    50,
+		PRBranch:          "updati/dependencies",
+		CommitMessage:     "chore(deps): update dependencies",
+		CommitAuthorName:  "Updati Bot",
+		CommitAuthorEmail: "updati@github.com",
+		PRTitle:           "⬆️ Update dependencies",
+		PRBody:            "This PR was automatically created by [Updati](https://github.com/janyksteenbeek/updati) to update project dependencies.",
+		Labels:            []string{"dependencies", "automated"},
+		LabelColor:        "0366d6",
+		ChangelogLinks:    true,
+		ComposerValidate:  true,
+		ComposerFlags:     []string{"--prefer-dist", "--with-all-dependencies"},
+		SecurityLabel:     "security",
+		StateFile:         ".updati-state.json",
+		LockFile:          ".updati.lock",
+		LockTTL:           6 * time.Hour,
+		StaleTempAfter:    24 * time.Hour,
+		MinFreeDiskMB:     1024,
+		CheckpointFile:    ".updati-checkpoint.jsonl",
+		LabelDescription:  "Dependency updates by updati",
+		MergeMethod:       MergeMethodSquash,
+		CommitMode:        CommitModeGit,
+
+		BranchUpdateStrategy: BranchUpdateForcePush,
+		ForeignCommits:       ForeignCommitsSkip,
+
+		Retry:             retry.DefaultPolicy(),
+		MissingExtensions: MissingExtensionsIgnore,
+		ContainerRuntime:  "docker",
+		RateLimitBudget:   RateLimitBudgetWarn,
+
+		ServeAddr: ":8080",
+
+		LogLevel:  "info",
+		LogFormat: "text",
 	}
 }
 
@@ -67,75 +582,651 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
-	// Override with environment variables
-	cfg.applyEnvOverrides()
-
-	// Compile regex patterns
-	if err := cfg.CompilePatterns(); err != nil {
-		return nil, err
+	// Override with environment variables
+	cfg.applyEnvOverrides()
+
+	// Compile regex patterns
+	if err := cfg.CompilePatterns(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// LoadFromEnv loads configuration entirely from environment variables
+func LoadFromEnv() (*Config, error) {
+	cfg := DefaultConfig()
+	cfg.applyEnvOverrides()
+
+	if err := cfg.CompilePatterns(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// applyEnvOverrides applies environment variable overrides to config
+func (c *Config) applyEnvOverrides() {
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		c.GitHubToken = token
+	}
+	if token := os.Getenv("INPUT_GITHUB_TOKEN"); token != "" {
+		c.GitHubToken = token
+	}
+	if tokens := os.Getenv("UPDATI_GITHUB_TOKENS"); tokens != "" {
+		c.GitHubTokens = parsePatterns(tokens)
+	}
+	if tokens := os.Getenv("INPUT_GITHUB_TOKENS"); tokens != "" {
+		c.GitHubTokens = parsePatterns(tokens)
+	}
+	if token := os.Getenv("UPDATI_READ_TOKEN"); token != "" {
+		c.ReadToken = token
+	}
+	if token := os.Getenv("INPUT_READ_TOKEN"); token != "" {
+		c.ReadToken = token
+	}
+
+	if owner := os.Getenv("UPDATI_OWNER"); owner != "" {
+		c.Owner = owner
+	}
+	if owner := os.Getenv("INPUT_OWNER"); owner != "" {
+		c.Owner = owner
+	}
+
+	if patterns := os.Getenv("UPDATI_REPO_PATTERNS"); patterns != "" {
+		c.RepoPatterns = parsePatterns(patterns)
+	}
+	if patterns := os.Getenv("INPUT_REPO_PATTERNS"); patterns != "" {
+		c.RepoPatterns = parsePatterns(patterns)
+	}
+
+	if workers := os.Getenv("UPDATI_WORKERS"); workers != "" {
+		if w, err := strconv.Atoi(workers); err == nil && w > 0 {
+			c.Workers = w
+		}
+	}
+	if workers := os.Getenv("INPUT_WORKERS"); workers != "" {
+		if w, err := strconv.Atoi(workers); err == nil && w > 0 {
+			c.Workers = w
+		}
+	}
+	if workers := os.Getenv("UPDATI_MIN_WORKERS"); workers != "" {
+		if w, err := strconv.Atoi(workers); err == nil && w >= 0 {
+			c.MinWorkers = w
+		}
+	}
+	if workers := os.Getenv("INPUT_MIN_WORKERS"); workers != "" {
+		if w, err := strconv.Atoi(workers); err == nil && w >= 0 {
+			c.MinWorkers = w
+		}
+	}
+	if concurrency := os.Getenv("UPDATI_PLUGIN_CONCURRENCY"); concurrency != "" {
+		if n, err := strconv.Atoi(concurrency); err == nil && n > 0 {
+			c.PluginConcurrency = n
+		}
+	}
+	if concurrency := os.Getenv("INPUT_PLUGIN_CONCURRENCY"); concurrency != "" {
+		if n, err := strconv.Atoi(concurrency); err == nil && n > 0 {
+			c.PluginConcurrency = n
+		}
+	}
+	if installs := os.Getenv("UPDATI_MAX_CONCURRENT_INSTALLS"); installs != "" {
+		if n, err := strconv.Atoi(installs); err == nil && n >= 0 {
+			c.MaxConcurrentInstalls = n
+		}
+	}
+	if installs := os.Getenv("INPUT_MAX_CONCURRENT_INSTALLS"); installs != "" {
+		if n, err := strconv.Atoi(installs); err == nil && n >= 0 {
+			c.MaxConcurrentInstalls = n
+		}
+	}
+
+	if branch := os.Getenv("UPDATI_BASE_BRANCH"); branch != "" {
+		c.BaseBranch = branch
+	}
+	if branch := os.Getenv("INPUT_BASE_BRANCH"); branch != "" {
+		c.BaseBranch = branch
+	}
+
+	if branches := os.Getenv("UPDATI_BRANCHES"); branches != "" {
+		c.Branches = parsePatterns(branches)
+	}
+	if branches := os.Getenv("INPUT_BRANCHES"); branches != "" {
+		c.Branches = parsePatterns(branches)
+	}
+
+	if staleBase := os.Getenv("UPDATI_STALE_BASE"); staleBase != "" {
+		c.StaleBase = staleBase
+	}
+	if staleBase := os.Getenv("INPUT_STALE_BASE"); staleBase != "" {
+		c.StaleBase = staleBase
+	}
+
+	if dryRun := os.Getenv("UPDATI_DRY_RUN"); dryRun != "" {
+		if level, err := ParseDryRun(dryRun); err == nil {
+			c.DryRun = level
+		}
+	}
+	if dryRun := os.Getenv("INPUT_DRY_RUN"); dryRun != "" {
+		if level, err := ParseDryRun(dryRun); err == nil {
+			c.DryRun = level
+		}
+	}
+
+	if missing := os.Getenv("UPDATI_MISSING_EXTENSIONS"); missing != "" {
+		c.MissingExtensions = missing
+	}
+	if missing := os.Getenv("INPUT_MISSING_EXTENSIONS"); missing != "" {
+		c.MissingExtensions = missing
+	}
+
+	if tags := os.Getenv("UPDATI_TAGS"); tags != "" {
+		c.Tags = parsePatterns(tags)
+	}
+	if tags := os.Getenv("INPUT_TAGS"); tags != "" {
+		c.Tags = parsePatterns(tags)
+	}
+
+	if attempts := os.Getenv("UPDATI_RETRY_ATTEMPTS"); attempts != "" {
+		if a, err := strconv.Atoi(attempts); err == nil && a > 0 {
+			c.Retry.Attempts = a
+		}
+	}
+	if attempts := os.Getenv("INPUT_RETRY_ATTEMPTS"); attempts != "" {
+		if a, err := strconv.Atoi(attempts); err == nil && a > 0 {
+			c.Retry.Attempts = a
+		}
+	}
+
+	if budget := os.Getenv("UPDATI_RATE_LIMIT_BUDGET"); budget != "" {
+		c.RateLimitBudget = budget
+	}
+	if budget := os.Getenv("INPUT_RATE_LIMIT_BUDGET"); budget != "" {
+		c.RateLimitBudget = budget
+	}
+
+	if metricsFile := os.Getenv("UPDATI_METRICS_FILE"); metricsFile != "" {
+		c.MetricsFile = metricsFile
+	}
+	if metricsFile := os.Getenv("INPUT_METRICS_FILE"); metricsFile != "" {
+		c.MetricsFile = metricsFile
+	}
+
+	if reportFile := os.Getenv("UPDATI_REPORT_FILE"); reportFile != "" {
+		c.ReportFile = reportFile
+	}
+	if reportFile := os.Getenv("INPUT_REPORT_FILE"); reportFile != "" {
+		c.ReportFile = reportFile
+	}
+
+	if level := os.Getenv("UPDATI_LOG_LEVEL"); level != "" {
+		c.LogLevel = level
+	}
+	if level := os.Getenv("INPUT_LOG_LEVEL"); level != "" {
+		c.LogLevel = level
+	}
+
+	if format := os.Getenv("UPDATI_LOG_FORMAT"); format != "" {
+		c.LogFormat = format
+	}
+	if format := os.Getenv("INPUT_LOG_FORMAT"); format != "" {
+		c.LogFormat = format
+	}
+
+	if verbose := os.Getenv("UPDATI_VERBOSE"); verbose == "true" {
+		c.Verbose = true
+	}
+	if verbose := os.Getenv("INPUT_VERBOSE"); verbose == "true" {
+		c.Verbose = true
+	}
+
+	if freshness := os.Getenv("UPDATI_FRESHNESS"); freshness != "" {
+		c.Freshness = freshness == "true"
+	}
+	if freshness := os.Getenv("INPUT_FRESHNESS"); freshness != "" {
+		c.Freshness = freshness == "true"
+	}
+
+	if cacheDir := os.Getenv("UPDATI_CACHE_DIR"); cacheDir != "" {
+		c.CacheDir = cacheDir
+	}
+	if cacheDir := os.Getenv("INPUT_CACHE_DIR"); cacheDir != "" {
+		c.CacheDir = cacheDir
+	}
+	if timeout := os.Getenv("UPDATI_REPO_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil {
+			c.RepoTimeout = d
+		}
+	}
+	if timeout := os.Getenv("INPUT_REPO_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil {
+			c.RepoTimeout = d
+		}
+	}
+	if includeDev := os.Getenv("UPDATI_INCLUDE_DEV"); includeDev != "" {
+		if scope, err := ParseIncludeDev(includeDev); err == nil {
+			c.IncludeDev = scope
+		}
+	}
+	if includeDev := os.Getenv("INPUT_INCLUDE_DEV"); includeDev != "" {
+		if scope, err := ParseIncludeDev(includeDev); err == nil {
+			c.IncludeDev = scope
+		}
+	}
+	if age := os.Getenv("UPDATI_MIN_RELEASE_AGE"); age != "" {
+		if a, err := ParseAge(age); err == nil {
+			c.MinReleaseAge = a
+		}
+	}
+	if age := os.Getenv("INPUT_MIN_RELEASE_AGE"); age != "" {
+		if a, err := ParseAge(age); err == nil {
+			c.MinReleaseAge = a
+		}
+	}
+	if maxDuration := os.Getenv("UPDATI_MAX_DURATION"); maxDuration != "" {
+		if d, err := time.ParseDuration(maxDuration); err == nil {
+			c.MaxDuration = d
+		}
+	}
+	if maxDuration := os.Getenv("INPUT_MAX_DURATION"); maxDuration != "" {
+		if d, err := time.ParseDuration(maxDuration); err == nil {
+			c.MaxDuration = d
+		}
+	}
+	if stateFile := os.Getenv("UPDATI_STATE_FILE"); stateFile != "" {
+		c.StateFile = stateFile
+	}
+	if stateFile := os.Getenv("INPUT_STATE_FILE"); stateFile != "" {
+		c.StateFile = stateFile
+	}
+	if lockFile := os.Getenv("UPDATI_LOCK_FILE"); lockFile != "" {
+		c.LockFile = lockFile
+	}
+	if lockFile := os.Getenv("INPUT_LOCK_FILE"); lockFile != "" {
+		c.LockFile = lockFile
+	}
+	if lockRepo := os.Getenv("UPDATI_LOCK_REPOSITORY"); lockRepo != "" {
+		c.LockRepository = lockRepo
+	}
+	if lockRepo := os.Getenv("INPUT_LOCK_REPOSITORY"); lockRepo != "" {
+		c.LockRepository = lockRepo
+	}
+	if ttl := os.Getenv("UPDATI_LOCK_TTL"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err == nil {
+			c.LockTTL = d
+		}
+	}
+	if ttl := os.Getenv("INPUT_LOCK_TTL"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err == nil {
+			c.LockTTL = d
+		}
+	}
+	if workDir := os.Getenv("UPDATI_WORKDIR"); workDir != "" {
+		c.WorkDir = workDir
+	}
+	if workDir := os.Getenv("INPUT_WORKDIR"); workDir != "" {
+		c.WorkDir = workDir
+	}
+	if after := os.Getenv("UPDATI_STALE_TEMP_AFTER"); after != "" {
+		if d, err := time.ParseDuration(after); err == nil {
+			c.StaleTempAfter = d
+		}
+	}
+	if after := os.Getenv("INPUT_STALE_TEMP_AFTER"); after != "" {
+		if d, err := time.ParseDuration(after); err == nil {
+			c.StaleTempAfter = d
+		}
+	}
+	if freeDisk := os.Getenv("UPDATI_MIN_FREE_DISK_MB"); freeDisk != "" {
+		if n, err := strconv.Atoi(freeDisk); err == nil {
+			c.MinFreeDiskMB = n
+		}
+	}
+	if freeDisk := os.Getenv("INPUT_MIN_FREE_DISK_MB"); freeDisk != "" {
+		if n, err := strconv.Atoi(freeDisk); err == nil {
+			c.MinFreeDiskMB = n
+		}
+	}
+	if historyFile := os.Getenv("UPDATI_HISTORY_FILE"); historyFile != "" {
+		c.HistoryFile = historyFile
+	}
+	if historyFile := os.Getenv("INPUT_HISTORY_FILE"); historyFile != "" {
+		c.HistoryFile = historyFile
+	}
+	if auditLog := os.Getenv("UPDATI_AUDIT_LOG"); auditLog != "" {
+		c.AuditLog = auditLog
+	}
+	if auditLog := os.Getenv("INPUT_AUDIT_LOG"); auditLog != "" {
+		c.AuditLog = auditLog
+	}
+	if pluginDir := os.Getenv("UPDATI_PLUGIN_DIR"); pluginDir != "" {
+		c.PluginDir = pluginDir
+	}
+	if pluginDir := os.Getenv("INPUT_PLUGIN_DIR"); pluginDir != "" {
+		c.PluginDir = pluginDir
+	}
+	if order := os.Getenv("UPDATI_PLUGIN_ORDER"); order != "" {
+		c.PluginOrder = parsePatterns(order)
+	}
+	if order := os.Getenv("INPUT_PLUGIN_ORDER"); order != "" {
+		c.PluginOrder = parsePatterns(order)
+	}
+
+	if testCommand := os.Getenv("UPDATI_TEST_COMMAND"); testCommand != "" {
+		c.TestCommand = testCommand
+	}
+	if testCommand := os.Getenv("INPUT_TEST_COMMAND"); testCommand != "" {
+		c.TestCommand = testCommand
+	}
+
+	if buildCommand := os.Getenv("UPDATI_BUILD_COMMAND"); buildCommand != "" {
+		c.BuildCommand = buildCommand
+	}
+	if buildCommand := os.Getenv("INPUT_BUILD_COMMAND"); buildCommand != "" {
+		c.BuildCommand = buildCommand
+	}
+	if buildPaths := os.Getenv("UPDATI_BUILD_PATHS"); buildPaths != "" {
+		c.BuildPaths = parsePatterns(buildPaths)
+	}
+	if buildPaths := os.Getenv("INPUT_BUILD_PATHS"); buildPaths != "" {
+		c.BuildPaths = parsePatterns(buildPaths)
+	}
+
+	if checkRun := os.Getenv("UPDATI_CHECK_RUN"); checkRun != "" {
+		c.CheckRun = checkRun == "true"
+	}
+	if checkRun := os.Getenv("INPUT_CHECK_RUN"); checkRun != "" {
+		c.CheckRun = checkRun == "true"
+	}
+
+	if limit := os.Getenv("UPDATI_MAX_PRS_PER_RUN"); limit != "" {
+		if n, err := strconv.Atoi(limit); err == nil && n >= 0 {
+			c.MaxPRsPerRun = n
+		}
+	}
+	if limit := os.Getenv("INPUT_MAX_PRS_PER_RUN"); limit != "" {
+		if n, err := strconv.Atoi(limit); err == nil && n >= 0 {
+			c.MaxPRsPerRun = n
+		}
+	}
+	if limit := os.Getenv("UPDATI_MAX_OPEN_PRS_PER_REPO"); limit != "" {
+		if n, err := strconv.Atoi(limit); err == nil && n >= 0 {
+			c.MaxOpenPRsPerRepo = n
+		}
+	}
+	if limit := os.Getenv("INPUT_MAX_OPEN_PRS_PER_REPO"); limit != "" {
+		if n, err := strconv.Atoi(limit); err == nil && n >= 0 {
+			c.MaxOpenPRsPerRepo = n
+		}
+	}
+
+	if after := os.Getenv("UPDATI_FAILURE_ISSUE_AFTER"); after != "" {
+		if n, err := strconv.Atoi(after); err == nil && n >= 0 {
+			c.FailureIssueAfter = n
+		}
+	}
+	if after := os.Getenv("INPUT_FAILURE_ISSUE_AFTER"); after != "" {
+		if n, err := strconv.Atoi(after); err == nil && n >= 0 {
+			c.FailureIssueAfter = n
+		}
+	}
+
+	if issue := os.Getenv("UPDATI_ADVISORY_ISSUE"); issue != "" {
+		c.AdvisoryIssue = issue == "true"
+	}
+	if issue := os.Getenv("INPUT_ADVISORY_ISSUE"); issue != "" {
+		c.AdvisoryIssue = issue == "true"
+	}
+
+	if checkpoint := os.Getenv("UPDATI_CHECKPOINT_FILE"); checkpoint != "" {
+		c.CheckpointFile = checkpoint
+	}
+	if checkpoint := os.Getenv("INPUT_CHECKPOINT_FILE"); checkpoint != "" {
+		c.CheckpointFile = checkpoint
+	}
+
+	if security := os.Getenv("UPDATI_SECURITY_ANNOTATIONS"); security != "" {
+		c.SecurityAnnotations = security == "true"
+	}
+	if security := os.Getenv("INPUT_SECURITY_ANNOTATIONS"); security != "" {
+		c.SecurityAnnotations = security == "true"
+	}
+
+	if changelog := os.Getenv("UPDATI_CHANGELOG_LINKS"); changelog != "" {
+		c.ChangelogLinks = changelog == "true"
+	}
+	if changelog := os.Getenv("INPUT_CHANGELOG_LINKS"); changelog != "" {
+		c.ChangelogLinks = changelog == "true"
+	}
+
+	if risk := os.Getenv("UPDATI_RISK_SCORE"); risk != "" {
+		c.RiskScore = risk == "true"
+	}
+	if risk := os.Getenv("INPUT_RISK_SCORE"); risk != "" {
+		c.RiskScore = risk == "true"
+	}
+
+	if createLabels := os.Getenv("UPDATI_CREATE_MISSING_LABELS"); createLabels != "" {
+		c.CreateMissingLabels = createLabels == "true"
+	}
+	if createLabels := os.Getenv("INPUT_CREATE_MISSING_LABELS"); createLabels != "" {
+		c.CreateMissingLabels = createLabels == "true"
+	}
+
+	if auditGate := os.Getenv("UPDATI_AUDIT_GATE"); auditGate != "" {
+		c.AuditGate = auditGate == "true"
+	}
+	if auditGate := os.Getenv("INPUT_AUDIT_GATE"); auditGate != "" {
+		c.AuditGate = auditGate == "true"
+	}
+	if flags := os.Getenv("UPDATI_COMPOSER_FLAGS"); flags != "" {
+		c.ComposerFlags = strings.Fields(flags)
+	}
+	if flags := os.Getenv("INPUT_COMPOSER_FLAGS"); flags != "" {
+		c.ComposerFlags = strings.Fields(flags)
+	}
+
+	if auth := os.Getenv("UPDATI_COMPOSER_AUTH"); auth != "" {
+		c.ComposerAuth = auth
+	}
+	if auth := os.Getenv("INPUT_COMPOSER_AUTH"); auth != "" {
+		c.ComposerAuth = auth
+	}
+	if npmrc := os.Getenv("UPDATI_NPMRC"); npmrc != "" {
+		c.NPMRC = npmrc
+	}
+	if npmrc := os.Getenv("INPUT_NPMRC"); npmrc != "" {
+		c.NPMRC = npmrc
+	}
+	if mirror := os.Getenv("UPDATI_COMPOSER_MIRROR"); mirror != "" {
+		c.ComposerMirror = mirror
+	}
+	if mirror := os.Getenv("INPUT_COMPOSER_MIRROR"); mirror != "" {
+		c.ComposerMirror = mirror
+	}
+	if registry := os.Getenv("UPDATI_NPM_REGISTRY"); registry != "" {
+		c.NPMRegistry = registry
+	}
+	if registry := os.Getenv("INPUT_NPM_REGISTRY"); registry != "" {
+		c.NPMRegistry = registry
+	}
+
+	if validate := os.Getenv("UPDATI_COMPOSER_VALIDATE"); validate != "" {
+		c.ComposerValidate = validate == "true"
+	}
+	if validate := os.Getenv("INPUT_COMPOSER_VALIDATE"); validate != "" {
+		c.ComposerValidate = validate == "true"
+	}
+
+	if depth := os.Getenv("UPDATI_CLONE_DEPTH"); depth != "" {
+		if d, err := strconv.Atoi(depth); err == nil && d >= 0 {
+			c.CloneDepth = d
+		}
+	}
+	if depth := os.Getenv("INPUT_CLONE_DEPTH"); depth != "" {
+		if d, err := strconv.Atoi(depth); err == nil && d >= 0 {
+			c.CloneDepth = d
+		}
+	}
+	if partial := os.Getenv("UPDATI_PARTIAL_CLONE"); partial != "" {
+		c.PartialClone = partial == "true"
+	}
+	if partial := os.Getenv("INPUT_PARTIAL_CLONE"); partial != "" {
+		c.PartialClone = partial == "true"
+	}
+
+	if mirrorDir := os.Getenv("UPDATI_MIRROR_DIR"); mirrorDir != "" {
+		c.MirrorDir = mirrorDir
+	}
+	if mirrorDir := os.Getenv("INPUT_MIRROR_DIR"); mirrorDir != "" {
+		c.MirrorDir = mirrorDir
+	}
+
+	if logDir := os.Getenv("UPDATI_LOG_DIR"); logDir != "" {
+		c.LogDir = logDir
+	}
+	if logDir := os.Getenv("INPUT_LOG_DIR"); logDir != "" {
+		c.LogDir = logDir
+	}
+
+	if addr := os.Getenv("UPDATI_SERVE_ADDR"); addr != "" {
+		c.ServeAddr = addr
+	}
+	if addr := os.Getenv("INPUT_SERVE_ADDR"); addr != "" {
+		c.ServeAddr = addr
+	}
+	if secret := os.Getenv("UPDATI_WEBHOOK_SECRET"); secret != "" {
+		c.WebhookSecret = secret
+	}
+	if secret := os.Getenv("INPUT_WEBHOOK_SECRET"); secret != "" {
+		c.WebhookSecret = secret
+	}
+	if token := os.Getenv("UPDATI_SERVE_TOKEN"); token != "" {
+		c.ServeToken = token
+	}
+	if token := os.Getenv("INPUT_SERVE_TOKEN"); token != "" {
+		c.ServeToken = token
+	}
+	if urls := os.Getenv("UPDATI_TEAMS_WEBHOOKS"); urls != "" {
+		c.Notifications.Teams = parsePatterns(urls)
+	}
+	if urls := os.Getenv("INPUT_TEAMS_WEBHOOKS"); urls != "" {
+		c.Notifications.Teams = parsePatterns(urls)
+	}
+	if urls := os.Getenv("UPDATI_DISCORD_WEBHOOKS"); urls != "" {
+		c.Notifications.Discord = parsePatterns(urls)
+	}
+	if urls := os.Getenv("INPUT_DISCORD_WEBHOOKS"); urls != "" {
+		c.Notifications.Discord = parsePatterns(urls)
+	}
+	if urls := os.Getenv("UPDATI_NOTIFY_WEBHOOKS"); urls != "" {
+		c.Notifications.Webhook.URLs = parsePatterns(urls)
+	}
+	if urls := os.Getenv("INPUT_NOTIFY_WEBHOOKS"); urls != "" {
+		c.Notifications.Webhook.URLs = parsePatterns(urls)
+	}
+	if secret := os.Getenv("UPDATI_NOTIFY_WEBHOOK_SECRET"); secret != "" {
+		c.Notifications.Webhook.Secret = secret
+	}
+	if secret := os.Getenv("INPUT_NOTIFY_WEBHOOK_SECRET"); secret != "" {
+		c.Notifications.Webhook.Secret = secret
+	}
+
+	if ready := os.Getenv("UPDATI_READY_AFTER_CI"); ready != "" {
+		c.ReadyAfterCI = ready == "true"
 	}
-
-	return cfg, nil
-}
-
-// LoadFromEnv loads configuration entirely from environment variables
-func LoadFromEnv() (*Config, error) {
-	cfg := DefaultConfig()
-	cfg.applyEnvOverrides()
-
-	if err := cfg.CompilePatterns(); err != nil {
-		return nil, err
+	if ready := os.Getenv("INPUT_READY_AFTER_CI"); ready != "" {
+		c.ReadyAfterCI = ready == "true"
+	}
+	if autoMerge := os.Getenv("UPDATI_AUTO_MERGE"); autoMerge != "" {
+		c.AutoMerge = autoMerge == "true"
+	}
+	if autoMerge := os.Getenv("INPUT_AUTO_MERGE"); autoMerge != "" {
+		c.AutoMerge = autoMerge == "true"
 	}
 
-	return cfg, nil
-}
-
-// applyEnvOverrides applies environment variable overrides to config
-func (c *Config) applyEnvOverrides() {
-	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
-		c.GitHubToken = token
+	if method := os.Getenv("UPDATI_MERGE_METHOD"); method != "" {
+		c.MergeMethod = method
 	}
-	if token := os.Getenv("INPUT_GITHUB_TOKEN"); token != "" {
-		c.GitHubToken = token
+	if method := os.Getenv("INPUT_MERGE_METHOD"); method != "" {
+		c.MergeMethod = method
 	}
 
-	if owner := os.Getenv("UPDATI_OWNER"); owner != "" {
-		c.Owner = owner
+	if key := os.Getenv("UPDATI_SIGNING_KEY"); key != "" {
+		c.SigningKey = key
 	}
-	if owner := os.Getenv("INPUT_OWNER"); owner != "" {
-		c.Owner = owner
+	if key := os.Getenv("INPUT_SIGNING_KEY"); key != "" {
+		c.SigningKey = key
 	}
 
-	if patterns := os.Getenv("UPDATI_REPO_PATTERNS"); patterns != "" {
-		c.RepoPatterns = parsePatterns(patterns)
+	if strategy := os.Getenv("UPDATI_BRANCH_UPDATE_STRATEGY"); strategy != "" {
+		c.BranchUpdateStrategy = strategy
 	}
-	if patterns := os.Getenv("INPUT_REPO_PATTERNS"); patterns != "" {
-		c.RepoPatterns = parsePatterns(patterns)
+	if strategy := os.Getenv("INPUT_BRANCH_UPDATE_STRATEGY"); strategy != "" {
+		c.BranchUpdateStrategy = strategy
 	}
 
-	if workers := os.Getenv("UPDATI_WORKERS"); workers != "" {
-		if w, err := strconv.Atoi(workers); err == nil && w > 0 {
-			c.Workers = w
-		}
+	if green := os.Getenv("UPDATI_REQUIRE_GREEN_CI"); green != "" {
+		c.RequireGreenCI = green == "true"
 	}
-	if workers := os.Getenv("INPUT_WORKERS"); workers != "" {
-		if w, err := strconv.Atoi(workers); err == nil && w > 0 {
-			c.Workers = w
-		}
+	if green := os.Getenv("INPUT_REQUIRE_GREEN_CI"); green != "" {
+		c.RequireGreenCI = green == "true"
+	}
+	if templates := os.Getenv("UPDATI_UPDATE_TEMPLATES"); templates != "" {
+		c.UpdateTemplates = templates == "true"
+	}
+	if templates := os.Getenv("INPUT_UPDATE_TEMPLATES"); templates != "" {
+		c.UpdateTemplates = templates == "true"
+	}
+	if closeSuperseded := os.Getenv("UPDATI_CLOSE_SUPERSEDED"); closeSuperseded != "" {
+		c.CloseSuperseded = closeSuperseded == "true"
+	}
+	if closeSuperseded := os.Getenv("INPUT_CLOSE_SUPERSEDED"); closeSuperseded != "" {
+		c.CloseSuperseded = closeSuperseded == "true"
+	}
+	if deleteBranches := os.Getenv("UPDATI_DELETE_SUPERSEDED_BRANCHES"); deleteBranches != "" {
+		c.DeleteSupersededBranches = deleteBranches == "true"
+	}
+	if deleteBranches := os.Getenv("INPUT_DELETE_SUPERSEDED_BRANCHES"); deleteBranches != "" {
+		c.DeleteSupersededBranches = deleteBranches == "true"
 	}
 
-	if branch := os.Getenv("UPDATI_BASE_BRANCH"); branch != "" {
-		c.BaseBranch = branch
+	if foreign := os.Getenv("UPDATI_FOREIGN_COMMITS"); foreign != "" {
+		c.ForeignCommits = foreign
 	}
-	if branch := os.Getenv("INPUT_BASE_BRANCH"); branch != "" {
-		c.BaseBranch = branch
+	if foreign := os.Getenv("INPUT_FOREIGN_COMMITS"); foreign != "" {
+		c.ForeignCommits = foreign
 	}
 
-	if dryRun := os.Getenv("UPDATI_DRY_RUN"); dryRun == "true" {
-		c.DryRun = true
+	if mode := os.Getenv("UPDATI_COMMIT_MODE"); mode != "" {
+		c.CommitMode = mode
+	}
+	if mode := os.Getenv("INPUT_COMMIT_MODE"); mode != "" {
+		c.CommitMode = mode
+	}
+	if name := os.Getenv("UPDATI_COMMIT_AUTHOR_NAME"); name != "" {
+		c.CommitAuthorName = name
 	}
-	if dryRun := os.Getenv("INPUT_DRY_RUN"); dryRun == "true" {
-		c.DryRun = true
+	if name := os.Getenv("INPUT_COMMIT_AUTHOR_NAME"); name != "" {
+		c.CommitAuthorName = name
+	}
+	if email := os.Getenv("UPDATI_COMMIT_AUTHOR_EMAIL"); email != "" {
+		c.CommitAuthorEmail = email
+	}
+	if email := os.Getenv("INPUT_COMMIT_AUTHOR_EMAIL"); email != "" {
+		c.CommitAuthorEmail = email
+	}
+	if signOff := os.Getenv("UPDATI_SIGN_OFF"); signOff != "" {
+		c.SignOff = signOff == "true"
+	}
+	if signOff := os.Getenv("INPUT_SIGN_OFF"); signOff != "" {
+		c.SignOff = signOff == "true"
+	}
+	if perPlugin := os.Getenv("UPDATI_COMMIT_PER_PLUGIN"); perPlugin != "" {
+		c.CommitPerPlugin = perPlugin == "true"
+	}
+	if perPlugin := os.Getenv("INPUT_COMMIT_PER_PLUGIN"); perPlugin != "" {
+		c.CommitPerPlugin = perPlugin == "true"
 	}
 
 	if createPR := os.Getenv("UPDATI_CREATE_PR"); createPR != "" {
@@ -145,18 +1236,23 @@ func (c *Config) applyEnvOverrides() {
 		c.CreatePR = createPR == "true"
 	}
 
+	// These also override the plugins setting, which otherwise decides over them
 	if updateComposer := os.Getenv("UPDATI_UPDATE_COMPOSER"); updateComposer != "" {
 		c.UpdateComposer = updateComposer == "true"
+		c.Plugins.setEnabled("composer", c.UpdateComposer)
 	}
 	if updateComposer := os.Getenv("INPUT_UPDATE_COMPOSER"); updateComposer != "" {
 		c.UpdateComposer = updateComposer == "true"
+		c.Plugins.setEnabled("composer", c.UpdateComposer)
 	}
 
 	if updateNPM := os.Getenv("UPDATI_UPDATE_NPM"); updateNPM != "" {
 		c.UpdateNPM = updateNPM == "true"
+		c.Plugins.setEnabled("npm", c.UpdateNPM)
 	}
 	if updateNPM := os.Getenv("INPUT_UPDATE_NPM"); updateNPM != "" {
 		c.UpdateNPM = updateNPM == "true"
+		c.Plugins.setEnabled("npm", c.UpdateNPM)
 	}
 }
 
@@ -177,9 +1273,89 @@ func (c *Config) CompilePatterns() error {
 		c.compiledPatterns = append(c.compiledPatterns, re)
 	}
 
+	for i := range c.Overrides {
+		re, err := regexp.Compile(c.Overrides[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid override pattern %q: %w", c.Overrides[i].Pattern, err)
+		}
+		c.Overrides[i].compiled = re
+	}
+
+	for i := range c.Rules {
+		c.Rules[i].packages = compileGlobs(c.Rules[i].Packages)
+		c.Rules[i].versions = compileGlobs(c.Rules[i].Versions)
+	}
+
 	return nil
 }
 
+// PluginEnabled reports whether the plugin with the given name should run. --only and
+// --skip decide over the plugins setting, and plugins over update_composer and
+// update_npm of the config file, which predate it; set through the environment or a
+// run's config, those are applied to plugins. Plugins run unless disabled.
+func (c *Config) PluginEnabled(name string) bool {
+	if slices.Contains(c.SkipPlugins, name) {
+		return false
+	}
+	if len(c.OnlyPlugins) > 0 {
+		return slices.Contains(c.OnlyPlugins, name)
+	}
+
+	if enabled := c.Plugins[name].Enabled; enabled != nil {
+		return *enabled
+	}
+	legacy := map[string]bool{"composer": c.UpdateComposer, "npm": c.UpdateNPM}
+	if enabled, ok := legacy[name]; ok {
+		return enabled
+	}
+	return true
+}
+
+// ToolsImage is updati's own image with PHP 8.2 to 8.4, composer and Node, selected
+// as a plugin image with ToolsImageAlias
+const (
+	ToolsImage      = "ghcr.io/janyksteenbeek/updati-tools:1"
+	ToolsImageAlias = "tools"
+)
+
+// PluginImage returns the container image to run the plugin's package manager in, from
+// plugins or plugin_images, or an empty string to run it on the host
+func (c *Config) PluginImage(name string) string {
+	image := c.Plugins[name].Image
+	if image == "" {
+		image = c.PluginImages[name]
+	}
+	if image == ToolsImageAlias {
+		return ToolsImage
+	}
+	return image
+}
+
+// UsesImages reports whether any plugin runs its package manager in a container image
+func (c *Config) UsesImages() bool {
+	if len(c.PluginImages) > 0 {
+		return true
+	}
+	for _, plugin := range c.Plugins {
+		if plugin.Image != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ForRepo returns the effective configuration for a repository, with all matching
+// overrides applied in order
+func (c *Config) ForRepo(repoName string) *Config {
+	effective := *c
+	for i := range c.Overrides {
+		if o := &c.Overrides[i]; o.compiled != nil && o.compiled.MatchString(repoName) {
+			o.apply(&effective)
+		}
+	}
+	return &effective
+}
+
 // MatchesRepo checks if a repository name matches any of the configured patterns
 func (c *Config) MatchesRepo(repoName string) bool {
 	// If no patterns configured, match all
@@ -196,7 +1372,164 @@ func (c *Config) MatchesRepo(repoName string) bool {
 	return false
 }
 
-// parsePatterns parses patterns from a string (supports newlines and commas)
+// ReadGitHubToken returns the token for read-only operations: the read token, or the
+// GitHub token when no separate read token is configured
+func (c *Config) ReadGitHubToken() string {
+	if c.ReadToken != "" {
+		return c.ReadToken
+	}
+	return c.GitHubToken
+}
+
+// Mode describes how updates are delivered: dry-run, pull-request or direct-push
+func (c *Config) Mode() string {
+	if c.DryRun.Enabled() {
+		return "dry-run"
+	}
+	if c.CreatePR {
+		return "pull-request"
+	}
+	return "direct-push"
+}
+
+// WithYAML returns a copy of the config with the settings of a YAML (or JSON) document
+// applied on top, like an inline override of a single run. Settings the document leaves
+// out keep their value and the config itself is left untouched.
+func (c *Config) WithYAML(data []byte) (*Config, error) {
+	merged := *c
+
+	// Decoding merges into existing maps and compiling writes into the slices, so
+	// neither may be shared with c
+	merged.Tools = maps.Clone(c.Tools)
+	merged.ComposerPlatform = maps.Clone(c.ComposerPlatform)
+	merged.PHPBinaries = maps.Clone(c.PHPBinaries)
+	merged.NodeBinaries = maps.Clone(c.NodeBinaries)
+	merged.PluginImages = maps.Clone(c.PluginImages)
+	merged.Plugins = maps.Clone(c.Plugins)
+	merged.CommitMessages = maps.Clone(c.CommitMessages)
+	merged.PluginAfter = maps.Clone(c.PluginAfter)
+	merged.Overrides = slices.Clone(c.Overrides)
+	merged.Rules = slices.Clone(c.Rules)
+
+	if err := yaml.Unmarshal(data, &merged); err != nil {
+		return nil, fmt.Errorf("failed to parse config override: %w", err)
+	}
+
+	// The legacy switches given here override the plugins setting of c
+	var legacy struct {
+		UpdateComposer *bool `yaml:"update_composer"`
+		UpdateNPM      *bool `yaml:"update_npm"`
+	}
+	if err := yaml.Unmarshal(data, &legacy); err != nil {
+		return nil, fmt.Errorf("failed to parse config override: %w", err)
+	}
+	if legacy.UpdateComposer != nil {
+		merged.Plugins.setEnabled("composer", *legacy.UpdateComposer)
+	}
+	if legacy.UpdateNPM != nil {
+		merged.Plugins.setEnabled("npm", *legacy.UpdateNPM)
+	}
+
+	if err := merged.CompilePatterns(); err != nil {
+		return nil, err
+	}
+
+	return &merged, nil
+}
+
+// BranchPrefix returns the prefix shared by all update branches: the PR branch up to
+// its last slash, or the PR branch itself when it has none. Of a template, only the text
+// before its first action counts.
+func (c *Config) BranchPrefix() string {
+	static, _, _ := strings.Cut(c.PRBranch, "{{")
+	if i := strings.LastIndex(static, "/"); i >= 0 {
+		return static[:i+1]
+	}
+	return static
+}
+
+// CommitMessage holds what commit_messages templates are rendered with
+type CommitMessage struct {
+	Plugin string // Name of the plugin whose changes are committed
+	Scope  string // Directory of the changed files in a monorepo, e.g. packages/web, or the plugin name when they are at the root
+}
+
+// RenderCommitMessage renders a commit message template
+func RenderCommitMessage(message string, data CommitMessage) (string, error) {
+	tmpl, err := template.New("commit_message").Option("missingkey=error").Parse(message)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(b.String()) == "" {
+		return "", fmt.Errorf("renders an empty message")
+	}
+	return b.String(), nil
+}
+
+// BranchName holds what a pr_branch template is rendered with
+type BranchName struct {
+	Repository string // Repository name, without owner
+	Base       string // Base branch, with slashes replaced by dashes
+	Plugins    string // Plugins that apply to the repository, joined by dashes, e.g. composer-npm
+	Date       string // Day of the run in UTC, e.g. 2026-01-31
+}
+
+// PRBranchName renders pr_branch for an update. A pr_branch without template actions is
+// returned as is.
+func (c *Config) PRBranchName(name BranchName) (string, error) {
+	if !strings.Contains(c.PRBranch, "{{") {
+		return c.PRBranch, nil
+	}
+
+	tmpl, err := template.New("pr_branch").Option("missingkey=error").Parse(c.PRBranch)
+	if err != nil {
+		return "", fmt.Errorf("invalid pr_branch template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, name); err != nil {
+		return "", fmt.Errorf("invalid pr_branch template: %w", err)
+	}
+
+	branch := strings.TrimSpace(b.String())
+	if !validBranchName(branch) {
+		return "", fmt.Errorf("pr_branch template renders %q, which is not a valid branch name", branch)
+	}
+	return branch, nil
+}
+
+// BranchPerBase reports whether pr_branch tells the update branches of different base
+// branches apart by itself
+func (c *Config) BranchPerBase() bool {
+	return strings.Contains(c.PRBranch, ".Base")
+}
+
+// validBranchName reports whether git accepts the name as a branch, see
+// git check-ref-format
+func validBranchName(name string) bool {
+	if name == "" || name == "@" || strings.ContainsAny(name, " ~^:?*[\\\x7f") ||
+		strings.Contains(name, "..") || strings.Contains(name, "//") || strings.Contains(name, "@{") ||
+		strings.HasPrefix(name, "/") || strings.HasPrefix(name, "-") ||
+		strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") || strings.HasSuffix(name, ".lock") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	for _, r := range name {
+		if r < 0x20 {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePatterns parses patterns or other lists from a string (supports newlines and commas)
 func parsePatterns(input string) []string {
 	var patterns []string
 
@@ -234,6 +1567,276 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("workers cannot exceed 20 (GitHub rate limits)")
 	}
 
+	if c.MinWorkers < 0 || c.MinWorkers > c.Workers {
+		return fmt.Errorf("min_workers must be between 0 and workers")
+	}
+
+	if c.PluginConcurrency < 1 {
+		return fmt.Errorf("plugin_concurrency must be at least 1")
+	}
+
+	if c.MaxConcurrentInstalls < 0 {
+		return fmt.Errorf("max_concurrent_installs cannot be negative")
+	}
+
+	// The history stores tags joined by commas
+	for _, tag := range c.Tags {
+		if tag == "" || strings.Contains(tag, ",") {
+			return fmt.Errorf("tags must be non-empty and cannot contain commas, got %q", tag)
+		}
+	}
+
+	switch c.StaleBase {
+	case StaleBaseWarn, StaleBaseDefault:
+	default:
+		return fmt.Errorf("stale_base must be warn or default, got %q", c.StaleBase)
+	}
+
+	for plugin, message := range c.CommitMessages {
+		if _, err := RenderCommitMessage(message, CommitMessage{Plugin: plugin, Scope: plugin}); err != nil {
+			return fmt.Errorf("invalid commit_messages.%s: %w", plugin, err)
+		}
+	}
+
+	if _, err := template.New("pr_body").Funcs(template.FuncMap{"include": func(string) string { return "" }}).Parse(c.PRBody); err != nil {
+		return fmt.Errorf("invalid pr_body template: %w", err)
+	}
+
+	if _, err := c.PRBranchName(BranchName{Repository: "repo", Base: "main", Plugins: "composer-npm", Date: "2006-01-02"}); err != nil {
+		return err
+	}
+	// Without a fixed prefix, cleanup, close_superseded and promote would take every
+	// branch for an update branch
+	if strings.TrimSpace(c.BranchPrefix()) == "" {
+		return fmt.Errorf("pr_branch must start with fixed text before its first template action, e.g. updati/{{ .Date }}, got %q", c.PRBranch)
+	}
+
+	if c.RepoTimeout < 0 {
+		return fmt.Errorf("repo_timeout cannot be negative")
+	}
+
+	if c.MaxDuration < 0 {
+		return fmt.Errorf("max_duration cannot be negative")
+	}
+
+	if c.MaxDuration > 0 && c.StateFile == "" {
+		return fmt.Errorf("max_duration needs a state_file to continue from")
+	}
+	if c.Resume && c.CheckpointFile == "" {
+		return fmt.Errorf("resuming needs a checkpoint_file to resume from")
+	}
+
+	if c.LockRepository != "" && c.LockTTL <= 0 {
+		return fmt.Errorf("lock_ttl must be positive with a lock_repository")
+	}
+
+	if c.StaleTempAfter < 0 {
+		return fmt.Errorf("stale_temp_after cannot be negative")
+	}
+	if c.MinFreeDiskMB < 0 {
+		return fmt.Errorf("min_free_disk_mb cannot be negative")
+	}
+
+	if c.CloneDepth < 0 {
+		return fmt.Errorf("clone_depth cannot be negative")
+	}
+
+	if c.MaxPRsPerRun < 0 || c.MaxOpenPRsPerRepo < 0 {
+		return fmt.Errorf("max_prs_per_run and max_open_prs_per_repo cannot be negative")
+	}
+
+	if c.FailureIssueAfter < 0 {
+		return fmt.Errorf("failure_issue_after cannot be negative")
+	}
+	if c.FailureIssueAfter > 0 && c.StateFile == "" {
+		return fmt.Errorf("failure_issue_after needs a state_file to count failures in")
+	}
+
+	if c.Retry.Attempts < 1 {
+		return fmt.Errorf("retry.attempts must be at least 1")
+	}
+	if c.Retry.Jitter < 0 || c.Retry.Jitter > 1 {
+		return fmt.Errorf("retry.jitter must be between 0 and 1")
+	}
+
+	if err := validateMergeMethod(c.MergeMethod); err != nil {
+		return err
+	}
+	if c.CommitMode != CommitModeGit && c.CommitMode != CommitModeAPI {
+		return fmt.Errorf("commit_mode must be git or api, got %q", c.CommitMode)
+	}
+	if err := validateBranchUpdateStrategy(c.BranchUpdateStrategy); err != nil {
+		return err
+	}
+	if c.ForeignCommits != ForeignCommitsSkip && c.ForeignCommits != ForeignCommitsAppend {
+		return fmt.Errorf("foreign_commits must be skip or append, got %q", c.ForeignCommits)
+	}
+	if c.CreateMissingLabels && !labelColorPattern.MatchString(c.LabelColor) {
+		return fmt.Errorf("label_color must be a 6 digit hex color without #, got %q", c.LabelColor)
+	}
+	for _, o := range c.Overrides {
+		if o.MergeMethod != "" {
+			if err := validateMergeMethod(o.MergeMethod); err != nil {
+				return fmt.Errorf("override %q: %w", o.Pattern, err)
+			}
+		}
+		if o.BranchUpdateStrategy != "" {
+			if err := validateBranchUpdateStrategy(o.BranchUpdateStrategy); err != nil {
+				return fmt.Errorf("override %q: %w", o.Pattern, err)
+			}
+		}
+		if err := validateComposerFlags(o.ComposerFlags); err != nil {
+			return fmt.Errorf("override %q: %w", o.Pattern, err)
+		}
+	}
+	if err := validateComposerFlags(c.ComposerFlags); err != nil {
+		return err
+	}
+	for name, plugin := range c.Plugins {
+		for _, flag := range plugin.Flags {
+			if !strings.HasPrefix(flag, "-") {
+				return fmt.Errorf("plugins.%s.flags must only contain flags, got %q", name, flag)
+			}
+		}
+		if plugin.Timeout < 0 {
+			return fmt.Errorf("plugins.%s.timeout must not be negative", name)
+		}
+		if plugin.MemoryLimitMB < 0 {
+			return fmt.Errorf("plugins.%s.memory_limit_mb must not be negative", name)
+		}
+	}
+
+	for i, rule := range c.Rules {
+		actions := 0
+		for _, set := range []bool{rule.Allow != "", rule.Pin != "", rule.Block} {
+			if set {
+				actions++
+			}
+		}
+		if actions != 1 {
+			return fmt.Errorf("rule %d must set exactly one of allow, pin and block", i+1)
+		}
+		if rule.Allow != "" && rule.Allow != AllowPatch && rule.Allow != AllowMinor {
+			return fmt.Errorf("rule %d: allow must be patch or minor, got %q", i+1, rule.Allow)
+		}
+		if rule.Ecosystem != "" && rule.Ecosystem != "composer" && rule.Ecosystem != "npm" {
+			return fmt.Errorf("rule %d: ecosystem must be composer or npm, got %q", i+1, rule.Ecosystem)
+		}
+	}
+
+	for name, path := range c.Tools {
+		if path == "" {
+			return fmt.Errorf("tools: no path for %s", name)
+		}
+	}
+
+	for version, path := range c.PHPBinaries {
+		if !phpVersionPattern.MatchString(version) {
+			return fmt.Errorf("php_binaries must be keyed by PHP version like 8.3, got %q", version)
+		}
+		if path == "" {
+			return fmt.Errorf("php_binaries: no path for PHP %s", version)
+		}
+	}
+
+	if c.ComposerAuth != "" && !json.Valid([]byte(c.ComposerAuth)) {
+		return fmt.Errorf("composer_auth must be the JSON content of an auth.json")
+	}
+
+	for version, path := range c.NodeBinaries {
+		if !phpVersionPattern.MatchString(version) {
+			return fmt.Errorf("node_binaries must be keyed by Node version like 20, got %q", version)
+		}
+		if path == "" {
+			return fmt.Errorf("node_binaries: no path for Node %s", version)
+		}
+	}
+
+	switch c.NodeManager {
+	case "", NodeManagerFnm, NodeManagerVolta:
+	default:
+		return fmt.Errorf("node_manager must be fnm or volta, got %q", c.NodeManager)
+	}
+
+	if c.CommitAuthorName == "" || !strings.Contains(c.CommitAuthorEmail, "@") {
+		return fmt.Errorf("commit_author_name and commit_author_email must be a name and an email address")
+	}
+
+	switch c.ContainerRuntime {
+	case "docker", "podman":
+	default:
+		return fmt.Errorf("container_runtime must be docker or podman, got %q", c.ContainerRuntime)
+	}
+
+	switch c.MissingExtensions {
+	case MissingExtensionsIgnore, MissingExtensionsSkip:
+	default:
+		return fmt.Errorf("missing_extensions must be ignore or skip, got %q", c.MissingExtensions)
+	}
+
+	switch c.RateLimitBudget {
+	case RateLimitBudgetWarn, RateLimitBudgetStagger, RateLimitBudgetAbort:
+	default:
+		return fmt.Errorf("rate_limit_budget must be one of warn, stagger or abort, got %q", c.RateLimitBudget)
+	}
+
+	var pluginNames []string
+	for _, p := range c.ExternalPlugins {
+		if p.Name == "" || p.Command == "" {
+			return fmt.Errorf("external_plugins need a name and a command")
+		}
+		if slices.Contains(pluginNames, p.Name) || p.Name == "composer" || p.Name == "npm" {
+			return fmt.Errorf("external plugin %q is defined twice or shadows a built-in plugin", p.Name)
+		}
+		pluginNames = append(pluginNames, p.Name)
+	}
+
+	for name, mirror := range map[string]string{"composer_mirror": c.ComposerMirror, "npm_registry": c.NPMRegistry} {
+		if mirror != "" && !strings.HasPrefix(mirror, "https://") && !strings.HasPrefix(mirror, "http://") {
+			return fmt.Errorf("%s must be an http or https URL, got %q", name, mirror)
+		}
+	}
+
+	for _, webhook := range slices.Concat(c.Notifications.Teams, c.Notifications.Discord, c.Notifications.Webhook.URLs) {
+		if !strings.HasPrefix(webhook, "https://") && !strings.HasPrefix(webhook, "http://") {
+			return fmt.Errorf("notification webhooks must be http or https URLs, got %q", webhook)
+		}
+	}
+
+	return nil
+}
+
+// phpVersionPattern matches the versions php_binaries and node_binaries are keyed by
+var phpVersionPattern = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+
+// labelColorPattern matches the hex colors GitHub accepts for labels
+var labelColorPattern = regexp.MustCompile(`^[0-9a-fA-F]{6}$`)
+
+func validateMergeMethod(method string) error {
+	switch method {
+	case MergeMethodSquash, MergeMethodMerge, MergeMethodRebase:
+		return nil
+	default:
+		return fmt.Errorf("merge_method must be one of squash, merge or rebase, got %q", method)
+	}
+}
+
+// validateComposerFlags rejects arguments that aren't flags, like package names, which
+// would turn the upgrade of everything into a partial one
+func validateComposerFlags(flags []string) error {
+	for _, flag := range flags {
+		if !strings.HasPrefix(flag, "-") {
+			return fmt.Errorf("composer_flags must only contain flags, got %q", flag)
+		}
+	}
 	return nil
 }
 
+func validateBranchUpdateStrategy(strategy string) error {
+	switch strategy {
+	case BranchUpdateForcePush, BranchUpdateRecreate, BranchUpdateAppend:
+		return nil
+	default:
+		return fmt.Errorf("branch_update_strategy must be one of force-push, recreate or append, got %q", strategy)
+	}
+}
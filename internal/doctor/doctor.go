@@ -0,0 +1,242 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+)
+
+// Check is the outcome of a single environment check
+type Check struct {
+	Name   string
+	OK     bool
+	Warn   bool   // Not fatal, but worth fixing
+	Detail string // What was found
+	Fix    string // How to fix a failed check
+}
+
+// endpoints lists the services updati needs to reach during a run
+var endpoints = []struct {
+	name string
+	url  string
+	fix  string
+}{
+	{"GitHub API", "https://api.github.com", "Allow outbound HTTPS to api.github.com"},
+	{"Packagist", "https://repo.packagist.org/packages.json", "Allow outbound HTTPS to repo.packagist.org or configure a Composer mirror"},
+	{"npm registry", "https://registry.npmjs.org/", "Allow outbound HTTPS to registry.npmjs.org or configure an npm registry mirror"},
+}
+
+// Run performs all environment checks
+func Run(ctx context.Context, cfg *config.Config) []Check {
+	var checks []Check
+	checks = append(checks, checkTools(ctx, cfg)...)
+	checks = append(checks, checkNetwork(ctx)...)
+	checks = append(checks, checkToken(ctx, cfg))
+	if len(cfg.GitHubTokens) > 0 {
+		checks = append(checks, checkRotatedTokens(ctx, cfg))
+	}
+	if cfg.ReadToken != "" {
+		checks = append(checks, checkReadToken(ctx, cfg))
+	}
+	return checks
+}
+
+// Failed reports whether any check failed
+func Failed(checks []Check) bool {
+	for _, c := range checks {
+		if !c.OK && !c.Warn {
+			return true
+		}
+	}
+	return false
+}
+
+func checkTools(ctx context.Context, cfg *config.Config) []Check {
+	var checks []Check
+	tools := toolchain.Detect(ctx)
+
+	find := func(name string) *toolchain.Tool {
+		for i := range tools {
+			if tools[i].Name == name {
+				return &tools[i]
+			}
+		}
+		return nil
+	}
+
+	checks = append(checks, toolCheck(find("git"), true, "Install git (e.g. apk add git / apt install git)"))
+
+	if cfg.UsesImages() {
+		checks = append(checks, runtimeCheck(ctx, cfg))
+	}
+
+	if cfg.PluginEnabled("composer") && cfg.PluginImage("composer") == "" {
+		var phps []string
+		for _, tool := range tools {
+			if strings.HasPrefix(tool.Name, "php") && tool.Error == "" {
+				phps = append(phps, fmt.Sprintf("%s %s", tool.Name, tool.Version))
+			}
+		}
+		php := Check{Name: "php", OK: len(phps) > 0, Detail: strings.Join(phps, ", ")}
+		if !php.OK {
+			php.Detail = "no PHP binary found"
+			php.Fix = "Install PHP 8.2 or newer (php, php82, php83, php84 ...) or disable plugins.composer"
+		}
+		checks = append(checks, php)
+		checks = append(checks, toolCheck(find("composer"), true, "Install Composer from https://getcomposer.org/download/ or disable plugins.composer"))
+	}
+
+	if cfg.PluginEnabled("npm") && cfg.PluginImage("npm") == "" {
+		checks = append(checks, toolCheck(find("node"), true, "Install Node.js or disable plugins.npm"))
+		checks = append(checks, toolCheck(find("npm"), true, "Install npm or disable plugins.npm"))
+	}
+
+	yarn := Check{Name: "yarn", OK: true, Detail: "not installed (optional)"}
+	if tool := find("yarn"); tool != nil {
+		yarn = toolCheck(tool, false, "Reinstall yarn or remove it from PATH")
+	}
+	checks = append(checks, yarn)
+
+	return checks
+}
+
+// runtimeCheck checks the container runtime plugin images run with
+func runtimeCheck(ctx context.Context, cfg *config.Config) Check {
+	check := Check{Name: cfg.ContainerRuntime, Fix: "Install " + cfg.ContainerRuntime + " or remove plugin_images"}
+
+	path, err := toolchain.LookPath(cfg.ContainerRuntime)
+	if err != nil {
+		check.Detail = "not found"
+		return check
+	}
+	version, err := toolchain.Version(ctx, path, "--version")
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+
+	check.OK = true
+	check.Detail = fmt.Sprintf("%s (%s)", version, path)
+	return check
+}
+
+func toolCheck(tool *toolchain.Tool, required bool, fix string) Check {
+	if tool == nil || tool.Error != "" {
+		detail := "not found"
+		if tool != nil {
+			detail = tool.Error
+		}
+		return Check{Name: toolName(tool), OK: false, Warn: !required, Detail: detail, Fix: fix}
+	}
+	return Check{Name: tool.Name, OK: true, Detail: fmt.Sprintf("%s (%s)", tool.Version, tool.Path)}
+}
+
+func toolName(tool *toolchain.Tool) string {
+	if tool == nil {
+		return "tool"
+	}
+	return tool.Name
+}
+
+func checkNetwork(ctx context.Context) []Check {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	var checks []Check
+	for _, ep := range endpoints {
+		check := Check{Name: ep.name}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, ep.url, nil)
+		if err == nil {
+			var resp *http.Response
+			start := time.Now()
+			resp, err = client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				check.Detail = fmt.Sprintf("reachable (%s, %s)", resp.Status, time.Since(start).Round(time.Millisecond))
+			}
+		}
+
+		check.OK = err == nil
+		if err != nil {
+			check.Detail = err.Error()
+			check.Fix = ep.fix
+		}
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+func checkToken(ctx context.Context, cfg *config.Config) Check {
+	check := Check{Name: "GitHub token"}
+
+	if cfg.GitHubToken == "" {
+		check.Detail = "no token configured"
+		check.Fix = "Set GITHUB_TOKEN, pass --token or add github_token to the config file"
+		return check
+	}
+
+	info, err := github.NewClient(cfg.GitHubToken, cfg.Owner, github.Options{Retry: cfg.Retry}).GetTokenInfo(ctx)
+	if err != nil {
+		check.Detail = err.Error()
+		check.Fix = "Create a new token at https://github.com/settings/tokens with the repo scope"
+		return check
+	}
+
+	check.OK = true
+	check.Detail = "authenticated as " + info.Login
+	if len(info.Scopes) > 0 {
+		check.Detail += " (scopes: " + strings.Join(info.Scopes, ", ") + ")"
+		if !slices.Contains(info.Scopes, "repo") {
+			check.Warn = true
+			check.OK = false
+			check.Fix = "Add the repo scope so updati can push branches and open pull requests"
+		}
+	}
+
+	return check
+}
+
+// checkRotatedTokens verifies the tokens requests rotate over besides github_token
+func checkRotatedTokens(ctx context.Context, cfg *config.Config) Check {
+	check := Check{Name: "Rotated tokens"}
+
+	var logins []string
+	for i, token := range cfg.GitHubTokens {
+		info, err := github.NewClient(token, cfg.Owner, github.Options{Retry: cfg.Retry}).GetTokenInfo(ctx)
+		if err != nil {
+			check.Detail = fmt.Sprintf("token %d: %s", i+1, err)
+			check.Fix = "Replace or remove the token in github_tokens"
+			return check
+		}
+		logins = append(logins, info.Login)
+	}
+
+	check.OK = true
+	check.Detail = fmt.Sprintf("%d tokens, authenticated as %s", len(logins), strings.Join(logins, ", "))
+	return check
+}
+
+// checkReadToken verifies the separate read token. It only needs read access, so its
+// scopes aren't checked.
+func checkReadToken(ctx context.Context, cfg *config.Config) Check {
+	check := Check{Name: "Read token"}
+
+	info, err := github.NewClient(cfg.ReadToken, cfg.Owner, github.Options{Retry: cfg.Retry}).GetTokenInfo(ctx)
+	if err != nil {
+		check.Detail = err.Error()
+		check.Fix = "Create a token with read access to the repositories, or unset read_token"
+		return check
+	}
+
+	check.OK = true
+	check.Detail = "authenticated as " + info.Login
+	return check
+}
@@ -0,0 +1,31 @@
+package github
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/janyksteenbeek/updati/internal/audit"
+)
+
+// Audit records a mutating action in the audit log, if one is configured, stamped with
+// the identity of the client's token. Actions performed outside of the client with its
+// token, like git pushes, are recorded through it too. Failing to write is logged and
+// doesn't undo the action.
+func (c *Client) Audit(ctx context.Context, entry audit.Entry) {
+	if c.audit == nil {
+		return
+	}
+
+	c.actorOnce.Do(func() {
+		// App installation tokens have no user, they're identified by the fingerprint
+		if info, err := c.GetTokenInfo(withToken(ctx, 0)); err == nil {
+			c.actor = info.Login
+		}
+	})
+	entry.Actor = c.actor
+	entry.Token = c.fingerprint
+
+	if err := c.audit.Record(entry); err != nil {
+		slog.Warn("failed to write audit log", "action", entry.Action, "repo", entry.Repository, "error", err)
+	}
+}
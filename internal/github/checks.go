@@ -0,0 +1,111 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/google/go-github/v57/github"
+	"github.com/janyksteenbeek/updati/internal/audit"
+)
+
+// CheckRun is a completed check run reporting on a commit
+type CheckRun struct {
+	Name       string
+	HeadSHA    string
+	Conclusion string // success, neutral or failure
+	Title      string
+	Summary    string // Markdown, at most 65535 characters
+	Text       string // Markdown details, at most 65535 characters
+}
+
+// CreateCheckRun creates a completed check run and returns its URL. GitHub only lets
+// GitHub Apps create check runs, so this fails with a personal access token.
+func (c *Client) CreateCheckRun(ctx context.Context, repo *Repository, run CheckRun) (string, error) {
+	check, _, err := c.client.Checks.CreateCheckRun(ctx, repo.Owner, repo.Name, github.CreateCheckRunOptions{
+		Name:       run.Name,
+		HeadSHA:    run.HeadSHA,
+		Status:     github.String("completed"),
+		Conclusion: github.String(run.Conclusion),
+		Output: &github.CheckRunOutput{
+			Title:   github.String(run.Title),
+			Summary: github.String(run.Summary),
+			Text:    github.String(run.Text),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create check run: %w", err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.CheckRunCreate, Repository: repo.FullName, Commit: run.HeadSHA, URL: check.GetHTMLURL()})
+	return check.GetHTMLURL(), nil
+}
+
+// Overall states of a commit's CI
+const (
+	CIPassing = "passing"
+	CIFailing = "failing"
+	CIPending = "pending"
+	CINone    = "none" // Neither statuses nor check runs
+)
+
+// CIStatus is the overall state of the statuses and check runs of a commit
+type CIStatus struct {
+	State   string   // One of the CI constants
+	Failing []string // Contexts of failing statuses and names of failing check runs
+}
+
+// failingConclusions are the check run conclusions that count as a red build
+var failingConclusions = []string{"failure", "timed_out", "startup_failure", "action_required"}
+
+// CommitCI returns the state of the commit statuses and latest check runs of a commit,
+// given as SHA or as the branch it heads. Any failing status or check run makes it
+// failing. Check runs named in ignore are left out, so a commit with only those has
+// no CI.
+func (c *Client) CommitCI(ctx context.Context, repo *Repository, ref string, ignore ...string) (*CIStatus, error) {
+	combined, _, err := c.client.Repositories.GetCombinedStatus(ctx, repo.Owner, repo.Name, ref, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit statuses of %s: %w", ref, err)
+	}
+
+	status := &CIStatus{State: CINone}
+	for _, s := range combined.Statuses {
+		switch s.GetState() {
+		case "failure", "error":
+			status.Failing = append(status.Failing, s.GetContext())
+		case "pending":
+			status.State = CIPending
+		default:
+			if status.State == CINone {
+				status.State = CIPassing
+			}
+		}
+	}
+
+	opts := &github.ListCheckRunsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		runs, resp, err := c.client.Checks.ListCheckRunsForRef(ctx, repo.Owner, repo.Name, ref, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list check runs of %s: %w", ref, err)
+		}
+		for _, run := range runs.CheckRuns {
+			switch {
+			case slices.Contains(ignore, run.GetName()):
+			case run.GetStatus() != "completed":
+				status.State = CIPending
+			case slices.Contains(failingConclusions, run.GetConclusion()):
+				status.Failing = append(status.Failing, run.GetName())
+			case status.State == CINone:
+				status.State = CIPassing
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if len(status.Failing) > 0 {
+		status.State = CIFailing
+	}
+	return status, nil
+}
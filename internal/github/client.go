@@ -1,11 +1,20 @@
 package github
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/go-github/v57/github"
+	"github.com/janyksteenbeek/updati/internal/audit"
+	"github.com/janyksteenbeek/updati/internal/retry"
 	"golang.org/x/oauth2"
 )
 
@@ -13,6 +22,12 @@ import (
 type Client struct {
 	client *github.Client
 	owner  string
+	limits *rateLimitTransport
+
+	audit       *audit.Log
+	fingerprint string // Identifies github_token, which makes all changes, in the audit log
+	actorOnce   sync.Once
+	actor       string // Login of the token's user, looked up for the first audit entry
 }
 
 // Repository represents a GitHub repository
@@ -24,24 +39,82 @@ type Repository struct {
 	DefaultRef  string
 	HasComposer bool
 	HasNPM      bool
+	Detected    bool  // HasComposer and HasNPM are known, no need to call DetectDependencies
+	Size        int64 // Size GitHub reports for the repository in kilobytes, 0 when unknown
+	Empty       bool  // Has no commits, and so no branch to update
+	Template    bool  // Is a template repository
 }
 
-// NewClient creates a new GitHub client
-func NewClient(token, owner string) *Client {
-	ctx := context.Background()
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
+// errOwnerNotFound is returned when the configured owner is neither a user nor an organization
+var errOwnerNotFound = errors.New("owner not found")
+
+// Options configures how a Client talks to the API
+type Options struct {
+	Retry    retry.Policy // Retries of read requests that failed because of the network or a server error
+	CacheDir string       // Directory for conditional request caching, empty disables the cache
+	Audit    *audit.Log   // Records every mutating request, nil to not record them
+	Tokens   []string     // Further tokens to spread requests over, each with its own rate limits
+}
+
+// NewClient creates a new GitHub client. With further tokens in the options, reads
+// rotate between all of them, while changes always go through token.
+func NewClient(token, owner string, opts Options) *Client {
+	tokens := append([]string{token}, opts.Tokens...)
+	transports := make([]http.RoundTripper, len(tokens))
+	for i, token := range tokens {
+		transports[i] = tokenTransport(token, opts)
+	}
+	limits := newRateLimitTransport(transports...)
 
 	return &Client{
-		client: github.NewClient(tc),
-		owner:  owner,
+		client:      github.NewClient(&http.Client{Transport: limits}),
+		owner:       owner,
+		limits:      limits,
+		audit:       opts.Audit,
+		fingerprint: audit.Fingerprint(token),
 	}
 }
 
-// ListRepositories lists all repositories for the configured owner
+// tokenTransport returns the transport authenticating requests with the token, retrying
+// and caching them as the options say
+func tokenTransport(token string, opts Options) http.RoundTripper {
+	var transport http.RoundTripper = &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}),
+	}
+	transport = &retryTransport{base: transport, policy: opts.Retry}
+	if opts.CacheDir != "" {
+		transport = newCacheTransport(transport, opts.CacheDir, token)
+	}
+	return transport
+}
+
+// ListRepositories lists all repositories for the configured owner. Repositories are
+// discovered through GraphQL, which also detects their manifests; the REST API is used
+// when that fails.
 func (c *Client) ListRepositories(ctx context.Context) ([]*Repository, error) {
+	repos, err := c.discoverRepositories(ctx)
+	if err == nil {
+		return repos, nil
+	}
+	if errors.Is(err, errOwnerNotFound) {
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	slog.Warn("GraphQL repository discovery failed, falling back to REST", "error", err)
+	return c.listRepositoriesREST(ctx)
+}
+
+// GetRepository looks up a single repository of the configured owner by name
+func (c *Client) GetRepository(ctx context.Context, name string) (*Repository, error) {
+	repo, _, err := c.client.Repositories.Get(ctx, c.owner, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository %s: %w", name, err)
+	}
+	return convertRepo(repo), nil
+}
+
+// listRepositoriesREST lists all repositories for the configured owner through the REST API
+func (c *Client) listRepositoriesREST(ctx context.Context) ([]*Repository, error) {
 	var allRepos []*Repository
 
 	opts := &github.RepositoryListByUserOptions{
@@ -98,11 +171,18 @@ func convertRepo(repo *github.Repository) *Repository {
 		FullName:   repo.GetFullName(),
 		CloneURL:   repo.GetCloneURL(),
 		DefaultRef: defaultRef,
+		Size:       int64(repo.GetSize()),
+		Template:   repo.GetIsTemplate(),
 	}
 }
 
-// DetectDependencies checks what dependency managers a repository uses
+// DetectDependencies checks what dependency managers a repository uses, unless that is
+// already known from listing it
 func (c *Client) DetectDependencies(ctx context.Context, repo *Repository) error {
+	if repo.Detected {
+		return nil
+	}
+
 	// Check for composer.json
 	_, _, _, err := c.client.Repositories.GetContents(
 		ctx, repo.Owner, repo.Name, "composer.json",
@@ -112,6 +192,14 @@ func (c *Client) DetectDependencies(ctx context.Context, repo *Repository) error
 		repo.HasComposer = true
 	}
 
+	// The REST API lists empty repositories like any other, only their contents tell
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && strings.Contains(errResp.Message, "repository is empty") {
+		repo.Empty = true
+		repo.Detected = true
+		return nil
+	}
+
 	// Check for package.json
 	_, _, _, err = c.client.Repositories.GetContents(
 		ctx, repo.Owner, repo.Name, "package.json",
@@ -121,9 +209,32 @@ func (c *Client) DetectDependencies(ctx context.Context, repo *Repository) error
 		repo.HasNPM = true
 	}
 
+	repo.Detected = true
 	return nil
 }
 
+// FileContent returns the content of a file on the repository's default branch, or
+// nil when the file doesn't exist. Files of any size up to 100 MB are fetched in a
+// single request.
+func (c *Client) FileContent(ctx context.Context, repo *Repository, path string) ([]byte, error) {
+	u := fmt.Sprintf("repos/%s/%s/contents/%s?ref=%s", repo.Owner, repo.Name, path, url.QueryEscape(repo.DefaultRef))
+	req, err := c.client.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.raw+json")
+
+	var buf bytes.Buffer
+	resp, err := c.client.Do(ctx, req, &buf)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get %s: %w", path, err)
+	}
+	return buf.Bytes(), nil
+}
+
 // GetDefaultBranch gets the default branch for a repository
 func (c *Client) GetDefaultBranch(ctx context.Context, repo *Repository) (string, error) {
 	r, _, err := c.client.Repositories.Get(ctx, repo.Owner, repo.Name)
@@ -134,6 +245,28 @@ func (c *Client) GetDefaultBranch(ctx context.Context, repo *Repository) (string
 	return r.GetDefaultBranch(), nil
 }
 
+// BranchExists reports whether the repository has a branch of the given name
+func (c *Client) BranchExists(ctx context.Context, repo *Repository, branch string) (bool, error) {
+	_, resp, err := c.client.Repositories.GetBranch(ctx, repo.Owner, repo.Name, branch, 0)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get branch %s: %w", branch, err)
+	}
+	return true, nil
+}
+
+// CompareBranches returns how many commits head is ahead of and behind base
+func (c *Client) CompareBranches(ctx context.Context, repo *Repository, base, head string) (ahead, behind int, err error) {
+	cmp, _, err := c.client.Repositories.CompareCommits(ctx, repo.Owner, repo.Name, base, head, &github.ListOptions{PerPage: 1})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to compare %s...%s: %w", base, head, err)
+	}
+
+	return cmp.GetAheadBy(), cmp.GetBehindBy(), nil
+}
+
 // CreateBranch creates a new branch from the default branch
 func (c *Client) CreateBranch(ctx context.Context, repo *Repository, branchName string) error {
 	ref, _, err := c.client.Git.GetRef(ctx, repo.Owner, repo.Name, "refs/heads/"+repo.DefaultRef)
@@ -153,16 +286,19 @@ func (c *Client) CreateBranch(ctx context.Context, repo *Repository, branchName
 			if err != nil {
 				return fmt.Errorf("failed to update existing branch: %w", err)
 			}
+			c.Audit(ctx, audit.Entry{Action: audit.BranchUpdate, Repository: repo.FullName, Branch: branchName, Commit: ref.Object.GetSHA(), Force: true})
 			return nil
 		}
 		return fmt.Errorf("failed to create branch: %w", err)
 	}
 
+	c.Audit(ctx, audit.Entry{Action: audit.BranchCreate, Repository: repo.FullName, Branch: branchName, Commit: ref.Object.GetSHA()})
 	return nil
 }
 
-// CreatePullRequest creates a pull request
-func (c *Client) CreatePullRequest(ctx context.Context, repo *Repository, title, body, head, base string, labels []string) (*github.PullRequest, error) {
+// CreatePullRequest creates a pull request, or updates the open one of the head branch.
+// With draft set, the pull request is opened as or turned back into a draft.
+func (c *Client) CreatePullRequest(ctx context.Context, repo *Repository, title, body, head, base string, labels []string, draft bool) (*github.PullRequest, error) {
 	prs, _, err := c.client.PullRequests.List(ctx, repo.Owner, repo.Name, &github.PullRequestListOptions{
 		Head:  fmt.Sprintf("%s:%s", repo.Owner, head),
 		Base:  base,
@@ -181,6 +317,15 @@ func (c *Client) CreatePullRequest(ctx context.Context, repo *Repository, title,
 		if err != nil {
 			return nil, fmt.Errorf("failed to update existing PR: %w", err)
 		}
+		c.Audit(ctx, audit.Entry{Action: audit.PREdit, Repository: repo.FullName, Branch: head, Number: pr.GetNumber(), URL: pr.GetHTMLURL()})
+
+		// The new commits haven't passed CI yet
+		if draft && !pr.GetDraft() {
+			if err := c.ConvertToDraft(ctx, pr); err != nil {
+				return nil, err
+			}
+			pr.Draft = github.Bool(true)
+		}
 		return pr, nil
 	}
 
@@ -189,21 +334,86 @@ func (c *Client) CreatePullRequest(ctx context.Context, repo *Repository, title,
 		Body:  github.String(body),
 		Head:  github.String(head),
 		Base:  github.String(base),
+		Draft: github.Bool(draft),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create pull request: %w", err)
 	}
+	c.Audit(ctx, audit.Entry{Action: audit.PROpen, Repository: repo.FullName, Branch: head, Number: pr.GetNumber(), URL: pr.GetHTMLURL()})
 
 	if len(labels) > 0 {
 		_, _, err = c.client.Issues.AddLabelsToIssue(ctx, repo.Owner, repo.Name, pr.GetNumber(), labels)
 		if err != nil {
-			fmt.Printf("Warning: failed to add labels to PR: %v\n", err)
+			slog.Warn("failed to add labels to PR", "repo", repo.FullName, "pr", pr.GetNumber(), "error", err)
+		} else {
+			c.Audit(ctx, audit.Entry{Action: audit.LabelAdd, Repository: repo.FullName, Number: pr.GetNumber(), Labels: labels})
 		}
 	}
 
 	return pr, nil
 }
 
+// RateLimit holds the core REST API budget of the tokens
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time // When the first token's limit resets
+
+	First *RateLimit // The budget of the first token alone, which makes every change
+}
+
+// GetRateLimit returns the current core rate limit, summed over the tokens (this call
+// does not count against it)
+func (c *Client) GetRateLimit(ctx context.Context) (*RateLimit, error) {
+	total := &RateLimit{}
+	for i := range c.limits.tokens {
+		limits, _, err := c.client.RateLimits(withToken(ctx, i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get rate limit: %w", err)
+		}
+
+		core := limits.GetCore()
+		if i == 0 {
+			total.First = &RateLimit{Limit: core.Limit, Remaining: core.Remaining, Reset: core.Reset.Time}
+		}
+		total.Limit += core.Limit
+		total.Remaining += core.Remaining
+		if total.Reset.IsZero() || core.Reset.Time.Before(total.Reset) {
+			total.Reset = core.Reset.Time
+		}
+	}
+	return total, nil
+}
+
+// RateStatus returns the rate limits as the responses so far reported them, without
+// making a request
+func (c *Client) RateStatus() RateStatus {
+	return c.limits.status()
+}
+
+// TokenInfo describes the identity and scopes behind a token
+type TokenInfo struct {
+	Login  string
+	Scopes []string // Empty for fine-grained tokens and GitHub App tokens
+}
+
+// GetTokenInfo returns the user the token belongs to and its OAuth scopes
+func (c *Client) GetTokenInfo(ctx context.Context) (*TokenInfo, error) {
+	user, resp, err := c.client.Users.Get(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authenticated user: %w", err)
+	}
+
+	info := &TokenInfo{Login: user.GetLogin()}
+	for _, scope := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			info.Scopes = append(info.Scopes, scope)
+		}
+	}
+
+	return info, nil
+}
+
 // GetRawClient returns the underlying GitHub client for advanced operations
 func (c *Client) GetRawClient() *github.Client {
 	return c.client
@@ -0,0 +1,185 @@
+package github
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v57/github"
+	"github.com/janyksteenbeek/updati/internal/audit"
+)
+
+// Git file modes used in tree entries
+const (
+	FileModeRegular    = "100644"
+	FileModeExecutable = "100755"
+	FileModeSymlink    = "120000"
+)
+
+// FileChange is a file written or deleted by a commit created through the API
+type FileChange struct {
+	Path    string
+	Mode    string // One of the FileMode constants
+	Content []byte // Contents, or the link target for symlinks
+	Deleted bool
+}
+
+// CommitRequest describes a commit created through the Git Data API
+type CommitRequest struct {
+	Branch  string   // Branch to point at the new commit, created if needed
+	Base    string   // Commit whose tree the changes are applied to
+	Parents []string // Parents of the new commit, Base if empty
+	Message string
+	Author  *CommitAuthor // Author of the commit, the token's user or app if nil
+	Changes []FileChange
+	Force   bool // Update the branch even if it doesn't fast-forward
+}
+
+// CommitAuthor is the name and email a commit is authored by
+type CommitAuthor struct {
+	Name  string
+	Email string
+}
+
+// CommitChanges creates a commit through the Git Data API and points the branch at it.
+// Unlike pushed commits, commits created this way are committed by the token's user or
+// app and signed by GitHub, so they show as verified, also when authored by someone
+// else. It returns the SHA of the new commit.
+func (c *Client) CommitChanges(ctx context.Context, repo *Repository, req CommitRequest) (string, error) {
+	baseCommit, _, err := c.client.Git.GetCommit(ctx, repo.Owner, repo.Name, req.Base)
+	if err != nil {
+		return "", fmt.Errorf("failed to get base commit: %w", err)
+	}
+
+	parents := req.Parents
+	if len(parents) == 0 {
+		parents = []string{req.Base}
+	}
+
+	entries := make([]*github.TreeEntry, 0, len(req.Changes))
+	for _, change := range req.Changes {
+		entry := &github.TreeEntry{
+			Path: github.String(change.Path),
+			Mode: github.String(change.Mode),
+			Type: github.String("blob"),
+		}
+
+		if !change.Deleted {
+			blob, _, err := c.client.Git.CreateBlob(ctx, repo.Owner, repo.Name, &github.Blob{
+				Content:  github.String(base64.StdEncoding.EncodeToString(change.Content)),
+				Encoding: github.String("base64"),
+			})
+			if err != nil {
+				return "", fmt.Errorf("failed to create blob for %s: %w", change.Path, err)
+			}
+			entry.SHA = blob.SHA
+		}
+
+		entries = append(entries, entry)
+	}
+
+	tree, _, err := c.client.Git.CreateTree(ctx, repo.Owner, repo.Name, baseCommit.GetTree().GetSHA(), entries)
+	if err != nil {
+		return "", fmt.Errorf("failed to create tree: %w", err)
+	}
+
+	parentCommits := make([]*github.Commit, len(parents))
+	for i, sha := range parents {
+		parentCommits[i] = &github.Commit{SHA: github.String(sha)}
+	}
+
+	newCommit := &github.Commit{
+		Message: github.String(req.Message),
+		Tree:    &github.Tree{SHA: tree.SHA},
+		Parents: parentCommits,
+	}
+	// The committer stays the token's identity, GitHub only signs commits it commits
+	if req.Author != nil {
+		newCommit.Author = &github.CommitAuthor{Name: github.String(req.Author.Name), Email: github.String(req.Author.Email)}
+	}
+	commit, _, err := c.client.Git.CreateCommit(ctx, repo.Owner, repo.Name, newCommit, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	ref := &github.Reference{
+		Ref:    github.String("refs/heads/" + req.Branch),
+		Object: &github.GitObject{SHA: commit.SHA},
+	}
+
+	_, _, err = c.client.Git.UpdateRef(ctx, repo.Owner, repo.Name, ref, req.Force)
+	if err != nil && strings.Contains(err.Error(), "Reference does not exist") {
+		_, _, err = c.client.Git.CreateRef(ctx, repo.Owner, repo.Name, ref)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to update branch %s: %w", req.Branch, err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.CommitPush, Repository: repo.FullName, Branch: req.Branch, Commit: commit.GetSHA(), Force: req.Force})
+
+	return commit.GetSHA(), nil
+}
+
+// BranchHead returns the SHA of the branch's latest commit, or an empty string if the
+// branch doesn't exist
+func (c *Client) BranchHead(ctx context.Context, repo *Repository, branch string) (string, error) {
+	ref, resp, err := c.client.Git.GetRef(ctx, repo.Owner, repo.Name, "refs/heads/"+branch)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get branch %s: %w", branch, err)
+	}
+	return ref.GetObject().GetSHA(), nil
+}
+
+// DeleteBranch deletes a branch, doing nothing if it doesn't exist
+func (c *Client) DeleteBranch(ctx context.Context, repo *Repository, branch string) error {
+	resp, err := c.client.Git.DeleteRef(ctx, repo.Owner, repo.Name, "refs/heads/"+branch)
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete branch %s: %w", branch, err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.BranchDelete, Repository: repo.FullName, Branch: branch})
+	return nil
+}
+
+// CommitInfo describes a commit on a branch
+type CommitInfo struct {
+	SHA         string
+	Subject     string // First line of the message
+	Author      string // Login, or name when the author has no GitHub account
+	AuthorEmail string
+}
+
+// BranchCommits returns the commits on branch that are not on base, or nothing if the
+// branch doesn't exist
+func (c *Client) BranchCommits(ctx context.Context, repo *Repository, base, branch string) ([]CommitInfo, error) {
+	cmp, resp, err := c.client.Repositories.CompareCommits(ctx, repo.Owner, repo.Name, base, branch, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to compare %s...%s: %w", base, branch, err)
+	}
+
+	commits := make([]CommitInfo, 0, len(cmp.Commits))
+	for _, commit := range cmp.Commits {
+		subject, _, _ := strings.Cut(commit.GetCommit().GetMessage(), "\n")
+		author := commit.GetAuthor().GetLogin()
+		if author == "" {
+			author = commit.GetCommit().GetAuthor().GetName()
+		}
+		commits = append(commits, CommitInfo{
+			SHA:         commit.GetSHA(),
+			Subject:     subject,
+			Author:      author,
+			AuthorEmail: commit.GetCommit().GetAuthor().GetEmail(),
+		})
+	}
+
+	return commits, nil
+}
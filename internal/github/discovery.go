@@ -0,0 +1,111 @@
+package github
+
+import (
+	"context"
+)
+
+// discoveryQuery lists an owner's repositories together with their default branch and
+// whether it contains the manifests updati supports, 100 repositories per request
+const discoveryQuery = `query($owner: String!, $cursor: String) {
+  repositoryOwner(login: $owner) {
+    repositories(first: 100, after: $cursor, ownerAffiliations: OWNER, orderBy: {field: NAME, direction: ASC}) {
+      pageInfo {
+        hasNextPage
+        endCursor
+      }
+      nodes {
+        name
+        nameWithOwner
+        url
+        owner { login }
+        defaultBranchRef { name }
+        diskUsage
+        isEmpty
+        isTemplate
+        composer: object(expression: "HEAD:composer.json") { __typename }
+        npm: object(expression: "HEAD:package.json") { __typename }
+      }
+    }
+  }
+}`
+
+// discoveryResponse is the data returned by discoveryQuery
+type discoveryResponse struct {
+	RepositoryOwner *struct {
+		Repositories struct {
+			PageInfo struct {
+				HasNextPage bool   `json:"hasNextPage"`
+				EndCursor   string `json:"endCursor"`
+			} `json:"pageInfo"`
+			Nodes []discoveredRepo `json:"nodes"`
+		} `json:"repositories"`
+	} `json:"repositoryOwner"`
+}
+
+// discoveredRepo is a repository as returned by discoveryQuery
+type discoveredRepo struct {
+	Name          string `json:"name"`
+	NameWithOwner string `json:"nameWithOwner"`
+	URL           string `json:"url"`
+	Owner         struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	DefaultBranchRef *struct {
+		Name string `json:"name"`
+	} `json:"defaultBranchRef"`
+	DiskUsage  int64     `json:"diskUsage"` // Kilobytes
+	IsEmpty    bool      `json:"isEmpty"`
+	IsTemplate bool      `json:"isTemplate"`
+	Composer   *struct{} `json:"composer"`
+	NPM        *struct{} `json:"npm"`
+}
+
+// discoverRepositories lists the owner's repositories and detects their manifests
+// with one GraphQL request per 100 repositories
+func (c *Client) discoverRepositories(ctx context.Context) ([]*Repository, error) {
+	var repos []*Repository
+
+	vars := map[string]any{"owner": c.owner, "cursor": nil}
+	for {
+		var data discoveryResponse
+		if err := c.graphql(ctx, discoveryQuery, vars, &data); err != nil {
+			return nil, err
+		}
+		if data.RepositoryOwner == nil {
+			return nil, errOwnerNotFound
+		}
+
+		page := data.RepositoryOwner.Repositories
+		for _, node := range page.Nodes {
+			repos = append(repos, node.convert())
+		}
+
+		if !page.PageInfo.HasNextPage {
+			break
+		}
+		vars["cursor"] = page.PageInfo.EndCursor
+	}
+
+	return repos, nil
+}
+
+func (r *discoveredRepo) convert() *Repository {
+	defaultRef := "main"
+	if r.DefaultBranchRef != nil {
+		defaultRef = r.DefaultBranchRef.Name
+	}
+
+	return &Repository{
+		Owner:       r.Owner.Login,
+		Name:        r.Name,
+		FullName:    r.NameWithOwner,
+		CloneURL:    r.URL + ".git",
+		DefaultRef:  defaultRef,
+		HasComposer: r.Composer != nil,
+		HasNPM:      r.NPM != nil,
+		Detected:    true,
+		Size:        r.DiskUsage,
+		Empty:       r.IsEmpty || r.DefaultBranchRef == nil,
+		Template:    r.IsTemplate,
+	}
+}
@@ -0,0 +1,52 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// graphqlRequest is the body of a GraphQL API call
+type graphqlRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables,omitempty"`
+}
+
+// graphqlResponse is the envelope of a GraphQL API response
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// graphql executes a GraphQL query or mutation and decodes its data into out
+func (c *Client) graphql(ctx context.Context, query string, vars map[string]any, out any) error {
+	req, err := c.client.NewRequest("POST", "graphql", &graphqlRequest{Query: query, Variables: vars})
+	if err != nil {
+		return fmt.Errorf("failed to build GraphQL request: %w", err)
+	}
+
+	var resp graphqlResponse
+	if _, err := c.client.Do(ctx, req, &resp); err != nil {
+		return fmt.Errorf("GraphQL request failed: %w", err)
+	}
+
+	if len(resp.Errors) > 0 {
+		msgs := make([]string, len(resp.Errors))
+		for i, e := range resp.Errors {
+			msgs[i] = e.Message
+		}
+		return fmt.Errorf("GraphQL request failed: %s", strings.Join(msgs, "; "))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return fmt.Errorf("failed to decode GraphQL response: %w", err)
+	}
+
+	return nil
+}
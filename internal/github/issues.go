@@ -0,0 +1,83 @@
+package github
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v57/github"
+	"github.com/janyksteenbeek/updati/internal/audit"
+)
+
+// FindOpenIssue returns the number of the open issue with the given title, or 0 when
+// there is none. Pull requests are not considered.
+func (c *Client) FindOpenIssue(ctx context.Context, repo *Repository, title string) (int, error) {
+	opts := &github.IssueListByRepoOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	for {
+		issues, resp, err := c.client.Issues.ListByRepo(ctx, repo.Owner, repo.Name, opts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list issues: %w", err)
+		}
+
+		for _, issue := range issues {
+			if !issue.IsPullRequest() && issue.GetTitle() == title {
+				return issue.GetNumber(), nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return 0, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// CreateIssue opens an issue and returns its URL
+func (c *Client) CreateIssue(ctx context.Context, repo *Repository, title, body string) (string, error) {
+	issue, _, err := c.client.Issues.Create(ctx, repo.Owner, repo.Name, &github.IssueRequest{
+		Title: github.String(title),
+		Body:  github.String(body),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create issue: %w", err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.IssueOpen, Repository: repo.FullName, Number: issue.GetNumber(), URL: issue.GetHTMLURL()})
+	return issue.GetHTMLURL(), nil
+}
+
+// UpdateIssue replaces the body of an issue and returns its URL
+func (c *Client) UpdateIssue(ctx context.Context, repo *Repository, number int, body string) (string, error) {
+	issue, _, err := c.client.Issues.Edit(ctx, repo.Owner, repo.Name, number, &github.IssueRequest{
+		Body: github.String(body),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to update issue #%d: %w", number, err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.IssueEdit, Repository: repo.FullName, Number: number, URL: issue.GetHTMLURL()})
+	return issue.GetHTMLURL(), nil
+}
+
+// CloseIssue comments on an issue and closes it
+func (c *Client) CloseIssue(ctx context.Context, repo *Repository, number int, comment string) error {
+	if comment != "" {
+		_, _, err := c.client.Issues.CreateComment(ctx, repo.Owner, repo.Name, number, &github.IssueComment{
+			Body: github.String(comment),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to comment on #%d: %w", number, err)
+		}
+	}
+
+	_, _, err := c.client.Issues.Edit(ctx, repo.Owner, repo.Name, number, &github.IssueRequest{
+		State: github.String("closed"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close #%d: %w", number, err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.IssueClose, Repository: repo.FullName, Number: number})
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v57/github"
+	"github.com/janyksteenbeek/updati/internal/audit"
+	"github.com/janyksteenbeek/updati/internal/lock"
+)
+
+// lockExpiresPrefix starts the line of a lock commit's message recording when the lock
+// expires
+const lockExpiresPrefix = "Expires: "
+
+// RemoteLock is a run lock held through a branch of a repository
+type RemoteLock struct {
+	client *Client
+	repo   *Repository
+	branch string
+	holder string
+	sha    string // Lock commit the branch points at while the lock is held
+}
+
+// AcquireLock takes the run lock held through the branch: a commit on top of the
+// default branch whose message names the holder and when the lock expires. Creating a
+// branch is atomic, so of two runs only one gets it. A lock that expired, because its
+// run crashed, is taken over. When another run holds it, a *lock.HeldError is returned.
+func (c *Client) AcquireLock(ctx context.Context, repo *Repository, branch, holder string, ttl time.Duration) (*RemoteLock, error) {
+	head, _, err := c.client.Git.GetRef(ctx, repo.Owner, repo.Name, "refs/heads/"+repo.DefaultRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default branch of %s: %w", repo.FullName, err)
+	}
+	parent, _, err := c.client.Git.GetCommit(ctx, repo.Owner, repo.Name, head.GetObject().GetSHA())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default branch of %s: %w", repo.FullName, err)
+	}
+
+	l := &RemoteLock{client: c, repo: repo, branch: branch, holder: holder}
+	sha, err := l.commit(ctx, parent, ttl)
+	if err != nil {
+		return nil, err
+	}
+	l.sha = sha
+
+	ref := &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: github.String(sha)},
+	}
+	_, _, err = c.client.Git.CreateRef(ctx, repo.Owner, repo.Name, ref)
+	if err == nil {
+		c.Audit(ctx, audit.Entry{Action: audit.BranchCreate, Repository: repo.FullName, Branch: branch, Commit: l.sha})
+		return l, nil
+	}
+	if !strings.Contains(err.Error(), "Reference already exists") {
+		return nil, fmt.Errorf("failed to create lock branch: %w", err)
+	}
+
+	// Someone holds the lock, take it over only when it expired
+	current, err := c.BranchHead(ctx, repo, branch)
+	if err != nil {
+		return nil, err
+	}
+	held, _, err := c.client.Git.GetCommit(ctx, repo.Owner, repo.Name, current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock: %w", err)
+	}
+	heldBy, heldUntil := parseLockMessage(held.GetMessage())
+	if heldUntil.IsZero() {
+		return nil, fmt.Errorf("branch %s of %s exists but isn't a lock", branch, repo.FullName)
+	}
+	if time.Now().Before(heldUntil) {
+		return nil, &lock.HeldError{Holder: heldBy}
+	}
+
+	// Take it over with a lock commit on top of the expired one. GitHub only moves the
+	// branch when that's a fast-forward, so of two runs taking over at the same time,
+	// only the first gets it.
+	if err := l.advance(ctx, held, ttl); err != nil {
+		if isNotFastForward(err) {
+			return nil, &lock.HeldError{Holder: "another run that took over the expired lock"}
+		}
+		return nil, fmt.Errorf("failed to take over expired lock: %w", err)
+	}
+	return l, nil
+}
+
+// Renew extends the lock to expire ttl from now, so it isn't taken over while its run
+// is still going. It fails with a *lock.HeldError when another run took the lock over.
+func (l *RemoteLock) Renew(ctx context.Context, ttl time.Duration) error {
+	held, _, err := l.client.client.Git.GetCommit(ctx, l.repo.Owner, l.repo.Name, l.sha)
+	if err != nil {
+		return fmt.Errorf("failed to renew lock: %w", err)
+	}
+	if err := l.advance(ctx, held, ttl); err != nil {
+		if isNotFastForward(err) {
+			return &lock.HeldError{Holder: "another run that took over the expired lock"}
+		}
+		return fmt.Errorf("failed to renew lock: %w", err)
+	}
+	return nil
+}
+
+// advance moves the lock branch from the lock commit held to a new one expiring ttl
+// from now, without force, so it fails when the branch moved on from held
+func (l *RemoteLock) advance(ctx context.Context, held *github.Commit, ttl time.Duration) error {
+	sha, err := l.commit(ctx, held, ttl)
+	if err != nil {
+		return err
+	}
+
+	ref := &github.Reference{
+		Ref:    github.String("refs/heads/" + l.branch),
+		Object: &github.GitObject{SHA: github.String(sha)},
+	}
+	if _, _, err := l.client.client.Git.UpdateRef(ctx, l.repo.Owner, l.repo.Name, ref, false); err != nil {
+		return err
+	}
+	l.client.Audit(ctx, audit.Entry{Action: audit.BranchUpdate, Repository: l.repo.FullName, Branch: l.branch, Commit: sha})
+	l.sha = sha
+	return nil
+}
+
+// commit creates a lock commit on top of parent, with its tree, expiring ttl from now
+func (l *RemoteLock) commit(ctx context.Context, parent *github.Commit, ttl time.Duration) (string, error) {
+	expires := time.Now().Add(ttl).UTC()
+	commit, _, err := l.client.client.Git.CreateCommit(ctx, l.repo.Owner, l.repo.Name, &github.Commit{
+		Message: github.String(fmt.Sprintf("updati run lock\n\nHolder: %s\n%s%s\n", l.holder, lockExpiresPrefix, expires.Format(time.RFC3339))),
+		Tree:    &github.Tree{SHA: parent.GetTree().SHA},
+		Parents: []*github.Commit{{SHA: parent.SHA}},
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create lock commit: %w", err)
+	}
+	return commit.GetSHA(), nil
+}
+
+// isNotFastForward reports whether GitHub refused to move a branch without force, which
+// it does when the update isn't a fast-forward
+func isNotFastForward(err error) bool {
+	var ghErr *github.ErrorResponse
+	return errors.As(err, &ghErr) && ghErr.Response != nil && ghErr.Response.StatusCode == http.StatusUnprocessableEntity
+}
+
+// Release gives up the lock by deleting its branch, unless another run took it over
+func (l *RemoteLock) Release(ctx context.Context) error {
+	current, err := l.client.BranchHead(ctx, l.repo, l.branch)
+	if err != nil {
+		return err
+	}
+	if current != l.sha {
+		return nil
+	}
+
+	resp, err := l.client.client.Git.DeleteRef(ctx, l.repo.Owner, l.repo.Name, "refs/heads/"+l.branch)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	l.client.Audit(ctx, audit.Entry{Action: audit.BranchDelete, Repository: l.repo.FullName, Branch: l.branch})
+	return nil
+}
+
+// parseLockMessage returns the holder and expiry recorded in a lock commit's message
+func parseLockMessage(message string) (string, time.Time) {
+	var holder string
+	var expires time.Time
+	for _, line := range strings.Split(message, "\n") {
+		if h, ok := strings.CutPrefix(line, "Holder: "); ok {
+			holder = h
+		}
+		if e, ok := strings.CutPrefix(line, lockExpiresPrefix); ok {
+			expires, _ = time.Parse(time.RFC3339, strings.TrimSpace(e))
+		}
+	}
+	if holder != "" && !expires.IsZero() {
+		holder += ", until " + expires.Local().Format(time.DateTime)
+	}
+	return holder, expires
+}
@@ -0,0 +1,106 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v57/github"
+	"github.com/janyksteenbeek/updati/internal/audit"
+)
+
+// Merge methods accepted by pull requests
+const (
+	MergeMethodSquash = "squash"
+	MergeMethodMerge  = "merge"
+	MergeMethodRebase = "rebase"
+)
+
+// MergeSettings describes which merge methods a repository allows
+type MergeSettings struct {
+	AllowAutoMerge bool
+	Methods        []string // Allowed methods in order of preference: squash, merge, rebase
+}
+
+// Allows reports whether the repository accepts the given merge method
+func (s *MergeSettings) Allows(method string) bool {
+	for _, m := range s.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMergeSettings returns the merge settings of a repository
+func (c *Client) GetMergeSettings(ctx context.Context, repo *Repository) (*MergeSettings, error) {
+	r, _, err := c.client.Repositories.Get(ctx, repo.Owner, repo.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository: %w", err)
+	}
+
+	settings := &MergeSettings{AllowAutoMerge: r.GetAllowAutoMerge()}
+	if r.GetAllowSquashMerge() {
+		settings.Methods = append(settings.Methods, MergeMethodSquash)
+	}
+	if r.GetAllowMergeCommit() {
+		settings.Methods = append(settings.Methods, MergeMethodMerge)
+	}
+	if r.GetAllowRebaseMerge() {
+		settings.Methods = append(settings.Methods, MergeMethodRebase)
+	}
+
+	return settings, nil
+}
+
+// EnableAutoMerge enables auto-merge on a pull request with the given merge method
+func (c *Client) EnableAutoMerge(ctx context.Context, pr *github.PullRequest, method string) error {
+	const mutation = `mutation($id: ID!, $method: PullRequestMergeMethod!) {
+  enablePullRequestAutoMerge(input: {pullRequestId: $id, mergeMethod: $method}) {
+    clientMutationId
+  }
+}`
+
+	err := c.graphql(withToken(ctx, 0), mutation, map[string]any{
+		"id":     pr.GetNodeID(),
+		"method": strings.ToUpper(method),
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to enable auto-merge: %w", err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.PRAutoMerge, Repository: pr.GetBase().GetRepo().GetFullName(), Number: pr.GetNumber(), URL: pr.GetHTMLURL()})
+
+	return nil
+}
+
+// MarkReadyForReview takes a draft pull request out of draft
+func (c *Client) MarkReadyForReview(ctx context.Context, pr *github.PullRequest) error {
+	const mutation = `mutation($id: ID!) {
+  markPullRequestReadyForReview(input: {pullRequestId: $id}) {
+    clientMutationId
+  }
+}`
+
+	if err := c.graphql(withToken(ctx, 0), mutation, map[string]any{"id": pr.GetNodeID()}, nil); err != nil {
+		return fmt.Errorf("failed to mark #%d ready for review: %w", pr.GetNumber(), err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.PRReady, Repository: pr.GetBase().GetRepo().GetFullName(), Number: pr.GetNumber(), URL: pr.GetHTMLURL()})
+
+	return nil
+}
+
+// ConvertToDraft turns a pull request back into a draft
+func (c *Client) ConvertToDraft(ctx context.Context, pr *github.PullRequest) error {
+	const mutation = `mutation($id: ID!) {
+  convertPullRequestToDraft(input: {pullRequestId: $id}) {
+    clientMutationId
+  }
+}`
+
+	if err := c.graphql(withToken(ctx, 0), mutation, map[string]any{"id": pr.GetNodeID()}, nil); err != nil {
+		return fmt.Errorf("failed to convert #%d to draft: %w", pr.GetNumber(), err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.PRDraft, Repository: pr.GetBase().GetRepo().GetFullName(), Number: pr.GetNumber(), URL: pr.GetHTMLURL()})
+
+	return nil
+}
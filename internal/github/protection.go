@@ -0,0 +1,70 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// rulesetRestrictions describes the ruleset rules that keep changes from being pushed
+// to a branch directly
+var rulesetRestrictions = map[string]string{
+	"pull_request":           "requires pull requests",
+	"required_status_checks": "requires status checks",
+	"merge_queue":            "uses a merge queue",
+	"required_deployments":   "requires deployments",
+	"update":                 "restricts updates",
+}
+
+// PushRestriction returns why changes can't be pushed to the branch directly, from its
+// branch protection and the rulesets that apply to it, or an empty string when nothing
+// stands in the way. Protection details are only visible to admins; a protected branch
+// whose details can't be read counts as restricted.
+func (c *Client) PushRestriction(ctx context.Context, repo *Repository, branch string) (string, error) {
+	var reasons []string
+
+	// GitHub Enterprise Server versions without rulesets don't know the endpoint
+	rules, resp, err := c.client.Repositories.GetRulesForBranch(ctx, repo.Owner, repo.Name, branch)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return "", fmt.Errorf("failed to get rules of %s: %w", branch, err)
+	}
+	for _, rule := range rules {
+		if reason, ok := rulesetRestrictions[rule.Type]; ok && !slices.Contains(reasons, reason) {
+			reasons = append(reasons, reason)
+		}
+	}
+
+	b, _, err := c.client.Repositories.GetBranch(ctx, repo.Owner, repo.Name, branch, 1)
+	if err != nil {
+		return "", fmt.Errorf("failed to get branch %s: %w", branch, err)
+	}
+	if b.GetProtected() {
+		protection, resp, err := c.client.Repositories.GetBranchProtection(ctx, repo.Owner, repo.Name, branch)
+		switch {
+		case err == nil:
+			if protection.RequiredPullRequestReviews != nil {
+				reasons = append(reasons, "requires pull request reviews")
+			}
+			if checks := protection.RequiredStatusChecks; checks != nil && (len(checks.Checks) > 0 || len(checks.Contexts) > 0) && !slices.Contains(reasons, "requires status checks") {
+				reasons = append(reasons, "requires status checks")
+			}
+			if protection.Restrictions != nil {
+				reasons = append(reasons, "restricts who can push")
+			}
+			if protection.LockBranch != nil && protection.LockBranch.GetEnabled() {
+				reasons = append(reasons, "is locked")
+			}
+		case resp != nil && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound):
+			reasons = append(reasons, "is protected")
+		default:
+			return "", fmt.Errorf("failed to get protection of %s: %w", branch, err)
+		}
+	}
+
+	if len(reasons) == 0 {
+		return "", nil
+	}
+	return branch + " " + strings.Join(reasons, ", "), nil
+}
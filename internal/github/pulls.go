@@ -0,0 +1,198 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v57/github"
+	"github.com/janyksteenbeek/updati/internal/audit"
+)
+
+// PullRequestInfo describes an open pull request
+type PullRequestInfo struct {
+	Number int
+	URL    string
+	Head   string // Head branch name
+	Base   string
+	State  string // open or closed
+	Merged bool
+	Draft  bool
+	SHA    string // Head commit
+}
+
+// ListOpenPullRequests returns the open pull requests against base whose head branch
+// is in the repository itself and starts with headPrefix
+func (c *Client) ListOpenPullRequests(ctx context.Context, repo *Repository, base, headPrefix string) ([]PullRequestInfo, error) {
+	opts := &github.PullRequestListOptions{
+		Base:        base,
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	var result []PullRequestInfo
+	for {
+		prs, resp, err := c.client.PullRequests.List(ctx, repo.Owner, repo.Name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pull requests: %w", err)
+		}
+
+		for _, pr := range prs {
+			head := pr.GetHead()
+			if head.GetRepo().GetFullName() != repo.FullName || !strings.HasPrefix(head.GetRef(), headPrefix) {
+				continue
+			}
+			result = append(result, PullRequestInfo{
+				Number: pr.GetNumber(),
+				URL:    pr.GetHTMLURL(),
+				Head:   head.GetRef(),
+				Base:   pr.GetBase().GetRef(),
+				Draft:  pr.GetDraft(),
+				SHA:    head.GetSHA(),
+			})
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return result, nil
+}
+
+// GetPullRequest returns a pull request by number
+func (c *Client) GetPullRequest(ctx context.Context, repo *Repository, number int) (*github.PullRequest, error) {
+	pr, _, err := c.client.PullRequests.Get(ctx, repo.Owner, repo.Name, number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get #%d: %w", number, err)
+	}
+	return pr, nil
+}
+
+// ClosePullRequest comments on a pull request and closes it
+func (c *Client) ClosePullRequest(ctx context.Context, repo *Repository, number int, comment string) error {
+	if comment != "" {
+		_, _, err := c.client.Issues.CreateComment(ctx, repo.Owner, repo.Name, number, &github.IssueComment{
+			Body: github.String(comment),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to comment on #%d: %w", number, err)
+		}
+	}
+
+	_, _, err := c.client.PullRequests.Edit(ctx, repo.Owner, repo.Name, number, &github.PullRequest{
+		State: github.String("closed"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close #%d: %w", number, err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.PRClose, Repository: repo.FullName, Number: number})
+
+	return nil
+}
+
+// BranchPullRequests returns the pull requests opened from a branch of the repository,
+// most recent first
+func (c *Client) BranchPullRequests(ctx context.Context, repo *Repository, branch string) ([]PullRequestInfo, error) {
+	prs, _, err := c.client.PullRequests.List(ctx, repo.Owner, repo.Name, &github.PullRequestListOptions{
+		Head:        repo.Owner + ":" + branch,
+		State:       "all",
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull requests of %s: %w", branch, err)
+	}
+
+	result := make([]PullRequestInfo, 0, len(prs))
+	for _, pr := range prs {
+		result = append(result, PullRequestInfo{
+			Number: pr.GetNumber(),
+			URL:    pr.GetHTMLURL(),
+			Head:   pr.GetHead().GetRef(),
+			Base:   pr.GetBase().GetRef(),
+			State:  pr.GetState(),
+			Merged: pr.MergedAt != nil,
+			SHA:    pr.GetHead().GetSHA(),
+		})
+	}
+
+	return result, nil
+}
+
+// ListBranches returns the names of the repository's branches starting with prefix
+func (c *Client) ListBranches(ctx context.Context, repo *Repository, prefix string) ([]string, error) {
+	opts := &github.ReferenceListOptions{
+		Ref:         "heads/" + prefix,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	var branches []string
+	for {
+		refs, resp, err := c.client.Git.ListMatchingRefs(ctx, repo.Owner, repo.Name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list branches: %w", err)
+		}
+
+		for _, ref := range refs {
+			branches = append(branches, strings.TrimPrefix(ref.GetRef(), "refs/heads/"))
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return branches, nil
+}
+
+// AddLabels adds labels to a pull request or issue
+func (c *Client) AddLabels(ctx context.Context, repo *Repository, number int, labels []string) error {
+	_, _, err := c.client.Issues.AddLabelsToIssue(ctx, repo.Owner, repo.Name, number, labels)
+	if err != nil {
+		return fmt.Errorf("failed to add labels to #%d: %w", number, err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.LabelAdd, Repository: repo.FullName, Number: number, Labels: labels})
+	return nil
+}
+
+// RemoveLabel removes a label from a pull request or issue
+func (c *Client) RemoveLabel(ctx context.Context, repo *Repository, number int, label string) error {
+	_, err := c.client.Issues.RemoveLabelForIssue(ctx, repo.Owner, repo.Name, number, label)
+	if err != nil {
+		return fmt.Errorf("failed to remove label %s from #%d: %w", label, number, err)
+	}
+	c.Audit(ctx, audit.Entry{Action: audit.LabelRemove, Repository: repo.FullName, Number: number, Labels: []string{label}})
+	return nil
+}
+
+// EnsureLabels creates the labels that don't exist in the repository yet, with the
+// given color (hex, without #) and description
+func (c *Client) EnsureLabels(ctx context.Context, repo *Repository, labels []string, color, description string) error {
+	for _, name := range labels {
+		_, resp, err := c.client.Issues.GetLabel(ctx, repo.Owner, repo.Name, name)
+		if err == nil {
+			continue
+		}
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("failed to get label %s: %w", name, err)
+		}
+
+		_, resp, err = c.client.Issues.CreateLabel(ctx, repo.Owner, repo.Name, &github.Label{
+			Name:        github.String(name),
+			Color:       github.String(color),
+			Description: github.String(description),
+		})
+		// Another worker may have created it in the meantime
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusUnprocessableEntity) {
+			return fmt.Errorf("failed to create label %s: %w", name, err)
+		}
+		if err == nil {
+			c.Audit(ctx, audit.Entry{Action: audit.LabelCreate, Repository: repo.FullName, Labels: []string{name}})
+		}
+	}
+
+	return nil
+}
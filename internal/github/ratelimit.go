@@ -0,0 +1,318 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Pause requests once a resource has this many requests left until it resets. It
+	// exceeds the maximum number of workers so in-flight requests never exhaust the
+	// limit, which would make go-github fail requests without sending them.
+	rateLimitReserve = 25
+	// Retries for requests rejected by a (secondary) rate limit
+	rateLimitRetries = 3
+	// Wait before retrying a secondary rate limit error without Retry-After
+	secondaryRateLimitWait = time.Minute
+)
+
+// rateLimitTransport spreads requests over one or more tokens, each with its own rate
+// limits. Every request goes through the token with the most requests left; a token
+// whose limit is nearly exhausted is paused, and requests rejected by primary or
+// secondary rate limits are retried, through another token when one is available.
+type rateLimitTransport struct {
+	tokens []*tokenLimits
+
+	mu   sync.Mutex
+	next int // Token to look at first, so tokens with the same budget take turns
+}
+
+// tokenLimits tracks the rate limits of a single token, guarded by the transport's mutex
+type tokenLimits struct {
+	base http.RoundTripper // Authenticates requests with the token
+
+	resumeAt  map[string]time.Time // Per rate limit resource (core, graphql, search)
+	remaining map[string]int       // Requests left per resource, unknown before the first response
+	core      RateStatus           // Last core limit the responses reported
+	secondary time.Time            // When a secondary rate limit last rejected a request
+}
+
+// pinnedToken is the context key of the index of the token a request has to use
+type pinnedToken struct{}
+
+// withToken makes the requests made with the context use the token at index i
+func withToken(ctx context.Context, i int) context.Context {
+	return context.WithValue(ctx, pinnedToken{}, i)
+}
+
+// RateStatus is what the responses so far tell about the tokens' rate limits
+type RateStatus struct {
+	Limit     int       // Core requests per hour, 0 before the first response reported it
+	Remaining int       // Core requests left until the reset
+	Secondary time.Time // When a secondary rate limit last rejected a request, zero if never
+}
+
+// newRateLimitTransport returns a transport spreading requests over the given
+// transports, one per token
+func newRateLimitTransport(bases ...http.RoundTripper) *rateLimitTransport {
+	t := &rateLimitTransport{}
+	for _, base := range bases {
+		t.tokens = append(t.tokens, &tokenLimits{
+			base:      base,
+			resumeAt:  make(map[string]time.Time),
+			remaining: make(map[string]int),
+		})
+	}
+	return t
+}
+
+// status returns the rate limits as last reported, summed over the tokens
+func (t *rateLimitTransport) status() RateStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var status RateStatus
+	for _, token := range t.tokens {
+		status.Limit += token.core.Limit
+		status.Remaining += token.core.Remaining
+		if token.secondary.After(status.Secondary) {
+			status.Secondary = token.secondary
+		}
+	}
+	return status
+}
+
+// pick returns the token to send a request for the resource through: the pinned one,
+// or the one with the most requests left that isn't paused, or when all are paused,
+// the one resuming first
+func (t *rateLimitTransport) pick(ctx context.Context, resource string) *tokenLimits {
+	if i, ok := ctx.Value(pinnedToken{}).(int); ok && i < len(t.tokens) {
+		return t.tokens[i]
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var best *tokenLimits
+	for i := range t.tokens {
+		token := t.tokens[(t.next+i)%len(t.tokens)]
+		if best == nil || token.preferredOver(best, resource, now) {
+			best = token
+		}
+	}
+	t.next = (t.next + 1) % len(t.tokens)
+	return best
+}
+
+// preferredOver reports whether a request for the resource should rather go through
+// this token than through other
+func (l *tokenLimits) preferredOver(other *tokenLimits, resource string, now time.Time) bool {
+	paused, otherPaused := l.resumeAt[resource].After(now), other.resumeAt[resource].After(now)
+	switch {
+	case paused != otherPaused:
+		return !paused
+	case paused:
+		return l.resumeAt[resource].Before(other.resumeAt[resource])
+	}
+	return l.left(resource) > other.left(resource)
+}
+
+// left returns the requests left for the resource, assuming plenty while unknown
+func (l *tokenLimits) left(resource string) int {
+	if n, ok := l.remaining[resource]; ok {
+		return n
+	}
+	return math.MaxInt
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resource := rateLimitResource(req)
+
+	// Changes always go through the first token, github_token, so the audit log can
+	// name who made them
+	ctx := req.Context()
+	if req.Method != http.MethodGet && req.Method != http.MethodHead && !strings.HasSuffix(req.URL.Path, "/graphql") {
+		ctx = withToken(ctx, 0)
+	}
+
+	for attempt := 0; ; attempt++ {
+		token := t.pick(ctx, resource)
+		if err := t.wait(req.Context(), token, resource); err != nil {
+			return nil, err
+		}
+
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := token.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		t.track(token, resource, resp)
+
+		wait, limited := rateLimited(resp)
+		if limited && resp.Header.Get("X-RateLimit-Remaining") != "0" {
+			t.mu.Lock()
+			token.secondary = time.Now()
+			t.mu.Unlock()
+		}
+		if !limited || attempt >= rateLimitRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+		slog.Warn("GitHub rate limit hit, retrying",
+			"resource", resource,
+			"url", req.URL.Path,
+			"wait", wait.Round(time.Second),
+			"attempt", attempt+1,
+		)
+		t.pause(token, resource, time.Now().Add(wait))
+	}
+}
+
+// wait blocks until the token may make requests to the resource again
+func (t *rateLimitTransport) wait(ctx context.Context, token *tokenLimits, resource string) error {
+	t.mu.Lock()
+	until := token.resumeAt[resource]
+	t.mu.Unlock()
+
+	d := time.Until(until)
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// pause holds back the token's requests to the resource until the given time
+func (t *rateLimitTransport) pause(token *tokenLimits, resource string, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until.After(token.resumeAt[resource]) {
+		token.resumeAt[resource] = until
+	}
+}
+
+// track records the limits the response reports for the token and pauses the token's
+// requests to the resource when it is nearly exhausted
+func (t *rateLimitTransport) track(token *tokenLimits, resource string, resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	token.remaining[resource] = remaining
+	if resource == "core" {
+		if limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil {
+			token.core = RateStatus{Limit: limit, Remaining: remaining}
+		}
+	}
+	t.mu.Unlock()
+	if remaining > rateLimitReserve {
+		return
+	}
+
+	reset := resetTime(resp)
+	if reset.IsZero() || !reset.After(time.Now()) {
+		return
+	}
+
+	t.mu.Lock()
+	alreadyPaused := !token.resumeAt[resource].Before(reset)
+	t.mu.Unlock()
+
+	if !alreadyPaused {
+		if len(t.tokens) > 1 {
+			slog.Warn("GitHub rate limit of a token nearly exhausted, using the other tokens",
+				"resource", resource,
+				"token", slices.Index(t.tokens, token)+1,
+				"remaining", remaining,
+				"until", reset.Format(time.TimeOnly),
+			)
+		} else {
+			slog.Warn("GitHub rate limit nearly exhausted, pausing requests",
+				"resource", resource,
+				"remaining", remaining,
+				"until", reset.Format(time.TimeOnly),
+			)
+		}
+		t.pause(token, resource, reset)
+	}
+}
+
+// rateLimited reports whether the response is a rate limit rejection and how long to wait
+func rateLimited(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if secs, err := strconv.Atoi(retryAfter); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset := resetTime(resp); !reset.IsZero() {
+			return max(time.Until(reset), time.Second), true
+		}
+	}
+
+	// Secondary rate limits are only recognizable by their message
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err == nil && strings.Contains(strings.ToLower(string(body)), "secondary rate limit") {
+		return secondaryRateLimitWait, true
+	}
+
+	return 0, false
+}
+
+func resetTime(resp *http.Response) time.Time {
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(reset, 0)
+}
+
+// rateLimitResource returns the rate limit bucket a request counts against
+func rateLimitResource(req *http.Request) string {
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/graphql"):
+		return "graphql"
+	case strings.HasPrefix(req.URL.Path, "/search/"):
+		return "search"
+	default:
+		return "core"
+	}
+}
@@ -0,0 +1,54 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/retry"
+)
+
+// errServerError marks a 5xx response so the retry policy treats it as transient
+var errServerError = errors.New("server error")
+
+// retryTransport retries idempotent requests that failed because of the network or
+// a GitHub server error, following the configured retry policy
+type retryTransport struct {
+	base   http.RoundTripper
+	policy retry.Policy
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+
+	var resp *http.Response
+	err := t.policy.Do(req.Context(), func() error {
+		var err error
+		resp, err = t.base.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented {
+			// The last response is handed to the caller if all attempts fail
+			return fmt.Errorf("%w: %s", errServerError, resp.Status)
+		}
+		return nil
+	}, func(err error) bool {
+		return errors.Is(err, errServerError) || retry.IsTransient(err)
+	}, func(attempt int, wait time.Duration, err error) {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		slog.Warn("GitHub request failed, retrying", "url", req.URL.Path, "attempt", attempt, "wait", wait.Round(time.Millisecond), "error", err)
+	})
+
+	if errors.Is(err, errServerError) {
+		return resp, nil
+	}
+	return resp, err
+}
@@ -0,0 +1,254 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/report"
+
+	_ "modernc.org/sqlite" // Registers the pure Go sqlite driver, so builds without cgo keep working
+)
+
+// schema creates the tables on first use. Times are stored as Unix seconds.
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          TEXT PRIMARY KEY,
+	owner       TEXT NOT NULL,
+	mode        TEXT NOT NULL,
+	started_at  INTEGER NOT NULL,
+	finished_at INTEGER NOT NULL,
+	total       INTEGER NOT NULL,
+	updated     INTEGER NOT NULL,
+	skipped     INTEGER NOT NULL,
+	deferred    INTEGER NOT NULL,
+	failed      INTEGER NOT NULL,
+	workers     INTEGER NOT NULL DEFAULT 0,
+	tags        TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS results (
+	run_id      TEXT NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+	name        TEXT NOT NULL,
+	repository  TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	error       TEXT NOT NULL DEFAULT '',
+	skip_reason TEXT NOT NULL DEFAULT '',
+	pr_url      TEXT NOT NULL DEFAULT '',
+	changes     INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (run_id, name)
+);
+CREATE INDEX IF NOT EXISTS results_name ON results (name);
+`
+
+// migrations add the columns of later versions to databases created before. A column
+// that already exists fails to be added, which is ignored.
+var migrations = []string{
+	`ALTER TABLE runs ADD COLUMN workers INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE runs ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+}
+
+// hasTag is the condition matching runs tagged with the tag given as its parameter, or
+// all runs when it's empty. Tags are stored joined by commas.
+const hasTag = `(? = '' OR ',' || runs.tags || ',' LIKE '%,' || ? || ',%')`
+
+// DB is the run history, kept in a SQLite database
+type DB struct {
+	db *sql.DB
+}
+
+// Run is a recorded run
+type Run struct {
+	ID         string
+	Owner      string
+	Mode       string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Total      int
+	Updated    int
+	Skipped    int
+	Deferred   int
+	Failed     int
+	Workers    int      // Workers the run was configured with, 0 for runs recorded before they were
+	Tags       []string // Tags the run was started with
+}
+
+// Trend sums up the recorded results of a repository
+type Trend struct {
+	Name        string // Repository, followed by @base when a non-default branch was updated
+	Runs        int
+	Failed      int
+	LastStatus  string
+	LastRun     time.Time
+	LastUpdated time.Time // Start of the latest run that updated the repository, zero if none did
+}
+
+// SuccessRate returns the share of runs that didn't fail, between 0 and 1
+func (t Trend) SuccessRate() float64 {
+	if t.Runs == 0 {
+		return 0
+	}
+	return float64(t.Runs-t.Failed) / float64(t.Runs)
+}
+
+// Open opens the history database at path, creating it when it doesn't exist yet
+func Open(path string) (*DB, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create history directory: %w", err)
+		}
+	}
+
+	// Concurrent runs wait for each other's writes instead of failing
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history %s: %w", path, err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open history %s: %w", path, err)
+	}
+	for _, migration := range migrations {
+		if _, err := db.Exec(migration); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate history %s: %w", path, err)
+		}
+	}
+
+	return &DB{db: db}, nil
+}
+
+// Close closes the database
+func (h *DB) Close() error {
+	return h.db.Close()
+}
+
+// Record stores a run and the result per repository. Recording a run with the ID of
+// an earlier one replaces it.
+func (h *DB) Record(ctx context.Context, rep *report.Report) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM runs WHERE id = ?`, rep.ID); err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO runs (id, owner, mode, started_at, finished_at, total, updated, skipped, deferred, failed, workers, tags)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rep.ID, rep.Owner, rep.Mode, rep.StartedAt.Unix(), rep.FinishedAt.Unix(),
+		rep.Total, rep.Updated, rep.Skipped, rep.Deferred, rep.Failed, rep.Workers, strings.Join(rep.Tags, ","),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+
+	for _, repo := range rep.Repositories {
+		_, err := tx.ExecContext(ctx,
+			`INSERT OR REPLACE INTO results (run_id, name, repository, status, error, skip_reason, pr_url, changes)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			rep.ID, repo.Key(), repo.Repository, repo.Status, repo.Error, repo.SkipReason, repo.PRURL, len(repo.Changes),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record result of %s: %w", repo.Key(), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+	return nil
+}
+
+// Save records a run in the history database at path
+func Save(ctx context.Context, path string, rep *report.Report) error {
+	h, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	return h.Record(ctx, rep)
+}
+
+// Runs returns the latest runs, or the latest runs tagged with tag when it's set, newest
+// first
+func (h *DB) Runs(ctx context.Context, limit int, tag string) ([]Run, error) {
+	rows, err := h.db.QueryContext(ctx,
+		`SELECT id, owner, mode, started_at, finished_at, total, updated, skipped, deferred, failed, workers, tags
+		FROM runs WHERE `+hasTag+` ORDER BY started_at DESC LIMIT ?`, tag, tag, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var r Run
+		var startedAt, finishedAt int64
+		var tags string
+		if err := rows.Scan(&r.ID, &r.Owner, &r.Mode, &startedAt, &finishedAt, &r.Total, &r.Updated, &r.Skipped, &r.Deferred, &r.Failed, &r.Workers, &tags); err != nil {
+			return nil, fmt.Errorf("failed to read runs: %w", err)
+		}
+		r.StartedAt, r.FinishedAt = unixTime(startedAt), unixTime(finishedAt)
+		if tags != "" {
+			r.Tags = strings.Split(tags, ",")
+		}
+		runs = append(runs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read runs: %w", err)
+	}
+	return runs, nil
+}
+
+// Trends sums up the results of every recorded repository, or of the repositories whose
+// name contains filter, ordered by name. With tag set, only the runs tagged with it
+// count.
+func (h *DB) Trends(ctx context.Context, filter, tag string) ([]Trend, error) {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			r.name,
+			COUNT(*),
+			SUM(r.status = 'failed'),
+			(SELECT l.status FROM results l JOIN runs ON runs.id = l.run_id
+				WHERE l.name = r.name AND `+hasTag+` ORDER BY runs.started_at DESC LIMIT 1),
+			MAX(runs.started_at),
+			COALESCE(MAX(CASE WHEN r.status = 'updated' THEN runs.started_at END), 0)
+		FROM results r JOIN runs ON runs.id = r.run_id
+		WHERE r.name LIKE '%' || ? || '%' AND `+hasTag+`
+		GROUP BY r.name
+		ORDER BY r.name`, tag, tag, filter, tag, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trends: %w", err)
+	}
+	defer rows.Close()
+
+	var trends []Trend
+	for rows.Next() {
+		var t Trend
+		var lastRun, lastUpdated int64
+		if err := rows.Scan(&t.Name, &t.Runs, &t.Failed, &t.LastStatus, &lastRun, &lastUpdated); err != nil {
+			return nil, fmt.Errorf("failed to read trends: %w", err)
+		}
+		t.LastRun, t.LastUpdated = unixTime(lastRun), unixTime(lastUpdated)
+		trends = append(trends, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trends: %w", err)
+	}
+	return trends, nil
+}
+
+// unixTime converts stored Unix seconds, leaving 0 as the zero time
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
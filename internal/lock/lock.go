@@ -0,0 +1,79 @@
+package lock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HeldError is returned when another run holds a lock
+type HeldError struct {
+	Holder string // Who holds the lock, as recorded by it
+}
+
+func (e *HeldError) Error() string {
+	if e.Holder == "" {
+		return "another run holds the lock"
+	}
+	return "another run holds the lock: " + e.Holder
+}
+
+// File is an exclusive lock on a local file. The operating system releases it when the
+// process exits, so a crashed run never leaves a stale lock behind.
+type File struct {
+	f *os.File
+}
+
+// Acquire takes the lock on the file at path, creating it if needed, and records the
+// holder in it. It doesn't wait: when another process holds the lock, it returns a
+// *HeldError naming that process.
+func Acquire(path, holder string) (*File, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if held, err := tryLock(f); err != nil || held {
+		defer f.Close()
+		if held {
+			data, _ := os.ReadFile(path)
+			return nil, &HeldError{Holder: strings.TrimSpace(string(data))}
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// Best effort, the lock itself doesn't depend on the contents
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%s since %s\n", holder, time.Now().UTC().Format(time.RFC3339))
+	}
+
+	return &File{f: f}, nil
+}
+
+// Release gives up the lock
+func (l *File) Release() error {
+	if err := l.f.Truncate(0); err != nil {
+		l.f.Close()
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return l.f.Close()
+}
+
+// Holder describes this process for lock records: the host, the process and, in GitHub
+// Actions, the workflow run
+func Holder() string {
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("%s (pid %d)", host, os.Getpid())
+	if run := os.Getenv("GITHUB_RUN_ID"); run != "" {
+		holder += fmt.Sprintf(", %s/actions/runs/%s", os.Getenv("GITHUB_REPOSITORY"), run)
+	}
+	return holder
+}
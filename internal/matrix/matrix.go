@@ -0,0 +1,148 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/updater"
+)
+
+// Row is a repository and the versions of the requested packages it locks
+type Row struct {
+	Repository string
+	Versions   map[string]string // Per requested package, missing when the repository doesn't lock it
+	Error      string            // Why a lock file couldn't be read, if one couldn't
+}
+
+// Run reads the lock files of the matching repositories through the API, without
+// cloning them, and returns the version each locks of the packages, sorted by
+// repository. Repositories are read by up to cfg.Workers at a time.
+func Run(ctx context.Context, cfg *config.Config, client *github.Client, packages []string, logger *slog.Logger) ([]Row, error) {
+	repos, err := client.ListRepositories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+	repos = slices.DeleteFunc(repos, func(repo *github.Repository) bool {
+		return repo.Empty || !cfg.MatchesRepo(repo.Name)
+	})
+	logger.Info("reading lock files", "repositories", len(repos), "packages", packages)
+
+	rows := make([]Row, len(repos))
+	slots := make(chan struct{}, max(cfg.Workers, 1))
+	var wg sync.WaitGroup
+	for i, repo := range repos {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			rows[i] = scan(ctx, client, repo, packages)
+			if rows[i].Error != "" {
+				logger.Warn("could not read lock file", "repo", repo.FullName, "error", rows[i].Error)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(rows, func(a, b Row) int {
+		return strings.Compare(a.Repository, b.Repository)
+	})
+	return rows, nil
+}
+
+// scan reads the versions of the packages from the lock files of a repository,
+// fetching only the lock files of the ecosystems the packages belong to
+func scan(ctx context.Context, client *github.Client, repo *github.Repository, packages []string) Row {
+	row := Row{Repository: repo.FullName, Versions: make(map[string]string)}
+
+	byLockFile := make(map[string][]string)
+	for _, name := range packages {
+		if composerPackage(name) {
+			if !repo.Detected || repo.HasComposer {
+				byLockFile["composer.lock"] = append(byLockFile["composer.lock"], name)
+			}
+		} else if !repo.Detected || repo.HasNPM {
+			byLockFile["package-lock.json"] = append(byLockFile["package-lock.json"], name)
+		}
+	}
+
+	for file, names := range byLockFile {
+		data, err := client.FileContent(ctx, repo, file)
+		if err != nil {
+			row.Error = err.Error()
+			return row
+		}
+		if data == nil {
+			continue // Not locked
+		}
+
+		versions, err := updater.LockedVersions(file, data)
+		if err != nil {
+			row.Error = err.Error()
+			return row
+		}
+		for _, name := range names {
+			if version, ok := versions[strings.ToLower(name)]; ok {
+				row.Versions[name] = version
+			}
+		}
+	}
+
+	return row
+}
+
+// composerPackage reports whether a package name is a Composer package or platform
+// requirement, like laravel/framework, php or ext-intl, rather than an npm package
+func composerPackage(name string) bool {
+	switch {
+	case name == "php", strings.HasPrefix(name, "ext-"), strings.HasPrefix(name, "lib-"):
+		return true
+	case strings.HasPrefix(name, "@"):
+		return false // Scoped npm package
+	}
+	return strings.Contains(name, "/")
+}
+
+// Distribution counts the repositories per major version of a package, e.g. 11.x, or
+// per constraint for platform requirements, most common first
+func Distribution(rows []Row, name string) []Count {
+	counts := make(map[string]int)
+	for _, row := range rows {
+		version, ok := row.Versions[name]
+		if !ok {
+			continue
+		}
+		if major := updater.MajorVersion(version); major != version {
+			version = major + ".x"
+		}
+		counts[version]++
+	}
+
+	dist := make([]Count, 0, len(counts))
+	for version, n := range counts {
+		dist = append(dist, Count{Version: version, Repositories: n})
+	}
+	slices.SortFunc(dist, func(a, b Count) int {
+		if a.Repositories != b.Repositories {
+			return b.Repositories - a.Repositories
+		}
+		return strings.Compare(a.Version, b.Version)
+	})
+	return dist
+}
+
+// Count is the number of repositories on a version
+type Count struct {
+	Version      string
+	Repositories int
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"strings"
+)
+
+// Embed colors by outcome
+const (
+	discordGreen = 0x2ea043
+	discordRed   = 0xcf222e
+	discordGrey  = 0x8b949e
+)
+
+// maxFieldValue is Discord's limit for the value of an embed field
+const maxFieldValue = 1024
+
+// Discord posts summaries as an embed to a Discord webhook
+type Discord struct {
+	URL string
+}
+
+// Name identifies the channel in logs
+func (d *Discord) Name() string {
+	return "discord"
+}
+
+// Send posts the summary
+func (d *Discord) Send(ctx context.Context, s *Summary) error {
+	return postJSON(ctx, d.URL, discordMessage(s))
+}
+
+// discordMessage renders the summary as a webhook message with a single embed
+func discordMessage(s *Summary) map[string]any {
+	color := discordGrey
+	switch {
+	case s.Failed > 0:
+		color = discordRed
+	case s.Updated > 0:
+		color = discordGreen
+	}
+
+	fields := []map[string]any{
+		{"name": "Mode", "value": s.Mode, "inline": true},
+		{"name": "Duration", "value": s.Duration.String(), "inline": true},
+	}
+	for _, section := range []struct {
+		title   string
+		entries []Entry
+	}{{"Updated", s.Updates}, {"Failed", s.Failures}} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		fields = append(fields, map[string]any{
+			"name":  section.title,
+			"value": truncate(strings.Join(Lines(section.entries), "\n"), maxFieldValue),
+		})
+	}
+
+	embed := map[string]any{
+		"title":       s.Title,
+		"description": s.Counts(),
+		"color":       color,
+		"fields":      fields,
+	}
+	if s.RunURL != "" {
+		embed["url"] = s.RunURL
+	}
+
+	return map[string]any{
+		"username": "Updati",
+		"embeds":   []map[string]any{embed},
+	}
+}
@@ -0,0 +1,231 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/report"
+	"github.com/janyksteenbeek/updati/internal/retry"
+	"github.com/janyksteenbeek/updati/internal/updater"
+)
+
+// maxEntries caps the updated and failed repositories listed in a notification, chat
+// services reject long messages
+const maxEntries = 10
+
+// maxDetail caps the length of an entry's detail, like a long error
+const maxDetail = 200
+
+// Summary is the outcome of a run as every notification channel presents it
+type Summary struct {
+	Title    string
+	Owner    string
+	Mode     string
+	Duration time.Duration
+	RunURL   string // GitHub Actions run updati was part of, empty outside of GitHub Actions
+
+	Total    int
+	Updated  int
+	Skipped  int
+	Deferred int
+	Failed   int
+
+	Updates  []Entry
+	Failures []Entry
+
+	Report *report.Report // The complete outcome, for channels posting structured results
+}
+
+// Entry is a repository listed in a summary
+type Entry struct {
+	Repository string
+	URL        string // Pull request of an update, empty when pushed directly or failed
+	Detail     string // Package changes of an update, the error of a failure
+}
+
+// NewSummary builds the summary of a run from its report
+func NewSummary(rep *report.Report) *Summary {
+	s := &Summary{
+		Title:    "Updati run for " + rep.Owner,
+		Owner:    rep.Owner,
+		Mode:     rep.Mode,
+		Duration: rep.FinishedAt.Sub(rep.StartedAt).Round(time.Second),
+		RunURL:   runURL(),
+		Total:    rep.Total,
+		Updated:  rep.Updated,
+		Skipped:  rep.Skipped,
+		Deferred: rep.Deferred,
+		Failed:   rep.Failed,
+		Report:   rep,
+	}
+
+	for _, repo := range rep.Repositories {
+		switch repo.Status {
+		case report.StatusUpdated:
+			entry := Entry{Repository: repo.Key(), URL: repo.PRURL}
+			if len(repo.Changes) > 0 {
+				entry.Detail = updater.CountChanges(repo.Changes).String()
+			}
+			s.Updates = append(s.Updates, entry)
+		case report.StatusFailed:
+			s.Failures = append(s.Failures, Entry{Repository: repo.Key(), Detail: truncate(strings.Join(strings.Fields(repo.Error), " "), maxDetail)})
+		}
+	}
+
+	return s
+}
+
+// Counts describes the counts of the summary, e.g. "3 updated, 10 skipped, 1 failed of 14
+// repositories"
+func (s *Summary) Counts() string {
+	parts := []string{fmt.Sprintf("%d updated", s.Updated), fmt.Sprintf("%d skipped", s.Skipped)}
+	if s.Deferred > 0 {
+		parts = append(parts, fmt.Sprintf("%d deferred", s.Deferred))
+	}
+	parts = append(parts, fmt.Sprintf("%d failed", s.Failed))
+	return fmt.Sprintf("%s of %d repositories", strings.Join(parts, ", "), s.Total)
+}
+
+// Lines renders entries as markdown lines, linking the repository to the pull request,
+// with a final line counting the entries left out
+func Lines(entries []Entry) []string {
+	var lines []string
+	for i, entry := range entries {
+		if i == maxEntries {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(entries)-maxEntries))
+			break
+		}
+
+		line := entry.Repository
+		if entry.URL != "" {
+			line = fmt.Sprintf("[%s](%s)", entry.Repository, entry.URL)
+		}
+		if entry.Detail != "" {
+			line += ": " + entry.Detail
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Channel sends run summaries to a notification service
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, s *Summary) error
+}
+
+// Channels returns the configured notification channels
+func Channels(cfg config.Notifications) []Channel {
+	var channels []Channel
+	for _, url := range cfg.Teams {
+		channels = append(channels, &Teams{URL: url})
+	}
+	for _, url := range cfg.Discord {
+		channels = append(channels, &Discord{URL: url})
+	}
+	for _, url := range cfg.Webhook.URLs {
+		channels = append(channels, &Webhook{URL: url, Secret: cfg.Webhook.Secret})
+	}
+	return channels
+}
+
+// Send sends the summary of a run to every configured channel. Failing channels are
+// logged and don't fail the run.
+func Send(ctx context.Context, cfg *config.Config, rep *report.Report, logger *slog.Logger) {
+	channels := Channels(cfg.Notifications)
+	if len(channels) == 0 {
+		return
+	}
+
+	summary := NewSummary(rep)
+	for _, channel := range channels {
+		err := cfg.Retry.Do(ctx, func() error {
+			return channel.Send(ctx, summary)
+		}, retryable, nil)
+		if err != nil {
+			logger.Warn("failed to send notification", "channel", channel.Name(), "error", err)
+			continue
+		}
+		logger.Debug("sent notification", "channel", channel.Name())
+	}
+}
+
+// statusError is a webhook that didn't accept a notification
+type statusError struct {
+	Status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d", e.Status)
+}
+
+// retryable retries rate limits, server errors and network problems
+func retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.Status == http.StatusTooManyRequests || status.Status >= 500
+	}
+	return retry.IsTransient(err)
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON posts the payload to a webhook URL
+func postJSON(ctx context.Context, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	return post(ctx, url, body, nil)
+}
+
+// post posts a JSON body to a webhook URL with the extra headers
+func post(ctx context.Context, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &statusError{Status: resp.StatusCode}
+	}
+	return nil
+}
+
+// runURL returns the URL of the GitHub Actions run updati is part of, or an empty
+// string outside of GitHub Actions
+func runURL() string {
+	server, repo, id := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID")
+	if server == "" || repo == "" || id == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/actions/runs/%s", server, repo, id)
+}
+
+// truncate shortens s to n characters, ending it with an ellipsis when cut
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
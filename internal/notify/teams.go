@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"context"
+	"strings"
+)
+
+// Teams posts summaries as an adaptive card to a Microsoft Teams incoming webhook or
+// Workflows webhook
+type Teams struct {
+	URL string
+}
+
+// Name identifies the channel in logs
+func (t *Teams) Name() string {
+	return "teams"
+}
+
+// Send posts the summary
+func (t *Teams) Send(ctx context.Context, s *Summary) error {
+	return postJSON(ctx, t.URL, teamsMessage(s))
+}
+
+// teamsMessage renders the summary as a message with an adaptive card attachment
+func teamsMessage(s *Summary) map[string]any {
+	color := "Default"
+	switch {
+	case s.Failed > 0:
+		color = "Attention"
+	case s.Updated > 0:
+		color = "Good"
+	}
+
+	body := []map[string]any{
+		{"type": "TextBlock", "text": s.Title, "size": "Large", "weight": "Bolder", "color": color, "wrap": true},
+		{"type": "TextBlock", "text": s.Counts(), "wrap": true},
+		{"type": "FactSet", "facts": []map[string]string{
+			{"title": "Mode", "value": s.Mode},
+			{"title": "Duration", "value": s.Duration.String()},
+		}},
+	}
+	for _, section := range []struct {
+		title   string
+		entries []Entry
+	}{{"Updated", s.Updates}, {"Failed", s.Failures}} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		body = append(body,
+			map[string]any{"type": "TextBlock", "text": section.title, "weight": "Bolder", "separator": true},
+			// Teams only renders lists when the items are separated by line breaks
+			map[string]any{"type": "TextBlock", "text": "- " + strings.Join(Lines(section.entries), "\r- "), "wrap": true},
+		)
+	}
+
+	card := map[string]any{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if s.RunURL != "" {
+		card["actions"] = []map[string]string{{"type": "Action.OpenUrl", "title": "View run", "url": s.RunURL}}
+	}
+
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	}
+}
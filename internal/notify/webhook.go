@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Webhook posts the JSON report of a run to a URL, signed with an HMAC of the body when
+// a secret is set
+type Webhook struct {
+	URL    string
+	Secret string
+}
+
+// Name identifies the channel in logs
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Send posts the report of the summary
+func (w *Webhook) Send(ctx context.Context, s *Summary) error {
+	body, err := json.Marshal(s.Report)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	header := http.Header{}
+	header.Set("User-Agent", "updati")
+	header.Set("X-Updati-Event", "run")
+	if w.Secret != "" {
+		header.Set("X-Updati-Signature-256", "sha256="+sign(w.Secret, body))
+	}
+
+	return post(ctx, w.URL, body, header)
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the body, the signature scheme GitHub
+// uses for its webhooks
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
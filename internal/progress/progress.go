@@ -0,0 +1,204 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How often the display is redrawn to advance the clocks
+	refreshInterval = 250 * time.Millisecond
+	// Width of the progress bar in characters
+	barWidth = 30
+	// Repository names are cut to this many characters to keep lines from wrapping
+	maxRepoWidth = 40
+)
+
+// Display renders a live status line per worker and an overall progress bar with an
+// ETA on a terminal. Log lines written through it scroll above the block.
+type Display struct {
+	mu      sync.Mutex
+	w       io.Writer
+	total   int
+	done    int
+	started time.Time
+	workers []status
+	drawn   int // Lines of the block currently on screen
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// status is what a worker is doing
+type status struct {
+	repo  string // Empty when idle
+	phase string
+	since time.Time
+}
+
+// IsTerminal reports whether f is attached to a terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// New creates a display drawing on w, which should be a terminal
+func New(w io.Writer) *Display {
+	return &Display{w: w}
+}
+
+// Start draws the display for total repositories processed by the given number of
+// workers and keeps it up to date until Stop
+func (d *Display) Start(total, workers int) {
+	d.mu.Lock()
+	d.total = total
+	d.workers = make([]status, workers)
+	d.started = time.Now()
+	d.stop = make(chan struct{})
+	d.stopped = make(chan struct{})
+	d.draw()
+	d.mu.Unlock()
+
+	go func() {
+		defer close(d.stopped)
+
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.mu.Lock()
+				d.redraw()
+				d.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop removes the display from the screen
+func (d *Display) Stop() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.stopped
+
+	d.mu.Lock()
+	d.clear()
+	d.workers = nil
+	d.mu.Unlock()
+}
+
+// Begin shows the worker processing the repository
+func (d *Display) Begin(worker int, repo string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if worker >= 0 && worker < len(d.workers) {
+		d.workers[worker] = status{repo: repo, phase: "starting", since: time.Now()}
+	}
+	d.redraw()
+}
+
+// Phase shows the phase the worker processing the repository entered
+func (d *Display) Phase(repo, phase string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range d.workers {
+		if d.workers[i].repo == repo {
+			d.workers[i].phase = phase
+			d.workers[i].since = time.Now()
+		}
+	}
+	d.redraw()
+}
+
+// Finish counts the repository as processed and shows its worker as idle
+func (d *Display) Finish(repo string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.done++
+	for i := range d.workers {
+		if d.workers[i].repo == repo {
+			d.workers[i] = status{}
+		}
+	}
+	d.redraw()
+}
+
+// Write writes log output above the display
+func (d *Display) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.clear()
+	n, err := d.w.Write(p)
+	d.draw()
+	return n, err
+}
+
+// clear moves the cursor to the start of the block and erases it. The caller holds d.mu.
+func (d *Display) clear() {
+	if d.drawn > 0 {
+		fmt.Fprintf(d.w, "\033[%dA\033[J", d.drawn)
+		d.drawn = 0
+	}
+}
+
+// redraw replaces the block on screen. The caller holds d.mu.
+func (d *Display) redraw() {
+	if d.stop == nil {
+		return
+	}
+	d.clear()
+	d.draw()
+}
+
+// draw writes the block below the cursor. The caller holds d.mu.
+func (d *Display) draw() {
+	if d.workers == nil {
+		return
+	}
+
+	var b strings.Builder
+	elapsed := time.Since(d.started)
+
+	filled := 0
+	if d.total > 0 {
+		filled = barWidth * d.done / d.total
+	}
+	fmt.Fprintf(&b, "   [%s%s] %d/%d  %s elapsed", strings.Repeat("█", filled), strings.Repeat("░", barWidth-filled), d.done, d.total, elapsed.Round(time.Second))
+	if d.done > 0 && d.done < d.total {
+		eta := elapsed / time.Duration(d.done) * time.Duration(d.total-d.done)
+		fmt.Fprintf(&b, ", ETA %s", eta.Round(time.Second))
+	}
+	b.WriteString("\n")
+
+	for i, w := range d.workers {
+		if w.repo == "" {
+			fmt.Fprintf(&b, "   worker %-2d idle\n", i)
+			continue
+		}
+		fmt.Fprintf(&b, "   worker %-2d %-*s %s (%s)\n", i, maxRepoWidth, cut(w.repo, maxRepoWidth), w.phase, time.Since(w.since).Round(time.Second))
+	}
+
+	io.WriteString(d.w, b.String())
+	d.drawn = len(d.workers) + 1
+}
+
+// cut shortens s to n characters, marking the cut with an ellipsis
+func cut(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,44 @@
+package promote
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/updater"
+)
+
+// Run checks the draft update pull requests of the matching repositories and marks the
+// ones whose CI passed ready for review, see Updater.Promote. Pull requests are looked
+// up through reader and promoted through client.
+func Run(ctx context.Context, cfg *config.Config, client, reader *github.Client, logger *slog.Logger) ([]updater.Promotion, error) {
+	repos, err := reader.ListRepositories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	u := updater.New(cfg, client, reader)
+
+	var promotions []updater.Promotion
+	for _, repo := range repos {
+		if !cfg.MatchesRepo(repo.Name) || repo.Empty {
+			continue
+		}
+		if ctx.Err() != nil {
+			return promotions, ctx.Err()
+		}
+
+		log := logger.With("repo", repo.FullName)
+
+		found, err := u.Promote(ctx, repo, log)
+		if err != nil {
+			log.Warn("could not look for draft pull requests", "error", err)
+			continue
+		}
+		promotions = append(promotions, found...)
+	}
+
+	return promotions, nil
+}
@@ -0,0 +1,189 @@
+package report
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/janyksteenbeek/updati/internal/updater"
+)
+
+// WriteStepSummary appends a markdown summary of the run to the GitHub Actions step summary file
+func (r *Report) WriteStepSummary(path string) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "## 🚀 Updati run `%s`\n\n", r.ID)
+	fmt.Fprintf(&b, "Owner: `%s` · Mode: `%s`", r.Owner, r.Mode)
+	if len(r.Tags) > 0 {
+		fmt.Fprintf(&b, " · Tags: `%s`", strings.Join(r.Tags, "`, `"))
+	}
+	b.WriteString("\n\n")
+	b.WriteString("| Total | Updated | Skipped | Failed |\n")
+	b.WriteString("|------:|--------:|--------:|-------:|\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d |\n\n", r.Total, r.Updated, r.Skipped, r.Failed)
+	if r.Deferred > 0 {
+		fmt.Fprintf(&b, "%d deferred to a later run by the pull request budget\n\n", r.Deferred)
+	}
+
+	if d := r.Drift(); d != nil {
+		fmt.Fprintf(&b, "Dependency drift: %d of %d direct dependencies outdated, %d majors behind, %.0f days behind on average\n\n",
+			d.Outdated, d.Direct, d.MajorsBehind, d.MeanDaysBehind)
+	}
+
+	var rows []string
+	for _, repo := range r.Repositories {
+		switch repo.Status {
+		case StatusUpdated:
+			details := "pushed to `" + repo.Branch + "`"
+			if repo.PRURL != "" {
+				details = fmt.Sprintf("[#%d](%s)", repo.PRNumber, repo.PRURL)
+			}
+			if len(repo.Changes) > 0 {
+				details += " · " + updater.SummarizeChanges(repo.Changes)
+			}
+			rows = append(rows, fmt.Sprintf("| %s | ✅ updated | %s |", repo.Key(), details))
+		case StatusFailed:
+			details := markdownCell(repo.Error)
+			if repo.FailureIssue != "" {
+				details += fmt.Sprintf(" · [failing for %d runs](%s)", repo.FailedRuns, repo.FailureIssue)
+			}
+			rows = append(rows, fmt.Sprintf("| %s | ❌ failed | %s |", repo.Key(), details))
+		}
+	}
+
+	if len(rows) > 0 {
+		b.WriteString("| Repository | Status | Details |\n")
+		b.WriteString("|------------|--------|---------|\n")
+		b.WriteString(strings.Join(rows, "\n"))
+		b.WriteString("\n")
+	}
+
+	var advisories []string
+	for _, repo := range r.Repositories {
+		for _, adv := range repo.Advisories {
+			advisories = append(advisories, fmt.Sprintf("- **%s**: %s", repo.Key(), adv.Message))
+		}
+	}
+	if len(advisories) > 0 {
+		b.WriteString("\n### 💡 Advisories\n\n")
+		b.WriteString(strings.Join(advisories, "\n"))
+		b.WriteString("\n")
+	}
+
+	if len(r.Laravel) > 0 {
+		b.WriteString("\n### 🧭 Laravel versions\n\n")
+		b.WriteString("| Major | Repositories |\n")
+		b.WriteString("|-------|--------------|\n")
+		for _, major := range r.Laravel {
+			fmt.Fprintf(&b, "| %s | %s |\n", major.Major, major.Summary())
+		}
+	}
+
+	if len(r.Toolchain) > 0 {
+		b.WriteString("\n<details><summary>Toolchain</summary>\n\n")
+		b.WriteString("| Tool | Version | Path |\n")
+		b.WriteString("|------|---------|------|\n")
+		for _, tool := range r.Toolchain {
+			version := tool.Version
+			if tool.Error != "" {
+				version = markdownCell(tool.Error)
+			}
+			fmt.Fprintf(&b, "| %s | %s | `%s` |\n", tool.Name, version, tool.Path)
+		}
+		b.WriteString("\n</details>\n")
+	}
+
+	return appendFile(path, b.String())
+}
+
+// WriteOutputs appends step outputs describing the run to the GitHub Actions output file
+func (r *Report) WriteOutputs(path string) error {
+	var prURLs, failed []string
+	for _, repo := range r.Repositories {
+		if repo.PRURL != "" {
+			prURLs = append(prURLs, repo.PRURL)
+		}
+		if repo.Status == StatusFailed {
+			failed = append(failed, repo.Key())
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "total=%d\n", r.Total)
+	fmt.Fprintf(&b, "updated=%d\n", r.Updated)
+	fmt.Fprintf(&b, "updated_count=%d\n", r.Updated)
+	fmt.Fprintf(&b, "failed=%d\n", r.Failed)
+	writeMultilineOutput(&b, "pr_urls", prURLs)
+	writeMultilineOutput(&b, "failed_repos", failed)
+	fmt.Fprintf(&b, "cache_key=%s\n", r.CacheKey())
+
+	return appendFile(path, b.String())
+}
+
+// writeMultilineOutput writes a newline separated output using the heredoc syntax
+func writeMultilineOutput(b *strings.Builder, name string, values []string) {
+	delim := make([]byte, 8)
+	_, _ = rand.Read(delim)
+	marker := "UPDATI_" + hex.EncodeToString(delim)
+
+	fmt.Fprintf(b, "%s<<%s\n", name, marker)
+	for _, v := range values {
+		b.WriteString(v)
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(b, "%s\n", marker)
+}
+
+// markdownCell flattens text so it fits in a single markdown table cell
+func markdownCell(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.ReplaceAll(s, "|", "\\|")
+	// Cut by characters, a cut through a multi-byte one isn't valid UTF-8
+	if runes := []rune(s); len(runes) > 200 {
+		s = string(runes[:200]) + "…"
+	}
+	return s
+}
+
+func appendFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// CacheKey identifies the dependency state of the processed repositories by their
+// lockfile hashes, consecutive failures and the repositories left for the next run, so
+// a cache is only saved again when any of them changed. It is empty when no repository
+// has lockfiles or failures.
+func (r *Report) CacheKey() string {
+	var lines []string
+	for _, repo := range r.Repositories {
+		if repo.LockfileHash != "" {
+			lines = append(lines, repo.Key()+" "+repo.LockfileHash)
+		}
+		if repo.FailedRuns > 0 {
+			lines = append(lines, fmt.Sprintf("%s failed %d", repo.Key(), repo.FailedRuns))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	// The queue of a time-boxed run is part of the state worth saving
+	slices.Sort(lines)
+	lines = append(lines, r.Remaining...)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
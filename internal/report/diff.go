@@ -0,0 +1,108 @@
+package report
+
+import "sort"
+
+// Change describes how a repository's outcome differs between two runs
+type Change struct {
+	Repository string
+	Before     *RepoResult // nil if the repository was not part of the first run
+	After      *RepoResult // nil if the repository was not part of the second run
+}
+
+// ToolChange describes a tool whose version differs between two runs
+type ToolChange struct {
+	Name   string
+	Before string // Empty if the tool was not available
+	After  string // Empty if the tool was not available
+}
+
+// Comparison groups the differences between two runs
+type Comparison struct {
+	Before *Report
+	After  *Report
+
+	NewlyFailing []Change // failed in the second run but not in the first
+	Fixed        []Change // failed in the first run but not in the second
+	NewlyUpdated []Change // updated in the second run but not in the first
+	Added        []Change // only present in the second run
+	Removed      []Change // only present in the first run
+
+	Toolchain []ToolChange // tools whose version changed
+}
+
+// Compare computes the differences between two runs
+func Compare(before, after *Report) *Comparison {
+	c := &Comparison{Before: before, After: after}
+
+	for _, a := range after.Repositories {
+		b := before.Lookup(a.Key())
+		change := Change{Repository: a.Key(), Before: b, After: a}
+
+		if b == nil {
+			c.Added = append(c.Added, change)
+			if a.Status == StatusFailed {
+				c.NewlyFailing = append(c.NewlyFailing, change)
+			}
+			continue
+		}
+
+		switch {
+		case a.Status == StatusFailed && b.Status != StatusFailed:
+			c.NewlyFailing = append(c.NewlyFailing, change)
+		case a.Status != StatusFailed && b.Status == StatusFailed:
+			c.Fixed = append(c.Fixed, change)
+		}
+
+		if a.Status == StatusUpdated && b.Status != StatusUpdated {
+			c.NewlyUpdated = append(c.NewlyUpdated, change)
+		}
+	}
+
+	for _, b := range before.Repositories {
+		if after.Lookup(b.Key()) == nil {
+			c.Removed = append(c.Removed, Change{Repository: b.Key(), Before: b})
+		}
+	}
+
+	c.Toolchain = compareToolchains(before, after)
+
+	for _, changes := range [][]Change{c.NewlyFailing, c.Fixed, c.NewlyUpdated, c.Added, c.Removed} {
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].Repository < changes[j].Repository
+		})
+	}
+
+	return c
+}
+
+func compareToolchains(before, after *Report) []ToolChange {
+	var changes []ToolChange
+	seen := make(map[string]bool)
+
+	for _, r := range []*Report{before, after} {
+		for _, tool := range r.Toolchain {
+			if seen[tool.Name] {
+				continue
+			}
+			seen[tool.Name] = true
+
+			var b, a string
+			if t := before.Tool(tool.Name); t != nil {
+				b = t.Version
+			}
+			if t := after.Tool(tool.Name); t != nil {
+				a = t.Version
+			}
+			if a != b {
+				changes = append(changes, ToolChange{Name: tool.Name, Before: b, After: a})
+			}
+		}
+	}
+
+	return changes
+}
+
+// HasRegressions reports whether any repository started failing
+func (c *Comparison) HasRegressions() bool {
+	return len(c.NewlyFailing) > 0
+}
@@ -0,0 +1,88 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/janyksteenbeek/updati/internal/updater"
+)
+
+// WriteMetrics writes the run as Prometheus metrics in the text exposition format, for
+// the node exporter's textfile collector. The file is replaced atomically so the
+// collector never reads a partial file. The run's metrics carry its tags, joined by
+// commas, in the tag label.
+func (r *Report) WriteMetrics(path string) error {
+	var b strings.Builder
+
+	tag := strings.Join(r.Tags, ",")
+	writeMetric(&b, "updati_last_run_timestamp_seconds", "Time the last run finished", []string{"tag", tag}, float64(r.FinishedAt.Unix()))
+	writeMetric(&b, "updati_last_run_duration_seconds", "Duration of the last run", []string{"tag", tag}, r.FinishedAt.Sub(r.StartedAt).Seconds())
+
+	writeMetric(&b, "updati_repositories", "Repositories processed in the last run by status", []string{"owner", r.Owner, "status", StatusUpdated, "tag", tag}, float64(r.Updated))
+	writeMetric(&b, "updati_repositories", "", []string{"owner", r.Owner, "status", StatusSkipped, "tag", tag}, float64(r.Skipped))
+	writeMetric(&b, "updati_repositories", "", []string{"owner", r.Owner, "status", StatusDeferred, "tag", tag}, float64(r.Deferred))
+	writeMetric(&b, "updati_repositories", "", []string{"owner", r.Owner, "status", StatusFailed, "tag", tag}, float64(r.Failed))
+
+	gauges := []struct {
+		name  string
+		help  string
+		value func(f updater.Freshness) float64
+	}{
+		{"updati_dependencies_direct", "Direct dependencies", func(f updater.Freshness) float64 { return float64(f.Direct) }},
+		{"updati_dependencies_outdated", "Direct dependencies not on their latest release", func(f updater.Freshness) float64 { return float64(f.Outdated) }},
+		{"updati_dependencies_majors_behind", "Major versions the direct dependencies are behind", func(f updater.Freshness) float64 { return float64(f.MajorsBehind) }},
+		{"updati_dependencies_days_behind_mean", "Mean days between the locked and latest release of direct dependencies", func(f updater.Freshness) float64 { return f.MeanDaysBehind }},
+	}
+	for _, g := range gauges {
+		help := g.help
+		for _, repo := range r.Repositories {
+			for _, f := range repo.Freshness {
+				labels := []string{"repository", repo.Repository, "base_branch", repo.BaseBranch, "ecosystem", f.Ecosystem}
+				writeMetric(&b, g.name, help, labels, g.value(f))
+				help = ""
+			}
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".updati-metrics-*")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+
+	return nil
+}
+
+// writeMetric writes a gauge sample, preceded by its HELP and TYPE lines when help is set.
+// labels alternate between names and values.
+func writeMetric(b *strings.Builder, name, help string, labels []string, value float64) {
+	if help != "" {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	b.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+		}
+		b.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	fmt.Fprintf(b, " %g\n", value)
+}
@@ -0,0 +1,350 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+	"github.com/janyksteenbeek/updati/internal/updater"
+	"github.com/janyksteenbeek/updati/internal/worker"
+)
+
+// Repository statuses recorded in a report
+const (
+	StatusUpdated  = "updated"
+	StatusSkipped  = "skipped"
+	StatusDeferred = "deferred" // Left for a later run by the pull request budget
+	StatusExcluded = "excluded" // An empty or template repository, counted as skipped
+	StatusRedCI    = "red_ci"   // Skipped by require_green_ci, counted as skipped
+	StatusFailed   = "failed"
+)
+
+// Report is the persisted outcome of a single run
+type Report struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"owner"`
+	Mode       string    `json:"mode"`
+	DryRun     bool      `json:"dry_run"`
+	DryRunMode string    `json:"dry_run_mode,omitempty"` // detect, resolve or push
+	Tags       []string  `json:"tags,omitempty"`
+	Workers    int       `json:"workers"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Updated    int `json:"updated"`
+	Skipped    int `json:"skipped"`
+	Deferred   int `json:"deferred,omitempty"`
+	Failed     int `json:"failed"`
+
+	// Tools available on the machine that performed the run
+	Toolchain []toolchain.Tool `json:"toolchain,omitempty"`
+
+	Repositories []*RepoResult  `json:"repositories"`
+	Remaining    []string       `json:"remaining,omitempty"` // Repositories a time-boxed run left for the next run
+	Laravel      []LaravelMajor `json:"laravel,omitempty"`   // Repositories per Laravel major version
+}
+
+// LaravelMajor lists the repositories on a Laravel major version
+type LaravelMajor struct {
+	Major        string   `json:"major"`
+	Repositories []string `json:"repositories"`
+}
+
+// RepoResult is the outcome for a single repository within a report
+type RepoResult struct {
+	Name          string   `json:"name"` // Repository, followed by @base when a non-default branch was updated
+	Repository    string   `json:"repository"`
+	Status        string   `json:"status"`
+	Error         string   `json:"error,omitempty"`
+	Timeout       *Timeout `json:"timeout,omitempty"` // The plugin that was killed for taking too long, when that failed the update
+	SkipReason    string   `json:"skip_reason,omitempty"`
+	Branch        string   `json:"branch,omitempty"`
+	BaseBranch    string   `json:"base_branch,omitempty"`
+	PRNumber      int      `json:"pr_number,omitempty"`
+	PRURL         string   `json:"pr_url,omitempty"`
+	AutoMerge     string   `json:"auto_merge,omitempty"` // Merge method auto-merge was enabled with
+	Draft         bool     `json:"draft,omitempty"`      // Opened as a draft that is marked ready once CI passes
+	Superseded    []int    `json:"superseded,omitempty"` // Older pull requests closed in favor of this one
+	CheckRunURL   string   `json:"check_run_url,omitempty"`
+	PRFallback    string   `json:"pr_fallback,omitempty"` // Why a pull request was opened instead of pushing directly
+	ChangedFiles  []string `json:"changed_files,omitempty"`
+	LockfileHash  string   `json:"lockfile_hash,omitempty"`   // Hash of the base branch's lockfiles
+	Laravel       string   `json:"laravel_version,omitempty"` // laravel/framework version locked on the base branch
+	FailedRuns    int      `json:"failed_runs,omitempty"`     // Consecutive failed runs, when failure issues are enabled
+	FailureIssue  string   `json:"failure_issue,omitempty"`   // Issue reporting the failures
+	AdvisoryIssue string   `json:"advisory_issue,omitempty"`  // Issue listing the open advisories
+
+	Changes         []updater.PackageChange    `json:"changes,omitempty"`          // Packages added, removed or changed by the update
+	ChangeStats     *updater.ChangeStats       `json:"change_stats,omitempty"`     // Counts of the changes by kind
+	Risk            *updater.Risk              `json:"risk,omitempty"`             // How carefully the update needs reviewing, with risk_score
+	FixedAdvisories []updater.SecurityAdvisory `json:"fixed_advisories,omitempty"` // Known vulnerabilities the update resolves
+	OpenAdvisories  []updater.SecurityAdvisory `json:"open_advisories,omitempty"`  // Known vulnerabilities left after the update, with advisory_issue
+	Advisories      []updater.Advisory         `json:"advisories,omitempty"`
+	Freshness       []updater.Freshness        `json:"freshness,omitempty"`
+
+	Timings *Timings `json:"timings,omitempty"` // Missing in reports written before timings were recorded
+}
+
+// Timeout is a plugin killed for exceeding its timeout
+type Timeout struct {
+	Plugin string  `json:"plugin"`
+	After  float64 `json:"after"` // The plugin's timeout, in seconds
+}
+
+// Timings is how long the phases of a repository's update took, in seconds
+type Timings struct {
+	Total   float64            `json:"total"`
+	Clone   float64            `json:"clone,omitempty"`
+	Plugins map[string]float64 `json:"plugins,omitempty"`
+	Push    float64            `json:"push,omitempty"`
+}
+
+func newTimings(t updater.Timings) *Timings {
+	timings := &Timings{
+		Total: t.Total.Seconds(),
+		Clone: t.Clone.Seconds(),
+		Push:  t.Push.Seconds(),
+	}
+	for name, d := range t.Plugins {
+		if timings.Plugins == nil {
+			timings.Plugins = make(map[string]float64)
+		}
+		timings.Plugins[name] = d.Seconds()
+	}
+	return timings
+}
+
+// New builds a report from the results of a run
+func New(cfg *config.Config, mode string, result *worker.ProcessResult, startedAt, finishedAt time.Time) *Report {
+	r := &Report{
+		ID:           startedAt.UTC().Format("20060102-150405"),
+		Owner:        cfg.Owner,
+		Mode:         mode,
+		DryRun:       cfg.DryRun.Enabled(),
+		DryRunMode:   string(cfg.DryRun),
+		Tags:         cfg.Tags,
+		Workers:      cfg.Workers,
+		StartedAt:    startedAt.UTC(),
+		FinishedAt:   finishedAt.UTC(),
+		Total:        result.Total,
+		Successful:   result.Successful,
+		Updated:      result.Updated,
+		Skipped:      result.Skipped,
+		Deferred:     result.Deferred,
+		Failed:       result.Failed,
+		Repositories: make([]*RepoResult, 0, len(result.Results)),
+	}
+
+	for _, res := range result.Results {
+		repo := &RepoResult{
+			Name:            res.Name(),
+			Repository:      res.Repository.FullName,
+			Branch:          res.Branch,
+			BaseBranch:      res.BaseBranch,
+			PRNumber:        res.PRNumber,
+			PRURL:           res.PRURL,
+			AutoMerge:       res.AutoMergeMethod,
+			Draft:           res.Draft,
+			Superseded:      res.Superseded,
+			CheckRunURL:     res.CheckRunURL,
+			PRFallback:      res.PRFallback,
+			LockfileHash:    res.LockfileHash,
+			Laravel:         res.Laravel,
+			FailedRuns:      res.FailedRuns,
+			FailureIssue:    res.FailureIssue,
+			AdvisoryIssue:   res.AdvisoryIssue,
+			ChangedFiles:    res.ChangedFiles,
+			SkipReason:      res.SkipReason,
+			Changes:         res.Changes,
+			Risk:            res.Risk,
+			FixedAdvisories: res.FixedAdvisories,
+			OpenAdvisories:  res.OpenAdvisories,
+			Advisories:      res.Advisories,
+			Freshness:       res.Freshness,
+			Timings:         newTimings(res.Timings),
+		}
+
+		if len(res.Changes) > 0 {
+			repo.ChangeStats = &res.ChangeStats
+		}
+
+		switch {
+		case res.Error != nil:
+			repo.Status = StatusFailed
+			repo.Error = res.Error.Error()
+			var timeout *updater.TimeoutError
+			if errors.As(res.Error, &timeout) {
+				repo.Timeout = &Timeout{Plugin: timeout.Plugin, After: timeout.Timeout.Seconds()}
+			}
+		case res.Updated:
+			repo.Status = StatusUpdated
+		case res.Deferred:
+			repo.Status = StatusDeferred
+		case res.Excluded:
+			repo.Status = StatusExcluded
+		case res.RedCI:
+			repo.Status = StatusRedCI
+		default:
+			repo.Status = StatusSkipped
+		}
+
+		r.Repositories = append(r.Repositories, repo)
+	}
+
+	for _, repo := range result.Remaining {
+		r.Remaining = append(r.Remaining, repo.FullName)
+	}
+	r.Laravel = laravelMajors(r.Repositories)
+
+	return r
+}
+
+// maxListedRepositories caps the repositories named per Laravel major in summaries
+const maxListedRepositories = 10
+
+// Summary counts the repositories and names the first of them, e.g. "12: acme/shop,
+// acme/blog, … and 2 more"
+func (m LaravelMajor) Summary() string {
+	names := m.Repositories
+	more := ""
+	if len(names) > maxListedRepositories {
+		more = fmt.Sprintf(", … and %d more", len(names)-maxListedRepositories)
+		names = names[:maxListedRepositories]
+	}
+	return fmt.Sprintf("%d: %s%s", len(m.Repositories), strings.Join(names, ", "), more)
+}
+
+// laravelMajors groups the repositories that lock Laravel by major version, newest
+// major first
+func laravelMajors(repos []*RepoResult) []LaravelMajor {
+	byMajor := make(map[string][]string)
+	for _, repo := range repos {
+		if repo.Laravel != "" {
+			major := updater.MajorVersion(repo.Laravel)
+			byMajor[major] = append(byMajor[major], repo.Key())
+		}
+	}
+
+	majors := make([]LaravelMajor, 0, len(byMajor))
+	for major, names := range byMajor {
+		slices.Sort(names)
+		majors = append(majors, LaravelMajor{Major: major, Repositories: names})
+	}
+	slices.SortFunc(majors, func(a, b LaravelMajor) int {
+		an, aErr := strconv.Atoi(a.Major)
+		bn, bErr := strconv.Atoi(b.Major)
+		switch {
+		case aErr == nil && bErr == nil:
+			return bn - an
+		case aErr == nil:
+			return -1 // Dev branches last
+		case bErr == nil:
+			return 1
+		}
+		return strings.Compare(a.Major, b.Major)
+	})
+	return majors
+}
+
+// Save writes the report as JSON to the given path
+func (r *Report) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	return nil
+}
+
+// Load reads a report previously written by Save
+func Load(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+
+	return &r, nil
+}
+
+// Tool returns the toolchain entry with the given name, or nil if it was not recorded
+func (r *Report) Tool(name string) *toolchain.Tool {
+	for i := range r.Toolchain {
+		if r.Toolchain[i].Name == name {
+			return &r.Toolchain[i]
+		}
+	}
+	return nil
+}
+
+// Lookup returns the result with the given name, or nil if it was not part of the run
+func (r *Report) Lookup(name string) *RepoResult {
+	for _, repo := range r.Repositories {
+		if repo.Key() == name {
+			return repo
+		}
+	}
+	return nil
+}
+
+// Key returns the name identifying the result, falling back to the repository for
+// reports written before results were named
+func (r *RepoResult) Key() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Repository
+}
+
+// Drift aggregates the dependency freshness of all repositories in the run
+type Drift struct {
+	Repositories   int
+	Direct         int
+	Outdated       int
+	MajorsBehind   int
+	MeanDaysBehind float64 // Averaged over all direct dependencies
+}
+
+// Drift returns the fleet-wide dependency freshness, or nil if none was measured
+func (r *Report) Drift() *Drift {
+	var d Drift
+	var days float64
+
+	for _, repo := range r.Repositories {
+		if len(repo.Freshness) == 0 {
+			continue
+		}
+		d.Repositories++
+		for _, f := range repo.Freshness {
+			d.Direct += f.Direct
+			d.Outdated += f.Outdated
+			d.MajorsBehind += f.MajorsBehind
+			days += f.MeanDaysBehind * float64(f.Direct)
+		}
+	}
+
+	if d.Repositories == 0 {
+		return nil
+	}
+	if d.Direct > 0 {
+		d.MeanDaysBehind = days / float64(d.Direct)
+	}
+	return &d
+}
@@ -0,0 +1,123 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+)
+
+// Policy describes how often and how long to wait when retrying an operation
+type Policy struct {
+	Attempts   int           `yaml:"attempts"`    // Total attempts, 1 disables retrying
+	Backoff    time.Duration `yaml:"backoff"`     // Wait before the first retry, doubled for every further retry
+	MaxBackoff time.Duration `yaml:"max_backoff"` // Upper bound for the wait between attempts
+	Jitter     float64       `yaml:"jitter"`      // Random fraction (0-1) added to or removed from each wait
+}
+
+// DefaultPolicy returns the policy used when none is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		Attempts:   3,
+		Backoff:    2 * time.Second,
+		MaxBackoff: 30 * time.Second,
+		Jitter:     0.2,
+	}
+}
+
+// Do runs fn until it succeeds, returns an error retryable rejects, the attempts are
+// exhausted or the context is cancelled. onRetry, if set, is called before each wait.
+func (p Policy) Do(ctx context.Context, fn func() error, retryable func(error) bool, onRetry func(attempt int, wait time.Duration, err error)) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		if attempt >= p.Attempts || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := p.Wait(attempt)
+		if onRetry != nil {
+			onRetry(attempt, wait, err)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// Wait returns how long to wait after the given failed attempt (starting at 1)
+func (p Policy) Wait(attempt int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(wait))
+	}
+
+	return max(wait, 0)
+}
+
+// transientMessages are fragments of git, composer, npm and network errors caused by
+// flaky connections rather than by the repository
+var transientMessages = []string{
+	"could not resolve host",
+	"connection timed out",
+	"connection reset",
+	"connection refused",
+	"operation timed out",
+	"timed out",
+	"temporary failure in name resolution",
+	"the remote end hung up unexpectedly",
+	"early eof",
+	"rpc failed",
+	"tls handshake",
+	"ssl_error",
+	"curl error",
+	"http request failed",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"etimedout",
+	"econnreset",
+	"econnrefused",
+	"eai_again",
+	"socket hang up",
+	"network is unreachable",
+}
+
+// IsTransient reports whether an error looks like a temporary network problem
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range transientMessages {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+
+	return false
+}
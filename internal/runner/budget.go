@@ -0,0 +1,212 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/worker"
+)
+
+// requestEstimate is the predicted number of GitHub API requests for a run
+type requestEstimate struct {
+	Discovery    int // Listing requests, already spent by the time the estimate is made
+	Detection    int // Manifest lookups for repositories discovery couldn't check
+	Checks       int // Lookups of base branches, CI, branch protection, update branches and open pull requests
+	PullRequests int // Creating/editing, labelling, auto-merging and closing pull requests
+	Repos        int
+}
+
+// Remaining returns the number of requests the run still has to make
+func (e requestEstimate) Remaining() int {
+	return e.Detection + e.Checks + e.PullRequests
+}
+
+// ForRepos returns the estimate for n of its repositories, rounded up
+func (e requestEstimate) ForRepos(n int) requestEstimate {
+	if e.Repos == 0 {
+		return e
+	}
+	return requestEstimate{
+		Detection:    (e.Detection*n + e.Repos - 1) / e.Repos,
+		Checks:       (e.Checks*n + e.Repos - 1) / e.Repos,
+		PullRequests: (e.PullRequests*n + e.Repos - 1) / e.Repos,
+		Repos:        n,
+	}
+}
+
+// tightestBudget returns the rate limit that covers the run's remaining requests the
+// worst, with the requests it has to cover. Pull requests draw on github_token's limit
+// alone, as changes never rotate to the other tokens. With a read token, detection and
+// checks draw on the read token's limit, otherwise on the tokens' combined limit.
+func (r *Runner) tightestBudget(ctx context.Context, est requestEstimate) (*github.RateLimit, int, error) {
+	write, err := r.client.GetRateLimit(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	read, reads := write, est.Remaining()
+	if r.reader != r.client {
+		if read, err = r.reader.GetRateLimit(ctx); err != nil {
+			return nil, 0, err
+		}
+		reads = est.Detection + est.Checks
+	}
+	if reads-read.Remaining > est.PullRequests-write.First.Remaining {
+		return read, reads, nil
+	}
+	return write.First, est.PullRequests, nil
+}
+
+// estimateRequests predicts the API requests needed to process the matched repositories,
+// assuming every repository ends up being updated and every update branch exists
+func (r *Runner) estimateRequests(totalRepos int, matched []*github.Repository) requestEstimate {
+	est := requestEstimate{
+		Discovery: (totalRepos + 99) / 100,
+		Repos:     len(matched),
+	}
+
+	bases := r.cfg.Branches
+	if len(bases) == 0 {
+		bases = []string{r.cfg.BaseBranch}
+	}
+	pullRequests := r.cfg.CreatePR && !r.cfg.DryRun.Enabled()
+
+	for _, repo := range matched {
+		if !repo.Detected {
+			est.Detection += 2 // composer.json and package.json
+		}
+		cfg := r.cfg.ForRepo(repo.Name)
+
+		for _, base := range bases {
+			if base != "" && base != repo.DefaultRef {
+				est.Checks += 2 // check the base branch exists and isn't stale
+			}
+			if r.cfg.RequireGreenCI {
+				est.Checks += 2 // statuses and check runs of the base branch
+			}
+
+			if !pullRequests {
+				if !r.cfg.DryRun.Enabled() {
+					est.Checks += 3 // check rulesets, branch and protection before pushing
+				}
+				continue
+			}
+
+			est.Checks++          // commits on the update branch
+			est.PullRequests += 3 // list existing, create or edit, add labels
+			if r.cfg.CreateMissingLabels {
+				est.PullRequests += len(r.cfg.Labels) // look up each label
+			}
+			if r.cfg.CheckRun {
+				est.Checks++ // look up the head
+				est.PullRequests++
+			}
+			if r.cfg.MaxPRsPerRun > 0 || r.cfg.MaxOpenPRsPerRepo > 0 {
+				est.Checks++ // count the open pull requests
+			}
+			if cfg.AutoMerge && !r.cfg.ReadyAfterCI {
+				est.Checks++ // merge settings
+				est.PullRequests++
+			}
+			if r.cfg.CloseSuperseded {
+				est.Checks++ // list the open pull requests
+				est.PullRequests++
+			}
+		}
+	}
+
+	return est
+}
+
+// preflight compares the estimated requests with the remaining rate limit and returns
+// the number of repositories to process per batch (0 means all at once)
+func (r *Runner) preflight(ctx context.Context, est requestEstimate) (int, error) {
+	limit, needed, err := r.tightestBudget(ctx, est)
+	if err != nil {
+		r.logger.Warn("could not check rate limit", "error", err)
+		return 0, nil
+	}
+
+	r.logger.Info("estimated API requests",
+		"total", est.Remaining(),
+		"detection", est.Detection,
+		"checks", est.Checks,
+		"pull_requests", est.PullRequests,
+		"rate_limit_remaining", limit.Remaining,
+		"rate_limit", limit.Limit,
+		"rate_limit_reset", limit.Reset,
+	)
+
+	if needed <= limit.Remaining {
+		return 0, nil
+	}
+
+	switch r.cfg.RateLimitBudget {
+	case config.RateLimitBudgetAbort:
+		return 0, fmt.Errorf("estimated %d API requests exceed the remaining rate limit of %d", needed, limit.Remaining)
+	case config.RateLimitBudgetStagger:
+		batch := limit.Limit / max(needed/max(est.Repos, 1), 1)
+		r.logger.Warn("rate limit insufficient, processing in batches", "batch_size", batch)
+		return max(batch, 1), nil
+	default:
+		r.logger.Warn("estimated API requests exceed the remaining rate limit, the run may fail midway",
+			"estimated", needed,
+			"remaining", limit.Remaining,
+		)
+		return 0, nil
+	}
+}
+
+// processInBatches processes repositories in batches, waiting for the rate limit to
+// cover each batch before starting it. Batches past the deadline are left remaining,
+// as are the batches after a failed wait, whose error is returned with the result.
+func (r *Runner) processInBatches(ctx context.Context, pool *worker.Pool, repos []*github.Repository, batchSize int, est requestEstimate, deadline time.Time) (*worker.ProcessResult, error) {
+	result := &worker.ProcessResult{}
+
+	for start := 0; start < len(repos); start += batchSize {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			result.Remaining = append(result.Remaining, repos[start:]...)
+			break
+		}
+
+		batch := repos[start:min(start+batchSize, len(repos))]
+
+		if err := r.waitForBudget(ctx, est.ForRepos(len(batch))); err != nil {
+			result.Remaining = append(result.Remaining, repos[start:]...)
+			return result, fmt.Errorf("stopped waiting for rate limit with %d repositories left: %w", len(repos)-start, err)
+		}
+
+		result.Merge(pool.Process(ctx, batch))
+	}
+
+	return result, nil
+}
+
+// waitForBudget blocks until the rate limits have the estimated requests left
+func (r *Runner) waitForBudget(ctx context.Context, est requestEstimate) error {
+	for {
+		limit, needed, err := r.tightestBudget(ctx, est)
+		if err != nil {
+			return err
+		}
+		if limit.Remaining >= needed {
+			return nil
+		}
+
+		wait := time.Until(limit.Reset) + time.Second
+		r.logger.Info("waiting for rate limit reset",
+			"wait", wait.Round(time.Second),
+			"remaining", limit.Remaining,
+			"needed", needed,
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/history"
+)
+
+// defaultRepoDuration is how long a repository is assumed to keep a worker busy when
+// the history has no runs to go by
+const defaultRepoDuration = time.Minute
+
+// estimateHistoryRuns is how many of the latest runs the duration estimate averages
+const estimateHistoryRuns = 20
+
+// printEstimate prints the API requests and duration predicted for the run, and warns
+// when the token's remaining rate limit doesn't cover the requests
+func (r *Runner) printEstimate(ctx context.Context, totalRepos int, est requestEstimate) error {
+	duration, basis := r.estimateDuration(ctx, est.Repos)
+
+	fmt.Println()
+	fmt.Println("📐 Estimate")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("   Repositories:  %d of %d (%s)\n", est.Repos, totalRepos, r.cfg.Mode())
+	fmt.Printf("   API requests:  %d (%d listing, %d detection, %d checks, %d pull requests)\n",
+		est.Discovery+est.Remaining(), est.Discovery, est.Detection, est.Checks, est.PullRequests)
+	fmt.Printf("   Duration:      ~%s with %d workers (%s)\n", formatEstimate(duration), r.cfg.Workers, basis)
+	if r.cfg.MaxDuration > 0 && duration > r.cfg.MaxDuration {
+		fmt.Println("   ⚠️  Longer than max_duration, the rest is left for the next run")
+	}
+
+	limit, needed, err := r.tightestBudget(ctx, est)
+	if err != nil {
+		fmt.Printf("   Rate limit:    unknown (%v)\n", err)
+		fmt.Println()
+		return nil
+	}
+	fmt.Printf("   Rate limit:    %d of %d left, resets %s\n", limit.Remaining, limit.Limit, limit.Reset.Local().Format("15:04"))
+
+	if short := needed - limit.Remaining; short > 0 {
+		fmt.Printf("   ⚠️  %d requests more than the rate limit has left, ", short)
+		switch r.cfg.RateLimitBudget {
+		case config.RateLimitBudgetAbort:
+			fmt.Println("the run would refuse to start (rate_limit_budget: abort)")
+		case config.RateLimitBudgetStagger:
+			// Every further hour of waiting restores the full limit
+			wait := time.Until(limit.Reset)
+			if limit.Limit > 0 {
+				wait += time.Duration((short-1)/limit.Limit) * time.Hour
+			}
+			fmt.Printf("the run would wait about %s for resets (rate_limit_budget: stagger)\n", formatEstimate(wait))
+		default:
+			fmt.Println("the run would likely fail midway (rate_limit_budget: warn)")
+		}
+	}
+	fmt.Println()
+
+	return nil
+}
+
+// estimateDuration predicts how long the run takes for the given number of
+// repositories: at the pace per worker of the latest runs of the owner in the same mode
+// recorded in the history, or else at defaultRepoDuration per repository and worker. It
+// returns what the estimate is based on as well.
+func (r *Runner) estimateDuration(ctx context.Context, repos int) (time.Duration, string) {
+	workers := max(r.cfg.Workers, 1)
+	fallback := time.Duration((repos+workers-1)/workers) * defaultRepoDuration
+	fallbackBasis := fmt.Sprintf("assuming %s per repository", formatEstimate(defaultRepoDuration))
+
+	if r.cfg.HistoryFile == "" {
+		return fallback, fallbackBasis
+	}
+	h, err := history.Open(r.cfg.HistoryFile)
+	if err != nil {
+		r.logger.Warn("could not read history for the estimate", "error", err)
+		return fallback, fallbackBasis
+	}
+	defer h.Close()
+
+	runs, err := h.Runs(ctx, estimateHistoryRuns, "")
+	if err != nil {
+		r.logger.Warn("could not read history for the estimate", "error", err)
+		return fallback, fallbackBasis
+	}
+
+	// Worker time, so runs with a different number of workers compare
+	var busy time.Duration
+	processed, counted := 0, 0
+	for _, run := range runs {
+		if run.Owner != r.cfg.Owner || run.Mode != r.cfg.Mode() || run.Total == 0 {
+			continue
+		}
+		// Runs recorded without their workers are assumed to have had as many
+		ran := run.Workers
+		if ran == 0 {
+			ran = workers
+		}
+		busy += run.FinishedAt.Sub(run.StartedAt) * time.Duration(min(ran, run.Total))
+		processed += run.Total
+		counted++
+	}
+	if processed == 0 {
+		return fallback, fallbackBasis
+	}
+
+	perRepo := busy / time.Duration(processed)
+	return perRepo * time.Duration(repos) / time.Duration(min(workers, max(repos, 1))), fmt.Sprintf("at the pace of the last %d runs", counted)
+}
+
+// formatEstimate rounds a predicted duration to whole seconds, minutes or ten minutes,
+// without the zero seconds, e.g. 2h10m
+func formatEstimate(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return d.Round(time.Second).String()
+	case d < time.Hour:
+		return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	default:
+		return strings.TrimSuffix(d.Round(10*time.Minute).String(), "0s")
+	}
+}
@@ -0,0 +1,190 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/janyksteenbeek/updati/internal/state"
+	"github.com/janyksteenbeek/updati/internal/updater"
+	"github.com/janyksteenbeek/updati/internal/worker"
+)
+
+// maxIssueError caps the error output in failure issues, GitHub rejects bodies over
+// 65536 characters
+const maxIssueError = 60000
+
+// reportFailures counts consecutive failures per repository in the state and opens or
+// updates an issue in repositories that failed failure_issue_after runs in a row. The
+// issue is closed once the repository updates successfully again.
+func (r *Runner) reportFailures(ctx context.Context, st *state.State, result *worker.ProcessResult) {
+	for _, res := range result.Results {
+		log := r.logger.With("repo", res.Name())
+		title := failureIssueTitle(res)
+
+		if res.Error == nil {
+			if st.ClearFailures(res.Name()) < r.cfg.FailureIssueAfter {
+				continue
+			}
+
+			number, err := r.reader.FindOpenIssue(ctx, res.Repository, title)
+			if err != nil {
+				log.Warn("could not look up failure issue", "error", err)
+				continue
+			}
+			if number == 0 {
+				continue
+			}
+			comment := "The dependencies updated successfully again."
+			if link := runLink(); link != "" {
+				comment = "The dependencies updated successfully again in " + link + "."
+			}
+			if err := r.client.CloseIssue(ctx, res.Repository, number, comment); err != nil {
+				log.Warn("could not close failure issue", "error", err)
+			} else {
+				log.Info("closed failure issue", "issue", number)
+			}
+			continue
+		}
+
+		res.FailedRuns = st.RecordFailure(res.Name())
+		if res.FailedRuns < r.cfg.FailureIssueAfter {
+			continue
+		}
+
+		number, err := r.reader.FindOpenIssue(ctx, res.Repository, title)
+		if err != nil {
+			log.Warn("could not look up failure issue", "error", err)
+			continue
+		}
+
+		body := failureIssueBody(res)
+		if number == 0 {
+			res.FailureIssue, err = r.client.CreateIssue(ctx, res.Repository, title, body)
+		} else {
+			res.FailureIssue, err = r.client.UpdateIssue(ctx, res.Repository, number, body)
+		}
+		if err != nil {
+			log.Warn("could not report failures in an issue", "error", err)
+			continue
+		}
+		log.Info("reported failures in an issue", "failed_runs", res.FailedRuns, "issue", res.FailureIssue)
+	}
+}
+
+// failureIssueTitle returns the title of the issue reporting the failures of a result,
+// which is also how an existing issue is found again
+func failureIssueTitle(res *updater.Result) string {
+	if res.BaseBranch != "" && res.BaseBranch != res.Repository.DefaultRef {
+		return fmt.Sprintf("updati: dependency updates of %s are failing", res.BaseBranch)
+	}
+	return "updati: dependency updates are failing"
+}
+
+// failureIssueBody describes the failures of a result for the issue
+func failureIssueBody(res *updater.Result) string {
+	msg := res.Error.Error()
+	if len(msg) > maxIssueError {
+		msg = "…" + msg[len(msg)-maxIssueError:]
+	}
+
+	// A fence longer than any backtick run in the output keeps it in one block
+	fence := "```"
+	for strings.Contains(msg, fence) {
+		fence += "`"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "updati could not update the dependencies of this repository in the last %d runs", res.FailedRuns)
+	if res.BaseBranch != "" {
+		fmt.Fprintf(&b, " (branch `%s`)", res.BaseBranch)
+	}
+	b.WriteString(".\n\nError of the latest run")
+	if link := runLink(); link != "" {
+		fmt.Fprintf(&b, " (%s)", link)
+	}
+	fmt.Fprintf(&b, ":\n\n%s\n%s\n%s\n\n", fence, msg, fence)
+	b.WriteString("This issue is updated while the failures continue and closed once an update succeeds.\n")
+	return b.String()
+}
+
+// reportAdvisories opens or updates an issue listing the security advisories left after
+// the update in repositories that have any, and closes the issue once none are left.
+// Repositories whose update failed or that weren't audited are left alone.
+func (r *Runner) reportAdvisories(ctx context.Context, result *worker.ProcessResult) {
+	for _, res := range result.Results {
+		if res.Error != nil || !res.Audited {
+			continue
+		}
+
+		log := r.logger.With("repo", res.Name())
+		title := advisoryIssueTitle(res)
+
+		number, err := r.reader.FindOpenIssue(ctx, res.Repository, title)
+		if err != nil {
+			log.Warn("could not look up advisory issue", "error", err)
+			continue
+		}
+
+		if len(res.OpenAdvisories) == 0 {
+			if number == 0 {
+				continue
+			}
+			if err := r.client.CloseIssue(ctx, res.Repository, number, "No known security advisories affect the locked dependencies anymore."); err != nil {
+				log.Warn("could not close advisory issue", "error", err)
+			} else {
+				log.Info("closed advisory issue", "issue", number)
+			}
+			continue
+		}
+
+		body := advisoryIssueBody(res)
+		if number == 0 {
+			res.AdvisoryIssue, err = r.client.CreateIssue(ctx, res.Repository, title, body)
+		} else {
+			res.AdvisoryIssue, err = r.client.UpdateIssue(ctx, res.Repository, number, body)
+		}
+		if err != nil {
+			log.Warn("could not report open advisories in an issue", "error", err)
+			continue
+		}
+		log.Info("reported open advisories in an issue", "advisories", len(res.OpenAdvisories), "issue", res.AdvisoryIssue)
+	}
+}
+
+// advisoryIssueTitle returns the title of the issue listing the open advisories of a
+// result, which is also how an existing issue is found again
+func advisoryIssueTitle(res *updater.Result) string {
+	if res.BaseBranch != "" && res.BaseBranch != res.Repository.DefaultRef {
+		return fmt.Sprintf("updati: open security advisories on %s", res.BaseBranch)
+	}
+	return "updati: open security advisories"
+}
+
+// advisoryIssueBody lists the open advisories of a result for the issue
+func advisoryIssueBody(res *updater.Result) string {
+	var b strings.Builder
+	b.WriteString("The locked dependencies of this repository")
+	if res.BaseBranch != "" {
+		fmt.Fprintf(&b, " (branch `%s`)", res.BaseBranch)
+	}
+	fmt.Fprintf(&b, " are affected by %d known security advisories that updating within the version constraints doesn't fix, for example because no patched version was released yet or a constraint holds the package back", len(res.OpenAdvisories))
+	if link := runLink(); link != "" {
+		fmt.Fprintf(&b, " (as of %s)", link)
+	}
+	b.WriteString(":\n\n")
+	b.WriteString(updater.AdvisoryList(res.OpenAdvisories))
+	b.WriteString("\nThis issue is updated by every run and closed once no advisories are left.\n")
+	return b.String()
+}
+
+// runLink links the GitHub Actions run updati is part of, or returns an empty string
+// outside of GitHub Actions
+func runLink() string {
+	server, repo, id := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID")
+	if server == "" || repo == "" || id == "" {
+		return ""
+	}
+	return fmt.Sprintf("[run %s](%s/%s/actions/runs/%s)", id, server, repo, id)
+}
@@ -0,0 +1,98 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/lock"
+)
+
+// ErrLockLost is the cause of a run stopped because another run took over its lock
+var ErrLockLost = errors.New("lost the run lock to another run")
+
+// lockBranch is the branch of the lock_repository that holds the run lock
+const lockBranch = "updati-lock"
+
+// Lock takes the local and remote run locks cfg configures and returns the function
+// releasing them. When another run takes over the remote lock, the run is canceled
+// through lost with ErrLockLost. Dry runs don't push and don't lock.
+func Lock(ctx context.Context, cfg *config.Config, client *github.Client, logger *slog.Logger, lost context.CancelCauseFunc) (func(), error) {
+	var releases []func()
+	release := func() {
+		for _, fn := range slices.Backward(releases) {
+			fn()
+		}
+	}
+	if cfg.DryRun.Enabled() {
+		return release, nil
+	}
+	holder := lock.Holder()
+
+	if cfg.LockFile != "" {
+		l, err := lock.Acquire(cfg.LockFile, holder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %s: %w", cfg.LockFile, err)
+		}
+		releases = append(releases, func() {
+			if err := l.Release(); err != nil {
+				logger.Warn("failed to release lock file", "error", err)
+			}
+		})
+	}
+
+	if cfg.LockRepository != "" {
+		repo, err := client.GetRepository(ctx, cfg.LockRepository)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("failed to get lock repository: %w", err)
+		}
+		l, err := client.AcquireLock(ctx, repo, lockBranch, holder, cfg.LockTTL)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("failed to lock %s: %w", repo.FullName, err)
+		}
+		logger.Debug("acquired run lock", "repository", repo.FullName, "branch", lockBranch)
+
+		// Renew the lock well before it expires, so a long run keeps it
+		renewCtx, stopRenewing := context.WithCancel(ctx)
+		renewed := make(chan struct{})
+		go func() {
+			defer close(renewed)
+			ticker := time.NewTicker(max(cfg.LockTTL/3, time.Second))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-renewCtx.Done():
+					return
+				case <-ticker.C:
+				}
+				if err := l.Renew(renewCtx, cfg.LockTTL); err != nil {
+					var held *lock.HeldError
+					if errors.As(err, &held) {
+						logger.Error("lost run lock, stopping the run", "repository", repo.FullName, "error", err)
+						lost(fmt.Errorf("%w: %w", ErrLockLost, err))
+						return
+					}
+					logger.Warn("failed to renew run lock", "repository", repo.FullName, "error", err)
+				}
+			}
+		}()
+
+		releases = append(releases, func() {
+			stopRenewing()
+			<-renewed
+			// Released even when the run was interrupted
+			if err := l.Release(context.WithoutCancel(ctx)); err != nil {
+				logger.Warn("failed to release run lock", "repository", repo.FullName, "error", err)
+			}
+		})
+	}
+
+	return release, nil
+}
@@ -1,11 +1,26 @@
 package runner
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/janyksteenbeek/updati/internal/audit"
 	"github.com/janyksteenbeek/updati/internal/config"
 	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/history"
+	"github.com/janyksteenbeek/updati/internal/notify"
+	"github.com/janyksteenbeek/updati/internal/progress"
+	"github.com/janyksteenbeek/updati/internal/report"
+	"github.com/janyksteenbeek/updati/internal/state"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
 	"github.com/janyksteenbeek/updati/internal/updater"
 	"github.com/janyksteenbeek/updati/internal/worker"
 )
@@ -13,31 +28,75 @@ import (
 // Runner orchestrates the update process
 type Runner struct {
 	cfg    *config.Config
-	client *github.Client
+	client *github.Client // Pushes and manages pull requests
+	reader *github.Client // Discovers and inspects repositories, the same client without a read token
+	logger *slog.Logger
+
+	progress *progress.Display // Live status of the workers, nil to only log
+}
+
+// ShowProgress renders the progress of the run on the display. The logger should write
+// through it, so log lines don't tear the display.
+func (r *Runner) ShowProgress(d *progress.Display) {
+	r.progress = d
 }
 
 // New creates a new Runner
-func New(cfg *config.Config) *Runner {
-	client := github.NewClient(cfg.GitHubToken, cfg.Owner)
+func New(cfg *config.Config, logger *slog.Logger) *Runner {
+	opts := github.Options{
+		Retry:    cfg.Retry,
+		CacheDir: cfg.CacheDir,
+		Audit:    audit.New(cfg.AuditLog),
+		Tokens:   cfg.GitHubTokens,
+	}
+	client := github.NewClient(cfg.GitHubToken, cfg.Owner, opts)
+	reader := client
+	if cfg.ReadToken != "" {
+		opts.Tokens = nil // The read token doesn't rotate with the others
+		reader = github.NewClient(cfg.ReadToken, cfg.Owner, opts)
+	}
 	return &Runner{
 		cfg:    cfg,
 		client: client,
+		reader: reader,
+		logger: logger,
 	}
 }
 
-// Run executes the update process
+// Run executes the update process. When another run takes over the run lock, the
+// run is canceled and returns an error saying so.
 func (r *Runner) Run(ctx context.Context) error {
-	r.printBanner()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	err := r.run(ctx, cancel)
+	if cause := context.Cause(ctx); errors.Is(cause, ErrLockLost) {
+		return cause
+	}
+	return err
+}
+
+func (r *Runner) run(ctx context.Context, cancel context.CancelCauseFunc) error {
+	startedAt := time.Now()
+	r.logStart()
+
+	// Keep another run from pushing to the same branches at the same time. An
+	// estimate pushes nothing.
+	if !r.cfg.Estimate {
+		release, err := Lock(ctx, r.cfg, r.client, r.logger, cancel)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
 
 	// List repositories
-	fmt.Println("📦 Fetching repositories...")
-	repos, err := r.client.ListRepositories(ctx)
+	r.logger.Info("fetching repositories")
+	repos, err := r.reader.ListRepositories(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to list repositories: %w", err)
 	}
 
-	fmt.Printf("   Found %d repositories\n", len(repos))
-
 	// Filter repositories by pattern
 	var matchedRepos []*github.Repository
 	for _, repo := range repos {
@@ -46,27 +105,168 @@ func (r *Runner) Run(ctx context.Context) error {
 		}
 	}
 
-	fmt.Printf("   %d repositories match patterns\n", len(matchedRepos))
-	fmt.Println()
+	r.logger.Info("found repositories", "total", len(repos), "matched", len(matchedRepos))
+
+	// Only retry what failed in the given run
+	if r.cfg.RetryFailed != "" {
+		failed, err := failedRepositories(r.cfg.RetryFailed)
+		if err != nil {
+			return err
+		}
+		matchedRepos = slices.DeleteFunc(matchedRepos, func(repo *github.Repository) bool {
+			return !failed[repo.FullName]
+		})
+		r.logger.Info("retrying failed repositories", "failed", len(failed), "matched", len(matchedRepos))
+	}
+
+	// Leave out what the interrupted run already did
+	if r.cfg.Resume {
+		completed, err := state.CompletedRepositories(r.cfg.CheckpointFile)
+		if err != nil {
+			return err
+		}
+		matchedRepos = slices.DeleteFunc(matchedRepos, func(repo *github.Repository) bool {
+			return completed[repo.FullName]
+		})
+		r.logger.Info("resuming interrupted run", "completed", len(completed), "remaining", len(matchedRepos))
+	}
 
 	if len(matchedRepos) == 0 {
-		fmt.Println("No repositories to process.")
+		r.logger.Info("no repositories to process")
 		return nil
 	}
 
+	// Time-boxed runs start with what the previous run didn't get to
+	var st *state.State
+	var deadline time.Time
+	if r.cfg.MaxDuration > 0 || r.trackFailures() {
+		st, err = state.Load(r.cfg.StateFile)
+		if err != nil {
+			return err
+		}
+	}
+	if r.cfg.MaxDuration > 0 {
+		matchedRepos = prioritize(st, matchedRepos)
+		deadline = startedAt.Add(r.cfg.MaxDuration)
+		r.logger.Info("time-boxed run", "max_duration", r.cfg.MaxDuration, "queued", len(st.Queue))
+	}
+
+	// Check the API budget before starting
+	est := r.estimateRequests(len(repos), matchedRepos)
+	if r.cfg.Estimate {
+		return r.printEstimate(ctx, len(repos), est)
+	}
+	batchSize, err := r.preflight(ctx, est)
+	if err != nil {
+		return err
+	}
+
+	if r.cfg.WorkDir != "" {
+		if err := os.MkdirAll(r.cfg.WorkDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create workdir: %w", err)
+		}
+	}
+	updater.CleanStaleTemp(r.cfg, r.logger)
+
 	// Create updater and worker pool
-	upd := updater.New(r.cfg, r.client)
-	pool := worker.New(r.cfg.Workers, upd, r.client)
+	upd := updater.New(r.cfg, r.client, r.reader)
+	pool := worker.New(r.cfg.Workers, upd, r.reader, r.logger)
+	pool.StopAt(deadline)
+	pool.Autoscale(r.cfg.MinWorkers)
+
+	// A dry run changes nothing, so it must neither replace the checkpoint of a real
+	// run nor count as done for --resume
+	if r.cfg.CheckpointFile != "" && !r.cfg.DryRun.Enabled() {
+		cp, err := state.OpenCheckpoint(r.cfg.CheckpointFile, r.cfg.Resume)
+		if err != nil {
+			return err
+		}
+		defer cp.Close()
+		pool.OnRepository(func(repo *github.Repository, results []*updater.Result) {
+			if err := cp.Record(checkpointEntry(repo, results)); err != nil {
+				r.logger.Warn("failed to write checkpoint", "error", err)
+			}
+		})
+	}
+
+	if r.progress != nil {
+		upd.OnPhase(func(repo *github.Repository, phase string) {
+			r.progress.Phase(repo.FullName, phase)
+		})
+		pool.OnStart(func(workerID int, repo *github.Repository) {
+			r.progress.Begin(workerID, repo.FullName)
+		})
+		pool.OnRepository(func(repo *github.Repository, _ []*updater.Result) {
+			r.progress.Finish(repo.FullName)
+		})
+	}
 
 	// Process repositories
-	fmt.Println("🔄 Processing repositories...")
-	fmt.Println()
+	r.logger.Info("processing repositories")
+	if r.progress != nil {
+		r.progress.Start(len(matchedRepos), r.cfg.Workers)
+	}
 
-	result := pool.Process(ctx, matchedRepos)
+	var result *worker.ProcessResult
+	var stopped error
+	if batchSize > 0 && batchSize < len(matchedRepos) {
+		result, stopped = r.processInBatches(ctx, pool, matchedRepos, batchSize, est, deadline)
+		if stopped != nil {
+			r.logger.Error("run incomplete", "error", stopped)
+		}
+	} else {
+		result = pool.Process(ctx, matchedRepos)
+	}
+	if r.progress != nil {
+		r.progress.Stop()
+	}
+
+	if st != nil {
+		if r.trackFailures() {
+			r.reportFailures(ctx, st, result)
+		}
+		r.saveState(st, result, startedAt)
+	}
+	if r.cfg.AdvisoryIssue && !r.cfg.DryRun.Enabled() {
+		r.reportAdvisories(ctx, result)
+	}
+
+	rep := report.New(r.cfg, r.cfg.Mode(), result, startedAt, time.Now())
+	rep.Toolchain = toolchain.Detect(ctx)
 
 	// Print summary
-	r.printSummary(result)
+	r.printSummary(result, rep)
+
+	// Write the run report if configured
+	if r.cfg.ReportFile != "" {
+		if err := rep.Save(r.cfg.ReportFile); err != nil {
+			return err
+		}
+		r.logger.Info("report written", "path", r.cfg.ReportFile)
+	}
+
+	// Recorded even when the run was interrupted
+	if r.cfg.HistoryFile != "" {
+		if err := history.Save(context.WithoutCancel(ctx), r.cfg.HistoryFile, rep); err != nil {
+			r.logger.Warn("failed to record run history", "error", err)
+		}
+	}
+
+	if r.cfg.MetricsFile != "" {
+		if err := rep.WriteMetrics(r.cfg.MetricsFile); err != nil {
+			return err
+		}
+		r.logger.Info("metrics written", "path", r.cfg.MetricsFile)
+	}
+
+	// Publish the summary and outputs when running inside GitHub Actions
+	r.writeActionsResults(rep)
 
+	notify.Send(ctx, r.cfg, rep, r.logger)
+
+	if stopped != nil {
+		return stopped
+	}
 	if result.Failed > 0 {
 		return fmt.Errorf("%d repositories failed to update", result.Failed)
 	}
@@ -74,29 +274,128 @@ func (r *Runner) Run(ctx context.Context) error {
 	return nil
 }
 
-func (r *Runner) printBanner() {
-	fmt.Println("🚀 Updati - Dependency Updater")
-	fmt.Printf("   Owner: %s\n", r.cfg.Owner)
-	fmt.Printf("   Workers: %d\n", r.cfg.Workers)
-	fmt.Printf("   Dry Run: %v\n", r.cfg.DryRun)
-	fmt.Printf("   Mode: %s\n", r.modeString())
-	if len(r.cfg.RepoPatterns) > 0 {
-		fmt.Printf("   Patterns: %v\n", r.cfg.RepoPatterns)
+func (r *Runner) writeActionsResults(rep *report.Report) {
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		if err := rep.WriteStepSummary(path); err != nil {
+			r.logger.Warn("failed to write step summary", "error", err)
+		}
+	}
+	if path := os.Getenv("GITHUB_OUTPUT"); path != "" {
+		if err := rep.WriteOutputs(path); err != nil {
+			r.logger.Warn("failed to write step outputs", "error", err)
+		}
 	}
-	fmt.Println()
 }
 
-func (r *Runner) modeString() string {
-	if r.cfg.DryRun {
-		return "dry-run"
+// failedRepositories returns the repositories recorded as failed in a JSON report or a
+// checkpoint file
+func failedRepositories(path string) (map[string]bool, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to read previous run: %w", err)
 	}
-	if r.cfg.CreatePR {
-		return "pull-request"
+
+	// A checkpoint file with several lines isn't a single JSON document
+	if rep, err := report.Load(path); err == nil && rep.Repositories != nil {
+		failed := make(map[string]bool)
+		for _, repo := range rep.Repositories {
+			if repo.Status == report.StatusFailed {
+				failed[repo.Repository] = true
+			}
+		}
+		return failed, nil
 	}
-	return "direct-push"
+
+	return state.FailedRepositories(path)
 }
 
-func (r *Runner) printSummary(result *worker.ProcessResult) {
+// checkpointEntry summarizes the results of a repository for the checkpoint file
+func checkpointEntry(repo *github.Repository, results []*updater.Result) state.CheckpointEntry {
+	entry := state.CheckpointEntry{
+		Repository: repo.FullName,
+		Status:     state.CheckpointSkipped,
+		FinishedAt: time.Now().UTC(),
+	}
+
+	var errs []string
+	for _, res := range results {
+		switch {
+		case res.Error != nil:
+			entry.Status = state.CheckpointFailed
+			errs = append(errs, res.Error.Error())
+		case res.Deferred && entry.Status != state.CheckpointFailed:
+			// A held back branch leaves the repository for --resume to pick up
+			entry.Status = state.CheckpointDeferred
+		case res.Updated && entry.Status == state.CheckpointSkipped:
+			entry.Status = state.CheckpointUpdated
+		}
+		if res.PRURL != "" {
+			entry.PRURLs = append(entry.PRURLs, res.PRURL)
+		}
+	}
+	entry.Error = strings.Join(errs, "; ")
+
+	return entry
+}
+
+// trackFailures reports whether consecutive failures are counted to open issues for
+// them. Dry runs don't count, they never change repositories.
+func (r *Runner) trackFailures() bool {
+	return r.cfg.FailureIssueAfter > 0 && !r.cfg.DryRun.Enabled()
+}
+
+// prioritize orders the repositories by the state's priority
+func prioritize(st *state.State, repos []*github.Repository) []*github.Repository {
+	byName := make(map[string]*github.Repository, len(repos))
+	names := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		byName[repo.FullName] = repo
+		names = append(names, repo.FullName)
+	}
+
+	ordered := make([]*github.Repository, 0, len(repos))
+	for _, name := range st.Prioritize(names) {
+		ordered = append(ordered, byName[name])
+	}
+	return ordered
+}
+
+// saveState queues the repositories the time box didn't cover for the next run
+func (r *Runner) saveState(st *state.State, result *worker.ProcessResult, startedAt time.Time) {
+	// Deferred repositories keep their place, they weren't updated
+	for _, res := range result.Results {
+		if !res.Deferred {
+			st.MarkProcessed(res.Repository.FullName, startedAt)
+		}
+	}
+
+	st.Queue = st.Queue[:0]
+	for _, repo := range result.Remaining {
+		st.Queue = append(st.Queue, repo.FullName)
+	}
+
+	if len(st.Queue) > 0 {
+		r.logger.Info("time box reached, continuing next run", "remaining", len(st.Queue))
+	}
+
+	if err := st.Save(r.cfg.StateFile); err != nil {
+		r.logger.Warn("failed to save state", "error", err)
+	}
+}
+
+func (r *Runner) logStart() {
+	r.logger.Info("starting updati",
+		"owner", r.cfg.Owner,
+		"workers", r.cfg.Workers,
+		"dry_run", string(r.cfg.DryRun),
+		"mode", r.cfg.Mode(),
+		"patterns", r.cfg.RepoPatterns,
+		"tags", r.cfg.Tags,
+		"plugins", r.cfg.OnlyPlugins,
+		"skip_plugins", r.cfg.SkipPlugins,
+	)
+}
+
+func (r *Runner) printSummary(result *worker.ProcessResult, rep *report.Report) {
 	fmt.Println()
 	fmt.Println("📊 Summary")
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
@@ -104,7 +403,13 @@ func (r *Runner) printSummary(result *worker.ProcessResult) {
 	fmt.Printf("   Successful:          %d\n", result.Successful)
 	fmt.Printf("   Updated:             %d\n", result.Updated)
 	fmt.Printf("   Skipped:             %d\n", result.Skipped)
+	if result.Deferred > 0 {
+		fmt.Printf("   Deferred:            %d\n", result.Deferred)
+	}
 	fmt.Printf("   Failed:              %d\n", result.Failed)
+	if len(result.Remaining) > 0 {
+		fmt.Printf("   Left for next run:   %d\n", len(result.Remaining))
+	}
 	fmt.Println()
 
 	// Print detailed results for updates and failures
@@ -113,22 +418,122 @@ func (r *Runner) printSummary(result *worker.ProcessResult) {
 		for _, res := range result.Results {
 			if res.Updated && res.Error == nil {
 				if res.PRURL != "" {
-					fmt.Printf("   - %s (PR: %s)\n", res.Repository.FullName, res.PRURL)
+					fmt.Printf("   - %s (PR: %s)\n", res.Name(), res.PRURL)
 				} else {
-					fmt.Printf("   - %s (pushed to %s)\n", res.Repository.FullName, res.Branch)
+					fmt.Printf("   - %s (pushed to %s)\n", res.Name(), res.Branch)
+				}
+				if len(res.Changes) > 0 {
+					fmt.Printf("     %s\n", res.ChangeStats)
+				}
+				if res.PRFallback != "" {
+					fmt.Printf("     opened a pull request, %s\n", res.PRFallback)
 				}
 			}
 		}
 		fmt.Println()
 	}
 
+	var advised []*updater.Result
+	for _, res := range result.Results {
+		if len(res.Advisories) > 0 {
+			advised = append(advised, res)
+		}
+	}
+	if len(advised) > 0 {
+		fmt.Println("💡 Advisories:")
+		for _, res := range advised {
+			for _, adv := range res.Advisories {
+				fmt.Printf("   - %s: %s\n", res.Name(), adv.Message)
+			}
+		}
+		fmt.Println()
+	}
+
+	if len(rep.Laravel) > 0 {
+		fmt.Println("🧭 Laravel versions:")
+		for _, major := range rep.Laravel {
+			fmt.Printf("   - Laravel %s: %s\n", major.Major, major.Summary())
+		}
+		fmt.Println()
+	}
+
+	if drift := rep.Drift(); drift != nil {
+		fmt.Printf("📉 Dependency drift: %d of %d direct dependencies outdated, %d majors behind, %.0f days behind on average\n\n",
+			drift.Outdated, drift.Direct, drift.MajorsBehind, drift.MeanDaysBehind)
+	}
+
+	var skipped []*updater.Result
+	for _, res := range result.Results {
+		if res.SkipReason != "" {
+			skipped = append(skipped, res)
+		}
+	}
+	if len(skipped) > 0 {
+		fmt.Println("⏭️  Skipped repositories:")
+		for _, res := range skipped {
+			fmt.Printf("   - %s: %s\n", res.Name(), res.SkipReason)
+		}
+		fmt.Println()
+	}
+
+	printSlowest(result.Results)
+
 	if result.Failed > 0 {
 		fmt.Println("❌ Failed repositories:")
 		for _, res := range result.Results {
 			if res.Error != nil {
-				fmt.Printf("   - %s: %v\n", res.Repository.FullName, res.Error)
+				fmt.Printf("   - %s: %v\n", res.Name(), res.Error)
+				if res.FailureIssue != "" {
+					fmt.Printf("     failing for %d runs, reported in %s\n", res.FailedRuns, res.FailureIssue)
+				}
 			}
 		}
 		fmt.Println()
 	}
 }
+
+// maxSlowest is the number of repositories the summary lists as slowest
+const maxSlowest = 5
+
+// printSlowest lists the repositories that took longest, with the time they spent per
+// phase, once the run has more than one
+func printSlowest(results []*updater.Result) {
+	if len(results) < 2 {
+		return
+	}
+
+	slowest := slices.Clone(results)
+	slices.SortStableFunc(slowest, func(a, b *updater.Result) int {
+		return cmp.Compare(b.Timings.Total, a.Timings.Total)
+	})
+	slowest = slowest[:min(len(slowest), maxSlowest)]
+
+	fmt.Println("🐢 Slowest repositories:")
+	for _, res := range slowest {
+		var phases []string
+		if res.Timings.Clone > 0 {
+			phases = append(phases, "clone "+roundDuration(res.Timings.Clone))
+		}
+		for _, name := range slices.Sorted(maps.Keys(res.Timings.Plugins)) {
+			phases = append(phases, name+" "+roundDuration(res.Timings.Plugins[name]))
+		}
+		if res.Timings.Push > 0 {
+			phases = append(phases, "push "+roundDuration(res.Timings.Push))
+		}
+
+		fmt.Printf("   - %s: %s", res.Name(), roundDuration(res.Timings.Total))
+		if len(phases) > 0 {
+			fmt.Printf(" (%s)", strings.Join(phases, ", "))
+		}
+		fmt.Println()
+	}
+	fmt.Println()
+}
+
+// roundDuration formats a duration to whole seconds, or tenths below ten seconds
+func roundDuration(d time.Duration) string {
+	if d < 10*time.Second {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,165 @@
+package selfupdate
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/google/go-github/v57/github"
+)
+
+// Repository updati releases are published to
+const (
+	releaseOwner = "janyksteenbeek"
+	releaseRepo  = "updati"
+)
+
+// checksumsAsset is the release asset listing the SHA-256 of every binary
+const checksumsAsset = "checksums.txt"
+
+// Release is a published updati release with the binary for this platform
+type Release struct {
+	Version     string
+	URL         string
+	assetURL    string
+	checksumURL string
+}
+
+// AssetName returns the name of the release binary for the current platform
+func AssetName() string {
+	return fmt.Sprintf("updati-%s-%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// Latest looks up the latest release and the assets for the current platform
+func Latest(ctx context.Context, token string) (*Release, error) {
+	client := github.NewClient(nil)
+	if token != "" {
+		client = client.WithAuthToken(token)
+	}
+
+	rel, _, err := client.Repositories.GetLatestRelease(ctx, releaseOwner, releaseRepo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest release: %w", err)
+	}
+
+	release := &Release{Version: rel.GetTagName(), URL: rel.GetHTMLURL()}
+	for _, asset := range rel.Assets {
+		switch asset.GetName() {
+		case AssetName():
+			release.assetURL = asset.GetBrowserDownloadURL()
+		case checksumsAsset:
+			release.checksumURL = asset.GetBrowserDownloadURL()
+		}
+	}
+
+	if release.assetURL == "" {
+		return nil, fmt.Errorf("release %s has no binary for %s/%s", release.Version, runtime.GOOS, runtime.GOARCH)
+	}
+	if release.checksumURL == "" {
+		return nil, fmt.Errorf("release %s has no %s, refusing to install an unverified binary", release.Version, checksumsAsset)
+	}
+
+	return release, nil
+}
+
+// IsNewer reports whether the release differs from the running version
+func (r *Release) IsNewer(current string) bool {
+	return strings.TrimPrefix(r.Version, "v") != strings.TrimPrefix(current, "v")
+}
+
+// Install downloads the release binary, verifies its checksum and replaces the
+// currently running executable with it
+func (r *Release) Install(ctx context.Context) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("failed to resolve executable: %w", err)
+	}
+
+	expected, err := r.checksum(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Download next to the executable so the final rename stays on one filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".updati-update-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	if err := download(ctx, r.assetURL, io.MultiWriter(tmp, hash)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write binary: %w", err)
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", AssetName(), expected, actual)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return fmt.Errorf("failed to make binary executable: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+
+	return nil
+}
+
+// checksum returns the expected SHA-256 of the platform binary from the checksums asset
+func (r *Release) checksum(ctx context.Context) (string, error) {
+	var buf strings.Builder
+	if err := download(ctx, r.checksumURL, &buf); err != nil {
+		return "", err
+	}
+
+	// sha256sum format: "<hash>  <file>"
+	scanner := bufio.NewScanner(strings.NewReader(buf.String()))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == AssetName() {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+
+	return "", fmt.Errorf("%s has no entry for %s", checksumsAsset, AssetName())
+}
+
+func download(ctx context.Context, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build download request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	return nil
+}
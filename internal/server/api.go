@@ -0,0 +1,42 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// updateRequest is the body of a request to the update endpoint
+type updateRequest struct {
+	Repository string `json:"repository"` // name or owner/name
+}
+
+// handleUpdate queues an update of the repository in the request body
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	var req updateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPayload)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Status: "error", Reason: "invalid request body"})
+		return
+	}
+
+	name, err := repositoryName(s.cfg, req.Repository)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, response{Status: "error", Repository: req.Repository, Reason: err.Error()})
+		return
+	}
+
+	s.respondQueued(w, name, "api")
+}
+
+// requireToken rejects requests that don't carry the serve token as bearer token
+func (s *Server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.ServeToken)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, response{Status: "error", Reason: "invalid token"})
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/runner"
+)
+
+// runLock takes the run lock for an update or run with cfg, so a scheduled run can't
+// push the same branches at the same time. The updates and runs in progress share the
+// lock: the first one takes it and the last one releases it, leaving it to other runs
+// while the server is idle. The returned context is canceled when another run takes
+// over the lock. Dry runs don't lock.
+func (s *Server) runLock(ctx context.Context, cfg *config.Config) (context.Context, func(), error) {
+	if cfg.DryRun.Enabled() {
+		return ctx, func() {}, nil
+	}
+
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+
+	if s.lockHolders == 0 {
+		lockCtx, lost := context.WithCancelCause(ctx)
+		release, err := runner.Lock(lockCtx, s.cfg, s.client, s.logger, lost)
+		if err != nil {
+			lost(nil)
+			return nil, nil, err
+		}
+		s.lockCtx = lockCtx
+		s.unlock = func() {
+			release()
+			lost(nil)
+		}
+	} else if s.lockCtx.Err() != nil {
+		// Lost to another run, the updates still holding it are stopping
+		return nil, nil, fmt.Errorf("failed to lock: %w", context.Cause(s.lockCtx))
+	}
+	s.lockHolders++
+
+	return s.lockCtx, func() {
+		s.lockMu.Lock()
+		defer s.lockMu.Unlock()
+
+		s.lockHolders--
+		if s.lockHolders == 0 {
+			s.unlock()
+			s.lockCtx, s.unlock = nil, nil
+		}
+	}, nil
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/history"
+	"github.com/janyksteenbeek/updati/internal/notify"
+	"github.com/janyksteenbeek/updati/internal/report"
+	"github.com/janyksteenbeek/updati/internal/runner"
+	"github.com/janyksteenbeek/updati/internal/updater"
+	"github.com/janyksteenbeek/updati/internal/worker"
+)
+
+// maxRuns caps the runs kept in memory, the oldest finished ones are forgotten first
+const maxRuns = 100
+
+// Run statuses
+const (
+	RunQueued   = "queued"
+	RunRunning  = "running"
+	RunFinished = "finished"
+	RunFailed   = "failed" // The run couldn't process the repositories, e.g. listing them failed
+)
+
+// runSettings are the settings the inline config of a run may change. Anything else,
+// like credentials, commands, binaries, file paths or notification URLs, stays as the
+// server's config has it, so API clients can't run commands on the server or send
+// its data elsewhere.
+var runSettings = []string{
+	"repo_patterns", "update_composer", "update_npm", "min_release_age", "include_dev",
+	"create_pr", "base_branch", "branches", "require_green_ci",
+	"commit_message", "pr_title", "changelog_links", "security_annotations", "security_label", "risk_score", "check_run",
+	"dry_run", "labels", "create_missing_labels", "auto_merge", "merge_method", "ready_after_ci",
+	"close_superseded", "max_prs_per_run", "max_open_prs_per_repo",
+	"audit_gate", "composer_validate", "freshness", "tags",
+}
+
+// Plugin selections of a run, like --only and --skip
+const (
+	runOnly = "only"
+	runSkip = "skip"
+)
+
+// run is a run triggered through the API
+type run struct {
+	ID           string         `json:"id"`
+	Status       string         `json:"status"`                 // One of the Run constants
+	Repositories []string       `json:"repositories,omitempty"` // Requested repositories, empty for all matching ones
+	Error        string         `json:"error,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+	Report       *report.Report `json:"report,omitempty"` // Outcome per repository, once finished
+
+	cfg *config.Config // Server config with the run's inline config applied
+}
+
+// runRequest is the body of a request to start a run
+type runRequest struct {
+	Repositories []string        `json:"repositories"` // name or owner/name, empty for all matching repositories
+	Config       json.RawMessage `json:"config"`       // Settings overriding the server's config for this run
+}
+
+// handleCreateRun queues a run of the requested repositories with the inline config
+func (s *Server) handleCreateRun(w http.ResponseWriter, r *http.Request) {
+	var req runRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPayload)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Status: "error", Reason: "invalid request body"})
+		return
+	}
+
+	cfg, err := s.runConfig(req.Config)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, response{Status: "error", Reason: err.Error()})
+		return
+	}
+
+	// A run's repo_patterns can only narrow down the server's
+	var names []string
+	for _, repository := range req.Repositories {
+		name, err := repositoryName(s.cfg, repository)
+		if err == nil {
+			name, err = repositoryName(cfg, repository)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, response{Status: "error", Repository: repository, Reason: err.Error()})
+			return
+		}
+		names = append(names, name)
+	}
+
+	id, err := newRunID()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, response{Status: "error", Reason: err.Error()})
+		return
+	}
+	rn := &run{ID: id, Status: RunQueued, Repositories: names, CreatedAt: time.Now().UTC(), cfg: cfg}
+
+	s.mu.Lock()
+	select {
+	case s.runQueue <- rn:
+		s.storeRun(rn)
+	default:
+		s.mu.Unlock()
+		writeJSON(w, http.StatusServiceUnavailable, response{Status: "error", Reason: errQueueFull.Error()})
+		return
+	}
+	snapshot := *rn
+	s.mu.Unlock()
+
+	s.logger.Info("queued run", "run", id, "repositories", len(names))
+	w.Header().Set("Location", "/runs/"+id)
+	writeJSON(w, http.StatusAccepted, &snapshot)
+}
+
+// handleGetRun returns the status of a run and, once finished, its results
+func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	rn, ok := s.runs[r.PathValue("id")]
+	var snapshot run
+	if ok {
+		snapshot = *rn
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, response{Status: "error", Reason: "unknown run"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &snapshot)
+}
+
+// handleLastResult returns the latest result of a repository, from a run or a single
+// update. Any repository with a recorded result is served, whichever repo_patterns
+// the update was made with.
+func (s *Server) handleLastResult(w http.ResponseWriter, r *http.Request) {
+	name, err := ownedName(s.cfg, r.PathValue("owner")+"/"+r.PathValue("name"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, response{Status: "error", Reason: err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	result, ok := s.lastResults[strings.ToLower(name)]
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, response{Status: "error", Repository: name, Reason: "repository wasn't processed yet"})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// runConfig applies the inline config of a run to the server's config
+func (s *Server) runConfig(inline json.RawMessage) (*config.Config, error) {
+	if len(inline) == 0 || string(inline) == "null" {
+		return s.cfg, nil
+	}
+
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(inline, &settings); err != nil {
+		return nil, fmt.Errorf("config must be an object: %w", err)
+	}
+
+	plugins := make(map[string][]string)
+	for _, key := range []string{runOnly, runSkip} {
+		raw, ok := settings[key]
+		if !ok {
+			continue
+		}
+		var names []string
+		if err := json.Unmarshal(raw, &names); err != nil {
+			return nil, fmt.Errorf("config setting %s must be a list of plugins", key)
+		}
+		for _, name := range names {
+			if !slices.Contains(updater.PluginNames(), name) {
+				return nil, fmt.Errorf("unknown plugin %q in %s, available: %s", name, key, strings.Join(updater.PluginNames(), ", "))
+			}
+		}
+		plugins[key] = names
+		delete(settings, key)
+	}
+	for key := range settings {
+		if !slices.Contains(runSettings, key) {
+			return nil, fmt.Errorf("config setting %s can't be changed per run", key)
+		}
+	}
+
+	// JSON is YAML, so the config's own decoding applies
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := s.cfg.WithYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	if names, ok := plugins[runOnly]; ok {
+		cfg.OnlyPlugins = names
+	}
+	if names, ok := plugins[runSkip]; ok {
+		cfg.SkipPlugins = names
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if _, err := updater.OrderedPlugins(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// storeRun keeps a new run, forgetting the oldest finished run when there are too many.
+// The caller holds s.mu.
+func (s *Server) storeRun(rn *run) {
+	s.runs[rn.ID] = rn
+	s.runOrder = append(s.runOrder, rn.ID)
+
+	if len(s.runOrder) <= maxRuns {
+		return
+	}
+	for i, id := range s.runOrder {
+		if status := s.runs[id].Status; status == RunFinished || status == RunFailed {
+			delete(s.runs, id)
+			s.runOrder = slices.Delete(s.runOrder, i, i+1)
+			return
+		}
+	}
+}
+
+// processRuns performs the queued runs one at a time until the context is canceled
+func (s *Server) processRuns(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rn := <-s.runQueue:
+			s.setRunStatus(rn, RunRunning, "")
+
+			rep, err := s.performRun(ctx, rn)
+			if err != nil {
+				s.logger.Error("run failed", "run", rn.ID, "error", err)
+				s.setRunStatus(rn, RunFailed, err.Error())
+				continue
+			}
+
+			s.mu.Lock()
+			rn.Report = rep
+			rn.Status = RunFinished
+			s.mu.Unlock()
+			s.recordResults(rep)
+
+			s.logger.Info("run finished", "run", rn.ID, "updated", rep.Updated, "skipped", rep.Skipped+rep.Deferred, "failed", rep.Failed)
+		}
+	}
+}
+
+// performRun updates the run's repositories with the run's config
+func (s *Server) performRun(ctx context.Context, rn *run) (*report.Report, error) {
+	startedAt := time.Now()
+
+	var repos []*github.Repository
+	if len(rn.Repositories) == 0 {
+		all, err := s.reader.ListRepositories(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories: %w", err)
+		}
+		for _, repo := range all {
+			if s.cfg.MatchesRepo(repo.Name) && rn.cfg.MatchesRepo(repo.Name) {
+				repos = append(repos, repo)
+			}
+		}
+	} else {
+		for _, name := range rn.Repositories {
+			repo, err := s.reader.GetRepository(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			repos = append(repos, repo)
+		}
+	}
+
+	// Single updates of the same repositories finish first, and wait for the run
+	names := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		names = append(names, repo.Name)
+	}
+	if err := s.claimAll(ctx, names); err != nil {
+		return nil, err
+	}
+	defer s.release(names)
+
+	ctx, unlock, err := s.runLock(ctx, rn.cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	s.logger.Info("starting run", "run", rn.ID, "repositories", len(repos))
+
+	pool := worker.New(rn.cfg.Workers, updater.New(rn.cfg, s.client, s.reader), s.reader, s.logger.With("run", rn.ID))
+	pool.Autoscale(rn.cfg.MinWorkers)
+	result := pool.Process(ctx, repos)
+	if cause := context.Cause(ctx); errors.Is(cause, runner.ErrLockLost) {
+		return nil, cause
+	}
+
+	rep := report.New(rn.cfg, rn.cfg.Mode(), result, startedAt, time.Now())
+	rep.ID = rn.ID
+	if rn.cfg.HistoryFile != "" {
+		if err := history.Save(context.WithoutCancel(ctx), rn.cfg.HistoryFile, rep); err != nil {
+			s.logger.Warn("failed to record run history", "run", rn.ID, "error", err)
+		}
+	}
+	notify.Send(ctx, rn.cfg, rep, s.logger.With("run", rn.ID))
+	return rep, nil
+}
+
+func (s *Server) setRunStatus(rn *run, status, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rn.Status = status
+	rn.Error = reason
+}
+
+// recordResults remembers the results of a report as the latest per repository
+func (s *Server) recordResults(rep *report.Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, result := range rep.Repositories {
+		_, name, _ := strings.Cut(result.Repository, "/")
+		s.lastResults[strings.ToLower(name)] = result
+	}
+}
+
+func newRunID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate run id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
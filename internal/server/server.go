@@ -0,0 +1,411 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/audit"
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/promote"
+	"github.com/janyksteenbeek/updati/internal/report"
+	"github.com/janyksteenbeek/updati/internal/runner"
+	"github.com/janyksteenbeek/updati/internal/updater"
+	"github.com/janyksteenbeek/updati/internal/worker"
+)
+
+// queueSize caps the updates waiting for a worker
+const queueSize = 100
+
+// promoteInterval is how often draft update pull requests are checked for promotion
+// with ready_after_ci
+const promoteInterval = 5 * time.Minute
+
+// errQueueFull is returned when an update can't be queued because too many are waiting
+var errQueueFull = errors.New("update queue is full")
+
+// Server accepts webhooks and API requests that ask for an update of a single
+// repository, and processes them with the configured number of workers. Runs of
+// several repositories triggered through the API are processed one at a time. A
+// repository is only updated by one worker or run at a time.
+type Server struct {
+	cfg    *config.Config
+	client *github.Client // Pushes and manages pull requests
+	reader *github.Client // Looks up and inspects repositories
+	logger *slog.Logger
+
+	queue    chan request
+	runQueue chan *run
+
+	mu          sync.Mutex
+	pending     map[string]bool               // Lowercase names of the repositories queued and not picked up by a worker yet
+	inFlight    map[string]bool               // Lowercase names of the repositories being updated
+	again       map[string]request            // Requests that arrived while their repository was in flight
+	released    chan struct{}                 // Closed and replaced whenever repositories leave inFlight
+	runs        map[string]*run               // Runs triggered through the API by ID
+	runOrder    []string                      // IDs of the runs, oldest first
+	lastResults map[string]*report.RepoResult // Latest result per lowercase repository name
+
+	lockMu      sync.Mutex
+	lockHolders int             // Updates and runs holding the run lock
+	lockCtx     context.Context // Canceled when another run takes over the run lock
+	unlock      func()          // Releases the run lock
+}
+
+// request is a queued update of a repository
+type request struct {
+	Repository string // Name of the repository, without owner
+	Source     string // What asked for the update, e.g. github:push or api
+}
+
+// New creates a Server
+func New(cfg *config.Config, logger *slog.Logger) *Server {
+	opts := github.Options{
+		Retry:    cfg.Retry,
+		CacheDir: cfg.CacheDir,
+		Audit:    audit.New(cfg.AuditLog),
+		Tokens:   cfg.GitHubTokens,
+	}
+	client := github.NewClient(cfg.GitHubToken, cfg.Owner, opts)
+	reader := client
+	if cfg.ReadToken != "" {
+		opts.Tokens = nil // The read token doesn't rotate with the others
+		reader = github.NewClient(cfg.ReadToken, cfg.Owner, opts)
+	}
+	return &Server{
+		cfg:         cfg,
+		client:      client,
+		reader:      reader,
+		logger:      logger,
+		queue:       make(chan request, queueSize),
+		runQueue:    make(chan *run, queueSize),
+		pending:     make(map[string]bool),
+		inFlight:    make(map[string]bool),
+		again:       make(map[string]request),
+		released:    make(chan struct{}),
+		runs:        make(map[string]*run),
+		lastResults: make(map[string]*report.RepoResult),
+	}
+}
+
+// Handler returns the HTTP handler of the server. Endpoints without a configured
+// secret or token are left out.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	if s.cfg.WebhookSecret != "" {
+		mux.HandleFunc("POST /webhooks/github", s.handleGitHub)
+	}
+	if s.cfg.ServeToken != "" {
+		mux.HandleFunc("POST /api/updates", s.requireToken(s.handleUpdate))
+		mux.HandleFunc("POST /runs", s.requireToken(s.handleCreateRun))
+		mux.HandleFunc("GET /runs/{id}", s.requireToken(s.handleGetRun))
+		mux.HandleFunc("GET /repos/{owner}/{name}/last-result", s.requireToken(s.handleLastResult))
+	}
+	return mux
+}
+
+// Run serves until the context is canceled. It then stops accepting requests and
+// cancels the updates in progress.
+func (s *Server) Run(ctx context.Context) error {
+	if s.cfg.WorkDir != "" {
+		if err := os.MkdirAll(s.cfg.WorkDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create workdir: %w", err)
+		}
+	}
+	updater.CleanStaleTemp(s.cfg, s.logger)
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.cfg.Workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			s.work(ctx, id)
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.processRuns(ctx)
+	}()
+	if s.cfg.ReadyAfterCI {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.promote(ctx)
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:              s.cfg.ServeAddr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	s.logger.Info("serving",
+		"addr", s.cfg.ServeAddr,
+		"github_webhook", s.cfg.WebhookSecret != "",
+		"api", s.cfg.ServeToken != "",
+		"workers", s.cfg.Workers,
+		"promote", s.cfg.ReadyAfterCI,
+	)
+
+	var err error
+	select {
+	case err = <-errc:
+		err = fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err = srv.Shutdown(shutdown)
+	}
+
+	wg.Wait()
+	return err
+}
+
+// promote marks draft update pull requests ready once their CI passed, every
+// promoteInterval until the context is canceled
+func (s *Server) promote(ctx context.Context) {
+	ticker := time.NewTicker(promoteInterval)
+	defer ticker.Stop()
+
+	log := s.logger.With("source", "promote")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			promotions, err := promote.Run(ctx, s.cfg, s.client, s.reader, log)
+			if err != nil && ctx.Err() == nil {
+				log.Error("could not promote draft pull requests", "error", err)
+			}
+			ready := 0
+			for _, p := range promotions {
+				if p.Ready {
+					ready++
+				}
+			}
+			log.Debug("checked draft pull requests", "drafts", len(promotions), "ready", ready)
+		}
+	}
+}
+
+// enqueue queues an update of the repository and reports whether it was queued, false
+// when an update of it is already waiting. A request for a repository that is being
+// updated is queued once more after that update finished.
+func (s *Server) enqueue(req request) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(req.Repository)
+	if _, waiting := s.again[key]; waiting || s.pending[key] {
+		return false, nil
+	}
+	if s.inFlight[key] {
+		s.again[key] = req
+		s.logger.Info("queued update after the one in progress", "repo", req.Repository, "source", req.Source)
+		return true, nil
+	}
+
+	select {
+	case s.queue <- req:
+		s.pending[key] = true
+		s.logger.Info("queued update", "repo", req.Repository, "source", req.Source)
+		return true, nil
+	default:
+		return false, errQueueFull
+	}
+}
+
+// work processes queued updates until the context is canceled
+func (s *Server) work(ctx context.Context, id int) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-s.queue:
+			if !s.claim(req) {
+				continue
+			}
+			s.process(ctx, id, req)
+			s.release([]string{req.Repository})
+		}
+	}
+}
+
+// claim marks the requested repository as in flight and reports whether the worker
+// may update it. When it is already being updated, by another worker or a run, the
+// request waits for that update to finish instead.
+func (s *Server) claim(req request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(req.Repository)
+	delete(s.pending, key)
+	if s.inFlight[key] {
+		if _, waiting := s.again[key]; !waiting {
+			s.again[key] = req
+		}
+		return false
+	}
+	s.inFlight[key] = true
+	return true
+}
+
+// claimAll marks the repositories of a run as in flight, waiting until none of them
+// is being updated anymore
+func (s *Server) claimAll(ctx context.Context, names []string) error {
+	for {
+		s.mu.Lock()
+		busy := slices.ContainsFunc(names, func(name string) bool {
+			return s.inFlight[strings.ToLower(name)]
+		})
+		if !busy {
+			for _, name := range names {
+				s.inFlight[strings.ToLower(name)] = true
+			}
+			s.mu.Unlock()
+			return nil
+		}
+		released := s.released
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// release marks the repositories as no longer in flight and queues the requests that
+// arrived for them in the meantime
+func (s *Server) release(names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range names {
+		key := strings.ToLower(name)
+		delete(s.inFlight, key)
+
+		req, ok := s.again[key]
+		if !ok {
+			continue
+		}
+		delete(s.again, key)
+		select {
+		case s.queue <- req:
+			s.pending[key] = true
+		default:
+			s.logger.Warn("dropped update requested during the previous one", "repo", req.Repository, "error", errQueueFull)
+		}
+	}
+
+	close(s.released)
+	s.released = make(chan struct{})
+}
+
+// process updates a single requested repository
+func (s *Server) process(ctx context.Context, id int, req request) {
+	log := s.logger.With("worker", id, "repo", req.Repository, "source", req.Source)
+
+	repo, err := s.reader.GetRepository(ctx, req.Repository)
+	if err != nil {
+		log.Error("could not look up repository", "error", err)
+		return
+	}
+
+	ctx, unlock, err := s.runLock(ctx, s.cfg)
+	if err != nil {
+		log.Error("could not take the run lock", "error", err)
+		return
+	}
+	defer unlock()
+
+	// A fresh updater per request, so budgets like max_prs_per_run apply per update
+	startedAt := time.Now()
+	pool := worker.New(1, updater.New(s.cfg, s.client, s.reader), s.reader, s.logger.With("source", req.Source))
+	result := pool.Process(ctx, []*github.Repository{repo})
+	s.recordResults(report.New(s.cfg, s.cfg.Mode(), result, startedAt, time.Now()))
+	if cause := context.Cause(ctx); errors.Is(cause, runner.ErrLockLost) {
+		log.Error("requested update stopped", "error", cause)
+		return
+	}
+
+	log.Info("requested update finished",
+		"updated", result.Updated,
+		"skipped", result.Skipped+result.Deferred,
+		"failed", result.Failed,
+	)
+}
+
+// repositoryName returns the name of a repository given as name or owner/name, or an
+// error when it belongs to another owner or doesn't match the repository patterns of cfg
+func repositoryName(cfg *config.Config, repository string) (string, error) {
+	name, err := ownedName(cfg, repository)
+	if err != nil {
+		return "", err
+	}
+	if !cfg.MatchesRepo(name) {
+		return "", fmt.Errorf("repository %s does not match repo_patterns", name)
+	}
+	return name, nil
+}
+
+// ownedName returns the name of a repository given as name or owner/name, or an error
+// when it belongs to another owner than that of cfg
+func ownedName(cfg *config.Config, repository string) (string, error) {
+	name := repository
+	if owner, rest, ok := strings.Cut(repository, "/"); ok {
+		if !strings.EqualFold(owner, cfg.Owner) {
+			return "", fmt.Errorf("repository %s does not belong to %s", repository, cfg.Owner)
+		}
+		name = rest
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid repository %q", repository)
+	}
+	return name, nil
+}
+
+// response is the body of every endpoint's response
+type response struct {
+	Status     string `json:"status"` // queued, already queued, ignored or error
+	Repository string `json:"repository,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// respondQueued queues the update of a repository and writes the outcome
+func (s *Server) respondQueued(w http.ResponseWriter, name, source string) {
+	queued, err := s.enqueue(request{Repository: name, Source: source})
+	switch {
+	case err != nil:
+		writeJSON(w, http.StatusServiceUnavailable, response{Status: "error", Repository: name, Reason: err.Error()})
+	case queued:
+		writeJSON(w, http.StatusAccepted, response{Status: "queued", Repository: name})
+	default:
+		writeJSON(w, http.StatusAccepted, response{Status: "already queued", Repository: name})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// maxPayload caps the size of webhook and API request bodies, GitHub's own limit
+const maxPayload = 25 << 20
+
+// dispatchEventType is the repository_dispatch event type that asks for an update
+const dispatchEventType = "updati"
+
+// dependencyFiles are the files whose change on the default branch calls for a refresh
+// of the update
+var dependencyFiles = []string{"composer.json", "composer.lock", "package.json", "package-lock.json"}
+
+// githubEvent holds the fields of the webhook payloads updati reacts to
+type githubEvent struct {
+	Action     string `json:"action"` // The event type of a repository_dispatch
+	Ref        string `json:"ref"`
+	Repository struct {
+		Name          string `json:"name"`
+		DefaultBranch string `json:"default_branch"`
+		Owner         struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Commits []struct {
+		Added    []string `json:"added"`
+		Removed  []string `json:"removed"`
+		Modified []string `json:"modified"`
+	} `json:"commits"`
+}
+
+// handleGitHub queues an update for GitHub webhook deliveries that ask for one: a
+// repository_dispatch with the updati event type, a workflow_dispatch, or a push to the
+// default branch that changes a manifest or lockfile. Deliveries have to be signed with
+// the webhook secret.
+func (s *Server) handleGitHub(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPayload))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Status: "error", Reason: "failed to read payload"})
+		return
+	}
+	if !validSignature(s.cfg.WebhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+		writeJSON(w, http.StatusUnauthorized, response{Status: "error", Reason: "invalid signature"})
+		return
+	}
+
+	kind := r.Header.Get("X-GitHub-Event")
+	if kind == "ping" {
+		writeJSON(w, http.StatusOK, response{Status: "pong"})
+		return
+	}
+
+	var event githubEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Status: "error", Reason: "invalid payload"})
+		return
+	}
+
+	if reason := ignoreReason(kind, &event); reason != "" {
+		writeJSON(w, http.StatusOK, response{Status: "ignored", Repository: event.Repository.Name, Reason: reason})
+		return
+	}
+
+	name, err := repositoryName(s.cfg, event.Repository.Owner.Login+"/"+event.Repository.Name)
+	if err != nil {
+		writeJSON(w, http.StatusOK, response{Status: "ignored", Repository: event.Repository.Name, Reason: err.Error()})
+		return
+	}
+
+	s.respondQueued(w, name, "github:"+kind)
+}
+
+// ignoreReason returns why a webhook event doesn't ask for an update, or an empty string
+// when it does
+func ignoreReason(kind string, event *githubEvent) string {
+	switch kind {
+	case "repository_dispatch":
+		if event.Action != dispatchEventType {
+			return "event type is not " + dispatchEventType
+		}
+	case "workflow_dispatch":
+	case "push":
+		if event.Ref != "refs/heads/"+event.Repository.DefaultBranch {
+			return "not a push to the default branch"
+		}
+		if !changesDependencies(event) {
+			return "no manifest or lockfile changed"
+		}
+	default:
+		return "unsupported event " + kind
+	}
+	return ""
+}
+
+// changesDependencies reports whether a push changed a manifest or lockfile
+func changesDependencies(event *githubEvent) bool {
+	for _, commit := range event.Commits {
+		for _, files := range [][]string{commit.Added, commit.Removed, commit.Modified} {
+			for _, file := range files {
+				if slices.Contains(dependencyFiles, file) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// validSignature checks the X-Hub-Signature-256 header of a delivery against the HMAC
+// of its body
+func validSignature(secret string, body []byte, header string) bool {
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
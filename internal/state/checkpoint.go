@@ -0,0 +1,128 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Checkpoint statuses of a repository
+const (
+	CheckpointUpdated  = "updated"
+	CheckpointSkipped  = "skipped"
+	CheckpointDeferred = "deferred" // Held back by the pull request budget, not completed
+	CheckpointFailed   = "failed"
+)
+
+// CheckpointEntry is the outcome of a repository, one JSON line in the checkpoint file
+type CheckpointEntry struct {
+	Repository string    `json:"repository"`
+	Status     string    `json:"status"` // One of the Checkpoint statuses, failed if any of its branches failed
+	Error      string    `json:"error,omitempty"`
+	PRURLs     []string  `json:"pr_urls,omitempty"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Checkpoint appends the outcome of every repository to a JSON lines file as soon as it
+// is processed, so an interrupted run can be resumed
+type Checkpoint struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenCheckpoint opens the checkpoint file, starting it anew unless resume is set, in
+// which case entries are added to those of the interrupted run
+func OpenCheckpoint(path string, resume bool) (*Checkpoint, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+
+	return &Checkpoint{f: f}, nil
+}
+
+// Record appends an entry and syncs it to disk, it is safe for concurrent use
+func (c *Checkpoint) Record(entry CheckpointEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint entry: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return c.f.Sync()
+}
+
+// Close closes the checkpoint file
+func (c *Checkpoint) Close() error {
+	return c.f.Close()
+}
+
+// CompletedRepositories returns the repositories the checkpoint file records as
+// processed without failure, by their latest entry. Deferred repositories weren't
+// processed yet. A missing file completed nothing.
+func CompletedRepositories(path string) (map[string]bool, error) {
+	return repositoriesWhere(path, func(entry CheckpointEntry) bool {
+		return entry.Status != CheckpointFailed && entry.Status != CheckpointDeferred
+	})
+}
+
+// FailedRepositories returns the repositories whose latest entry in the checkpoint file
+// records a failure
+func FailedRepositories(path string) (map[string]bool, error) {
+	return repositoriesWhere(path, func(entry CheckpointEntry) bool {
+		return entry.Status == CheckpointFailed
+	})
+}
+
+// repositoriesWhere returns the repositories whose latest entry in the checkpoint file
+// matches. A truncated last line, left by a crash, is ignored.
+func repositoriesWhere(path string, match func(CheckpointEntry) bool) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]bool{}, nil
+		}
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	defer f.Close()
+
+	matched := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry CheckpointEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || entry.Repository == "" {
+			continue
+		}
+		matched[entry.Repository] = match(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	for name, ok := range matched {
+		if !ok {
+			delete(matched, name)
+		}
+	}
+	return matched, nil
+}
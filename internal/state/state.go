@@ -0,0 +1,124 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// State is what updati remembers between runs
+type State struct {
+	Queue         []string             `json:"queue,omitempty"`          // Repositories a time-boxed run didn't get to, in order
+	LastProcessed map[string]time.Time `json:"last_processed,omitempty"` // When each repository was last processed
+	Failures      map[string]int       `json:"failures,omitempty"`       // Consecutive failed runs per repository
+}
+
+// Load reads the state file, returning an empty state when it doesn't exist yet
+func Load(path string) (*State, error) {
+	s := &State{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Save writes the state file atomically, so an interrupted run never leaves a
+// truncated state behind
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to write state: %w", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".updati-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+
+	return nil
+}
+
+// Prioritize orders repository names for processing: repositories queued by the
+// previous run first, in their queued order, then the rest by when they were last
+// processed, never processed ones first
+func (s *State) Prioritize(names []string) []string {
+	ordered := slices.Clone(names)
+
+	queued := make(map[string]int, len(s.Queue))
+	for i, name := range s.Queue {
+		queued[name] = i
+	}
+
+	slices.SortStableFunc(ordered, func(a, b string) int {
+		qa, aQueued := queued[a]
+		qb, bQueued := queued[b]
+		switch {
+		case aQueued && bQueued:
+			return qa - qb
+		case aQueued:
+			return -1
+		case bQueued:
+			return 1
+		}
+		return s.LastProcessed[a].Compare(s.LastProcessed[b])
+	})
+
+	return ordered
+}
+
+// MarkProcessed records that the repository was processed at the given time
+func (s *State) MarkProcessed(name string, at time.Time) {
+	if s.LastProcessed == nil {
+		s.LastProcessed = make(map[string]time.Time)
+	}
+	s.LastProcessed[name] = at.UTC()
+}
+
+// RecordFailure counts another consecutive failed run of the repository and returns
+// the number of consecutive failures
+func (s *State) RecordFailure(name string) int {
+	if s.Failures == nil {
+		s.Failures = make(map[string]int)
+	}
+	s.Failures[name]++
+	return s.Failures[name]
+}
+
+// ClearFailures resets the failures of a repository after a successful run and returns
+// how many consecutive runs had failed before it
+func (s *State) ClearFailures(name string) int {
+	n := s.Failures[name]
+	delete(s.Failures, name)
+	return n
+}
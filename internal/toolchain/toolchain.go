@@ -0,0 +1,256 @@
+package toolchain
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Tool describes an external binary used during updates
+type Tool struct {
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// probe describes how to find a tool and ask it for its version
+type probe struct {
+	name     string
+	args     []string
+	optional bool // Omit from the matrix when not installed
+}
+
+// phpVersionArgs prints the bare PHP version
+var phpVersionArgs = []string{"-r", "echo PHP_VERSION;"}
+
+// probes lists the tools in the order they appear in the matrix
+var probes = []probe{
+	{name: "git", args: []string{"--version"}},
+	{name: "php", args: phpVersionArgs},
+	{name: "php81", args: phpVersionArgs, optional: true},
+	{name: "php82", args: phpVersionArgs, optional: true},
+	{name: "php83", args: phpVersionArgs, optional: true},
+	{name: "php84", args: phpVersionArgs, optional: true},
+	{name: "php85", args: phpVersionArgs, optional: true},
+	{name: "php8.1", args: phpVersionArgs, optional: true},
+	{name: "php8.2", args: phpVersionArgs, optional: true},
+	{name: "php8.3", args: phpVersionArgs, optional: true},
+	{name: "php8.4", args: phpVersionArgs, optional: true},
+	{name: "php8.5", args: phpVersionArgs, optional: true},
+	{name: "composer", args: []string{"--version", "--no-ansi"}},
+	{name: "node", args: []string{"--version"}},
+	{name: "npm", args: []string{"--version"}},
+	{name: "yarn", args: []string{"--version"}, optional: true},
+	{name: "fnm", args: []string{"--version"}, optional: true},
+	{name: "volta", args: []string{"--version"}, optional: true},
+}
+
+// phpNames lists the PHP binary names updati looks for, the default binary first
+var phpNames = []string{"php", "php85", "php84", "php83", "php82", "php81", "php8.5", "php8.4", "php8.3", "php8.2", "php8.1"}
+
+// paths holds the configured paths of tools, which take precedence over PATH
+var paths map[string]string
+
+// fallbackDirs are searched for tools that aren't on PATH. Services, cron jobs and
+// minimal containers often run with a PATH that leaves out where tools get installed.
+var fallbackDirs = []string{
+	"/usr/local/bin",
+	"/opt/homebrew/bin",              // Homebrew on Apple silicon
+	"/home/linuxbrew/.linuxbrew/bin", // Homebrew on Linux
+	"/usr/bin",
+	"/bin",
+}
+
+// brewPrefixes are where Homebrew installs, for its versioned PHP formulae
+var brewPrefixes = []string{"/opt/homebrew", "/usr/local", "/home/linuxbrew/.linuxbrew"}
+
+// brewPHPVersions are the versioned PHP formulae looked for, e.g. php@8.3, whose
+// binaries Homebrew doesn't link into its bin directory
+var brewPHPVersions = []string{"8.5", "8.4", "8.3", "8.2", "8.1"}
+
+// SetPaths configures the paths of tools by name, e.g. composer: /opt/composer.phar.
+// Tools without a configured path are looked up on PATH.
+func SetPaths(configured map[string]string) {
+	paths = configured
+}
+
+// LookPath returns the path of a tool: the configured one, or where it is found on PATH
+// or in one of the usual install directories. A configured PHP archive doesn't have to
+// be executable, it is run through PHP.
+func LookPath(name string) (string, error) {
+	path, ok := paths[name]
+	if !ok {
+		return lookPath(name)
+	}
+	if IsPhar(path) {
+		if _, err := os.Stat(path); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+	return exec.LookPath(path)
+}
+
+// IsPhar reports whether the path is a PHP archive, like composer.phar
+func IsPhar(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".phar")
+}
+
+// lookPath finds a tool on PATH or, failing that, in the fallback directories
+func lookPath(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err == nil || strings.ContainsRune(name, filepath.Separator) {
+		return path, err
+	}
+	for _, dir := range fallbackDirs {
+		if found, ferr := exec.LookPath(filepath.Join(dir, name)); ferr == nil {
+			return found, nil
+		}
+	}
+	return "", err
+}
+
+// Path returns the configured path of a tool, or its name so commands look it up on
+// PATH. A tool found only in one of the fallback directories is returned by path.
+func Path(name string) string {
+	if path, ok := paths[name]; ok {
+		return path
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		if path, err := lookPath(name); err == nil {
+			return path
+		}
+	}
+	return name
+}
+
+// PHPBinaries returns the paths of all PHP binaries found, the default binary first,
+// followed by configured PHP tools with names updati doesn't look for itself and the
+// versioned PHP formulae of Homebrew
+func PHPBinaries() []string {
+	var found []string
+	seen := make(map[string]bool)
+
+	names := slices.Clone(phpNames)
+	for _, name := range slices.Sorted(maps.Keys(paths)) {
+		if strings.HasPrefix(name, "php") && !slices.Contains(phpNames, name) {
+			names = append(names, name)
+		}
+	}
+
+	// Names linked to the same binary, like php and php84, count once
+	add := func(path string) {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			resolved = path
+		}
+		if seen[resolved] {
+			return
+		}
+		seen[resolved] = true
+		found = append(found, path)
+	}
+
+	for _, name := range names {
+		if path, err := LookPath(name); err == nil {
+			add(path)
+		}
+	}
+
+	for _, prefix := range brewPrefixes {
+		for _, version := range brewPHPVersions {
+			path := filepath.Join(prefix, "opt", "php@"+version, "bin", "php")
+			if _, err := os.Stat(path); err == nil {
+				add(path)
+			}
+		}
+	}
+
+	return found
+}
+
+// AsdfInstalls returns the binaries of the versions asdf installed for a plugin, keyed
+// by version, e.g. AsdfInstalls("nodejs", "node"). asdf's data directory is taken from
+// ASDF_DATA_DIR, ~/.asdf by default.
+func AsdfInstalls(plugin, binary string) map[string]string {
+	dataDir := os.Getenv("ASDF_DATA_DIR")
+	if dataDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		dataDir = filepath.Join(home, ".asdf")
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dataDir, "installs", plugin))
+	if err != nil {
+		return nil
+	}
+
+	installs := make(map[string]string)
+	for _, entry := range entries {
+		path := filepath.Join(dataDir, "installs", plugin, entry.Name(), "bin", binary)
+		if _, err := os.Stat(path); err == nil {
+			installs[entry.Name()] = path
+		}
+	}
+	return installs
+}
+
+var versionPattern = regexp.MustCompile(`\d+\.\d+(\.\d+)?([-+.][0-9A-Za-z.]+)?`)
+
+// Detect probes the known tools and returns their paths and versions
+func Detect(ctx context.Context) []Tool {
+	var tools []Tool
+
+	for _, p := range probes {
+		path, err := LookPath(p.name)
+		if err != nil {
+			if _, configured := paths[p.name]; configured {
+				tools = append(tools, Tool{Name: p.name, Path: paths[p.name], Error: err.Error()})
+			} else if !p.optional {
+				tools = append(tools, Tool{Name: p.name, Error: "not found"})
+			}
+			continue
+		}
+
+		var version string
+		if IsPhar(path) {
+			version, err = Version(ctx, Path("php"), append([]string{path}, p.args...)...)
+		} else {
+			version, err = Version(ctx, path, p.args...)
+		}
+		tool := Tool{Name: p.name, Path: path, Version: version}
+		if err != nil {
+			tool.Error = err.Error()
+		}
+		tools = append(tools, tool)
+	}
+
+	return tools
+}
+
+// Version runs a binary with the given arguments and extracts the version number from its output
+func Version(ctx context.Context, path string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get version: %w", err)
+	}
+
+	if v := versionPattern.FindString(string(output)); v != "" {
+		return v, nil
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
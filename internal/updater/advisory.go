@@ -0,0 +1,58 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Advisory is a finding about a repository that updati reports but does not act on
+type Advisory struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// lockfiles are the JavaScript lockfiles that pin an asset pipeline's dependencies
+var lockfiles = []string{"package-lock.json", "yarn.lock", "pnpm-lock.yaml"}
+
+// detectAdvisories looks for legacy dependency systems in a checkout that are too
+// risky to update automatically
+func detectAdvisories(dir string) []Advisory {
+	var advisories []Advisory
+
+	if fileExists(dir, "bower.json") {
+		advisories = append(advisories, Advisory{
+			Code:    "bower",
+			Message: "bower.json found: Bower is deprecated, migrate these dependencies to npm",
+		})
+	}
+
+	if fileExists(dir, "webpack.mix.js") && !anyFileExists(dir, lockfiles...) {
+		advisories = append(advisories, Advisory{
+			Code:    "mix-without-lockfile",
+			Message: "webpack.mix.js without a lockfile: asset builds are not reproducible, commit a lockfile or migrate to Vite",
+		})
+	}
+
+	if anyFileExists(dir, "gulpfile.js", "Gruntfile.js") {
+		advisories = append(advisories, Advisory{
+			Code:    "legacy-task-runner",
+			Message: "Gulp or Grunt build found: consider migrating the asset pipeline to Vite",
+		})
+	}
+
+	return advisories
+}
+
+func fileExists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
+
+func anyFileExists(dir string, names ...string) bool {
+	for _, name := range names {
+		if fileExists(dir, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,288 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	gh "github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+)
+
+// SecurityAdvisory is a known vulnerability reported by `composer audit` or `npm audit`
+type SecurityAdvisory struct {
+	AdvisoryID  string `json:"advisoryId"`
+	PackageName string `json:"packageName"`
+	Title       string `json:"title"`
+	CVE         string `json:"cve,omitempty"`
+	Link        string `json:"link,omitempty"`
+}
+
+// Auditor is implemented by plugins that can report the security advisories affecting
+// the dependencies locked in a checkout, keyed by package and advisory id
+type Auditor interface {
+	Audit(ctx context.Context, job *Job) (map[string]SecurityAdvisory, error)
+}
+
+// String describes the advisory by its CVE, or its advisory id when it has none
+func (a SecurityAdvisory) String() string {
+	id := a.CVE
+	if id == "" {
+		id = a.AdvisoryID
+	}
+	return fmt.Sprintf("%s: %s", id, a.Title)
+}
+
+// auditLocked runs `composer audit` against composer.lock and returns the reported
+// advisories keyed by package and advisory id. Without a lock file there is nothing
+// to audit.
+func auditLocked(ctx context.Context, job *Job, php *phpBinary, env []string) (map[string]SecurityAdvisory, error) {
+	if !fileExists(job.Dir, "composer.lock") {
+		return nil, nil
+	}
+
+	cmd := composerCommand(ctx, php, "audit", "--locked", "--format=json", "--no-interaction")
+	cmd.Dir = job.Dir
+	cmd.Env = env
+	cmd = job.isolate(ctx, cmd)
+
+	// composer audit exits non-zero when it finds advisories, the report is still on stdout
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("composer audit failed: %w", err)
+	}
+
+	var report struct {
+		Advisories json.RawMessage `json:"advisories"` // An object keyed by package, or an empty array
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		if exitErr != nil {
+			return nil, fmt.Errorf("composer audit failed: %s", exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("failed to parse composer audit output: %w", err)
+	}
+
+	var byPackage map[string][]SecurityAdvisory
+	if len(report.Advisories) > 0 && report.Advisories[0] == '{' {
+		if err := json.Unmarshal(report.Advisories, &byPackage); err != nil {
+			return nil, fmt.Errorf("failed to parse composer audit output: %w", err)
+		}
+	}
+
+	advisories := make(map[string]SecurityAdvisory)
+	for name, list := range byPackage {
+		for _, adv := range list {
+			if adv.PackageName == "" {
+				adv.PackageName = name
+			}
+			advisories[adv.PackageName+" "+adv.AdvisoryID] = adv
+		}
+	}
+
+	return advisories, nil
+}
+
+// introducedAdvisories describes the advisories in after that weren't in before, with
+// the locked version of the affected package
+func introducedAdvisories(dir string, before, after map[string]SecurityAdvisory) ([]string, error) {
+	var introduced []SecurityAdvisory
+	for key, adv := range after {
+		if _, known := before[key]; !known {
+			introduced = append(introduced, adv)
+		}
+	}
+	if len(introduced) == 0 {
+		return nil, nil
+	}
+
+	packages, err := composerLockPackages(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptions := make([]string, 0, len(introduced))
+	for _, adv := range introduced {
+		descriptions = append(descriptions, fmt.Sprintf("%s %s (%s)", adv.PackageName, packages[strings.ToLower(adv.PackageName)].Version, adv))
+	}
+	sort.Strings(descriptions)
+
+	return descriptions, nil
+}
+
+// Audit reports the security advisories affecting the packages in composer.lock
+func (p *ComposerPlugin) Audit(ctx context.Context, job *Job) (map[string]SecurityAdvisory, error) {
+	php, _, err := p.resolvePlatform(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	homeEnv, removeHome, err := composerHomeEnv(ctx, php, job.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer removeHome()
+	return auditLocked(ctx, job, php, composerEnv(job.Config, homeEnv...))
+}
+
+// Audit reports the security advisories affecting the packages in package-lock.json,
+// from the GitHub Advisory Database npm audit queries
+func (p *NPMPlugin) Audit(ctx context.Context, job *Job) (map[string]SecurityAdvisory, error) {
+	if !fileExists(job.Dir, "package-lock.json") {
+		return nil, nil
+	}
+
+	env, cleanup, err := npmEnv(job.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	cmd := exec.CommandContext(ctx, toolchain.Path("npm"), "audit", "--json", "--package-lock-only")
+	cmd.Dir = job.Dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd = job.isolate(ctx, cmd)
+
+	// npm audit exits non-zero when it finds vulnerabilities, the report is still on stdout
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("npm audit failed: %w", err)
+	}
+
+	var report struct {
+		Vulnerabilities map[string]struct {
+			Via []json.RawMessage `json:"via"` // Advisories, or names of the vulnerable dependencies it comes through
+		} `json:"vulnerabilities"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse npm audit output: %w", err)
+	}
+
+	advisories := make(map[string]SecurityAdvisory)
+	for _, vuln := range report.Vulnerabilities {
+		for _, raw := range vuln.Via {
+			var via struct {
+				Source int    `json:"source"`
+				Name   string `json:"name"`
+				Title  string `json:"title"`
+				URL    string `json:"url"`
+			}
+			if json.Unmarshal(raw, &via) != nil || via.Source == 0 {
+				continue
+			}
+
+			id := strconv.Itoa(via.Source)
+			if i := strings.LastIndex(via.URL, "/"); i >= 0 && strings.HasPrefix(via.URL[i+1:], "GHSA-") {
+				id = via.URL[i+1:]
+			}
+			advisories[via.Name+" "+id] = SecurityAdvisory{
+				AdvisoryID:  id,
+				PackageName: via.Name,
+				Title:       via.Title,
+				Link:        via.URL,
+			}
+		}
+	}
+
+	return advisories, nil
+}
+
+// auditAll collects the advisories of every enabled plugin that can audit, per plugin.
+// Plugins whose audit fails are left out.
+func (u *Updater) auditAll(ctx context.Context, dir string, repo *gh.Repository, log *slog.Logger, output io.Writer) map[string]map[string]SecurityAdvisory {
+	all := make(map[string]map[string]SecurityAdvisory)
+
+	cfg := u.cfg.ForRepo(repo.Name)
+
+	for _, plugin := range Plugins() {
+		auditor, ok := plugin.(Auditor)
+		if !ok || !u.isPluginEnabled(plugin.Name()) || !plugin.Detect(repo) {
+			continue
+		}
+
+		job := &Job{
+			Dir:    dir,
+			Config: cfg,
+			Logger: log.With("plugin", plugin.Name()),
+			Output: output,
+			Image:  cfg.PluginImage(plugin.Name()),
+		}
+
+		advisories, err := auditor.Audit(ctx, job)
+		if err != nil {
+			job.Logger.Warn("could not audit dependencies", "error", err)
+			continue
+		}
+		all[plugin.Name()] = advisories
+	}
+
+	return all
+}
+
+// fixedAdvisories returns the advisories reported before the update that no longer
+// apply after it, for the plugins audited both times
+func fixedAdvisories(before, after map[string]map[string]SecurityAdvisory) []SecurityAdvisory {
+	var fixed []SecurityAdvisory
+	for plugin, advisories := range before {
+		remaining, ok := after[plugin]
+		if !ok {
+			continue
+		}
+		for key, adv := range advisories {
+			if _, open := remaining[key]; !open {
+				fixed = append(fixed, adv)
+			}
+		}
+	}
+
+	sortAdvisories(fixed)
+	return fixed
+}
+
+// openAdvisories returns the advisories of all plugins, sorted
+func openAdvisories(audited map[string]map[string]SecurityAdvisory) []SecurityAdvisory {
+	var open []SecurityAdvisory
+	for _, advisories := range audited {
+		for _, adv := range advisories {
+			open = append(open, adv)
+		}
+	}
+	sortAdvisories(open)
+	return open
+}
+
+// securitySection renders the fixed advisories for the PR body
+func securitySection(fixed []SecurityAdvisory) string {
+	var b strings.Builder
+
+	b.WriteString("### 🔒 Security fixes\n\n")
+	writeAdvisories(&b, fixed)
+
+	return b.String()
+}
+
+// AdvisoryList renders the advisories as a markdown list
+func AdvisoryList(advisories []SecurityAdvisory) string {
+	var b strings.Builder
+	writeAdvisories(&b, advisories)
+	return b.String()
+}
+
+// writeAdvisories writes the advisories as a markdown list, linked when they have a link
+func writeAdvisories(b *strings.Builder, advisories []SecurityAdvisory) {
+	for _, adv := range advisories {
+		if adv.Link != "" {
+			fmt.Fprintf(b, "- `%s`: [%s](%s)\n", adv.PackageName, adv, adv.Link)
+		} else {
+			fmt.Fprintf(b, "- `%s`: %s\n", adv.PackageName, adv)
+		}
+	}
+}
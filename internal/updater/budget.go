@@ -0,0 +1,71 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	gh "github.com/janyksteenbeek/updati/internal/github"
+)
+
+// budgetLimited reports whether new pull requests are limited per run or per repository
+func (u *Updater) budgetLimited() bool {
+	return u.cfg.MaxPRsPerRun > 0 || u.cfg.MaxOpenPRsPerRepo > 0
+}
+
+// runBudgetSpent reports whether the run opened as many pull requests as it may
+func (u *Updater) runBudgetSpent() bool {
+	return u.cfg.MaxPRsPerRun > 0 && int(u.prsOpened.Load()) >= u.cfg.MaxPRsPerRun
+}
+
+// reservePR claims a new pull request from the budget before the update is pushed. It
+// returns whether a pull request was reserved, which has to be released if none is
+// opened after all, or the reason to defer the update instead. Updates of a branch that
+// already has an open pull request don't open a new one and always go ahead.
+func (u *Updater) reservePR(ctx context.Context, log *slog.Logger, repo *gh.Repository, baseBranch, targetBranch string) (bool, string, error) {
+	open, err := u.reader.ListOpenPullRequests(ctx, repo, "", u.cfg.BranchPrefix())
+	if err != nil {
+		return false, "", fmt.Errorf("failed to check the pull request budget: %w", err)
+	}
+
+	counted := 0
+	for _, pr := range open {
+		if pr.Head == targetBranch {
+			return false, "", nil
+		}
+		// Pull requests the new one supersedes are about to be closed
+		if u.cfg.CloseSuperseded && pr.Base == baseBranch {
+			continue
+		}
+		counted++
+	}
+
+	if u.cfg.MaxOpenPRsPerRepo > 0 && counted >= u.cfg.MaxOpenPRsPerRepo {
+		return false, fmt.Sprintf("deferred, %d update pull requests are open (max_open_prs_per_repo is %d)", counted, u.cfg.MaxOpenPRsPerRepo), nil
+	}
+
+	if u.cfg.MaxPRsPerRun > 0 {
+		for {
+			opened := u.prsOpened.Load()
+			if int(opened) >= u.cfg.MaxPRsPerRun {
+				return false, u.runBudgetReason(), nil
+			}
+			if u.prsOpened.CompareAndSwap(opened, opened+1) {
+				log.Debug("reserved pull request from the run's budget", "opened", opened+1, "max", u.cfg.MaxPRsPerRun)
+				return true, "", nil
+			}
+		}
+	}
+
+	return false, "", nil
+}
+
+// releasePR returns a reserved pull request to the budget
+func (u *Updater) releasePR() {
+	u.prsOpened.Add(-1)
+}
+
+// runBudgetReason is the skip reason of updates deferred by max_prs_per_run
+func (u *Updater) runBudgetReason() string {
+	return fmt.Sprintf("deferred, the run opened %d pull requests (max_prs_per_run)", u.cfg.MaxPRsPerRun)
+}
@@ -0,0 +1,238 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+)
+
+// maxChangelogRows caps the package table so the PR body stays within GitHub's limit
+const maxChangelogRows = 100
+
+// githubSource matches the repository in the URL notations lock files and registries use
+var githubSource = regexp.MustCompile(`github\.com[:/]([\w.-]+)/([\w.-]+?)(?:\.git)?/?$`)
+
+// githubRepo returns the https URL of a GitHub repository URL, or an empty string when
+// the URL points elsewhere
+func githubRepo(source string) string {
+	m := githubSource.FindStringSubmatch(source)
+	if m == nil {
+		return ""
+	}
+	return "https://github.com/" + m[1] + "/" + m[2]
+}
+
+// changelogURL links to the release notes of the package's new version: for Composer
+// the source the package's metadata links, or the tree of the tag when the package
+// lives on GitHub, since many packages don't publish GitHub releases; for npm the
+// GitHub release of the tag. The registry page otherwise.
+func changelogURL(change PackageChange) string {
+	repo := githubRepo(change.Source)
+
+	switch change.Ecosystem {
+	case "composer":
+		// Composer locks the tag name as the version
+		if strings.HasPrefix(change.SourceURL, "https://") {
+			return change.SourceURL
+		}
+		if repo != "" && !strings.HasPrefix(change.To, "dev-") {
+			return repo + "/tree/" + url.PathEscape(change.To)
+		}
+		return "https://packagist.org/packages/" + change.Name + "#" + url.QueryEscape(change.To)
+	case "npm":
+		// npm version tags releases as v<version>
+		if repo != "" {
+			return repo + "/releases/tag/v" + url.PathEscape(change.To)
+		}
+		return "https://www.npmjs.com/package/" + change.Name + "/v/" + url.PathEscape(change.To)
+	}
+
+	return ""
+}
+
+// compareURL links to the upstream diff between the package's old and new version when
+// the package lives on GitHub and the versions' tags or commits are known, or returns an
+// empty string
+func compareURL(change PackageChange) string {
+	repo := githubRepo(change.Source)
+	if repo == "" || change.From == "" || change.To == "" {
+		return ""
+	}
+
+	from, to := change.From, change.To
+	switch change.Ecosystem {
+	case "composer":
+		// Composer locks the tag name as the version, dev versions lock a branch
+		if strings.HasPrefix(from, "dev-") || strings.HasPrefix(to, "dev-") {
+			return ""
+		}
+	case "npm":
+		// npm tags aren't named consistently, compare the commits the versions were
+		// published from instead
+		if change.FromHead == "" || change.ToHead == "" {
+			return ""
+		}
+		from, to = change.FromHead, change.ToHead
+	default:
+		return ""
+	}
+
+	return repo + "/compare/" + url.PathEscape(from) + "..." + url.PathEscape(to)
+}
+
+// resolveNPMSources looks up the repository of the changed npm packages and the commits
+// their old and new versions were published from in the configured registry. Packages
+// that can't be looked up keep an empty source.
+func resolveNPMSources(ctx context.Context, cfg *config.Config, dir string, changes []PackageChange) {
+	registry := npmRegistry(ctx, cfg, dir)
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	for i := range changes {
+		if changes[i].Ecosystem != "npm" || changes[i].Kind == ChangeRemoved || i >= maxChangelogRows {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		manifest := npmVersionManifest(ctx, client, registry, changes[i].Name, changes[i].To)
+		changes[i].Source = repositoryURL(manifest.Repository)
+		changes[i].ToHead = manifest.GitHead
+		if changes[i].From != "" && changes[i].Source != "" {
+			changes[i].FromHead = npmVersionManifest(ctx, client, registry, changes[i].Name, changes[i].From).GitHead
+		}
+	}
+}
+
+// npmManifest is the part of a version's manifest in the npm registry that links it to
+// its source
+type npmManifest struct {
+	Repository json.RawMessage `json:"repository"` // An object with a url, or a shorthand string
+	GitHead    string          `json:"gitHead"`    // Commit the version was published from, if npm publish recorded it
+}
+
+// npmVersionManifest fetches the manifest of a package version from the registry, or
+// returns an empty one when it can't be fetched
+func npmVersionManifest(ctx context.Context, client *http.Client, registry, pkg, version string) npmManifest {
+	var manifest npmManifest
+
+	// Scoped names keep their slash encoded in registry URLs
+	name := strings.ReplaceAll(pkg, "/", "%2f")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, registry+name+"/"+url.PathEscape(version), nil)
+	if err != nil {
+		return manifest
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return manifest
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		_ = json.NewDecoder(resp.Body).Decode(&manifest)
+	}
+	return manifest
+}
+
+// repositoryURL extracts the URL of a package.json repository field
+func repositoryURL(raw json.RawMessage) string {
+	var shorthand string
+	if json.Unmarshal(raw, &shorthand) == nil {
+		if rest, ok := strings.CutPrefix(shorthand, "github:"); ok {
+			return "https://github.com/" + rest
+		}
+		if !strings.Contains(shorthand, ":") && strings.Count(shorthand, "/") == 1 {
+			return "https://github.com/" + shorthand
+		}
+		return shorthand
+	}
+
+	var repo struct {
+		URL string `json:"url"`
+	}
+	_ = json.Unmarshal(raw, &repo)
+	return repo.URL
+}
+
+// npmRegistry returns the npm_registry mirror or else the registry npm is configured
+// to use in the checkout, with a trailing slash
+func npmRegistry(ctx context.Context, cfg *config.Config, dir string) string {
+	registry := "https://registry.npmjs.org/"
+	if cfg.NPMRegistry != "" {
+		registry = cfg.NPMRegistry
+	} else {
+		cmd := inGroup(exec.CommandContext(ctx, toolchain.Path("npm"), "config", "get", "registry"))
+		cmd.Dir = dir
+		if output, err := cmd.Output(); err == nil {
+			if r := strings.TrimSpace(string(output)); strings.HasPrefix(r, "http") {
+				registry = r
+			}
+		}
+	}
+	if !strings.HasSuffix(registry, "/") {
+		registry += "/"
+	}
+	return registry
+}
+
+// changesTable renders the changed packages as a markdown table for the PR body, with
+// columns linking the release notes and the upstream diff if links is set
+func changesTable(changes []PackageChange, links bool) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "### Updated packages\n\n%s\n\n", SummarizeChanges(changes))
+	if links {
+		b.WriteString("| Package | Version | Change | Release notes | Diff |\n")
+		b.WriteString("|---------|---------|--------|---------------|------|\n")
+	} else {
+		b.WriteString("| Package | Version | Change |\n")
+		b.WriteString("|---------|---------|--------|\n")
+	}
+
+	for i, change := range changes {
+		if i == maxChangelogRows {
+			fmt.Fprintf(&b, "\n…and %d more.\n", len(changes)-maxChangelogRows)
+			break
+		}
+
+		version := orDash(change.From) + " → " + orDash(change.To)
+		kind := change.Kind
+		if change.Kind == ChangeUpgraded && change.Bump != "" {
+			kind = change.Bump
+		}
+
+		fmt.Fprintf(&b, "| `%s` | %s | %s |", change.Name, version, kind)
+		if links {
+			if change.Kind == ChangeRemoved {
+				b.WriteString(" — |")
+			} else {
+				fmt.Fprintf(&b, " [%s](%s) |", change.To, changelogURL(change))
+			}
+			if compare := compareURL(change); compare != "" {
+				fmt.Fprintf(&b, " [compare](%s) |", compare)
+			} else {
+				b.WriteString(" — |")
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "—"
+	}
+	return s
+}
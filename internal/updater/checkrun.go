@@ -0,0 +1,116 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	gh "github.com/janyksteenbeek/updati/internal/github"
+)
+
+// maxCheckRunText is the longest summary or text GitHub accepts in a check run
+const maxCheckRunText = 65535
+
+// checkRunName is the name of updati's check run on update branches
+const checkRunName = "updati"
+
+// auditOutcome is what auditing before and after an update found
+type auditOutcome struct {
+	Fixed []SecurityAdvisory // Reported before the update, gone after it
+	Open  []SecurityAdvisory // Still reported after the update
+}
+
+// newAuditOutcome compares the advisories reported before and after an update
+func newAuditOutcome(before, after map[string]map[string]SecurityAdvisory) *auditOutcome {
+	return &auditOutcome{Fixed: fixedAdvisories(before, after), Open: openAdvisories(after)}
+}
+
+// createCheckRun reports the update in a check run on the head of the update branch,
+// with the package changes, the audit results and the package manager output. Failures
+// are logged, they don't fail the update.
+func (u *Updater) createCheckRun(ctx context.Context, log *slog.Logger, repo *gh.Repository, branch string, result *Result, audit *auditOutcome, logs string) string {
+	sha, err := u.reader.BranchHead(ctx, repo, branch)
+	if err != nil || sha == "" {
+		log.Warn("could not find the head of the update branch for the check run", "error", err)
+		return ""
+	}
+
+	title := "Dependencies updated"
+	if len(result.Changes) > 0 {
+		title = SummarizeChanges(result.Changes)
+	}
+
+	var summary strings.Builder
+	if len(result.Changes) > 0 {
+		summary.WriteString(changesTable(result.Changes, u.cfg.ChangelogLinks))
+	} else {
+		fmt.Fprintf(&summary, "Changed files: %s\n", strings.Join(result.ChangedFiles, ", "))
+	}
+	if audit != nil {
+		summary.WriteString("\n" + auditSection(audit))
+	}
+
+	var text string
+	if logs != "" {
+		// Keep the end of the output, where failures and summaries are
+		const header = "### Package manager output\n\n"
+		if limit := maxCheckRunText - len(header) - 16; len(logs) > limit {
+			logs = "…" + logs[len(logs)-limit:]
+		}
+		text = header + "````\n" + logs + "\n````\n"
+	}
+
+	url, err := u.client.CreateCheckRun(ctx, repo, gh.CheckRun{
+		Name:       checkRunName,
+		HeadSHA:    sha,
+		Conclusion: "neutral",
+		Title:      title,
+		Summary:    truncate(summary.String(), maxCheckRunText),
+		Text:       text,
+	})
+	if err != nil {
+		log.Warn("could not create check run", "error", err)
+		return ""
+	}
+
+	log.Debug("created check run", "url", url)
+	return url
+}
+
+// auditSection renders the audit results for the check run
+func auditSection(audit *auditOutcome) string {
+	var b strings.Builder
+
+	b.WriteString("### Security audit\n\n")
+	if len(audit.Fixed) > 0 {
+		fmt.Fprintf(&b, "Fixed by this update: %d\n\n", len(audit.Fixed))
+		writeAdvisories(&b, audit.Fixed)
+		b.WriteString("\n")
+	}
+	if len(audit.Open) == 0 {
+		b.WriteString("No known vulnerabilities in the updated lock files.\n")
+	} else {
+		fmt.Fprintf(&b, "Still open after this update: %d\n\n", len(audit.Open))
+		writeAdvisories(&b, audit.Open)
+	}
+
+	return b.String()
+}
+
+func sortAdvisories(advisories []SecurityAdvisory) {
+	sort.Slice(advisories, func(i, j int) bool {
+		if advisories[i].PackageName != advisories[j].PackageName {
+			return advisories[i].PackageName < advisories[j].PackageName
+		}
+		return advisories[i].AdvisoryID < advisories[j].AdvisoryID
+	})
+}
+
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return s[:limit-len("…")] + "…"
+}
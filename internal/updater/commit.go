@@ -0,0 +1,570 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/janyksteenbeek/updati/internal/audit"
+	"github.com/janyksteenbeek/updati/internal/config"
+	gh "github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+)
+
+// fastForward is the branch update strategy of direct pushes to the base branch: the
+// update has to fast-forward it, whatever branch_update_strategy says, so commits
+// pushed since the clone are never overwritten
+const fastForward = "fast-forward"
+
+// branchStrategy returns the branch update strategy for the update branch. When the
+// existing branch has commits that weren't made by updati, force-pushing or recreating
+// it would destroy them: the repository is skipped, or the update is appended instead.
+func (u *Updater) branchStrategy(ctx context.Context, log *slog.Logger, repo *gh.Repository, baseBranch, branchName string) (string, error) {
+	if branchName == baseBranch {
+		return fastForward, nil
+	}
+
+	cfg := u.cfg.ForRepo(repo.Name)
+	if cfg.BranchUpdateStrategy == config.BranchUpdateAppend {
+		return cfg.BranchUpdateStrategy, nil
+	}
+
+	commits, err := u.reader.BranchCommits(ctx, repo, baseBranch, branchName)
+	if err != nil {
+		return "", err
+	}
+
+	var foreign []gh.CommitInfo
+	for _, commit := range commits {
+		if !isOwnCommit(cfg, commit) {
+			foreign = append(foreign, commit)
+		}
+	}
+	if len(foreign) == 0 {
+		return cfg.BranchUpdateStrategy, nil
+	}
+
+	authors := make([]string, 0, len(foreign))
+	for _, commit := range foreign {
+		if !slices.Contains(authors, commit.Author) {
+			authors = append(authors, commit.Author)
+		}
+	}
+	log.Warn("update branch has commits not made by updati",
+		"branch", branchName,
+		"commits", len(foreign),
+		"authors", authors,
+	)
+
+	if cfg.ForeignCommits == config.ForeignCommitsAppend {
+		return config.BranchUpdateAppend, nil
+	}
+	return "", &SkipError{Reason: fmt.Sprintf("%s has %d commit(s) by %s", branchName, len(foreign), strings.Join(authors, ", "))}
+}
+
+// isOwnCommit reports whether a commit on the update branch was made by updati with
+// the repository's configuration, recognized by its author or its commit message
+func isOwnCommit(cfg *config.Config, commit gh.CommitInfo) bool {
+	if commit.AuthorEmail == cfg.CommitAuthorEmail {
+		return true
+	}
+
+	messages := []string{cfg.CommitMessage}
+	for _, plugin := range Plugins() {
+		if _, configured := cfg.CommitMessages[plugin.Name()]; !configured {
+			messages = append(messages, derivedCommitMessage(cfg.CommitMessage, plugin.Name()))
+		}
+	}
+	for _, message := range messages {
+		subject, _, _ := strings.Cut(message, "\n")
+		if strings.TrimSpace(commit.Subject) == strings.TrimSpace(subject) {
+			return true
+		}
+	}
+
+	// Configured messages differ in their scope, only their fixed text has to match
+	for _, message := range cfg.CommitMessages {
+		if pattern := commitSubjectPattern(message); pattern != nil && pattern.MatchString(strings.TrimSpace(commit.Subject)) {
+			return true
+		}
+	}
+	return false
+}
+
+// templateAction matches the actions of a template
+var templateAction = regexp.MustCompile(`{{.*?}}`)
+
+// commitSubjectPattern matches the subjects a commit message template renders, or is
+// nil when the subject has no fixed words to recognize them by, like
+// "{{.Plugin}}: {{.Scope}}", and would match the subjects of people's commits too
+func commitSubjectPattern(message string) *regexp.Regexp {
+	subject, _, _ := strings.Cut(message, "\n")
+	literals := templateAction.Split(strings.TrimSpace(subject), -1)
+	if !strings.ContainsFunc(strings.Join(literals, ""), unicode.IsLetter) {
+		return nil
+	}
+	for i, literal := range literals {
+		literals[i] = regexp.QuoteMeta(literal)
+	}
+	return regexp.MustCompile("^" + strings.Join(literals, ".*") + "$")
+}
+
+// commitArgs returns the git arguments committing the staged changes with the message,
+// signed off by the commit author when sign_off is set
+func (u *Updater) commitArgs(message string) []string {
+	args := []string{"commit", "-m", message}
+	if u.cfg.SignOff {
+		args = append(args, "--signoff")
+	}
+	return args
+}
+
+// signedOff returns the message with a Signed-off-by trailer for the commit author when
+// sign_off is set, for commits git doesn't create
+func (u *Updater) signedOff(message string) string {
+	if !u.cfg.SignOff {
+		return message
+	}
+	return fmt.Sprintf("%s\n\nSigned-off-by: %s <%s>", strings.TrimRight(message, "\n"), u.cfg.CommitAuthorName, u.cfg.CommitAuthorEmail)
+}
+
+// pluginChanges are the files a plugin changed, and the package changes it reported
+type pluginChanges struct {
+	Plugin   string // Empty for files plugins running concurrently changed beyond their manifests
+	Files    []string
+	Packages []PackageChange
+}
+
+// plannedCommit is a commit of an update. Files lists what goes into it; the last
+// planned commit also takes the staged commit_paths.
+type plannedCommit struct {
+	Message string
+	Files   []string
+}
+
+// commitPlan returns the commits to make for the plugins' changes: one per plugin when
+// commit_per_plugin is set and several plugins changed files, a single one otherwise.
+// A single commit of one plugin's changes takes the plugin's commit_messages entry.
+func (u *Updater) commitPlan(changes []pluginChanges) []plannedCommit {
+	if len(changes) == 1 {
+		if _, configured := u.cfg.CommitMessages[changes[0].Plugin]; configured {
+			return []plannedCommit{{Message: u.pluginCommitMessage(changes[0].Plugin, changes[0].Files), Files: changes[0].Files}}
+		}
+	}
+
+	if !u.cfg.CommitPerPlugin || len(changes) < 2 {
+		var files []string
+		for _, change := range changes {
+			files = append(files, change.Files...)
+		}
+		return []plannedCommit{{Message: u.cfg.CommitMessage, Files: files}}
+	}
+
+	commits := make([]plannedCommit, 0, len(changes))
+	for _, change := range changes {
+		message := u.cfg.CommitMessage
+		if change.Plugin != "" {
+			message = u.pluginCommitMessage(change.Plugin, change.Files)
+		}
+		commits = append(commits, plannedCommit{Message: message, Files: change.Files})
+	}
+	return commits
+}
+
+// stageChanges stages the files the plugins changed and the changes under the
+// configured commit_paths. Anything else the package managers left behind, like caches
+// or build artifacts, stays out of the commit with a warning.
+func (u *Updater) stageChanges(ctx context.Context, log *slog.Logger, dir string, commits []plannedCommit) error {
+	tracked, untracked, err := dirtyPaths(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	expected := make(map[string]bool)
+	for _, commit := range commits {
+		for _, file := range commit.Files {
+			expected[file] = true
+		}
+	}
+
+	var paths, unexpected []string
+	for _, set := range []map[string]bool{tracked, untracked} {
+		for path := range set {
+			if expected[path] || underAny(path, u.cfg.CommitPaths) {
+				paths = append(paths, path)
+			} else {
+				unexpected = append(unexpected, path)
+			}
+		}
+	}
+
+	if len(unexpected) > 0 {
+		sort.Strings(unexpected)
+		log.Warn("not committing files the update changed unexpectedly", "files", unexpected)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	return u.runGit(ctx, dir, append([]string{"add", "-A", "--"}, paths...)...)
+}
+
+// underAny reports whether the path is one of the given paths or inside one of them
+func underAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		dir = strings.TrimSuffix(filepath.ToSlash(dir), "/")
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginCommitMessage returns the commit message for a plugin's changes to files: the
+// plugin's commit_messages template, rendered with the scope of the files, or the commit
+// message named after the plugin
+func (u *Updater) pluginCommitMessage(plugin string, files []string) string {
+	message, configured := u.cfg.CommitMessages[plugin]
+	if !configured {
+		return derivedCommitMessage(u.cfg.CommitMessage, plugin)
+	}
+
+	scope := commonDir(files)
+	if scope == "" {
+		scope = plugin
+	}
+	rendered, err := config.RenderCommitMessage(message, config.CommitMessage{Plugin: plugin, Scope: scope})
+	if err != nil {
+		// Validated when the config is loaded
+		return derivedCommitMessage(u.cfg.CommitMessage, plugin)
+	}
+	return rendered
+}
+
+// commonDir returns the directory all files are in, or an empty string when some are at
+// the root
+func commonDir(files []string) string {
+	var common []string
+	for i, file := range files {
+		dir := strings.Split(path.Dir(file), "/")
+		if dir[0] == "." {
+			return ""
+		}
+		if i == 0 {
+			common = dir
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(dir) && common[n] == dir[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	return strings.Join(common, "/")
+}
+
+// derivedCommitMessage returns the commit message with the plugin named before
+// "dependencies", e.g. "chore(deps): update composer dependencies", or with the plugin
+// appended in parentheses
+func derivedCommitMessage(message, plugin string) string {
+	subject, body, hasBody := strings.Cut(message, "\n")
+	if strings.Contains(subject, "dependencies") {
+		subject = strings.Replace(subject, "dependencies", plugin+" dependencies", 1)
+	} else {
+		subject += " (" + plugin + ")"
+	}
+	if hasBody {
+		return subject + "\n" + body
+	}
+	return subject
+}
+
+// commitViaAPI commits the working tree changes through the GitHub Git Data API instead
+// of pushing a local commit, so the commit is verified. The update branch is handled
+// according to the branch update strategy; the base branch only fast-forwards.
+func (u *Updater) commitViaAPI(ctx context.Context, log *slog.Logger, repo *gh.Repository, dir, branchName, strategy string, commits []plannedCommit) error {
+	if err := u.stageChanges(ctx, log, dir, commits); err != nil {
+		return err
+	}
+
+	changes, err := stagedChanges(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil // Nothing to commit
+	}
+
+	base, err := gitOutput(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	// Authored like a pushed commit, so the Signed-off-by trailer matches the author
+	req := gh.CommitRequest{
+		Branch: branchName,
+		Base:   base,
+		Author: &gh.CommitAuthor{Name: u.cfg.CommitAuthorName, Email: u.cfg.CommitAuthorEmail},
+	}
+
+	switch strategy {
+	case config.BranchUpdateForcePush:
+		req.Force = true
+	case config.BranchUpdateRecreate:
+		if err := u.client.DeleteBranch(ctx, repo, branchName); err != nil {
+			return err
+		}
+	case config.BranchUpdateAppend:
+		head, err := u.reader.BranchHead(ctx, repo, branchName)
+		if err != nil {
+			return err
+		}
+		if head != "" {
+			req.Base = head
+		}
+	}
+
+	// Later commits build on the previous one, which only fast-forwards the branch
+	for i, commit := range commits {
+		req.Message = u.signedOff(commit.Message)
+		req.Changes = changes
+		if i < len(commits)-1 {
+			req.Changes, changes = splitChanges(changes, commit.Files)
+		}
+		if len(req.Changes) == 0 {
+			continue
+		}
+
+		sha, err := u.client.CommitChanges(ctx, repo, req)
+		if err != nil {
+			return err
+		}
+		log.Debug("created commit through the API", "sha", sha, "files", len(req.Changes))
+
+		req.Base = sha
+		req.Force = false
+	}
+
+	return nil
+}
+
+// splitChanges separates the changes to the given files from the rest
+func splitChanges(changes []gh.FileChange, files []string) ([]gh.FileChange, []gh.FileChange) {
+	var matched, rest []gh.FileChange
+	for _, change := range changes {
+		if slices.Contains(files, change.Path) {
+			matched = append(matched, change)
+		} else {
+			rest = append(rest, change)
+		}
+	}
+	return matched, rest
+}
+
+// fetchBranch fetches the remote update branch and returns its head, or an empty
+// string when the branch doesn't exist yet
+func (u *Updater) fetchBranch(ctx context.Context, log *slog.Logger, dir, branchName string) (string, error) {
+	err := u.withRetry(ctx, log, "git fetch", func() error {
+		return u.runGit(ctx, dir, "fetch", "origin", "refs/heads/"+branchName)
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "couldn't find remote ref") {
+			return "", nil // The branch is created by the push
+		}
+		return "", err
+	}
+	return gitOutput(ctx, dir, "rev-parse", "FETCH_HEAD")
+}
+
+// prepareAppend moves the checkout onto the head of the existing update branch before
+// the plugins run, so the update is committed on top of it. When the base branch moved
+// on since, the branch is brought up to date first, so the pull request doesn't show
+// stale conflicts: rebased onto the base branch when rebase is set, returning the
+// branch's previous head, which the push has to replace, or else by merging the base
+// branch, which needs no force-push. A branch that can't be updated cleanly is
+// appended to as it is.
+func (u *Updater) prepareAppend(ctx context.Context, log *slog.Logger, dir, branchName, head string, rebase bool) (string, error) {
+	base, err := gitOutput(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if err := u.runGit(ctx, dir, "reset", "--hard", head); err != nil {
+		return "", err
+	}
+	if _, err := gitOutput(ctx, dir, "merge-base", "--is-ancestor", base, head); err == nil {
+		return "", nil // Already up to date with the base branch
+	}
+
+	if !rebase {
+		if err := u.merge(ctx, dir, base); err != nil {
+			log.Warn("could not merge the base branch into the update branch, appending to it as it is", "branch", branchName, "error", err)
+		} else {
+			log.Info("merged the base branch into the update branch", "branch", branchName)
+		}
+		return "", nil
+	}
+
+	if err := u.rebase(ctx, dir, base); err != nil {
+		log.Warn("could not rebase the update branch onto the base branch, appending to it as it is", "branch", branchName, "error", err)
+		return "", nil
+	}
+	log.Info("rebased the update branch onto the base branch", "branch", branchName)
+	return head, nil
+}
+
+// merge merges the given commit into the checked out branch, restoring the branch
+// when that fails
+func (u *Updater) merge(ctx context.Context, dir, commit string) error {
+	if err := u.configureCommitter(ctx, dir); err != nil {
+		return err
+	}
+	if err := u.runGit(ctx, dir, "merge", "--no-edit", commit); err != nil {
+		_ = u.runGit(ctx, dir, "merge", "--abort")
+		return err
+	}
+	return nil
+}
+
+// rebase rebases the checked out branch onto the given commit, restoring the branch
+// when that fails
+func (u *Updater) rebase(ctx context.Context, dir, onto string) error {
+	if err := u.configureCommitter(ctx, dir); err != nil {
+		return err
+	}
+	if err := u.runGit(ctx, dir, "rebase", onto); err != nil {
+		_ = u.runGit(ctx, dir, "rebase", "--abort")
+		return err
+	}
+	return nil
+}
+
+// push pushes the local commit to the branch according to the branch update strategy,
+// retrying when the connection drops. A rebased update branch replaces its previous
+// head, unless someone pushed to it in the meantime. The base branch is never
+// force-pushed, a push that doesn't fast-forward it is rejected.
+func (u *Updater) push(ctx context.Context, log *slog.Logger, repo *gh.Repository, dir, branchName, strategy, replaced string) error {
+	args := []string{"push", "origin", branchName}
+	if replaced != "" {
+		args = []string{"push", "--force-with-lease=refs/heads/" + branchName + ":" + replaced, "origin", branchName}
+	}
+
+	switch strategy {
+	case config.BranchUpdateRecreate:
+		// Deleting the branch closes its pull request, a new one is opened afterwards
+		err := u.withRetry(ctx, log, "git push", func() error {
+			return u.pushGit(ctx, dir, "push", "origin", "--delete", branchName)
+		})
+		if err != nil && !strings.Contains(err.Error(), "remote ref does not exist") {
+			return err
+		}
+		if err == nil {
+			u.client.Audit(ctx, audit.Entry{Action: audit.BranchDelete, Repository: repo.FullName, Branch: branchName})
+		}
+	case config.BranchUpdateAppend, fastForward:
+	default:
+		args = []string{"push", "-f", "origin", branchName}
+	}
+
+	err := u.withRetry(ctx, log, "git push", func() error {
+		return u.pushGit(ctx, dir, args...)
+	})
+	if err != nil {
+		return err
+	}
+
+	head, _ := gitOutput(ctx, dir, "rev-parse", "HEAD")
+	u.client.Audit(ctx, audit.Entry{
+		Action:     audit.CommitPush,
+		Repository: repo.FullName,
+		Branch:     branchName,
+		Commit:     strings.TrimSpace(head),
+		Force:      slices.Contains(args, "-f") || replaced != "",
+	})
+	return nil
+}
+
+// stagedChanges returns the staged changes of the checkout as API file changes
+func stagedChanges(ctx context.Context, dir string) ([]gh.FileChange, error) {
+	output, err := gitOutput(ctx, dir, "diff", "--cached", "--name-status", "--no-renames", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	// -z output alternates between status and path
+	fields := strings.Split(strings.TrimRight(output, "\x00"), "\x00")
+
+	var changes []gh.FileChange
+	for i := 0; i+1 < len(fields); i += 2 {
+		status, path := fields[i], fields[i+1]
+
+		if status == "D" {
+			changes = append(changes, gh.FileChange{Path: path, Mode: gh.FileModeRegular, Deleted: true})
+			continue
+		}
+
+		change, err := readChange(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// readChange reads a changed file with its git file mode
+func readChange(dir, path string) (gh.FileChange, error) {
+	full := filepath.Join(dir, path)
+	change := gh.FileChange{Path: path, Mode: gh.FileModeRegular}
+
+	info, err := os.Lstat(full)
+	if err != nil {
+		return change, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(full)
+		if err != nil {
+			return change, fmt.Errorf("failed to read link %s: %w", path, err)
+		}
+		change.Mode = gh.FileModeSymlink
+		change.Content = []byte(target)
+		return change, nil
+	case info.Mode()&0o111 != 0:
+		change.Mode = gh.FileModeExecutable
+	}
+
+	change.Content, err = os.ReadFile(full)
+	if err != nil {
+		return change, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return change, nil
+}
+
+// gitOutput runs git in dir and returns its trimmed standard output
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := inGroup(exec.CommandContext(ctx, toolchain.Path("git"), args...))
+	cmd.Dir = dir
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %s", args[0], stderr.String())
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
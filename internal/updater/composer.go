@@ -2,12 +2,18 @@ package updater
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/janyksteenbeek/updati/internal/config"
 	gh "github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
 )
 
 // ComposerPlugin handles Composer dependency updates
@@ -23,46 +29,456 @@ func (p *ComposerPlugin) Detect(repo *gh.Repository) bool {
 	return repo.HasComposer
 }
 
+// Manifests returns the files composer reads and updates
+func (p *ComposerPlugin) Manifests() []string {
+	return []string{"composer.json", "composer.lock"}
+}
+
 // Update runs composer upgrade and returns changed files
-func (p *ComposerPlugin) Update(ctx context.Context, dir string) (bool, []string, error) {
-	lockPath := filepath.Join(dir, "composer.lock")
-	jsonPath := filepath.Join(dir, "composer.json")
-
-	// Get original hashes
-	lockHash, _ := fileHash(lockPath)
-	jsonHash, _ := fileHash(jsonPath)
-
-	// Run composer upgrade with all dependencies
-	cmd := exec.CommandContext(ctx, "composer", "upgrade",
-		"--no-interaction",
-		"--no-scripts",
-		"--prefer-dist",
-		"--with-all-dependencies",
-		"--ignore-platform-reqs",
-	)
-	cmd.Dir = dir
-	cmd.Env = append(os.Environ(),
-		"COMPOSER_NO_INTERACTION=1",
-		"COMPOSER_NO_AUDIT=1",
-	)
-
-	output, err := cmd.CombinedOutput()
+func (p *ComposerPlugin) Update(ctx context.Context, job *Job) (bool, []string, error) {
+	// Remember the state of the tree to find the files the upgrade changes
+	before, err := snapshotTree(ctx, job.Dir)
+	if err != nil {
+		return false, nil, err
+	}
+
+	// Pick a PHP binary that has the extensions the project requires
+	php, platformFlags, err := p.resolvePlatform(ctx, job)
 	if err != nil {
-		return false, nil, fmt.Errorf("composer upgrade failed: %s", string(output))
+		return false, nil, err
+	}
+
+	// Pin platform packages and use the mirror through a global config so the lock
+	// file's content hash is unaffected
+	env := composerEnv(job.Config, "COMPOSER_NO_AUDIT=1")
+	if job.MemoryMB > 0 {
+		env = append(env, fmt.Sprintf("COMPOSER_MEMORY_LIMIT=%dM", job.MemoryMB))
+	}
+	homeEnv, removeHome, err := composerHomeEnv(ctx, php, job.Config)
+	if err != nil {
+		return false, nil, err
+	}
+	defer removeHome()
+	env = append(env, homeEnv...)
+	if len(job.Config.ComposerPlatform) > 0 {
+		job.Logger.Debug("pinned composer platform", "platform", job.Config.ComposerPlatform)
+	}
+
+	// Remember the advisories affecting the current lock file, so the update can be
+	// checked for introducing new ones
+	var auditBefore map[string]SecurityAdvisory
+	if job.Config.AuditGate {
+		auditBefore, err = auditLocked(ctx, job, php, env)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+
+	// Only update the production or development dependencies if asked to
+	scope, err := composerScope(job)
+	if err != nil {
+		return false, nil, err
+	}
+	if scope != nil && len(scope) == 0 {
+		job.Logger.Debug("no dependencies in scope", "include_dev", job.Config.IncludeDev)
+		return false, nil, nil
+	}
+
+	// Remember the locked versions, so updates the rules or min_release_age don't allow
+	// can be held back
+	holdBack := job.Config.MinReleaseAge > 0 || len(job.Config.Rules) > 0
+	var locked map[string]lockedPackage
+	if holdBack {
+		locked, _ = composerLockPackages(job.Dir)
+	}
+
+	// Run composer upgrade with the configured flags. Scripts of the project are never
+	// run, they could do anything with the token in the environment.
+	args := []string{"upgrade", "--no-interaction", "--no-scripts"}
+	args = append(args, job.Config.ComposerFlags...)
+	args = append(args, job.Flags...)
+	args = append(args, platformFlags...)
+	args = append(args, scope...)
+
+	upgrade := func(with []string) error {
+		newCmd := func() *exec.Cmd {
+			cmd := composerCommand(ctx, php, slices.Concat(args, with)...)
+			cmd.Dir = job.Dir
+			cmd.Env = env
+			return cmd
+		}
+
+		job.Logger.Debug("running composer upgrade", "php", php.Path, "args", args, "with", with)
+
+		if output, err := runCommand(ctx, job, newCmd); err != nil {
+			return fmt.Errorf("composer upgrade failed: %s", string(output))
+		}
+		return nil
+	}
+
+	if err := upgrade(nil); err != nil {
+		return false, nil, err
+	}
+	if holdBack {
+		if err := holdBackComposer(ctx, job, locked, upgrade); err != nil {
+			return false, nil, err
+		}
 	}
 
 	// Check which files changed
-	var changedFiles []string
+	changedFiles, err := changedSince(ctx, job.Dir, before, p.Manifests(), !job.shared)
+	if err != nil {
+		return false, nil, err
+	}
 
-	newLockHash, _ := fileHash(lockPath)
-	if lockHash != newLockHash {
-		changedFiles = append(changedFiles, "composer.lock")
+	// Don't open a pull request with a lock file composer install would reject
+	if job.Config.ComposerValidate && len(changedFiles) > 0 {
+		if err := validateComposer(ctx, job, php, env); err != nil {
+			return false, nil, err
+		}
 	}
 
-	newJsonHash, _ := fileHash(jsonPath)
-	if jsonHash != newJsonHash {
-		changedFiles = append(changedFiles, "composer.json")
+	if job.Config.AuditGate && slices.Contains(changedFiles, "composer.lock") {
+		auditAfter, err := auditLocked(ctx, job, php, env)
+		if err != nil {
+			return false, nil, err
+		}
+		introduced, err := introducedAdvisories(job.Dir, auditBefore, auditAfter)
+		if err != nil {
+			return false, nil, err
+		}
+		if len(introduced) > 0 {
+			return false, nil, fmt.Errorf("update introduces vulnerable versions: %s", strings.Join(introduced, "; "))
+		}
 	}
 
+	job.Logger.Debug("composer upgrade finished", "changed_files", changedFiles)
+
 	return len(changedFiles) > 0, changedFiles, nil
 }
+
+// composerScope returns the packages in composer.json's require or require-dev that
+// include_dev limits the update to, or nil to update all packages. Platform packages
+// like php are left out, composer doesn't update those.
+func composerScope(job *Job) ([]string, error) {
+	var manifest composerManifest
+	if err := readJSON(filepath.Join(job.Dir, "composer.json"), &manifest); err != nil {
+		return nil, err
+	}
+
+	names, scoped := dependencyScope(job.Config.IncludeDev, manifest.Require, manifest.RequireDev)
+	if !scoped {
+		return nil, nil
+	}
+	packages := []string{}
+	for _, name := range names {
+		if strings.Contains(name, "/") {
+			packages = append(packages, name)
+		}
+	}
+	return packages, nil
+}
+
+// resolvePlatform selects the PHP binary to run composer with and the platform
+// requirement flags for the extensions it lacks
+func (p *ComposerPlugin) resolvePlatform(ctx context.Context, job *Job) (*phpBinary, []string, error) {
+	// The image provides PHP and its extensions, composer checks the requirements
+	if job.Image != "" {
+		php, err := containerPHP(job)
+		return php, nil, err
+	}
+
+	required, err := requiredExtensions(job.Dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A pinned PHP version is what composer resolves against, whichever binary runs it
+	constraint := ""
+	if _, pinned := job.Config.ComposerPlatform["php"]; !pinned {
+		if constraint, err = phpRequirement(job.Dir); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	php, missing, err := selectPHPBinary(ctx, job.Config.PHPBinaries, constraint, required)
+	if err != nil {
+		return nil, nil, err
+	}
+	job.Logger.Debug("selected PHP binary", "php", php.Path, "version", php.Version, "constraint", constraint)
+
+	// The PHP version requirement is checked against the selected binary, or the pinned
+	// platform version; composer_flags can add --ignore-platform-req=php to skip it
+	var flags []string
+
+	// Pinned extensions are taken from the platform config instead of the binary
+	missing = slices.DeleteFunc(missing, func(ext string) bool {
+		_, pinned := job.Config.ComposerPlatform["ext-"+ext]
+		return pinned
+	})
+
+	if len(missing) == 0 {
+		return php, flags, nil
+	}
+
+	if job.Config.MissingExtensions == config.MissingExtensionsSkip {
+		return nil, nil, &SkipError{Reason: "missing " + formatExtensions(missing)}
+	}
+
+	job.Logger.Warn("ignoring missing PHP extensions", "php", php.Path, "extensions", formatExtensions(missing))
+	for _, ext := range missing {
+		flags = append(flags, "--ignore-platform-req=ext-"+ext)
+	}
+
+	return php, flags, nil
+}
+
+// composerCommand runs composer with the given PHP binary, or directly when it is
+// the default binary and composer is executable. A composer installed next to the PHP
+// binary, as asdf does, is preferred over the one on PATH unless composer's path is
+// configured.
+func composerCommand(ctx context.Context, php *phpBinary, args ...string) *exec.Cmd {
+	if php.InContainer && php.Path != "php" {
+		return exec.CommandContext(ctx, php.Path, append([]string{toolsImageComposer}, args...)...)
+	}
+	if php.InContainer {
+		return exec.CommandContext(ctx, "composer", args...)
+	}
+
+	composer := toolchain.Path("composer")
+	defaultPHP, _ := toolchain.LookPath("php")
+	if php.Path != defaultPHP || toolchain.IsPhar(composer) {
+		bundled := filepath.Join(filepath.Dir(php.Path), "composer")
+		if _, err := os.Stat(bundled); err == nil && composer == "composer" {
+			return exec.CommandContext(ctx, php.Path, append([]string{bundled}, args...)...)
+		}
+		if path, err := toolchain.LookPath("composer"); err == nil {
+			return exec.CommandContext(ctx, php.Path, append([]string{path}, args...)...)
+		}
+	}
+	return exec.CommandContext(ctx, composer, args...)
+}
+
+// validateComposer runs `composer validate`, which fails on an invalid composer.json and
+// on a lock file whose content hash doesn't match composer.json. Publishing checks,
+// which don't matter for projects, are left out.
+func validateComposer(ctx context.Context, job *Job, php *phpBinary, env []string) error {
+	cmd := composerCommand(ctx, php, "validate", "--no-check-publish", "--no-interaction")
+	cmd.Dir = job.Dir
+	cmd.Env = env
+	cmd = job.isolate(ctx, cmd)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("composer.json or composer.lock is inconsistent after the update: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// composerOutdated is the output of `composer outdated --locked --format=json`
+type composerOutdated struct {
+	Locked []struct {
+		Name              string `json:"name"`
+		Version           string `json:"version"`
+		Latest            string `json:"latest"`
+		ReleaseDate       string `json:"release-date"`
+		LatestReleaseDate string `json:"latest-release-date"`
+	} `json:"locked"`
+}
+
+// Freshness measures how far the locked direct dependencies lag behind their latest releases
+func (p *ComposerPlugin) Freshness(ctx context.Context, job *Job) (*Freshness, error) {
+	data, err := os.ReadFile(filepath.Join(job.Dir, "composer.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read composer.json: %w", err)
+	}
+	var manifest composerManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse composer.json: %w", err)
+	}
+
+	direct := 0
+	for _, requires := range []map[string]string{manifest.Require, manifest.RequireDev} {
+		for name := range requires {
+			// Platform packages have no releases to lag behind
+			if strings.Contains(name, "/") {
+				direct++
+			}
+		}
+	}
+
+	php, _, err := p.resolvePlatform(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	homeEnv, removeHome, err := composerHomeEnv(ctx, php, job.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer removeHome()
+
+	cmd := composerCommand(ctx, php, "outdated", "--locked", "--direct", "--format=json", "--ignore-platform-reqs", "--no-interaction")
+	cmd.Dir = job.Dir
+	cmd.Env = composerEnv(job.Config, homeEnv...)
+	cmd = job.isolate(ctx, cmd)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("composer outdated failed: %w", err)
+	}
+
+	var outdated composerOutdated
+	if err := json.Unmarshal(output, &outdated); err != nil {
+		return nil, fmt.Errorf("failed to parse composer outdated output: %w", err)
+	}
+
+	var ages []dependencyAge
+	for _, pkg := range outdated.Locked {
+		if pkg.Latest == "" || pkg.Latest == pkg.Version {
+			continue
+		}
+		current, _ := time.Parse(time.RFC3339, pkg.ReleaseDate)
+		latest, _ := time.Parse(time.RFC3339, pkg.LatestReleaseDate)
+		ages = append(ages, dependencyAge{
+			Current:     pkg.Version,
+			Latest:      pkg.Latest,
+			CurrentDate: current,
+			LatestDate:  latest,
+		})
+	}
+
+	return summarizeFreshness(p.Name(), direct, ages), nil
+}
+
+// composerHome is a temporary Composer home directory and the environment pointing
+// composer at it
+type composerHome struct {
+	Dir string
+	Env []string
+}
+
+// composerHomeEnv returns the environment pointing composer at a configHome when
+// composer_platform or composer_mirror is set, and a function removing the home.
+// Otherwise composer keeps its own home.
+func composerHomeEnv(ctx context.Context, php *phpBinary, cfg *config.Config) ([]string, func(), error) {
+	if len(cfg.ComposerPlatform) == 0 && cfg.ComposerMirror == "" {
+		return nil, func() {}, nil
+	}
+	home, err := configHome(ctx, php, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return home.Env, func() { os.RemoveAll(home.Dir) }, nil
+}
+
+// configHome creates a Composer home whose global config pins the composer_platform
+// packages and replaces packagist.org with the composer_mirror. The config and
+// credentials of the real home are carried over and the real cache directory is kept,
+// so only those differ. The home is created in the work directory, or the system temp
+// directory if it's empty.
+func configHome(ctx context.Context, php *phpBinary, cfg *config.Config) (*composerHome, error) {
+	// A container image's home is its own, there is nothing to carry over
+	var realHome, cacheDir string
+	if !php.InContainer {
+		var err error
+		if realHome, err = composerGlobalConfig(ctx, php, "home"); err != nil {
+			return nil, err
+		}
+		if cacheDir, err = composerGlobalConfig(ctx, php, "cache-dir"); err != nil {
+			return nil, err
+		}
+	}
+
+	dir, err := os.MkdirTemp(cfg.WorkDir, tempPrefix+"composer-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composer home: %w", err)
+	}
+
+	if err := writeGlobalConfig(realHome, dir, cfg.ComposerPlatform, cfg.ComposerMirror); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	home := &composerHome{Dir: dir, Env: []string{"COMPOSER_HOME=" + dir}}
+	if realHome == "" {
+		return home, nil
+	}
+
+	if auth, err := os.ReadFile(filepath.Join(realHome, "auth.json")); err == nil {
+		if err := os.WriteFile(filepath.Join(dir, "auth.json"), auth, 0o600); err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("failed to copy composer auth: %w", err)
+		}
+	}
+	home.Env = append(home.Env, "COMPOSER_CACHE_DIR="+cacheDir)
+
+	return home, nil
+}
+
+// writeGlobalConfig writes the global config.json of the real home to dir with the
+// platform packages merged into it and the mirror, if set, in place of packagist.org
+func writeGlobalConfig(realHome, dir string, platform map[string]string, mirror string) error {
+	global := map[string]any{}
+	if data, err := os.ReadFile(filepath.Join(realHome, "config.json")); err == nil && realHome != "" {
+		if err := json.Unmarshal(data, &global); err != nil {
+			return fmt.Errorf("failed to parse global composer config: %w", err)
+		}
+	}
+
+	settings, _ := global["config"].(map[string]any)
+	if settings == nil {
+		settings = map[string]any{}
+	}
+	pinned, _ := settings["platform"].(map[string]any)
+	if pinned == nil {
+		pinned = map[string]any{}
+	}
+
+	for name, version := range platform {
+		// false hides a platform package from composer entirely
+		if version == "false" {
+			pinned[name] = false
+		} else {
+			pinned[name] = version
+		}
+	}
+	if len(pinned) > 0 {
+		settings["platform"] = pinned
+	}
+	global["config"] = settings
+
+	if mirror != "" {
+		repo := map[string]any{"type": "composer", "url": mirror}
+		switch repos := global["repositories"].(type) {
+		case []any:
+			global["repositories"] = append(repos, repo, map[string]any{"packagist.org": false})
+		case map[string]any:
+			repos["packagist.org"] = repo
+		default:
+			global["repositories"] = map[string]any{"packagist.org": repo}
+		}
+	}
+
+	data, err := json.MarshalIndent(global, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write composer config: %w", err)
+	}
+
+	return nil
+}
+
+// composerGlobalConfig reads a setting from composer's global config
+func composerGlobalConfig(ctx context.Context, php *phpBinary, key string) (string, error) {
+	cmd := inGroup(composerCommand(ctx, php, "config", "--global", key))
+	cmd.Env = append(os.Environ(), "COMPOSER_NO_INTERACTION=1")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read composer %s: %w", key, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,229 @@
+package updater
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// versionConstraint is a parsed Composer version constraint: alternatives separated by
+// ||, each a list of comparisons that all have to hold
+type versionConstraint [][]versionComparison
+
+// versionComparison compares a version against a bound
+type versionComparison struct {
+	op    string // One of =, !=, <, <=, >, >=
+	bound [3]int
+}
+
+// constraintSplit separates the comparisons of an alternative, by spaces or commas but
+// not within hyphen ranges
+var constraintSplit = regexp.MustCompile(`\s*,\s*|\s+`)
+
+// constraintOr separates the alternatives of a constraint, Composer accepts || and |
+var constraintOr = regexp.MustCompile(`\s*\|\|?\s*`)
+
+// hyphenRange matches a range like 8.1 - 8.3
+var hyphenRange = regexp.MustCompile(`^\s*(\S+)\s+-\s+(\S+)\s*$`)
+
+// parseConstraint parses a version constraint with Composer's semantics: exact versions,
+// comparison operators, wildcards (8.*), tilde (~8.1), caret (^8.1) and hyphen ranges
+// (8.1 - 8.3), combined with spaces or commas (and) and || (or). Stability flags like
+// @dev are ignored.
+func parseConstraint(s string) (versionConstraint, error) {
+	return parseRange(s, false)
+}
+
+// parseNodeRange parses a version range with npm's semantics, which match Composer's
+// except that a partial version like 20 or 20.11 stands for every version it prefixes
+func parseNodeRange(s string) (versionConstraint, error) {
+	return parseRange(s, true)
+}
+
+// operatorSpace matches the space npm allows between an operator and its version
+var operatorSpace = regexp.MustCompile(`([<>=!~^]+)\s+`)
+
+func parseRange(s string, partialPrefix bool) (versionConstraint, error) {
+	var c versionConstraint
+
+	s = operatorSpace.ReplaceAllString(strings.TrimSpace(s), "$1")
+	for _, alternative := range constraintOr.Split(s, -1) {
+		if m := hyphenRange.FindStringSubmatch(alternative); m != nil {
+			lower, _, okLower := parseVersionParts(m[1])
+			upper, parts, okUpper := parseVersionParts(m[2])
+			if !okLower || !okUpper {
+				return nil, fmt.Errorf("invalid version range %q", alternative)
+			}
+			cmps := []versionComparison{{">=", lower}}
+			if parts < 3 {
+				cmps = append(cmps, versionComparison{"<", bump(upper, parts-1)})
+			} else {
+				cmps = append(cmps, versionComparison{"<=", upper})
+			}
+			c = append(c, cmps)
+			continue
+		}
+
+		var cmps []versionComparison
+		for _, term := range constraintSplit.Split(alternative, -1) {
+			if term == "" {
+				continue
+			}
+			parsed, err := parseTerm(term, partialPrefix)
+			if err != nil {
+				return nil, err
+			}
+			cmps = append(cmps, parsed...)
+		}
+		if len(cmps) == 0 {
+			return nil, fmt.Errorf("empty version constraint %q", s)
+		}
+		c = append(c, cmps)
+	}
+
+	return c, nil
+}
+
+// parseTerm parses a single constraint term into the comparisons it stands for. With
+// partialPrefix, npm's semantics apply: a partial version without operator matches
+// every version it prefixes, and a tilde on major.minor only allows patch updates.
+func parseTerm(term string, partialPrefix bool) ([]versionComparison, error) {
+	term, _, _ = strings.Cut(term, "@")
+	if term == "*" || term == "" {
+		return []versionComparison{{">=", [3]int{}}}, nil
+	}
+
+	op := ""
+	for _, prefix := range []string{">=", "<=", "!=", "==", "<>", ">", "<", "=", "^", "~"} {
+		if rest, ok := strings.CutPrefix(term, prefix); ok {
+			op, term = prefix, strings.TrimSpace(rest)
+			break
+		}
+	}
+
+	v, parts, ok := parseVersionParts(term)
+	if !ok {
+		return nil, fmt.Errorf("invalid version constraint %q", op+term)
+	}
+	body, _, _ := strings.Cut(term, "-")
+	wildcard := strings.HasSuffix(body, "*") || strings.HasSuffix(strings.ToLower(body), "x")
+
+	switch op {
+	case "^":
+		// The first non-zero part may not change
+		i := parts - 1
+		for j := 0; j < parts; j++ {
+			if v[j] != 0 {
+				i = j
+				break
+			}
+		}
+		return []versionComparison{{">=", v}, {"<", bump(v, i)}}, nil
+	case "~":
+		i := max(parts-2, 0)
+		if partialPrefix {
+			i = min(parts-1, 1)
+		}
+		return []versionComparison{{">=", v}, {"<", bump(v, i)}}, nil
+	case "==", "":
+		if wildcard || (partialPrefix && op == "" && parts < 3) {
+			return []versionComparison{{">=", v}, {"<", bump(v, parts-1)}}, nil
+		}
+		return []versionComparison{{"=", v}}, nil
+	case "<>":
+		return []versionComparison{{"!=", v}}, nil
+	default:
+		return []versionComparison{{op, v}}, nil
+	}
+}
+
+// parseVersionParts parses a version like 8, 8.1, 8.1.2, v8.1 or 8.1.* into its major,
+// minor and patch numbers, and returns how many of them were given. Pre-release and
+// build suffixes are ignored.
+func parseVersionParts(s string) ([3]int, int, bool) {
+	var v [3]int
+
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, _, _ = strings.Cut(s, "-")
+
+	fields := strings.Split(s, ".")
+	if len(fields) > 3 && fields[3] == "0" {
+		fields = fields[:3] // Composer's four part versions
+	}
+	if len(fields) > 3 {
+		return v, 0, false
+	}
+
+	parts := 0
+	for i, field := range fields {
+		if field == "*" || strings.EqualFold(field, "x") {
+			break
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return v, 0, false
+		}
+		v[i] = n
+		parts++
+	}
+	if parts == 0 {
+		return v, 0, false
+	}
+
+	return v, parts, true
+}
+
+// bump increments part i of a version and zeroes the parts after it
+func bump(v [3]int, i int) [3]int {
+	v[i]++
+	for j := i + 1; j < 3; j++ {
+		v[j] = 0
+	}
+	return v
+}
+
+// Allows reports whether the version satisfies the constraint
+func (c versionConstraint) Allows(v [3]int) bool {
+	for _, alternative := range c {
+		ok := true
+		for _, cmp := range alternative {
+			if !cmp.allows(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c versionComparison) allows(v [3]int) bool {
+	d := compareVersions(v, c.bound)
+	switch c.op {
+	case "=":
+		return d == 0
+	case "!=":
+		return d != 0
+	case "<":
+		return d < 0
+	case "<=":
+		return d <= 0
+	case ">":
+		return d > 0
+	default:
+		return d >= 0
+	}
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+)
+
+// containers numbers the containers started by this process, for their names
+var containers atomic.Int64
+
+// isolate returns the command that runs cmd for the job: cmd itself, or, when the
+// plugin has a container image, a run of the same program in that image. The checkout
+// is mounted at its own path; its .git holds no credentials, git gets the tokens from
+// gitAuthEnv per command. The environment variables the plugin added are passed on
+// and the paths they point to are mounted as well, so temporary Composer homes and npm
+// configs keep working. Programs are looked up by name in the image. Either way the
+// command runs in a process group of its own, see inGroup, and cancelling it removes
+// the container.
+func (j *Job) isolate(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	if j.Image == "" {
+		return inGroup(cmd)
+	}
+
+	name := fmt.Sprintf("updati-%d-%d", os.Getpid(), containers.Add(1))
+	args := []string{"run", "--rm", "--name", name, "--workdir", cmd.Dir, "--volume", cmd.Dir + ":" + cmd.Dir}
+	if j.MemoryMB > 0 {
+		args = append(args, "--memory", fmt.Sprintf("%dm", j.MemoryMB))
+	}
+
+	// Files written to the checkout have to stay removable by the host user
+	if runtime.GOOS == "linux" {
+		args = append(args, "--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()), "--env", "HOME=/tmp")
+	}
+
+	host := os.Environ()
+	mounted := []string{cmd.Dir}
+	for _, kv := range cmd.Env {
+		if slices.Contains(host, kv) {
+			continue
+		}
+		// Passed by name, so credentials don't show up in the command line
+		name, value, _ := strings.Cut(kv, "=")
+		args = append(args, "--env", name)
+
+		if !filepath.IsAbs(value) || slices.Contains(mounted, value) {
+			continue
+		}
+		if _, err := os.Stat(value); err == nil {
+			args = append(args, "--volume", value+":"+value)
+			mounted = append(mounted, value)
+		}
+	}
+
+	args = append(args, j.Image, filepath.Base(cmd.Args[0]))
+	args = append(args, cmd.Args[1:]...)
+
+	cli := toolchain.Path(j.Config.ContainerRuntime)
+	isolated := inGroup(exec.CommandContext(ctx, cli, args...))
+	isolated.Dir = cmd.Dir
+	isolated.Env = cmd.Env
+
+	// Killing the runtime's client leaves the container running
+	kill := isolated.Cancel
+	isolated.Cancel = func() error {
+		_ = exec.Command(cli, "rm", "--force", name).Run()
+		return kill()
+	}
+	return isolated
+}
@@ -0,0 +1,85 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	gh "github.com/janyksteenbeek/updati/internal/github"
+)
+
+// diskCheckInterval is how often a worker waiting for disk space checks again
+const diskCheckInterval = 10 * time.Second
+
+// checkoutGrowth estimates the disk space a checkout takes, with its installed
+// dependencies, as a multiple of the size GitHub reports for the repository
+const checkoutGrowth = 3
+
+// checkouts counts the checkouts on disk in this process. Workers wait for disk space
+// only while there are checkouts that will free some.
+var checkouts atomic.Int32
+
+// waitForDisk waits until the work directory has room for a checkout of the repository:
+// min_free_disk_mb plus an estimate from its size on GitHub. While other checkouts are
+// on disk the worker waits for them to be removed, which pauses the pool when space
+// runs low; once none are left, waiting won't help and it returns an error saying so.
+func (u *Updater) waitForDisk(ctx context.Context, repo *gh.Repository, log *slog.Logger) error {
+	if u.cfg.MinFreeDiskMB <= 0 {
+		return nil
+	}
+
+	dir := u.cfg.WorkDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	need := uint64(u.cfg.MinFreeDiskMB) << 20
+	if repo.Size > 0 {
+		need += uint64(repo.Size) << 10 * checkoutGrowth
+	}
+
+	waiting := false
+	for {
+		free, err := freeDiskSpace(dir)
+		if err != nil {
+			log.Warn("could not check free disk space", "dir", dir, "error", err)
+			return nil
+		}
+		if free >= need {
+			if waiting {
+				log.Info("disk space available again, continuing", "free", formatBytes(free))
+			}
+			return nil
+		}
+		if checkouts.Load() == 0 {
+			return fmt.Errorf("not enough disk space in %s: %s free, %s needed (min_free_disk_mb plus an estimate for the repository)", dir, formatBytes(free), formatBytes(need))
+		}
+
+		if !waiting {
+			log.Warn("low disk space, waiting for other checkouts to finish", "dir", dir, "free", formatBytes(free), "needed", formatBytes(need))
+			u.phase(repo, PhaseWaitingForDisk)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(diskCheckInterval):
+		}
+	}
+}
+
+// formatBytes formats a byte count for humans, e.g. "1.5 GB"
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
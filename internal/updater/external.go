@@ -0,0 +1,268 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	gh "github.com/janyksteenbeek/updati/internal/github"
+)
+
+// externalProtocolVersion is sent with every request, so plugins can reject requests
+// of a protocol they don't speak
+const externalProtocolVersion = 1
+
+// externalDetectTimeout bounds the detect and manifests requests, which run outside of
+// an update
+const externalDetectTimeout = 30 * time.Second
+
+// ExternalPlugin is a plugin implemented by an executable. Every request starts the
+// executable with a JSON request on stdin and reads a JSON response from stdout; what
+// it writes to stderr ends up in the verbose output.
+type ExternalPlugin struct {
+	name     string
+	command  string
+	args     []string
+	settings map[string]any
+	after    []string
+
+	manifestsOnce sync.Once
+	manifests     []string
+	detected      sync.Map // Detect results by repository full name
+}
+
+// externalRequest is the request an external plugin reads from stdin
+type externalRequest struct {
+	Version    int                 `json:"version"`
+	Method     string              `json:"method"` // manifests, detect or update
+	Repository *externalRepository `json:"repository,omitempty"`
+	Dir        string              `json:"dir,omitempty"`       // Checkout to update
+	Manifests  []string            `json:"manifests,omitempty"` // Paths of the plugin's manifests in the checkout
+	Settings   map[string]any      `json:"settings,omitempty"`  // The plugin's settings from the config
+}
+
+// externalRepository describes the repository of a request
+type externalRepository struct {
+	Owner         string `json:"owner"`
+	Name          string `json:"name"`
+	FullName      string `json:"full_name"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// externalResponse is the response an external plugin writes to stdout. Which fields
+// are set depends on the method.
+type externalResponse struct {
+	Error        string          `json:"error,omitempty"`         // The request failed
+	Skip         string          `json:"skip,omitempty"`          // The repository can't be updated in this environment
+	Manifests    []string        `json:"manifests,omitempty"`     // manifests: files the plugin reads and updates
+	Detected     bool            `json:"detected,omitempty"`      // detect: the plugin handles the repository
+	Updated      bool            `json:"updated,omitempty"`       // update: files changed
+	ChangedFiles []string        `json:"changed_files,omitempty"` // update: paths relative to the checkout
+	Changes      []PackageChange `json:"changes,omitempty"`       // update: packages added, removed or changed
+}
+
+// LoadExternalPlugins registers the plugins configured in external_plugins and the
+// executables in plugin_dir, which are named after their file without extension.
+// Configured plugins take precedence over a file of the same name.
+func LoadExternalPlugins(cfg *config.Config) error {
+	for _, p := range cfg.ExternalPlugins {
+		if err := registerExternal(&ExternalPlugin{name: p.Name, command: p.Command, args: p.Args, settings: p.Settings, after: p.After}); err != nil {
+			return err
+		}
+	}
+
+	if cfg.PluginDir == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(cfg.PluginDir)
+	if err != nil {
+		return fmt.Errorf("failed to read plugin_dir: %w", err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if slices.Contains(PluginNames(), name) {
+			continue
+		}
+
+		command, err := filepath.Abs(filepath.Join(cfg.PluginDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if err := registerExternal(&ExternalPlugin{name: name, command: command}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func registerExternal(p *ExternalPlugin) error {
+	if slices.Contains(PluginNames(), p.name) {
+		return fmt.Errorf("plugin %q is already registered", p.name)
+	}
+	Register(p)
+	return nil
+}
+
+// Name returns the plugin name
+func (p *ExternalPlugin) Name() string {
+	return p.name
+}
+
+// After returns the plugins configured to run before this one
+func (p *ExternalPlugin) After() []string {
+	return p.after
+}
+
+// Manifests asks the plugin once for the files it reads and updates
+func (p *ExternalPlugin) Manifests() []string {
+	p.manifestsOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), externalDetectTimeout)
+		defer cancel()
+
+		resp, err := p.call(ctx, &externalRequest{Method: "manifests"}, "", nil)
+		if err != nil {
+			slog.Warn("external plugin failed to list its manifests", "plugin", p.name, "error", err)
+			return
+		}
+		p.manifests = resp.Manifests
+	})
+	return p.manifests
+}
+
+// Detect asks the plugin whether it handles the repository. The answer is remembered
+// for the rest of the run; a plugin that fails to answer doesn't handle it.
+func (p *ExternalPlugin) Detect(repo *gh.Repository) bool {
+	if detected, ok := p.detected.Load(repo.FullName); ok {
+		return detected.(bool)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), externalDetectTimeout)
+	defer cancel()
+
+	resp, err := p.call(ctx, &externalRequest{Method: "detect", Repository: newExternalRepository(repo)}, "", nil)
+	if err != nil {
+		slog.Warn("external plugin failed to detect repository", "plugin", p.name, "repo", repo.FullName, "error", err)
+	}
+	detected := err == nil && resp.Detected
+	p.detected.Store(repo.FullName, detected)
+	return detected
+}
+
+// Update asks the plugin to update the checkout
+func (p *ExternalPlugin) Update(ctx context.Context, job *Job) (bool, []string, error) {
+	req := &externalRequest{
+		Method:     "update",
+		Repository: newExternalRepository(job.Repo),
+		Dir:        job.Dir,
+		Manifests:  job.Manifests,
+		Settings:   p.settings,
+	}
+	if len(job.Settings) > 0 {
+		req.Settings = maps.Clone(p.settings)
+		if req.Settings == nil {
+			req.Settings = map[string]any{}
+		}
+		maps.Copy(req.Settings, job.Settings)
+	}
+
+	job.Logger.Debug("running external plugin", "command", p.command)
+
+	resp, err := p.call(ctx, req, job.Dir, job.Output)
+	if err != nil {
+		return false, nil, err
+	}
+	if resp.Skip != "" {
+		return false, nil, &SkipError{Reason: resp.Skip}
+	}
+
+	for _, file := range resp.ChangedFiles {
+		if !filepath.IsLocal(file) {
+			return false, nil, fmt.Errorf("changed file %q is outside of the repository", file)
+		}
+	}
+	job.ReportChanges(resp.Changes...)
+
+	return resp.Updated && len(resp.ChangedFiles) > 0, resp.ChangedFiles, nil
+}
+
+// call runs the executable with the request and decodes its response. stderr is
+// streamed to output when it's set and included in the error otherwise.
+func (p *ExternalPlugin) call(ctx context.Context, req *externalRequest, dir string, output io.Writer) (*externalResponse, error) {
+	req.Version = externalProtocolVersion
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s request: %w", req.Method, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := inGroup(exec.CommandContext(ctx, p.command, p.args...))
+	cmd.Dir = dir
+	cmd.Env = pluginEnv()
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if output != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, output)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", req.Method, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp externalResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("invalid %s response: %w", req.Method, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s failed: %s", req.Method, resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// pluginEnv returns updati's environment without its tokens and configuration, so
+// third-party code can't pick up credentials it wasn't given
+func pluginEnv() []string {
+	return withoutTokens(os.Environ())
+}
+
+// withoutTokens returns the environment without updati's tokens and configuration
+func withoutTokens(environ []string) []string {
+	var env []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "UPDATI_") || strings.HasPrefix(name, "INPUT_") || name == "GITHUB_TOKEN" || name == "GH_TOKEN" {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+func newExternalRepository(repo *gh.Repository) *externalRepository {
+	return &externalRepository{
+		Owner:         repo.Owner,
+		Name:          repo.Name,
+		FullName:      repo.FullName,
+		DefaultBranch: repo.DefaultRef,
+	}
+}
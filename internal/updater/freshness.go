@@ -0,0 +1,110 @@
+package updater
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	gh "github.com/janyksteenbeek/updati/internal/github"
+)
+
+// Freshness summarizes how far a repository's direct dependencies of one ecosystem lag
+// behind their latest releases
+type Freshness struct {
+	Ecosystem      string  `json:"ecosystem"`
+	Direct         int     `json:"direct"`           // Direct dependencies
+	Outdated       int     `json:"outdated"`         // Direct dependencies not on their latest release
+	MajorsBehind   int     `json:"majors_behind"`    // Major versions behind, summed over all direct dependencies
+	MeanDaysBehind float64 `json:"mean_days_behind"` // Days between the locked and latest release, averaged over all direct dependencies
+}
+
+// FreshnessReporter is implemented by plugins that can measure dependency freshness
+type FreshnessReporter interface {
+	Freshness(ctx context.Context, job *Job) (*Freshness, error)
+}
+
+// dependencyAge describes how far a single direct dependency lags behind
+type dependencyAge struct {
+	Current     string
+	Latest      string
+	CurrentDate time.Time
+	LatestDate  time.Time
+}
+
+// summarizeFreshness aggregates the outdated dependencies out of direct dependencies.
+// Up-to-date dependencies count as zero days behind.
+func summarizeFreshness(ecosystem string, direct int, outdated []dependencyAge) *Freshness {
+	f := &Freshness{
+		Ecosystem: ecosystem,
+		Direct:    direct,
+		Outdated:  len(outdated),
+	}
+
+	var days float64
+	for _, dep := range outdated {
+		current, okCurrent := majorVersion(dep.Current)
+		latest, okLatest := majorVersion(dep.Latest)
+		if okCurrent && okLatest && latest > current {
+			f.MajorsBehind += latest - current
+		}
+
+		if !dep.CurrentDate.IsZero() && dep.LatestDate.After(dep.CurrentDate) {
+			days += dep.LatestDate.Sub(dep.CurrentDate).Hours() / 24
+		}
+	}
+
+	if direct > 0 {
+		f.MeanDaysBehind = days / float64(direct)
+	}
+
+	return f
+}
+
+// majorVersion returns the major version of a version string like v1.2.3 or 2.0.0-beta
+func majorVersion(version string) (int, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	major, _, _ := strings.Cut(version, ".")
+	n, err := strconv.Atoi(major)
+	return n, err == nil
+}
+
+// measureFreshness collects the freshness of every enabled plugin that supports it.
+// Failures are logged and leave the plugin out, they never fail the update.
+func (u *Updater) measureFreshness(ctx context.Context, dir string, repo *gh.Repository, log *slog.Logger, output io.Writer) []Freshness {
+	var all []Freshness
+
+	cfg := u.cfg.ForRepo(repo.Name)
+
+	for _, plugin := range Plugins() {
+		reporter, ok := plugin.(FreshnessReporter)
+		if !ok || !u.isPluginEnabled(plugin.Name()) || !plugin.Detect(repo) {
+			continue
+		}
+
+		job := &Job{
+			Dir:    dir,
+			Config: cfg,
+			Logger: log.With("plugin", plugin.Name()),
+			Output: output,
+			Image:  cfg.PluginImage(plugin.Name()),
+		}
+		f, err := reporter.Freshness(ctx, job)
+		if err != nil {
+			job.Logger.Warn("could not measure dependency freshness", "error", err)
+			continue
+		}
+
+		job.Logger.Debug("measured dependency freshness",
+			"direct", f.Direct,
+			"outdated", f.Outdated,
+			"majors_behind", f.MajorsBehind,
+			"mean_days_behind", f.MeanDaysBehind,
+		)
+		all = append(all, *f)
+	}
+
+	return all
+}
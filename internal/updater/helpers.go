@@ -1,38 +1,164 @@
 package updater
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/retry"
 )
 
-// fileHash returns a simple hash of a file for change detection
-func fileHash(path string) (string, error) {
-	data, err := os.ReadFile(path)
+// runCommand runs the command built by newCmd and returns its combined output,
+// streaming it to the job's output as well when verbose mode is on. Failures that look
+// like network problems are retried according to the retry policy.
+func runCommand(ctx context.Context, job *Job, newCmd func() *exec.Cmd) ([]byte, error) {
+	var output []byte
+
+	err := job.Config.Retry.Do(ctx, func() error {
+		cmd := job.isolate(ctx, newCmd())
+
+		var buf bytes.Buffer
+		var w io.Writer = &buf
+		if job.Output != nil {
+			fmt.Fprintf(job.Output, "$ %s\n", cmd.String())
+			w = io.MultiWriter(&buf, job.Output)
+		}
+
+		// A single writer for both streams keeps exec from writing concurrently
+		cmd.Stdout = w
+		cmd.Stderr = w
+
+		err := cmd.Run()
+		output = buf.Bytes()
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, output)
+		}
+		return nil
+	}, retry.IsTransient, func(attempt int, wait time.Duration, err error) {
+		job.Logger.Warn("command failed, retrying", "attempt", attempt, "wait", wait.Round(time.Millisecond))
+	})
+
+	return output, err
+}
+
+// treeSnapshot maps the files of a checkout that differ from HEAD, untracked ones
+// included, to a hash of their content, empty for deleted files
+type treeSnapshot map[string]string
+
+// snapshotTree records which files of the checkout differ from HEAD and their content,
+// so changedSince can tell the files changed afterwards, including files that were
+// changed before and edits that keep a file's size
+func snapshotTree(ctx context.Context, dir string) (treeSnapshot, error) {
+	tracked, untracked, err := treeChanges(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := make(treeSnapshot, len(tracked)+len(untracked))
+	for _, set := range []map[string]bool{tracked, untracked} {
+		for path := range set {
+			snap[path] = contentHash(filepath.Join(dir, path))
+		}
+	}
+	return snap, nil
+}
+
+// changedSince returns the files of the checkout whose content changed since the
+// snapshot, sorted. Tracked files count anywhere in the tree, like a composer.json
+// bump or a regenerated yarn.lock, unless wholeTree is false; untracked files only
+// when they are one of the manifests, the rest are caches and build output.
+func changedSince(ctx context.Context, dir string, before treeSnapshot, manifests []string, wholeTree bool) ([]string, error) {
+	tracked, untracked, err := treeChanges(ctx, dir)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if len(data) == 0 {
-		return "empty", nil
+	var changed []string
+	for _, set := range []map[string]bool{tracked, untracked} {
+		for path := range set {
+			if (untracked[path] || !wholeTree) && !slices.Contains(manifests, path) {
+				continue
+			}
+			if hash, ok := before[path]; ok && hash == contentHash(filepath.Join(dir, path)) {
+				continue
+			}
+			changed = append(changed, path)
+		}
 	}
+	slices.Sort(changed)
+	return changed, nil
+}
 
-	start := data[:min(10, len(data))]
-	end := data[max(0, len(data)-10):]
+// treeChanges returns the tracked files that differ from HEAD, deleted ones included,
+// and the untracked files that aren't ignored
+func treeChanges(ctx context.Context, dir string) (map[string]bool, map[string]bool, error) {
+	tracked, err := gitPaths(ctx, dir, "diff", "--name-only", "--no-renames", "-z", "HEAD")
+	if err != nil {
+		return nil, nil, err
+	}
+	untracked, err := gitPaths(ctx, dir, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, nil, err
+	}
+	return tracked, untracked, nil
+}
 
-	return fmt.Sprintf("%d-%x-%x", len(data), start, end), nil
+// contentHash returns the SHA-256 of a file's content, or an empty string when it
+// can't be read, e.g. because it was deleted
+func contentHash(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+// dependencyScope returns the sorted names of the direct dependencies an update is
+// limited to by include_dev, and false when it covers all dependencies
+func dependencyScope(include config.IncludeDev, production, development map[string]string) ([]string, bool) {
+	var deps map[string]string
+	switch include {
+	case config.IncludeDevNone:
+		deps = production
+	case config.IncludeDevOnly:
+		deps = development
+	default:
+		return nil, false
 	}
-	return b
+	return slices.Sorted(maps.Keys(deps)), true
 }
 
-func max(a, b int) int {
-	if a > b {
-		return a
+// readJSON decodes the JSON file at path into v
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
 	}
-	return b
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+	return nil
 }
 
+// existingFiles returns the paths in dir of the named files that exist
+func existingFiles(dir string, names []string) []string {
+	var paths []string
+	for _, name := range names {
+		if fileExists(dir, name) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	return paths
+}
@@ -0,0 +1,26 @@
+package updater
+
+import "strings"
+
+// laravelPackage is the package whose locked version is an app's Laravel version
+const laravelPackage = "laravel/framework"
+
+// laravelVersion returns the laravel/framework version locked in the checkout's
+// composer.lock without a leading v, or an empty string when it doesn't lock Laravel
+func laravelVersion(dir string) string {
+	packages, err := composerLockPackages(dir)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(packages[laravelPackage].Version, "v")
+}
+
+// MajorVersion returns the major of a version, e.g. "11" for 11.9.2, or the version
+// itself when it isn't semver, like a dev branch
+func MajorVersion(version string) string {
+	if _, ok := parseSemver(version); !ok {
+		return version
+	}
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	return major
+}
@@ -0,0 +1,28 @@
+package updater
+
+import (
+	"context"
+	"sync"
+)
+
+// installSlots holds a semaphore per max_concurrent_installs value. It's shared by all
+// updaters, so the limit also holds across the runs serve mode processes at once.
+var installSlots sync.Map
+
+// acquireInstall waits until fewer than limit package manager updates run and returns
+// the function that frees the slot again. A limit of 0 doesn't wait.
+func acquireInstall(ctx context.Context, limit int) (func(), error) {
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	value, _ := installSlots.LoadOrStore(limit, make(chan struct{}, limit))
+	slots := value.(chan struct{})
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
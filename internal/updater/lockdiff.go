@@ -0,0 +1,396 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of package changes
+const (
+	ChangeAdded      = "added"
+	ChangeRemoved    = "removed"
+	ChangeUpgraded   = "upgraded"
+	ChangeDowngraded = "downgraded"
+)
+
+// Semantic version bumps of upgraded packages
+const (
+	BumpMajor = "major"
+	BumpMinor = "minor"
+	BumpPatch = "patch"
+)
+
+// PackageChange is a package that was added, removed or changed version in an update
+type PackageChange struct {
+	Ecosystem string `json:"ecosystem"` // composer or npm
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`             // One of the Change constants
+	From      string `json:"from,omitempty"`   // Empty for added packages
+	To        string `json:"to,omitempty"`     // Empty for removed packages
+	Bump      string `json:"bump,omitempty"`   // Semver bump of upgrades, empty when a version isn't semver
+	Direct    bool   `json:"direct,omitempty"` // Required by composer.json or package.json itself rather than through another package
+	Source    string `json:"-"`                // Repository URL of the package, if the lock file records it
+	SourceURL string `json:"-"`                // Browsable source of the new version, if the lock file records it
+	FromHead  string `json:"-"`                // Commit the old version was published from, if the registry records it
+	ToHead    string `json:"-"`                // Commit the new version was published from, if the registry records it
+}
+
+// lockedPackage is a package as recorded in a lock file
+type lockedPackage struct {
+	Version   string
+	Source    string
+	SourceURL string    // Browsable source of the version from the registry's support metadata
+	Time      time.Time // Release time, zero when the lock file doesn't record it
+}
+
+// lockSnapshot holds the locked packages of a checkout per ecosystem
+type lockSnapshot map[string]map[string]lockedPackage
+
+// snapshotLocks reads the packages locked in composer.lock and package-lock.json.
+// Missing or unreadable lock files contribute nothing.
+func snapshotLocks(dir string) lockSnapshot {
+	snap := lockSnapshot{}
+	if packages, err := composerLockPackages(dir); err == nil {
+		snap["composer"] = packages
+	}
+	if packages, err := npmLockPackages(dir); err == nil {
+		snap["npm"] = packages
+	}
+	return snap
+}
+
+// diffLocks returns the packages added, removed or changed between the snapshots,
+// sorted by ecosystem and name. Ecosystems without a lock file on either side are
+// left out, a lock file created from scratch isn't a diff.
+func diffLocks(before, after lockSnapshot) []PackageChange {
+	var changes []PackageChange
+	for ecosystem, packages := range after {
+		old, ok := before[ecosystem]
+		if !ok {
+			continue
+		}
+
+		for name, pkg := range packages {
+			prev, existed := old[name]
+			switch {
+			case !existed:
+				changes = append(changes, PackageChange{Ecosystem: ecosystem, Name: name, Kind: ChangeAdded, To: pkg.Version, Source: pkg.Source, SourceURL: pkg.SourceURL})
+			case prev.Version != pkg.Version:
+				change := PackageChange{Ecosystem: ecosystem, Name: name, Kind: ChangeUpgraded, From: prev.Version, To: pkg.Version, Source: pkg.Source, SourceURL: pkg.SourceURL}
+				change.Bump, change.Kind = semverBump(prev.Version, pkg.Version)
+				changes = append(changes, change)
+			}
+		}
+
+		for name, pkg := range old {
+			if _, kept := packages[name]; !kept {
+				changes = append(changes, PackageChange{Ecosystem: ecosystem, Name: name, Kind: ChangeRemoved, From: pkg.Version, Source: pkg.Source})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Ecosystem != changes[j].Ecosystem {
+			return changes[i].Ecosystem < changes[j].Ecosystem
+		}
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+// mergeReported replaces the lock file changes of every ecosystem plugins reported
+// changes for with the reported ones
+func mergeReported(changes []PackageChange, plugins []pluginChanges) []PackageChange {
+	reported := make(map[string]bool)
+	var packages []PackageChange
+	for _, plugin := range plugins {
+		for _, change := range plugin.Packages {
+			reported[change.Ecosystem] = true
+			packages = append(packages, change)
+		}
+	}
+	if len(packages) == 0 {
+		return changes
+	}
+
+	changes = slices.DeleteFunc(changes, func(change PackageChange) bool {
+		return reported[change.Ecosystem]
+	})
+	changes = append(changes, packages...)
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Ecosystem != changes[j].Ecosystem {
+			return changes[i].Ecosystem < changes[j].Ecosystem
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// LockedVersions parses a composer.lock or package-lock.json, as named by file, and
+// returns the locked version of every package without a leading v. Composer's platform
+// requirements, like php, come with their constraint.
+func LockedVersions(file string, data []byte) (map[string]string, error) {
+	var packages map[string]lockedPackage
+	var err error
+	switch file {
+	case "composer.lock":
+		packages, err = parseComposerLock(data)
+	case "package-lock.json":
+		packages, err = parseNPMLock(data)
+	default:
+		return nil, fmt.Errorf("unsupported lock file %s", file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]string, len(packages))
+	for name, pkg := range packages {
+		versions[name] = strings.TrimPrefix(pkg.Version, "v")
+	}
+
+	// An empty platform is an empty array rather than an object
+	if file == "composer.lock" {
+		var lock struct {
+			Platform json.RawMessage `json:"platform"`
+		}
+		var platform map[string]string
+		if json.Unmarshal(data, &lock) == nil && json.Unmarshal(lock.Platform, &platform) == nil {
+			for name, constraint := range platform {
+				versions[strings.ToLower(name)] = constraint
+			}
+		}
+	}
+
+	return versions, nil
+}
+
+// composerLockPackages returns the packages locked in composer.lock
+func composerLockPackages(dir string) (map[string]lockedPackage, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "composer.lock"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read composer.lock: %w", err)
+	}
+	return parseComposerLock(data)
+}
+
+// parseComposerLock returns the packages locked in the content of a composer.lock
+func parseComposerLock(data []byte) (map[string]lockedPackage, error) {
+	var lock struct {
+		Packages    []composerLockedPackage `json:"packages"`
+		PackagesDev []composerLockedPackage `json:"packages-dev"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse composer.lock: %w", err)
+	}
+
+	packages := make(map[string]lockedPackage)
+	for _, pkg := range append(lock.Packages, lock.PackagesDev...) {
+		released, _ := time.Parse(time.RFC3339, pkg.Time)
+		packages[strings.ToLower(pkg.Name)] = lockedPackage{Version: pkg.Version, Source: pkg.Source.URL, SourceURL: pkg.Support.Source, Time: released}
+	}
+	return packages, nil
+}
+
+// composerLockedPackage is a package entry in composer.lock
+type composerLockedPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Source  struct {
+		URL string `json:"url"`
+	} `json:"source"`
+	Support struct {
+		Source string `json:"source"` // Packagist metadata, e.g. the repository tree at the version's tag
+	} `json:"support"`
+	Time string `json:"time"`
+}
+
+// npmLockPackages returns the top-level packages locked in package-lock.json
+func npmLockPackages(dir string) (map[string]lockedPackage, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "package-lock.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package-lock.json: %w", err)
+	}
+	return parseNPMLock(data)
+}
+
+// parseNPMLock returns the top-level packages locked in the content of a
+// package-lock.json, from the packages map of lockfile v2 and v3 or the dependencies
+// map of v1
+func parseNPMLock(data []byte) (map[string]lockedPackage, error) {
+	var lock struct {
+		Packages map[string]struct {
+			Version string `json:"version"`
+		} `json:"packages"`
+		Dependencies map[string]struct {
+			Version string `json:"version"`
+		} `json:"dependencies"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse package-lock.json: %w", err)
+	}
+
+	packages := make(map[string]lockedPackage)
+	if len(lock.Packages) > 0 {
+		for path, pkg := range lock.Packages {
+			name, ok := strings.CutPrefix(path, "node_modules/")
+			if !ok || strings.Contains(name, "/node_modules/") {
+				continue // The root project or a nested copy
+			}
+			packages[name] = lockedPackage{Version: pkg.Version}
+		}
+		return packages, nil
+	}
+
+	for name, pkg := range lock.Dependencies {
+		packages[name] = lockedPackage{Version: pkg.Version}
+	}
+	return packages, nil
+}
+
+// semverBump classifies a version change as a major, minor or patch bump and as an
+// upgrade or downgrade. Versions that aren't semver, like dev branches, have no bump
+// and count as upgrades.
+func semverBump(from, to string) (string, string) {
+	a, aOK := parseSemver(from)
+	b, bOK := parseSemver(to)
+	if !aOK || !bOK {
+		return "", ChangeUpgraded
+	}
+
+	kind := ChangeUpgraded
+	for i := range a {
+		if a[i] != b[i] {
+			if b[i] < a[i] {
+				kind = ChangeDowngraded
+			}
+			return []string{BumpMajor, BumpMinor, BumpPatch}[i], kind
+		}
+	}
+
+	// Only the pre-release or build metadata differs
+	return BumpPatch, kind
+}
+
+// parseSemver parses the major, minor and patch numbers of a version like v1.2.3,
+// 1.2 or 2.0.0-beta.1
+func parseSemver(version string) ([3]int, bool) {
+	var parts [3]int
+
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	version, _, _ = strings.Cut(version, "+")
+	version, _, _ = strings.Cut(version, "-")
+
+	fields := strings.Split(version, ".")
+	if len(fields) > 3 && fields[3] == "0" {
+		fields = fields[:3] // Composer's four part versions
+	}
+	if len(fields) == 0 || len(fields) > 3 {
+		return parts, false
+	}
+
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+
+	return parts, true
+}
+
+// SummarizeChanges describes changes by kind and bump, e.g. "1 major, 3 minor, 2 patch,
+// 1 added"
+func SummarizeChanges(changes []PackageChange) string {
+	counts := make(map[string]int)
+	for _, change := range changes {
+		switch {
+		case change.Kind == ChangeUpgraded && change.Bump != "":
+			counts[change.Bump]++
+		case change.Kind == ChangeUpgraded:
+			counts["other"]++
+		default:
+			counts[change.Kind]++
+		}
+	}
+
+	var parts []string
+	for _, key := range []string{BumpMajor, BumpMinor, BumpPatch, "other", ChangeDowngraded, ChangeAdded, ChangeRemoved} {
+		if counts[key] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[key], key))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// ChangeStats counts the package changes of an update by kind
+type ChangeStats struct {
+	Upgraded    int    `json:"upgraded"`
+	Downgraded  int    `json:"downgraded,omitempty"`
+	Added       int    `json:"added,omitempty"`
+	Removed     int    `json:"removed,omitempty"`
+	Majors      int    `json:"majors,omitempty"`       // Upgrades to a new major version
+	LargestBump string `json:"largest_bump,omitempty"` // Largest semver bump among the upgrades: major, minor or patch
+}
+
+// CountChanges counts the changes by kind and finds the largest semver bump
+func CountChanges(changes []PackageChange) ChangeStats {
+	var stats ChangeStats
+	rank := map[string]int{BumpPatch: 1, BumpMinor: 2, BumpMajor: 3}
+	for _, change := range changes {
+		switch change.Kind {
+		case ChangeUpgraded:
+			stats.Upgraded++
+			if change.Bump == BumpMajor {
+				stats.Majors++
+			}
+			if rank[change.Bump] > rank[stats.LargestBump] {
+				stats.LargestBump = change.Bump
+			}
+		case ChangeDowngraded:
+			stats.Downgraded++
+		case ChangeAdded:
+			stats.Added++
+		case ChangeRemoved:
+			stats.Removed++
+		}
+	}
+	return stats
+}
+
+// String describes the stats for the console, e.g. "14 packages updated, 1 major, 2
+// added" or "3 packages updated, at most minor"
+func (s ChangeStats) String() string {
+	noun := "packages"
+	if s.Upgraded == 1 {
+		noun = "package"
+	}
+	parts := []string{fmt.Sprintf("%d %s updated", s.Upgraded, noun)}
+
+	switch {
+	case s.Majors > 0:
+		parts = append(parts, fmt.Sprintf("%d major", s.Majors))
+	case s.LargestBump != "":
+		parts = append(parts, "at most "+s.LargestBump)
+	}
+	for _, count := range []struct {
+		n    int
+		kind string
+	}{{s.Downgraded, ChangeDowngraded}, {s.Added, ChangeAdded}, {s.Removed, ChangeRemoved}} {
+		if count.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count.n, count.kind))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,81 @@
+package updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	gh "github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+)
+
+// mirrorLocks serializes updates of a mirror shared by several base branches of a repository
+var mirrorLocks sync.Map
+
+// updateMirror creates or fetches the bare mirror of the repository in the mirror
+// directory and returns its path. The token is only passed in git's environment, so
+// the mirror can be stored in a shared cache.
+func (u *Updater) updateMirror(ctx context.Context, log *slog.Logger, repo *gh.Repository) (string, error) {
+	path := filepath.Join(u.cfg.MirrorDir, repo.Owner, repo.Name+".git")
+
+	lock, _ := mirrorLocks.LoadOrStore(path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(filepath.Join(path, "HEAD")); err != nil {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return "", fmt.Errorf("failed to create mirror directory: %w", err)
+		}
+		err := u.withRetry(ctx, log, "git clone --mirror", func() error {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			return u.runGit(ctx, "", "clone", "--mirror", repo.CloneURL, path)
+		})
+		return path, err
+	}
+
+	err := u.withRetry(ctx, log, "git fetch", func() error {
+		return u.runGit(ctx, path, "fetch", "--prune", "origin", "+refs/heads/*:refs/heads/*")
+	})
+	return path, err
+}
+
+// runGitEnv runs git with the extra environment, without ever prompting for credentials
+func runGitEnv(ctx context.Context, dir string, env []string, args ...string) error {
+	cmd := inGroup(exec.CommandContext(ctx, toolchain.Path("git"), args...))
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s failed: %s", args[0], string(output))
+	}
+	return nil
+}
+
+// lockfileHash hashes the lockfiles in the checkout, or returns an empty string when
+// it has none
+func lockfileHash(dir string) string {
+	h := sha256.New()
+	found := false
+	for _, name := range append([]string{"composer.lock"}, lockfiles...) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		found = true
+		fmt.Fprintf(h, "%s %d\n", name, len(data))
+		h.Write(data)
+	}
+	if !found {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
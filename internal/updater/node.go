@@ -0,0 +1,179 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+)
+
+// nodeBinary is the Node runtime npm runs on: a node binary, or a version a version
+// manager provides
+type nodeBinary struct {
+	Path    string // Empty when a version manager provides Node
+	Version string
+	Manager string // One of the config.NodeManager constants, or empty
+}
+
+// nodeRequirement is the Node version a project asks for
+type nodeRequirement struct {
+	Constraint string // Version or range, e.g. 20, v20.11.0 or ^18 || >=20
+	Source     string // File it was read from
+}
+
+// nodeAlias matches the version files' aliases that only a version manager can resolve,
+// like lts/* or lts/iron
+var nodeAlias = regexp.MustCompile(`^(lts/.+|node|stable|latest|system)$`)
+
+// readNodeRequirement reads the Node version the project asks for from .tool-versions,
+// .nvmrc, .node-version or the engines field of package.json, in that order. It
+// returns nil when none of them sets one.
+func readNodeRequirement(dir string) (*nodeRequirement, error) {
+	versions := readToolVersions(dir)
+	for _, tool := range []string{"nodejs", "node"} {
+		if version, ok := versions[tool]; ok {
+			return &nodeRequirement{Constraint: version, Source: ".tool-versions"}, nil
+		}
+	}
+
+	for _, name := range []string{".nvmrc", ".node-version"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		// The version is on the first line, comments may follow
+		version, _, _ := strings.Cut(strings.TrimSpace(string(data)), "\n")
+		if version = strings.TrimSpace(version); version != "" {
+			return &nodeRequirement{Constraint: version, Source: name}, nil
+		}
+	}
+
+	var manifest struct {
+		Engines map[string]string `json:"engines"`
+	}
+	if err := readJSON(filepath.Join(dir, "package.json"), &manifest); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if constraint := strings.TrimSpace(manifest.Engines["node"]); constraint != "" {
+		return &nodeRequirement{Constraint: constraint, Source: "package.json"}, nil
+	}
+
+	return nil, nil
+}
+
+// selectNodeBinary picks the Node runtime for the project's requirement. With a version
+// manager configured, the version from a version file is handed to it. Otherwise it
+// picks the newest configured binary that satisfies the requirement, or the first of
+// the node on PATH and the versions asdf installed. .tool-versions pins the minor
+// version. A nil requirement allows any version.
+func selectNodeBinary(ctx context.Context, cfg *config.Config, req *nodeRequirement) (*nodeBinary, error) {
+	if cfg.NodeManager != "" && req != nil && req.Source != "package.json" {
+		return &nodeBinary{Version: req.Constraint, Manager: cfg.NodeManager}, nil
+	}
+
+	var allowed versionConstraint
+	if req != nil && !nodeAlias.MatchString(req.Constraint) {
+		constraint := req.Constraint
+		if req.Source == ".tool-versions" {
+			constraint = minorRange(constraint)
+		}
+
+		var err error
+		if allowed, err = parseNodeRange(constraint); err != nil {
+			return nil, fmt.Errorf("%s requires node %q: %w", req.Source, req.Constraint, err)
+		}
+	}
+
+	var candidates []nodeBinary
+	if len(cfg.NodeBinaries) > 0 {
+		// The versions they're listed by only order them, their actual versions are
+		// asked for below
+		for _, version := range newestFirst(cfg.NodeBinaries) {
+			candidates = append(candidates, nodeBinary{Path: cfg.NodeBinaries[version]})
+		}
+	} else {
+		if path, err := toolchain.LookPath("node"); err == nil {
+			candidates = append(candidates, nodeBinary{Path: path})
+		}
+		installs := toolchain.AsdfInstalls("nodejs", "node")
+		for _, version := range newestFirst(installs) {
+			candidates = append(candidates, nodeBinary{Path: installs[version], Version: version})
+		}
+	}
+
+	var found []string
+	for _, candidate := range candidates {
+		if allowed == nil {
+			return &candidate, nil
+		}
+		if candidate.Version == "" {
+			version, err := toolchain.Version(ctx, candidate.Path, "--version")
+			if err != nil {
+				continue
+			}
+			candidate.Version = version
+		}
+
+		v, _, ok := parseVersionParts(candidate.Version)
+		if !ok {
+			continue
+		}
+		found = append(found, candidate.Version)
+		if allowed.Allows(v) {
+			return &candidate, nil
+		}
+	}
+
+	if len(found) > 0 {
+		return nil, &SkipError{Reason: fmt.Sprintf("no installed Node satisfies node %s from %s (found %s)", req.Constraint, req.Source, strings.Join(found, ", "))}
+	}
+	return nil, fmt.Errorf("no usable Node binary found")
+}
+
+// nodeCommand runs a command on the given Node runtime: through the version manager, or
+// with the node binary's directory first on PATH, so npm and the scripts it starts find
+// that node. npm is taken from the same directory when it ships there. The command's
+// environment is always set, so callers can add to it.
+func nodeCommand(ctx context.Context, node *nodeBinary, name string, args ...string) *exec.Cmd {
+	var cmd *exec.Cmd
+	switch node.Manager {
+	case config.NodeManagerFnm:
+		cmd = exec.CommandContext(ctx, toolchain.Path("fnm"), append([]string{"exec", "--using=" + node.Version, "--", name}, args...)...)
+	case config.NodeManagerVolta:
+		cmd = exec.CommandContext(ctx, toolchain.Path("volta"), append([]string{"run", "--node", node.Version, name}, args...)...)
+	}
+	if cmd != nil {
+		cmd.Env = os.Environ()
+		return cmd
+	}
+
+	path := toolchain.Path(name)
+	if node.Path == "" {
+		cmd = exec.CommandContext(ctx, path, args...)
+		cmd.Env = os.Environ()
+		return cmd
+	}
+
+	dir := filepath.Dir(node.Path)
+	if bundled, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+		path = bundled
+	}
+
+	cmd = exec.CommandContext(ctx, path, args...)
+	cmd.Env = append(os.Environ(), "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return cmd
+}
@@ -1,14 +1,20 @@
 package updater
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/janyksteenbeek/updati/internal/config"
 	gh "github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
 )
 
 // NPMPlugin handles NPM dependency updates
@@ -19,45 +25,267 @@ func (p *NPMPlugin) Name() string {
 	return "npm"
 }
 
+// Exclusive reports whether the update runs a build_command, which reads and writes
+// anywhere in the checkout
+func (p *NPMPlugin) Exclusive(cfg *config.Config) bool {
+	return cfg.BuildCommand != ""
+}
+
 // Detect checks if the repository has a package.json
 func (p *NPMPlugin) Detect(repo *gh.Repository) bool {
 	return repo.HasNPM
 }
 
+// Manifests returns the files npm reads and updates
+func (p *NPMPlugin) Manifests() []string {
+	return []string{"package.json", "package-lock.json"}
+}
+
 // Update runs npm update and returns changed files
-func (p *NPMPlugin) Update(ctx context.Context, dir string) (bool, []string, error) {
-	lockPath := filepath.Join(dir, "package-lock.json")
+func (p *NPMPlugin) Update(ctx context.Context, job *Job) (bool, []string, error) {
+	// Remember the state of the tree to find the files the update changes
+	before, err := snapshotTree(ctx, job.Dir)
+	if err != nil {
+		return false, nil, err
+	}
 
-	// Get original hash
-	originalHash, err := fileHash(lockPath)
-	if err != nil && !os.IsNotExist(err) {
-		return false, nil, fmt.Errorf("failed to hash package-lock.json: %w", err)
+	node, err := p.resolveNode(ctx, job)
+	if err != nil {
+		return false, nil, err
 	}
 
-	// Run npm update
-	cmd := exec.CommandContext(ctx, "npm", "update", "--no-audit", "--no-fund")
-	cmd.Dir = dir
+	env, cleanup, err := npmEnv(job.Config)
+	if err != nil {
+		return false, nil, err
+	}
+	defer cleanup()
+	if job.MemoryMB > 0 {
+		env = append(env, "NODE_OPTIONS="+strings.TrimSpace(os.Getenv("NODE_OPTIONS")+fmt.Sprintf(" --max-old-space-size=%d", job.MemoryMB)))
+	}
 
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	// Run npm update, resolving versions as of min_release_age ago if set
+	args := []string{"update", "--no-audit", "--no-fund"}
+	args = append(args, job.Flags...)
+	if job.Config.MinReleaseAge > 0 {
+		args = append(args, "--before="+releaseCutoff(job.Config).Format(time.RFC3339))
+	}
 
-	if err := cmd.Run(); err != nil {
-		return false, nil, fmt.Errorf("npm update failed: %s", stderr.String())
+	update := func(names []string) error {
+		newCmd := func() *exec.Cmd {
+			cmd := nodeCommand(ctx, node, "npm", slices.Concat(args, names)...)
+			cmd.Dir = job.Dir
+			cmd.Env = append(cmd.Env, env...)
+			return cmd
+		}
+
+		job.Logger.Debug("running npm update", "packages", names)
+
+		if output, err := runCommand(ctx, job, newCmd); err != nil {
+			return fmt.Errorf("npm update failed: %s", string(output))
+		}
+		return nil
 	}
 
-	// Check if file changed
-	newHash, err := fileHash(lockPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil, nil
+	// Only update the production or development dependencies if asked to
+	var manifest struct {
+		Dependencies         map[string]string `json:"dependencies"`
+		OptionalDependencies map[string]string `json:"optionalDependencies"`
+		DevDependencies      map[string]string `json:"devDependencies"`
+	}
+	if err := readJSON(filepath.Join(job.Dir, "package.json"), &manifest); err != nil {
+		return false, nil, err
+	}
+	production := maps.Clone(manifest.Dependencies)
+	if production == nil {
+		production = map[string]string{}
+	}
+	maps.Copy(production, manifest.OptionalDependencies)
+	scope, scoped := dependencyScope(job.Config.IncludeDev, production, manifest.DevDependencies)
+	if scoped && len(scope) == 0 {
+		job.Logger.Debug("no dependencies in scope", "include_dev", job.Config.IncludeDev)
+		return false, nil, nil
+	}
+
+	// Remember the locked versions, so updates the rules don't allow can be held back
+	var locked map[string]lockedPackage
+	if len(job.Config.Rules) > 0 {
+		locked, _ = npmLockPackages(job.Dir)
+	}
+
+	if err := update(scope); err != nil {
+		return false, nil, err
+	}
+	if len(job.Config.Rules) > 0 {
+		if err := holdBackNPM(ctx, job, locked, update); err != nil {
+			return false, nil, err
 		}
-		return false, nil, fmt.Errorf("failed to hash package-lock.json after update: %w", err)
 	}
 
-	if originalHash != newHash {
-		return true, []string{"package-lock.json"}, nil
+	// Check which files changed
+	changedFiles, err := changedSince(ctx, job.Dir, before, p.Manifests(), !job.shared)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if len(changedFiles) > 0 {
+		job.Logger.Debug("npm update changed files", "changed_files", changedFiles)
+
+		if job.Config.BuildCommand != "" {
+			built, err := p.build(ctx, job, node, env)
+			if err != nil {
+				return false, nil, err
+			}
+			changedFiles = append(changedFiles, built...)
+		}
+		return true, changedFiles, nil
 	}
 
 	return false, nil, nil
 }
 
+// resolveNode selects the Node runtime matching the version the project asks for
+func (p *NPMPlugin) resolveNode(ctx context.Context, job *Job) (*nodeBinary, error) {
+	// The image provides Node
+	if job.Image != "" {
+		return &nodeBinary{}, nil
+	}
+
+	req, err := readNodeRequirement(job.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := selectNodeBinary(ctx, job.Config, req)
+	if err != nil {
+		return nil, err
+	}
+	if req != nil {
+		job.Logger.Debug("selected Node runtime", "node", node.Path, "version", node.Version, "manager", node.Manager, "required", req.Constraint, "source", req.Source)
+	}
+
+	return node, nil
+}
+
+// build rebuilds the frontend assets with the updated packages and stages the build
+// paths, including files ignored by git: bundlers write hashed file names, which a
+// .gitignore'd but committed build directory would otherwise leave out. It returns the
+// changed build files.
+func (p *NPMPlugin) build(ctx context.Context, job *Job, node *nodeBinary, env []string) ([]string, error) {
+	newCmd := func() *exec.Cmd {
+		cmd := nodeCommand(ctx, node, "sh", "-c", job.Config.BuildCommand)
+		cmd.Dir = job.Dir
+		// The build runs the repository's code, which doesn't get updati's tokens
+		cmd.Env = append(withoutTokens(cmd.Env), env...)
+		return cmd
+	}
+
+	job.Logger.Debug("building assets", "command", job.Config.BuildCommand)
+
+	if output, err := runCommand(ctx, job, newCmd); err != nil {
+		return nil, fmt.Errorf("build failed: %s", string(output))
+	}
+
+	// git refuses paths that match nothing
+	paths := []string{"--"}
+	for _, path := range job.Config.BuildPaths {
+		if _, err := os.Stat(filepath.Join(job.Dir, path)); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 1 {
+		return nil, nil
+	}
+
+	if _, err := gitOutput(ctx, job.Dir, append([]string{"add", "--force", "--all"}, paths...)...); err != nil {
+		return nil, err
+	}
+	staged, err := gitOutput(ctx, job.Dir, append([]string{"diff", "--cached", "--name-only"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	if staged == "" {
+		return nil, nil
+	}
+	return strings.Split(staged, "\n"), nil
+}
+
+// npmOutdated is an entry of `npm outdated --json`
+type npmOutdated struct {
+	Latest string `json:"latest"`
+}
+
+// Freshness measures how far the locked direct dependencies lag behind their latest releases
+func (p *NPMPlugin) Freshness(ctx context.Context, job *Job) (*Freshness, error) {
+	var manifest struct {
+		Dependencies    map[string]string `json:"dependencies"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if err := readJSON(filepath.Join(job.Dir, "package.json"), &manifest); err != nil {
+		return nil, err
+	}
+
+	var lock struct {
+		Packages map[string]struct {
+			Version string `json:"version"`
+		} `json:"packages"`
+	}
+	if err := readJSON(filepath.Join(job.Dir, "package-lock.json"), &lock); err != nil {
+		return nil, err
+	}
+
+	env, cleanup, err := npmEnv(job.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	env = append(os.Environ(), env...)
+
+	// npm outdated exits with 1 when anything is outdated, so only the output counts
+	cmd := exec.CommandContext(ctx, toolchain.Path("npm"), "outdated", "--json")
+	cmd.Dir = job.Dir
+	cmd.Env = env
+	cmd = job.isolate(ctx, cmd)
+	output, _ := cmd.Output()
+
+	outdated := map[string]npmOutdated{}
+	if err := json.Unmarshal(output, &outdated); err != nil {
+		return nil, fmt.Errorf("failed to parse npm outdated output: %w", err)
+	}
+
+	direct := len(manifest.Dependencies) + len(manifest.DevDependencies)
+
+	var ages []dependencyAge
+	for name, pkg := range outdated {
+		current := lock.Packages["node_modules/"+name].Version
+		if current == "" || pkg.Latest == "" || current == pkg.Latest {
+			continue
+		}
+
+		age := dependencyAge{Current: current, Latest: pkg.Latest}
+		if times, err := npmReleaseTimes(ctx, job, env, name); err == nil {
+			age.CurrentDate = times[current]
+			age.LatestDate = times[pkg.Latest]
+		}
+		ages = append(ages, age)
+	}
+
+	return summarizeFreshness(p.Name(), direct, ages), nil
+}
+
+// npmReleaseTimes returns the publish time of every version of a package
+func npmReleaseTimes(ctx context.Context, job *Job, env []string, name string) (map[string]time.Time, error) {
+	cmd := exec.CommandContext(ctx, toolchain.Path("npm"), "view", name, "time", "--json")
+	cmd.Dir = job.Dir
+	cmd.Env = env
+	cmd = job.isolate(ctx, cmd)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("npm view %s failed: %w", name, err)
+	}
+
+	times := map[string]time.Time{}
+	if err := json.Unmarshal(output, &times); err != nil {
+		return nil, fmt.Errorf("failed to parse release times of %s: %w", name, err)
+	}
+	return times, nil
+}
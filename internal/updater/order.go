@@ -0,0 +1,88 @@
+package updater
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+)
+
+// Sequenced is implemented by plugins that must run after other plugins, e.g. because
+// they read files those plugins generate
+type Sequenced interface {
+	// After returns the names of the plugins to run first. Plugins that aren't
+	// registered or don't run for a repository are ignored.
+	After() []string
+}
+
+// OrderedPlugins returns the registered plugins in the order they run: the plugins
+// listed in plugin_order first, then the rest in registration order, each moved after
+// the plugins it runs after according to plugin_after or its own declaration
+func OrderedPlugins(cfg *config.Config) ([]Plugin, error) {
+	names := PluginNames()
+
+	rank := make(map[string]int, len(registry))
+	for i, name := range names {
+		rank[name] = len(cfg.PluginOrder) + i
+	}
+	for i, name := range cfg.PluginOrder {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("unknown plugin %q in plugin_order", name)
+		}
+		rank[name] = i
+	}
+
+	for name := range cfg.PluginAfter {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("unknown plugin %q in plugin_after", name)
+		}
+	}
+	after := pluginAfter(cfg)
+
+	// Repeatedly take the highest ranked plugin whose dependencies all ran
+	remaining := slices.Clone(registry)
+	slices.SortStableFunc(remaining, func(a, b Plugin) int {
+		return rank[a.Name()] - rank[b.Name()]
+	})
+	ordered := make([]Plugin, 0, len(registry))
+	done := make(map[string]bool, len(registry))
+	for len(remaining) > 0 {
+		next := slices.IndexFunc(remaining, func(plugin Plugin) bool {
+			for _, dep := range after[plugin.Name()] {
+				if slices.Contains(names, dep) && !done[dep] {
+					return false
+				}
+			}
+			return true
+		})
+		if next < 0 {
+			var cycle []string
+			for _, plugin := range remaining {
+				cycle = append(cycle, plugin.Name())
+			}
+			return nil, fmt.Errorf("plugins can't run after each other in a cycle: %s", strings.Join(cycle, ", "))
+		}
+
+		done[remaining[next].Name()] = true
+		ordered = append(ordered, remaining[next])
+		remaining = slices.Delete(remaining, next, next+1)
+	}
+
+	return ordered, nil
+}
+
+// pluginAfter returns the plugins each plugin runs after, from plugin_after and the
+// plugins' own declarations
+func pluginAfter(cfg *config.Config) map[string][]string {
+	after := make(map[string][]string, len(registry))
+	for _, plugin := range registry {
+		if s, ok := plugin.(Sequenced); ok {
+			after[plugin.Name()] = append(after[plugin.Name()], s.After()...)
+		}
+	}
+	for name, deps := range cfg.PluginAfter {
+		after[name] = append(after[name], deps...)
+	}
+	return after
+}
@@ -0,0 +1,99 @@
+package updater
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// consoleMu serializes writes of streamed output from all workers to the console
+var consoleMu sync.Mutex
+
+// prefixWriter writes complete lines to the console, each prefixed with a label
+type prefixWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.buf.Write(b)
+	for {
+		line, err := p.buf.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write
+			p.buf.Reset()
+			p.buf.WriteString(line)
+			break
+		}
+		p.writeLine(line)
+	}
+
+	return len(b), nil
+}
+
+// Close flushes a trailing line without newline
+func (p *prefixWriter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.buf.Len() > 0 {
+		p.writeLine(p.buf.String() + "\n")
+		p.buf.Reset()
+	}
+	return nil
+}
+
+func (p *prefixWriter) writeLine(line string) {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	fmt.Fprintf(p.w, "%s %s", p.prefix, line)
+}
+
+// syncWriter serializes writes to a writer shared by plugins running concurrently, like
+// the buffer collecting the output for the check run
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(b)
+}
+
+// openOutput returns the writer plugin command output is streamed to in verbose mode:
+// a per-repository file when a log directory is configured, the console otherwise.
+// It returns nil when verbose mode is off.
+func (u *Updater) openOutput(fullName string, workerID int) (io.WriteCloser, error) {
+	if !u.cfg.Verbose {
+		return nil, nil
+	}
+
+	if u.cfg.LogDir != "" {
+		if err := os.MkdirAll(u.cfg.LogDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %w", err)
+		}
+
+		name := strings.ReplaceAll(fullName, "/", "__") + ".log"
+		f, err := os.Create(filepath.Join(u.cfg.LogDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create log file: %w", err)
+		}
+		return f, nil
+	}
+
+	return &prefixWriter{
+		w:      os.Stderr,
+		prefix: fmt.Sprintf("[worker %d] %s |", workerID, fullName),
+	}, nil
+}
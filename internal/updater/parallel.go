@@ -0,0 +1,177 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+)
+
+// Exclusive is implemented by plugins that, depending on the configuration, read or
+// write beyond their manifests and then can't run alongside other plugins
+type Exclusive interface {
+	Exclusive(cfg *config.Config) bool
+}
+
+// pluginRun is a plugin's update of a checkout
+type pluginRun struct {
+	plugin   Plugin
+	job      *Job
+	updated  bool
+	files    []string
+	err      error
+	duration time.Duration // How long the update took, zero if it didn't run
+}
+
+// exclusive reports whether the run may touch files beyond its manifests: when the
+// plugin doesn't name its manifests, or says so itself
+func (r *pluginRun) exclusive() bool {
+	if len(r.plugin.Manifests()) == 0 {
+		return true
+	}
+	e, ok := r.plugin.(Exclusive)
+	return ok && e.Exclusive(r.job.Config)
+}
+
+// conflicts reports whether run, which comes after prev, has to wait for prev: when
+// it runs after prev, either of them is exclusive or they share a manifest
+func (r *pluginRun) conflicts(prev *pluginRun, after map[string][]string) bool {
+	if slices.Contains(after[r.plugin.Name()], prev.plugin.Name()) || r.exclusive() || prev.exclusive() {
+		return true
+	}
+	for _, manifest := range r.plugin.Manifests() {
+		if slices.Contains(prev.plugin.Manifests(), manifest) {
+			return true
+		}
+	}
+	return false
+}
+
+// update runs the plugin once a max_concurrent_installs slot is free, killing it when
+// it exceeds its timeout
+func (r *pluginRun) update(ctx context.Context) {
+	release, err := acquireInstall(ctx, r.job.Config.MaxConcurrentInstalls)
+	if err != nil {
+		r.err = err
+		return
+	}
+	defer release()
+
+	parent := ctx
+	if r.job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.job.Timeout)
+		defer cancel()
+	}
+
+	started := time.Now()
+	r.updated, r.files, r.err = r.plugin.Update(ctx, r.job)
+	r.duration = time.Since(started)
+
+	if r.err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+		r.err = &TimeoutError{Plugin: r.plugin.Name(), Timeout: r.job.Timeout, Err: r.err}
+	}
+}
+
+// updateSequentially runs the plugins one after another, stopping at the first failure
+func updateSequentially(ctx context.Context, runs []*pluginRun) error {
+	for _, run := range runs {
+		run.update(ctx)
+		if run.err != nil {
+			return fmt.Errorf("%s: %w", run.plugin.Name(), run.err)
+		}
+	}
+	return nil
+}
+
+// updateConcurrently runs up to limit plugins at a time. Each plugin waits for the
+// plugins before it that it conflicts with, so plugins touching disjoint files overlap
+// while the rest keep their order. The first failure cancels the plugins still running.
+func updateConcurrently(ctx context.Context, runs []*pluginRun, limit int, after map[string][]string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Changes outside the manifests can't be told apart while other plugins run, they
+	// are left for unclaimedChanges
+	for _, run := range runs {
+		run.job.shared = !run.exclusive()
+	}
+
+	done := make([]chan struct{}, len(runs))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	slots := make(chan struct{}, limit)
+
+	var failOnce sync.Once
+	var failure error
+	var wg sync.WaitGroup
+	for i, run := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[i])
+
+			for j := range i {
+				if !run.conflicts(runs[j], after) {
+					continue
+				}
+				select {
+				case <-done[j]:
+				case <-ctx.Done():
+					run.err = ctx.Err()
+					return
+				}
+			}
+
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				run.err = ctx.Err()
+				return
+			}
+			if ctx.Err() != nil {
+				run.err = ctx.Err()
+				return
+			}
+
+			run.update(ctx)
+			if run.err != nil {
+				failOnce.Do(func() {
+					failure = fmt.Errorf("%s: %w", run.plugin.Name(), run.err)
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failure != nil {
+		return failure
+	}
+	for _, run := range runs {
+		if run.err != nil {
+			return fmt.Errorf("%s: %w", run.plugin.Name(), run.err)
+		}
+	}
+	return nil
+}
+
+// unclaimedChanges returns the files changed since the snapshot taken before the
+// plugins ran concurrently that none of them reported, like a yarn.lock regenerated by
+// a composer script
+func unclaimedChanges(ctx context.Context, dir string, before treeSnapshot, runs []*pluginRun) ([]string, error) {
+	changed, err := changedSince(ctx, dir, before, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(changed, func(path string) bool {
+		return slices.ContainsFunc(runs, func(run *pluginRun) bool {
+			return slices.Contains(run.files, path)
+		})
+	}), nil
+}
@@ -0,0 +1,263 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
+)
+
+// phpBinary is a PHP interpreter together with its version and the extensions it has
+// loaded
+type phpBinary struct {
+	Path        string
+	Version     string
+	Extensions  map[string]bool
+	InContainer bool // Provided by the plugin's container image
+}
+
+// composerManifest holds the parts of composer.json and composer.lock updati inspects
+type composerManifest struct {
+	Require    map[string]string `json:"require"`
+	RequireDev map[string]string `json:"require-dev"`
+	Packages   []struct {
+		Require map[string]string `json:"require"`
+	} `json:"packages"`
+	PackagesDev []struct {
+		Require map[string]string `json:"require"`
+	} `json:"packages-dev"`
+}
+
+// requiredExtensions collects the ext-* requirements from composer.json and the
+// packages locked in composer.lock, without the "ext-" prefix
+func requiredExtensions(dir string) ([]string, error) {
+	seen := make(map[string]bool)
+
+	for _, name := range []string{"composer.json", "composer.lock"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		var m composerManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+
+		requires := []map[string]string{m.Require, m.RequireDev}
+		for _, pkg := range m.Packages {
+			requires = append(requires, pkg.Require)
+		}
+		for _, pkg := range m.PackagesDev {
+			requires = append(requires, pkg.Require)
+		}
+
+		for _, req := range requires {
+			for pkg := range req {
+				if ext, ok := strings.CutPrefix(strings.ToLower(pkg), "ext-"); ok {
+					seen[ext] = true
+				}
+			}
+		}
+	}
+
+	exts := make([]string, 0, len(seen))
+	for ext := range seen {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	return exts, nil
+}
+
+// loadedExtensions returns the extensions reported by `php -m`
+func loadedExtensions(ctx context.Context, php string) (map[string]bool, error) {
+	output, err := inGroup(exec.CommandContext(ctx, php, "-m")).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s -m failed: %w", php, err)
+	}
+
+	exts := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" || strings.HasPrefix(line, "[") {
+			continue
+		}
+		exts[line] = true
+		// "Zend OPcache" is required as ext-zend-opcache
+		exts[strings.ReplaceAll(line, " ", "-")] = true
+	}
+
+	return exts, nil
+}
+
+// missing returns the required extensions the binary does not have
+func (b *phpBinary) missing(required []string) []string {
+	var missing []string
+	for _, ext := range required {
+		if !b.Extensions[ext] {
+			missing = append(missing, ext)
+		}
+	}
+	return missing
+}
+
+// phpRequirement returns the constraint on the PHP version the project runs on: the
+// minor version of php in .tool-versions, or else the php constraint composer.json
+// requires. It returns an empty string when neither sets one.
+func phpRequirement(dir string) (string, error) {
+	if version, ok := readToolVersions(dir)["php"]; ok {
+		return minorRange(version), nil
+	}
+
+	var m composerManifest
+	if err := readJSON(filepath.Join(dir, "composer.json"), &m); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(m.Require["php"]), nil
+}
+
+// phpCandidates returns the PHP binaries to choose from: the configured ones newest
+// first, or the ones found on PATH with the default binary first, followed by the
+// versions asdf installed. The versions the configured ones are listed by only order
+// them, their actual versions are asked for when selecting.
+func phpCandidates(configured map[string]string) []phpBinary {
+	var candidates []phpBinary
+	if len(configured) > 0 {
+		for _, version := range newestFirst(configured) {
+			candidates = append(candidates, phpBinary{Path: configured[version]})
+		}
+		return candidates
+	}
+
+	for _, path := range toolchain.PHPBinaries() {
+		candidates = append(candidates, phpBinary{Path: path})
+	}
+	installs := toolchain.AsdfInstalls("php", "php")
+	for _, version := range newestFirst(installs) {
+		candidates = append(candidates, phpBinary{Path: installs[version], Version: version})
+	}
+	return candidates
+}
+
+// toolsImagePHP lists the PHP versions config.ToolsImage ships, newest first, each as
+// php<version>
+var toolsImagePHP = []string{"8.4", "8.3", "8.2"}
+
+// toolsImageComposer is where config.ToolsImage has composer, run with the selected PHP
+const toolsImageComposer = "/usr/local/bin/composer"
+
+// containerPHP returns the PHP binary of the job's container image: its default php,
+// or in config.ToolsImage the newest version composer.json allows
+func containerPHP(job *Job) (*phpBinary, error) {
+	if job.Image != config.ToolsImage {
+		return &phpBinary{Path: "php", InContainer: true}, nil
+	}
+
+	constraint := ""
+	if _, pinned := job.Config.ComposerPlatform["php"]; !pinned {
+		var err error
+		if constraint, err = phpRequirement(job.Dir); err != nil {
+			return nil, err
+		}
+	}
+	if constraint == "" {
+		return &phpBinary{Path: "php" + toolsImagePHP[0], Version: toolsImagePHP[0], InContainer: true}, nil
+	}
+
+	allowed, err := parseConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("composer.json requires php %q: %w", constraint, err)
+	}
+	for _, version := range toolsImagePHP {
+		if v, _, ok := parseVersionParts(version); ok && allowed.Allows(v) {
+			return &phpBinary{Path: "php" + version, Version: version, InContainer: true}, nil
+		}
+	}
+	return nil, &SkipError{Reason: fmt.Sprintf("%s has no PHP satisfying php %s (has %s)", config.ToolsImage, constraint, strings.Join(toolsImagePHP, ", "))}
+}
+
+// selectPHPBinary picks the PHP binary to run composer with. Binaries whose version
+// doesn't satisfy the constraint are passed over, an empty constraint allows any. Of
+// the rest it picks the one that satisfies most of the required extensions, preferring
+// earlier candidates on ties, and returns the extensions it lacks.
+func selectPHPBinary(ctx context.Context, configured map[string]string, constraint string, required []string) (*phpBinary, []string, error) {
+	var allowed versionConstraint
+	if constraint != "" {
+		var err error
+		if allowed, err = parseConstraint(constraint); err != nil {
+			return nil, nil, fmt.Errorf("composer.json requires php %q: %w", constraint, err)
+		}
+	}
+
+	var best *phpBinary
+	var bestMissing, found []string
+
+	for _, candidate := range phpCandidates(configured) {
+		if candidate.Version == "" {
+			version, err := toolchain.Version(ctx, candidate.Path, "-r", "echo PHP_VERSION;")
+			if err != nil {
+				continue
+			}
+			candidate.Version = version
+		}
+
+		if allowed != nil {
+			v, _, ok := parseVersionParts(candidate.Version)
+			if !ok {
+				continue
+			}
+			found = append(found, candidate.Version)
+			if !allowed.Allows(v) {
+				continue
+			}
+		}
+
+		exts, err := loadedExtensions(ctx, candidate.Path)
+		if err != nil {
+			continue
+		}
+
+		bin := &phpBinary{Path: candidate.Path, Version: candidate.Version, Extensions: exts}
+		missing := bin.missing(required)
+		if best == nil || len(missing) < len(bestMissing) {
+			best, bestMissing = bin, missing
+		}
+		if len(missing) == 0 {
+			break
+		}
+	}
+
+	if best == nil && allowed != nil && len(found) > 0 {
+		return nil, nil, &SkipError{Reason: fmt.Sprintf("no installed PHP satisfies php %s (found %s)", constraint, strings.Join(found, ", "))}
+	}
+	if best == nil {
+		return nil, nil, fmt.Errorf("no usable PHP binary found")
+	}
+
+	return best, bestMissing, nil
+}
+
+// formatExtensions renders extension names as composer platform packages
+func formatExtensions(exts []string) string {
+	names := make([]string, len(exts))
+	for i, ext := range exts {
+		names[i] = "ext-" + ext
+	}
+	return strings.Join(names, ", ")
+}
@@ -2,10 +2,76 @@ package updater
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
 
+	"github.com/janyksteenbeek/updati/internal/config"
 	gh "github.com/janyksteenbeek/updati/internal/github"
 )
 
+// Job holds the state a plugin needs to update a single checkout
+type Job struct {
+	Dir       string         // Path to the cloned repository
+	Repo      *gh.Repository // Repository the checkout belongs to
+	Manifests []string       // Paths of the plugin's Manifests that exist in the checkout
+	Config    *config.Config // Effective configuration, with the repository's overrides applied
+	Logger    *slog.Logger   // Logger scoped to the repository and worker
+	Output    io.Writer      // Receives package manager output in verbose mode, nil otherwise
+	Image     string         // Container image to run the package manager in, empty to run it on the host
+	Flags     []string       // Extra flags for the package manager's update command, from plugins
+	Settings  map[string]any // Plugin settings from plugins
+	Timeout   time.Duration  // How long the update may take, 0 for no limit
+	MemoryMB  int            // Megabytes of memory the package manager may use, 0 for no limit
+
+	plugin  string
+	changes []PackageChange // Reported by the plugin, nil if it reported nothing
+	shared  bool            // Runs alongside other plugins, so only its manifests are its own changes
+}
+
+// ReportChanges reports the packages the update added, removed or changed. Plugins
+// whose lock files updati can't read report their changes this way; for the ecosystems
+// a plugin reports, the reported changes replace what updati reads from the lock
+// files. Changes without an ecosystem get the plugin's name.
+func (j *Job) ReportChanges(changes ...PackageChange) {
+	for _, change := range changes {
+		if change.Ecosystem == "" {
+			change.Ecosystem = j.plugin
+		}
+		if change.Kind == ChangeUpgraded && change.Bump == "" {
+			change.Bump, change.Kind = semverBump(change.From, change.To)
+		}
+		j.changes = append(j.changes, change)
+	}
+}
+
+// SkipError is returned by a plugin when the repository cannot be updated in this
+// environment and should be skipped rather than reported as failed
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return e.Reason
+}
+
+// TimeoutError is returned for a plugin whose update took longer than its timeout and
+// was killed
+type TimeoutError struct {
+	Plugin  string
+	Timeout time.Duration
+	Err     error // What the update returned once killed
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s: %v", e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
 // Plugin defines the interface for dependency updaters
 type Plugin interface {
 	// Name returns the plugin name (e.g., "composer", "npm")
@@ -14,8 +80,12 @@ type Plugin interface {
 	// Detect checks if the repository uses this dependency manager
 	Detect(repo *gh.Repository) bool
 
+	// Manifests returns the manifest and lock files the plugin reads and updates,
+	// relative to the repository root
+	Manifests() []string
+
 	// Update runs the update command and returns true if files changed
-	Update(ctx context.Context, dir string) (updated bool, changedFiles []string, err error)
+	Update(ctx context.Context, job *Job) (updated bool, changedFiles []string, err error)
 }
 
 // registry holds all registered plugins
@@ -36,4 +106,3 @@ func init() {
 	Register(&ComposerPlugin{})
 	Register(&NPMPlugin{})
 }
-
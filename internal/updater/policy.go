@@ -0,0 +1,236 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+)
+
+// maxHoldBackRounds caps how often a package manager resolves again to hold packages
+// back. Holding a package back can pull in other unwanted versions through its
+// dependencies, each round handles those.
+const maxHoldBackRounds = 3
+
+// heldBack is a package change that has to be undone
+type heldBack struct {
+	Change PackageChange
+	Reason string
+	Hold   string // Composer constraint keeping the package within bounds, empty to keep the locked version
+}
+
+// checkRules returns the changes that break a configured rule. All rules matching a
+// change have to allow it. Removed packages are never held back.
+func checkRules(rules []config.Rule, changes []PackageChange) ([]heldBack, error) {
+	var held []heldBack
+	for _, change := range changes {
+		if change.Kind == ChangeRemoved {
+			continue
+		}
+
+		for i := range rules {
+			rule := &rules[i]
+			if !rule.Matches(change.Ecosystem, change.Name, change.To) {
+				continue
+			}
+
+			switch {
+			case rule.Block && len(rule.Versions) > 0:
+				held = append(held, heldBack{Change: change, Reason: "version blocked by a rule", Hold: "!=" + change.To})
+			case rule.Block:
+				held = append(held, heldBack{Change: change, Reason: "blocked by a rule"})
+			case rule.Allow != "":
+				if change.Kind != ChangeUpgraded || !exceedsBump(change.Bump, rule.Allow) {
+					continue
+				}
+				from, _ := parseSemver(change.From)
+				upper := bump(from, 0)
+				if rule.Allow == config.AllowPatch {
+					upper = bump(from, 1)
+				}
+				held = append(held, heldBack{
+					Change: change,
+					Reason: "only " + rule.Allow + " updates allowed",
+					Hold:   fmt.Sprintf(">=%d.%d.%d <%d.%d.%d", from[0], from[1], from[2], upper[0], upper[1], upper[2]),
+				})
+			case rule.Pin != "":
+				parse := parseConstraint
+				if change.Ecosystem == "npm" {
+					parse = parseNodeRange
+				}
+				constraint, err := parse(rule.Pin)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pin %q: %w", rule.Pin, err)
+				}
+				if to, ok := parseSemver(change.To); !ok || constraint.Allows(to) {
+					continue
+				}
+				held = append(held, heldBack{Change: change, Reason: "pinned to " + rule.Pin, Hold: rule.Pin})
+			}
+		}
+	}
+	return held, nil
+}
+
+// exceedsBump reports whether a semver bump is larger than the allowed level
+func exceedsBump(bump, allow string) bool {
+	switch bump {
+	case BumpMajor:
+		return true
+	case BumpMinor:
+		return allow == config.AllowPatch
+	}
+	return false
+}
+
+// holdBackComposer upgrades again with `--with` constraints for the packages the
+// upgrade moved somewhere the rules or min_release_age don't allow. Packages younger
+// than min_release_age are held back to their newest release published before the
+// cutoff, or to the version locked before, as are packages the rules block. Releases
+// the lock file records no time for are adopted.
+func holdBackComposer(ctx context.Context, job *Job, before map[string]lockedPackage, upgrade func(with []string) error) error {
+	constraints := map[string][]string{}
+
+	// A lock file created from scratch has nothing to hold back to
+	if before == nil {
+		return nil
+	}
+
+	for round := 0; ; round++ {
+		after, err := composerLockPackages(job.Dir)
+		if err != nil {
+			return nil // Nothing locked, nothing to hold back
+		}
+
+		changes := diffLocks(lockSnapshot{"composer": before}, lockSnapshot{"composer": after})
+		held, err := checkRules(job.Config.Rules, changes)
+		if err != nil {
+			return err
+		}
+		if job.Config.MinReleaseAge > 0 {
+			held = append(held, recentReleases(ctx, job, changes, after)...)
+		}
+		if len(held) == 0 {
+			return nil
+		}
+		if round == maxHoldBackRounds {
+			return fmt.Errorf("could not hold back %s", describeHeld(held))
+		}
+
+		for _, h := range held {
+			hold := h.Hold
+			if hold == "" {
+				hold = h.Change.From
+			}
+			if hold == "" {
+				return fmt.Errorf("%s %s: %s, and it wasn't locked before", h.Change.Name, h.Change.To, h.Reason)
+			}
+
+			job.Logger.Info("holding back update", "package", h.Change.Name, "version", h.Change.To, "reason", h.Reason, "constraint", hold)
+			if !slices.Contains(constraints[h.Change.Name], hold) {
+				constraints[h.Change.Name] = append(constraints[h.Change.Name], hold)
+			}
+		}
+
+		var with []string
+		for name, holds := range constraints {
+			with = append(with, "--with="+name+":"+strings.Join(holds, " "))
+		}
+		sort.Strings(with)
+
+		if err := upgrade(with); err != nil {
+			return err
+		}
+	}
+}
+
+// recentReleases returns the composer changes to releases younger than min_release_age,
+// held back to the newest release published before the cutoff when Packagist knows one
+func recentReleases(ctx context.Context, job *Job, changes []PackageChange, locked map[string]lockedPackage) []heldBack {
+	cutoff := releaseCutoff(job.Config)
+
+	var held []heldBack
+	for _, change := range changes {
+		released := locked[change.Name].Time
+		if change.Kind == ChangeRemoved || released.IsZero() || !released.After(cutoff) {
+			continue
+		}
+
+		h := heldBack{Change: change, Reason: "released " + released.Format(time.DateOnly) + ", within min_release_age"}
+		if releases, err := packagistReleases(ctx, change.Name); err == nil {
+			h.Hold = newestReleaseBefore(releases, change.From, change.To, cutoff)
+		} else {
+			job.Logger.Debug("could not look up releases", "package", change.Name, "error", err)
+		}
+		held = append(held, h)
+	}
+	return held
+}
+
+// holdBackNPM updates again for the packages the rules allow to change, after restoring
+// the lock file, until no update breaks a rule. npm has no way to constrain a package
+// for a single update, so a held back package keeps its locked version.
+func holdBackNPM(ctx context.Context, job *Job, before map[string]lockedPackage, update func(names []string) error) error {
+	held := map[string]bool{}
+
+	// A lock file created from scratch has nothing to hold back to
+	if before == nil {
+		return nil
+	}
+
+	for round := 0; ; round++ {
+		after, err := npmLockPackages(job.Dir)
+		if err != nil {
+			return nil // Nothing locked, nothing to hold back
+		}
+
+		changes := diffLocks(lockSnapshot{"npm": before}, lockSnapshot{"npm": after})
+		violations, err := checkRules(job.Config.Rules, changes)
+		if err != nil {
+			return err
+		}
+		if len(violations) == 0 {
+			return nil
+		}
+		if round == maxHoldBackRounds {
+			return fmt.Errorf("could not hold back %s", describeHeld(violations))
+		}
+
+		for _, h := range violations {
+			job.Logger.Info("holding back update", "package", h.Change.Name, "version", h.Change.To, "reason", h.Reason)
+			held[h.Change.Name] = true
+		}
+
+		// Only packages that were locked before can be named in an update
+		allowed := map[string]bool{}
+		for _, change := range changes {
+			if change.From != "" && change.To != "" && !held[change.Name] {
+				allowed[change.Name] = true
+			}
+		}
+
+		if _, err := gitOutput(ctx, job.Dir, "checkout", "--", "package-lock.json"); err != nil {
+			return err
+		}
+		if len(allowed) == 0 {
+			return nil
+		}
+		if err := update(slices.Sorted(maps.Keys(allowed))); err != nil {
+			return err
+		}
+	}
+}
+
+// describeHeld lists held back changes for an error message
+func describeHeld(held []heldBack) string {
+	parts := make([]string, 0, len(held))
+	for _, h := range held {
+		parts = append(parts, fmt.Sprintf("%s %s (%s)", h.Change.Name, h.Change.To, h.Reason))
+	}
+	return strings.Join(parts, ", ")
+}
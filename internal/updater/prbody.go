@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// maxIncludeDepth stops include cycles in PR body templates
+const maxIncludeDepth = 10
+
+// PRBodyData is what pr_body and pr_body_file are rendered with
+type PRBodyData struct {
+	Repository      string // Full name, e.g. acme/shop
+	Base            string // Branch the pull request is opened against
+	Branch          string // Update branch
+	Date            string // Day of the run in UTC, e.g. 2026-01-31
+	Summary         string // Summary of Changes, e.g. 1 major, 3 minor
+	Changes         []PackageChange
+	FixedAdvisories []SecurityAdvisory
+}
+
+// renderPRBody renders pr_body_file when set, pr_body otherwise. The file is read from
+// the checkout in dir, or from the machine updati runs on when the checkout doesn't
+// have it. The files it includes with {{ include "path" }} are read from the checkout
+// as well; only a template from the machine may include files from the machine, so a
+// repository can't put host files like /proc/self/environ into its pull request.
+func (u *Updater) renderPRBody(dir string, result *Result) (string, error) {
+	cfg := u.cfg.ForRepo(result.Repository.Name)
+	data := PRBodyData{
+		Repository:      result.Repository.FullName,
+		Base:            result.BaseBranch,
+		Branch:          result.Branch,
+		Date:            time.Now().UTC().Format(time.DateOnly),
+		Summary:         SummarizeChanges(result.Changes),
+		Changes:         result.Changes,
+		FixedAdvisories: result.FixedAdvisories,
+	}
+
+	checkout, err := os.OpenRoot(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to render PR body: %w", err)
+	}
+	defer checkout.Close()
+
+	name, text, host := "pr_body", cfg.PRBody, true
+	if cfg.PRBodyFile != "" {
+		name = cfg.PRBodyFile
+		if text, host, err = readBodyTemplate(checkout, name, true); err != nil {
+			return "", fmt.Errorf("failed to render PR body: %w", err)
+		}
+	}
+
+	body, err := renderBodyTemplate(checkout, name, text, host, data, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to render PR body: %w", err)
+	}
+	return body, nil
+}
+
+// renderBodyTemplate renders a PR body template, with includes rendered from the same
+// data. host tells whether the template came from the machine updati runs on.
+func renderBodyTemplate(checkout *os.Root, name, text string, host bool, data PRBodyData, depth int) (string, error) {
+	funcs := template.FuncMap{
+		"include": func(path string) (string, error) {
+			if depth >= maxIncludeDepth {
+				return "", fmt.Errorf("includes nested deeper than %d", maxIncludeDepth)
+			}
+			included, fromHost, err := readBodyTemplate(checkout, path, host)
+			if err != nil {
+				return "", err
+			}
+			return renderBodyTemplate(checkout, path, included, fromHost, data, depth+1)
+		},
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// readBodyTemplate reads a PR body template from the checkout, which can't be left
+// through .. or symlinks, or, when allowHost is set, from the machine updati runs on
+// when the checkout doesn't have it. It reports whether the template came from the
+// machine.
+func readBodyTemplate(checkout *os.Root, path string, allowHost bool) (string, bool, error) {
+	if filepath.IsLocal(path) {
+		data, err := checkout.ReadFile(path)
+		if err == nil {
+			return string(data), false, nil
+		}
+		if !allowHost {
+			return "", false, err
+		}
+	} else if !allowHost {
+		return "", false, fmt.Errorf("%s is outside the repository", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
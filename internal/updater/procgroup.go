@@ -0,0 +1,7 @@
+package updater
+
+import "time"
+
+// groupWaitDelay is how long a killed command's output is still read, for processes
+// that left the group and hold on to it
+const groupWaitDelay = 5 * time.Second
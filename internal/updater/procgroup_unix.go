@@ -0,0 +1,21 @@
+//go:build unix
+
+package updater
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// inGroup starts cmd in a process group of its own and makes cancelling its context,
+// by a timeout or an interrupted run, kill the whole group. Otherwise only cmd itself
+// is killed and the node and php processes composer, npm, git and test commands spawn
+// keep running as orphans.
+func inGroup(cmd *exec.Cmd) *exec.Cmd {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = groupWaitDelay
+	return cmd
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package updater
+
+import "os/exec"
+
+// inGroup keeps the default cancellation on Windows, which kills cmd itself, and stops
+// waiting for its output once the processes it spawned held on to it for too long
+func inGroup(cmd *exec.Cmd) *exec.Cmd {
+	cmd.WaitDelay = groupWaitDelay
+	return cmd
+}
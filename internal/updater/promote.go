@@ -0,0 +1,69 @@
+package updater
+
+import (
+	"context"
+	"log/slog"
+
+	gh "github.com/janyksteenbeek/updati/internal/github"
+)
+
+// Promotion is a draft update pull request checked for promotion
+type Promotion struct {
+	Repository      string
+	PRNumber        int
+	PRURL           string
+	CI              string // State of the checks on the head commit, one of the github CI constants
+	Failing         []string
+	Ready           bool   // Marked ready for review; false in dry runs
+	AutoMergeMethod string // Merge method auto-merge was enabled with, empty if not enabled
+}
+
+// Promote marks the repository's draft update pull requests ready for review once the
+// statuses and check runs of their head commit passed, and enables auto-merge on them
+// when configured. Drafts with pending, failing or no CI stay drafts. Only the drafts
+// whose CI passed are acted on in dry runs.
+func (u *Updater) Promote(ctx context.Context, repo *gh.Repository, log *slog.Logger) ([]Promotion, error) {
+	prs, err := u.reader.ListOpenPullRequests(ctx, repo, "", u.cfg.BranchPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	var promotions []Promotion
+	for _, info := range prs {
+		if !info.Draft {
+			continue
+		}
+
+		// updati's own check run always passes, it doesn't tell whether the update works
+		ci, err := u.reader.CommitCI(ctx, repo, info.SHA, checkRunName)
+		if err != nil {
+			log.Warn("could not check CI of pull request", "pr", info.Number, "error", err)
+			continue
+		}
+
+		promotion := Promotion{Repository: repo.FullName, PRNumber: info.Number, PRURL: info.URL, CI: ci.State, Failing: ci.Failing}
+		if ci.State != gh.CIPassing || u.cfg.DryRun.Enabled() {
+			promotions = append(promotions, promotion)
+			continue
+		}
+
+		pr, err := u.reader.GetPullRequest(ctx, repo, info.Number)
+		if err != nil {
+			log.Warn("could not mark pull request ready", "pr", info.Number, "error", err)
+			continue
+		}
+		if err := u.client.MarkReadyForReview(ctx, pr); err != nil {
+			log.Warn("could not mark pull request ready", "pr", info.Number, "error", err)
+			continue
+		}
+		promotion.Ready = true
+		log.Info("marked pull request ready for review", "pr", info.Number)
+
+		if cfg := u.cfg.ForRepo(repo.Name); cfg.AutoMerge {
+			promotion.AutoMergeMethod = u.enableAutoMerge(ctx, repo, pr, cfg.MergeMethod, log.With("pr", info.Number))
+		}
+		promotions = append(promotions, promotion)
+	}
+
+	return promotions, nil
+}
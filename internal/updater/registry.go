@@ -0,0 +1,72 @@
+package updater
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+)
+
+// composerEnv returns the environment composer runs with: non-interactive, plus the
+// configured credentials in COMPOSER_AUTH, which composer merges with auth.json
+func composerEnv(cfg *config.Config, extra ...string) []string {
+	env := append(os.Environ(), "COMPOSER_NO_INTERACTION=1")
+	if cfg.ComposerAuth != "" {
+		env = append(env, "COMPOSER_AUTH="+cfg.ComposerAuth)
+	}
+	return append(env, extra...)
+}
+
+// npmEnv returns the environment variables that give npm the configured registries and
+// credentials: the npm_registry mirror, and a temporary user config holding the real
+// user config followed by the configured lines, so nothing is written to the checkout.
+// The returned function removes the temporary config. Without either npm runs
+// unchanged.
+func npmEnv(cfg *config.Config) ([]string, func(), error) {
+	var env []string
+	if cfg.NPMRegistry != "" {
+		env = append(env, "npm_config_registry="+cfg.NPMRegistry)
+	}
+	if cfg.NPMRC == "" {
+		return env, func() {}, nil
+	}
+
+	userConfig := os.Getenv("NPM_CONFIG_USERCONFIG")
+	if userConfig == "" {
+		userConfig = os.Getenv("npm_config_userconfig")
+	}
+	if userConfig == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			userConfig = filepath.Join(home, ".npmrc")
+		}
+	}
+
+	var content []byte
+	if userConfig != "" {
+		content, _ = os.ReadFile(userConfig)
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			content = append(content, '\n')
+		}
+	}
+	content = append(content, cfg.NPMRC...)
+	content = append(content, '\n')
+
+	f, err := os.CreateTemp(cfg.WorkDir, tempPrefix+"npmrc-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to write npm config: %w", err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to write npm config: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to write npm config: %w", err)
+	}
+
+	return append(env, "NPM_CONFIG_USERCONFIG="+f.Name(), "npm_config_userconfig="+f.Name()), cleanup, nil
+}
@@ -0,0 +1,100 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+)
+
+// packagistMetadata is the Packagist metadata endpoint of a package's tagged releases
+const packagistMetadata = "https://repo.packagist.org/p2/%s.json"
+
+// releaseCutoff returns the release time after which versions are too recent to adopt
+func releaseCutoff(cfg *config.Config) time.Time {
+	return time.Now().Add(-time.Duration(cfg.MinReleaseAge)).UTC()
+}
+
+// packageRelease is a tagged release of a package
+type packageRelease struct {
+	Version string
+	Time    time.Time
+}
+
+// packagistReleases looks up the tagged releases of a package on Packagist
+func packagistReleases(ctx context.Context, name string) ([]packageRelease, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(packagistMetadata, name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("packagist returned %s", resp.Status)
+	}
+
+	var metadata struct {
+		Packages map[string][]struct {
+			Version string `json:"version"`
+			Time    string `json:"time"`
+		} `json:"packages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse packagist metadata: %w", err)
+	}
+
+	var releases []packageRelease
+	for _, release := range metadata.Packages[name] {
+		released, err := time.Parse(time.RFC3339, release.Time)
+		if err != nil {
+			continue
+		}
+		releases = append(releases, packageRelease{Version: release.Version, Time: released})
+	}
+	return releases, nil
+}
+
+// newestReleaseBefore returns the newest stable release published before cutoff that
+// is newer than from, when set, and older than to, within the major version of either,
+// so it still fits the constraints both satisfy. It returns an empty string when no
+// release qualifies.
+func newestReleaseBefore(releases []packageRelease, from, to string, cutoff time.Time) string {
+	upper, ok := parseSemver(to)
+	if !ok {
+		return ""
+	}
+	lower, hasLower := parseSemver(from)
+	majors := []int{upper[0]}
+	if hasLower {
+		majors = append(majors, lower[0])
+	}
+
+	best, bestVersion := "", [3]int{}
+	for _, release := range releases {
+		if release.Time.After(cutoff) || strings.Contains(release.Version, "-") {
+			continue // Too recent, or a pre-release
+		}
+		v, ok := parseSemver(release.Version)
+		if !ok || !slices.Contains(majors, v[0]) || compareVersions(v, upper) >= 0 {
+			continue
+		}
+		if hasLower && compareVersions(v, lower) <= 0 {
+			continue
+		}
+		if best == "" || compareVersions(v, bestVersion) > 0 {
+			best, bestVersion = release.Version, v
+		}
+	}
+	return best
+}
@@ -0,0 +1,125 @@
+package updater
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Risk levels of an update
+const (
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+)
+
+// riskLabelPrefix starts the labels risk levels are surfaced as, e.g. risk:high
+const riskLabelPrefix = "risk:"
+
+// Risk is a rough estimate of how likely an update needs a careful review
+type Risk struct {
+	Score   int      `json:"score"`
+	Level   string   `json:"level"`             // One of the Risk constants
+	Reasons []string `json:"reasons,omitempty"` // What the score is made of, e.g. "1 major bump of a direct dependency"
+}
+
+// Label returns the label the risk level is surfaced as
+func (r Risk) Label() string {
+	return riskLabelPrefix + r.Level
+}
+
+// Badge renders the risk as a badge for the PR body, with its reasons
+func (r Risk) Badge() string {
+	color := map[string]string{RiskLow: "brightgreen", RiskMedium: "yellow", RiskHigh: "red"}[r.Level]
+	badge := fmt.Sprintf("![risk: %s](https://img.shields.io/badge/risk-%s-%s)", r.Level, r.Level, color)
+	if len(r.Reasons) > 0 {
+		badge += " " + strings.Join(r.Reasons, ", ")
+	}
+	return badge
+}
+
+// assessRisk scores an update: major bumps weigh most, of direct dependencies more than
+// of transitive ones, then downgrades and direct dependencies upgraded without release
+// notes to read. Security fixes lower the score, leaving them unmerged is a risk too.
+func assessRisk(changes []PackageChange, fixed int) Risk {
+	var directMajors, transitiveMajors, downgrades, undocumented int
+	for _, change := range changes {
+		switch {
+		case change.Kind == ChangeDowngraded:
+			downgrades++
+		case change.Kind != ChangeUpgraded:
+		case change.Bump == BumpMajor && change.Direct:
+			directMajors++
+		case change.Bump == BumpMajor:
+			transitiveMajors++
+		case change.Direct && githubRepo(change.Source) == "":
+			undocumented++
+		}
+	}
+
+	var risk Risk
+	for _, factor := range []struct {
+		n         int
+		weight    int
+		one, many string
+	}{
+		{directMajors, 5, "major bump of a direct dependency", "major bumps of direct dependencies"},
+		{transitiveMajors, 2, "major bump of a transitive dependency", "major bumps of transitive dependencies"},
+		{downgrades, 2, "downgrade", "downgrades"},
+		{undocumented, 1, "direct dependency without release notes on GitHub", "direct dependencies without release notes on GitHub"},
+		{fixed, -2, "security fix", "security fixes"},
+	} {
+		if factor.n == 0 {
+			continue
+		}
+		risk.Score += factor.n * factor.weight
+		reason := factor.one
+		if factor.n > 1 {
+			reason = factor.many
+		}
+		risk.Reasons = append(risk.Reasons, fmt.Sprintf("%d %s", factor.n, reason))
+	}
+	risk.Score = max(risk.Score, 0)
+
+	switch {
+	case risk.Score >= 8:
+		risk.Level = RiskHigh
+	case risk.Score >= 3:
+		risk.Level = RiskMedium
+	default:
+		risk.Level = RiskLow
+	}
+	return risk
+}
+
+// markDirect flags the changes of packages the checkout requires itself in composer.json
+// or package.json
+func markDirect(dir string, changes []PackageChange) {
+	direct := map[string]map[string]bool{"composer": {}, "npm": {}}
+
+	var composer composerManifest
+	if readJSON(filepath.Join(dir, "composer.json"), &composer) == nil {
+		for _, requires := range []map[string]string{composer.Require, composer.RequireDev} {
+			for name := range requires {
+				direct["composer"][strings.ToLower(name)] = true
+			}
+		}
+	}
+
+	var npm struct {
+		Dependencies         map[string]string `json:"dependencies"`
+		DevDependencies      map[string]string `json:"devDependencies"`
+		OptionalDependencies map[string]string `json:"optionalDependencies"`
+	}
+	if readJSON(filepath.Join(dir, "package.json"), &npm) == nil {
+		for _, deps := range []map[string]string{npm.Dependencies, npm.DevDependencies, npm.OptionalDependencies} {
+			for name := range deps {
+				direct["npm"][name] = true
+			}
+		}
+	}
+
+	for i := range changes {
+		changes[i].Direct = direct[changes[i].Ecosystem][changes[i].Name]
+	}
+}
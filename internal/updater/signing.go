@@ -0,0 +1,107 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// signingPassphraseEnv holds the passphrase of the signing key. It is only read from
+// the environment so it never ends up in a config file.
+const signingPassphraseEnv = "UPDATI_SIGNING_PASSPHRASE"
+
+// Helper scripts handing the passphrase to gpg and ssh-keygen without a terminal
+const (
+	gpgWrapper = `#!/bin/sh
+if [ -z "$` + signingPassphraseEnv + `" ]; then
+	exec gpg "$@"
+fi
+exec gpg --batch --pinentry-mode loopback --passphrase-fd 3 "$@" 3<<PASSPHRASE
+$` + signingPassphraseEnv + `
+PASSPHRASE
+`
+	sshWrapper = `#!/bin/sh
+if [ -n "$` + signingPassphraseEnv + `" ]; then
+	export SSH_ASKPASS="$(dirname "$0")/updati-askpass.sh" SSH_ASKPASS_REQUIRE=force DISPLAY="${DISPLAY:-:0}"
+fi
+exec ssh-keygen "$@"
+`
+	askpassScript = `#!/bin/sh
+printf '%s\n' "$` + signingPassphraseEnv + `"
+`
+)
+
+// configureSigning configures the checkout to sign commits with the configured key.
+// Keys that look like a path or a public key are SSH keys, anything else is a GPG key id.
+func (u *Updater) configureSigning(ctx context.Context, dir string) error {
+	key := u.cfg.SigningKey
+	if key == "" {
+		return nil
+	}
+
+	// Helper scripts live in .git so they are never committed and go away with the clone
+	gitDir := filepath.Join(dir, ".git")
+
+	if isSSHKey(key) {
+		if strings.HasPrefix(key, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				key = filepath.Join(home, key[2:])
+			}
+		}
+		if err := writeScript(filepath.Join(gitDir, "updati-askpass.sh"), askpassScript); err != nil {
+			return err
+		}
+		if err := writeScript(filepath.Join(gitDir, "updati-ssh-sign.sh"), sshWrapper); err != nil {
+			return err
+		}
+		if err := u.setGitConfig(ctx, dir,
+			"gpg.format", "ssh",
+			"gpg.ssh.program", filepath.Join(gitDir, "updati-ssh-sign.sh"),
+		); err != nil {
+			return err
+		}
+	} else {
+		if err := writeScript(filepath.Join(gitDir, "updati-gpg.sh"), gpgWrapper); err != nil {
+			return err
+		}
+		if err := u.setGitConfig(ctx, dir,
+			"gpg.format", "openpgp",
+			"gpg.program", filepath.Join(gitDir, "updati-gpg.sh"),
+		); err != nil {
+			return err
+		}
+	}
+
+	return u.setGitConfig(ctx, dir,
+		"user.signingkey", key,
+		"commit.gpgsign", "true",
+	)
+}
+
+// isSSHKey reports whether a signing key refers to an SSH key rather than a GPG key id
+func isSSHKey(key string) bool {
+	return strings.HasPrefix(key, "ssh-") ||
+		strings.HasPrefix(key, "key::") ||
+		strings.HasPrefix(key, "~") ||
+		strings.ContainsRune(key, filepath.Separator) ||
+		strings.HasSuffix(key, ".pub")
+}
+
+// setGitConfig sets pairs of git config keys and values in the checkout
+func (u *Updater) setGitConfig(ctx context.Context, dir string, pairs ...string) error {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if err := u.runGit(ctx, dir, "config", pairs[i], pairs[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeScript(path, content string) error {
+	if err := os.WriteFile(path, []byte(content), 0o700); err != nil {
+		return fmt.Errorf("failed to write signing helper: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/go-github/v57/github"
+	gh "github.com/janyksteenbeek/updati/internal/github"
+)
+
+// closeSuperseded closes the repository's other open updati pull requests against the
+// same base branch, which the new pull request replaces, and returns their numbers.
+// Failures are logged, they don't fail the update.
+func (u *Updater) closeSuperseded(ctx context.Context, log *slog.Logger, repo *gh.Repository, pr *github.PullRequest, baseBranch, targetBranch string) []int {
+	prs, err := u.reader.ListOpenPullRequests(ctx, repo, baseBranch, u.cfg.BranchPrefix())
+	if err != nil {
+		log.Warn("could not look for superseded pull requests", "error", err)
+		return nil
+	}
+
+	var closed []int
+	for _, old := range prs {
+		if old.Number == pr.GetNumber() || old.Head == targetBranch {
+			continue
+		}
+
+		comment := fmt.Sprintf("Superseded by #%d, which contains the latest dependency updates.", pr.GetNumber())
+		if err := u.client.ClosePullRequest(ctx, repo, old.Number, comment); err != nil {
+			log.Warn("could not close superseded pull request", "pr", old.Number, "error", err)
+			continue
+		}
+		log.Info("closed superseded pull request", "pr", old.Number, "branch", old.Head)
+		closed = append(closed, old.Number)
+
+		if u.cfg.DeleteSupersededBranches {
+			if err := u.client.DeleteBranch(ctx, repo, old.Head); err != nil {
+				log.Warn("could not delete superseded branch", "branch", old.Head, "error", err)
+			}
+		}
+	}
+
+	return closed
+}
@@ -0,0 +1,56 @@
+package updater
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/updati/internal/config"
+)
+
+// tempPrefix starts the names of the checkouts and temporary files updati creates
+const tempPrefix = "updati-"
+
+// CleanStaleTemp removes what crashed runs left behind in the configured work directory:
+// updati-* checkouts and temporary files that weren't modified for stale_temp_after.
+// Those of runs still in progress are younger. The system temp directory is shared with
+// other updati instances and users, so without a workdir nothing is removed. It returns
+// how many it removed.
+func CleanStaleTemp(cfg *config.Config, log *slog.Logger) int {
+	if cfg.StaleTempAfter <= 0 || cfg.WorkDir == "" {
+		return 0
+	}
+
+	dir := cfg.WorkDir
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Warn("could not look for stale temporary files", "dir", dir, "error", err)
+		return 0
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-cfg.StaleTempAfter)
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), tempPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			log.Warn("could not remove stale temporary file", "path", path, "error", err)
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.Info("removed temporary files of crashed runs", "dir", dir, "count", removed)
+	}
+	return removed
+}
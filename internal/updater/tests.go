@@ -0,0 +1,122 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// maxTestOutputLines is how much of the end of the test output a failure reports
+const maxTestOutputLines = 50
+
+// runTests runs the configured test command in the updated checkout. Files the tests
+// create or change, like result caches, are reverted so they aren't committed with the
+// update. A failing command blocks the update.
+func (u *Updater) runTests(ctx context.Context, dir, command string, log *slog.Logger, output io.Writer) error {
+	tracked, untracked, err := dirtyPaths(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	log.Info("running tests", "command", command)
+
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	if output != nil {
+		fmt.Fprintf(output, "$ %s\n", command)
+		w = io.MultiWriter(&buf, output)
+	}
+
+	cmd := inGroup(exec.CommandContext(ctx, "sh", "-c", command))
+	cmd.Dir = dir
+	// The tests are the repository's code, they don't get updati's tokens
+	cmd.Env = append(pluginEnv(), "CI=true")
+	cmd.Stdout = w
+	cmd.Stderr = w
+	testErr := cmd.Run()
+
+	if err := revertNewChanges(ctx, dir, tracked, untracked); err != nil {
+		return fmt.Errorf("failed to revert changes made by the tests: %w", err)
+	}
+
+	if testErr != nil {
+		return fmt.Errorf("update blocked by failing tests: %w\n%s", testErr, lastLines(buf.String(), maxTestOutputLines))
+	}
+	return nil
+}
+
+// dirtyPaths returns the changed or deleted tracked files and the untracked files of
+// the checkout
+func dirtyPaths(ctx context.Context, dir string) (map[string]bool, map[string]bool, error) {
+	tracked, err := gitPaths(ctx, dir, "ls-files", "-z", "--modified", "--deleted")
+	if err != nil {
+		return nil, nil, err
+	}
+	untracked, err := gitPaths(ctx, dir, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, nil, err
+	}
+	return tracked, untracked, nil
+}
+
+// revertNewChanges restores the files that were changed since dirtyPaths returned
+// tracked and untracked, leaving earlier changes alone
+func revertNewChanges(ctx context.Context, dir string, tracked, untracked map[string]bool) error {
+	nowTracked, nowUntracked, err := dirtyPaths(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	var restore []string
+	for path := range nowTracked {
+		if !tracked[path] {
+			restore = append(restore, path)
+		}
+	}
+	if len(restore) > 0 {
+		if _, err := gitOutput(ctx, dir, append([]string{"checkout", "HEAD", "--"}, restore...)...); err != nil {
+			return err
+		}
+	}
+
+	for path := range nowUntracked {
+		if !untracked[path] {
+			if err := os.Remove(filepath.Join(dir, path)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// gitPaths runs a git command printing NUL separated paths and returns them as a set
+func gitPaths(ctx context.Context, dir string, args ...string) (map[string]bool, error) {
+	output, err := gitOutput(ctx, dir, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool)
+	for _, path := range strings.Split(output, "\x00") {
+		if path != "" {
+			paths[path] = true
+		}
+	}
+	return paths, nil
+}
+
+// lastLines returns the last n lines of s
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = append([]string{"…"}, lines[len(lines)-n:]...)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,61 @@
+package updater
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// readToolVersions returns the preferred version of each tool in the project's
+// .tool-versions, as used by asdf and mise. Versions that aren't version numbers, like
+// system, ref: and path: entries, are left out.
+func readToolVersions(dir string) map[string]string {
+	f, err := os.Open(filepath.Join(dir, ".tool-versions"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	versions := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// Later versions on the line are fallbacks
+		if _, _, ok := parseVersionParts(fields[1]); ok {
+			versions[fields[0]] = fields[1]
+		}
+	}
+
+	return versions
+}
+
+// minorRange turns a version like 8.2.12 into a constraint on its minor version,
+// 8.2.*, as patch releases don't change how dependencies resolve
+func minorRange(version string) string {
+	v, parts, _ := parseVersionParts(version)
+	if parts < 2 {
+		return version
+	}
+	return fmt.Sprintf("%d.%d.*", v[0], v[1])
+}
+
+// newestFirst returns the versions binaries are keyed by, newest first
+func newestFirst(binaries map[string]string) []string {
+	versions := make([]string, 0, len(binaries))
+	for version := range binaries {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		a, _, _ := parseVersionParts(versions[i])
+		b, _, _ := parseVersionParts(versions[j])
+		return compareVersions(a, b) > 0
+	})
+	return versions
+}
@@ -1,14 +1,25 @@
 package updater
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/go-github/v57/github"
 	"github.com/janyksteenbeek/updati/internal/config"
 	gh "github.com/janyksteenbeek/updati/internal/github"
+	"github.com/janyksteenbeek/updati/internal/retry"
+	"github.com/janyksteenbeek/updati/internal/toolchain"
 )
 
 // Result represents the result of an update operation
@@ -19,32 +30,241 @@ type Result struct {
 	Error        error
 	PRNumber     int
 	PRURL        string
-	Branch       string
+	Branch       string // Branch the update was pushed to
+	BaseBranch   string // Branch the update is based on
 	ChangedFiles []string
+	SkipReason   string // Why the repository was skipped, if it was
+	Deferred     bool   // Skipped to stay within the pull request budget, for a later run to pick up
+	Excluded     bool   // Skipped without trying, because the repository is empty or a template
+	RedCI        bool   // Skipped by require_green_ci, because the base branch's CI is failing
+	Advisories   []Advisory
+	Freshness    []Freshness     // Dependency freshness after the update, per ecosystem
+	LockfileHash string          // Hash of the base branch's lockfiles, to key dependency caches
+	Laravel      string          // laravel/framework version locked on the base branch, empty if not a Laravel app
+	Changes      []PackageChange // Packages the update added, removed or changed the locked version of
+	ChangeStats  ChangeStats     // Counts of Changes by kind
+	Risk         *Risk           // How carefully the update needs reviewing, with risk_score
+
+	FixedAdvisories []SecurityAdvisory // Known vulnerabilities the update resolves
+	OpenAdvisories  []SecurityAdvisory // Known vulnerabilities left after the update, with advisory_issue
+	Audited         bool               // OpenAdvisories is known, at least one plugin audited the dependencies
+
+	AutoMergeMethod string // Merge method auto-merge was enabled with, empty if not enabled
+	Draft           bool   // The pull request is a draft waiting for CI, see ready_after_ci
+	Superseded      []int  // Older updati pull requests closed in favor of this one
+	CheckRunURL     string // Check run reporting the update on the pull request's head commit
+	PRFallback      string // Why a pull request was opened instead of pushing to the base branch directly
+
+	FailedRuns    int    // Consecutive runs the update failed in, counted when failure issues are enabled
+	FailureIssue  string // URL of the issue reporting the failures, if one was opened or updated
+	AdvisoryIssue string // URL of the issue listing OpenAdvisories, if one was opened or updated
+
+	Timings Timings // How long the phases of the update took
+}
+
+// Timings holds how long the phases of an update took. Phases the update didn't get
+// to are zero.
+type Timings struct {
+	Total   time.Duration
+	Clone   time.Duration
+	Plugins map[string]time.Duration // Per plugin that ran, excluding the wait for an install slot
+	Push    time.Duration            // Committing and pushing
+}
+
+// Name identifies the result: the repository's full name, followed by the base
+// branch when it isn't the default branch
+func (r *Result) Name() string {
+	if r.BaseBranch != "" && r.BaseBranch != r.Repository.DefaultRef {
+		return r.Repository.FullName + "@" + r.BaseBranch
+	}
+	return r.Repository.FullName
 }
 
 // Updater handles updating repositories using registered plugins
 type Updater struct {
 	cfg    *config.Config
-	client *gh.Client
+	client *gh.Client // Pushes and manages pull requests
+	reader *gh.Client // Inspects repositories and branches
+
+	prsOpened atomic.Int32 // New pull requests opened or about to be opened in this run
+
+	onPhase func(repo *gh.Repository, phase string)
+}
+
+// Phases of an update reported to OnPhase
+const (
+	PhaseCloning     = "cloning"
+	PhaseUpdating    = "updating"
+	PhaseTesting     = "testing"
+	PhasePushing     = "pushing"
+	PhasePullRequest = "opening pull request"
+
+	PhaseWaitingForDisk = "waiting for disk space"
+)
+
+// OnPhase registers fn to be called when the update of a repository enters one of the
+// Phase constants. It is called from the workers, concurrently.
+func (u *Updater) OnPhase(fn func(repo *gh.Repository, phase string)) {
+	u.onPhase = fn
+}
+
+// phase reports that the update of the repository entered a phase
+func (u *Updater) phase(repo *gh.Repository, phase string) {
+	if u.onPhase != nil {
+		u.onPhase(repo, phase)
+	}
 }
 
-// New creates a new Updater
-func New(cfg *config.Config, client *gh.Client) *Updater {
+// New creates a new Updater. Read-only requests go through reader, which may use a
+// token with fewer permissions than client.
+func New(cfg *config.Config, client, reader *gh.Client) *Updater {
 	return &Updater{
 		cfg:    cfg,
 		client: client,
+		reader: reader,
+	}
+}
+
+// Update updates every target branch of a repository on behalf of the given worker,
+// logging progress to the given logger. It returns one result per target branch.
+func (u *Updater) Update(ctx context.Context, repo *gh.Repository, workerID int, log *slog.Logger) []*Result {
+	// A hung command shouldn't hold up the worker forever
+	if u.cfg.RepoTimeout > 0 {
+		parent := ctx
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.cfg.RepoTimeout)
+		defer cancel()
+
+		results := u.update(ctx, repo, workerID, log)
+		if ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+			for _, result := range results {
+				if result.Error != nil {
+					result.Error = fmt.Errorf("timed out after %s: %w", u.cfg.RepoTimeout, result.Error)
+				}
+			}
+		}
+		return results
+	}
+
+	return u.update(ctx, repo, workerID, log)
+}
+
+// update updates every target branch of a repository
+func (u *Updater) update(ctx context.Context, repo *gh.Repository, workerID int, log *slog.Logger) []*Result {
+	// Stream package manager output in verbose mode
+	output, err := u.openOutput(repo.FullName, workerID)
+	if err != nil {
+		return []*Result{{Repository: repo, Error: err}}
+	}
+	if output != nil {
+		defer output.Close()
+	}
+
+	// Without a branches list, only the base branch is updated
+	if len(u.cfg.Branches) == 0 {
+		return []*Result{u.updateBranch(ctx, repo, u.cfg.BaseBranch, false, log, output)}
 	}
+
+	results := make([]*Result, 0, len(u.cfg.Branches))
+	for _, branch := range u.cfg.Branches {
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, u.updateBranch(ctx, repo, branch, true, log.With("base", branch), output))
+	}
+	return results
 }
 
-// Update updates a single repository
-func (u *Updater) Update(ctx context.Context, repo *gh.Repository) *Result {
+// updateBranch updates a single base branch of a repository. An explicitly requested
+// branch is skipped if it doesn't exist instead of falling back to the default branch.
+func (u *Updater) updateBranch(ctx context.Context, repo *gh.Repository, requested string, explicit bool, log *slog.Logger, output io.Writer) *Result {
 	result := &Result{
 		Repository: repo,
 	}
+	started := time.Now()
+	defer func() {
+		result.Timings.Total = time.Since(started)
+	}()
+
+	// Keep the package manager output for the check run
+	var logs *bytes.Buffer
+	if u.checkRunEnabled() {
+		logs = &bytes.Buffer{}
+		if output != nil {
+			output = io.MultiWriter(output, logs)
+		} else {
+			output = logs
+		}
+	}
+
+	// Determine the branch to base the update on
+	baseBranch, ok, err := u.resolveBaseBranch(ctx, repo, requested, explicit, result, log)
+	result.BaseBranch = baseBranch
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	if !ok {
+		result.Success = true
+		result.SkipReason = fmt.Sprintf("branch %s does not exist", requested)
+		return result
+	}
+
+	// Updates of a branch that is already broken only add noise
+	if u.cfg.RequireGreenCI {
+		ci, err := u.reader.CommitCI(ctx, repo, baseBranch)
+		if err != nil {
+			log.Warn("could not check CI of base branch, updating anyway", "error", err)
+		} else if ci.State == gh.CIFailing {
+			log.Info("CI of base branch is failing, skipping", "failing", ci.Failing)
+			result.Success = true
+			result.RedCI = true
+			result.SkipReason = fmt.Sprintf("CI of %s is failing: %s", baseBranch, strings.Join(ci.Failing, ", "))
+			return result
+		}
+	}
+
+	// Open a pull request instead when the base branch doesn't take direct pushes
+	createPR := u.cfg.CreatePR
+	if !createPR && u.cfg.DryRun != config.DryRunDetect {
+		restriction, err := u.reader.PushRestriction(ctx, repo, baseBranch)
+		if err != nil {
+			log.Warn("could not check branch protection, pushing directly", "error", err)
+		} else if restriction != "" {
+			log.Info("base branch does not allow direct pushes, opening a pull request instead", "reason", restriction)
+			createPR = true
+			result.PRFallback = restriction
+		}
+	}
+
+	// Stop at detection without cloning
+	if u.cfg.DryRun == config.DryRunDetect {
+		plugins := u.applicablePlugins(repo)
+		log.Info("dry run, not cloning", "plugins", plugins)
+		result.Success = true
+		result.SkipReason = "dry run, would run " + strings.Join(plugins, ", ")
+		return result
+	}
+
+	// Don't spend a clone on an update the run has no pull request left for
+	if createPR && !u.cfg.DryRun.Enabled() && u.runBudgetSpent() {
+		log.Info("pull request budget spent, deferring")
+		result.Success = true
+		result.Deferred = true
+		result.SkipReason = u.runBudgetReason()
+		return result
+	}
+
+	// Rather pause than fail plugins halfway with "no space left on device"
+	if err := u.waitForDisk(ctx, repo, log); err != nil {
+		result.Error = err
+		return result
+	}
+	checkouts.Add(1)
+	defer checkouts.Add(-1)
 
 	// Create temp directory for the repo
-	tmpDir, err := os.MkdirTemp("", "updati-"+repo.Name+"-")
+	tmpDir, err := os.MkdirTemp(u.cfg.WorkDir, tempPrefix+repo.Name+"-")
 	if err != nil {
 		result.Error = fmt.Errorf("failed to create temp directory: %w", err)
 		return result
@@ -52,31 +272,127 @@ func (u *Updater) Update(ctx context.Context, repo *gh.Repository) *Result {
 	defer os.RemoveAll(tmpDir)
 
 	// Clone the repository
-	if err := u.cloneRepo(ctx, repo, tmpDir); err != nil {
+	log.Debug("cloning repository", "dir", tmpDir, "ref", baseBranch)
+	u.phase(repo, PhaseCloning)
+	cloneStarted := time.Now()
+	err = u.cloneRepo(ctx, log, repo, baseBranch, tmpDir)
+	result.Timings.Clone = time.Since(cloneStarted)
+	if err != nil {
 		result.Error = fmt.Errorf("failed to clone repository: %w", err)
 		return result
 	}
 
+	result.LockfileHash = lockfileHash(tmpDir)
+	result.Laravel = laravelVersion(tmpDir)
+
+	// Report legacy dependency systems we don't update
+	legacy := detectAdvisories(tmpDir)
+	for _, adv := range legacy {
+		log.Warn("advisory", "code", adv.Code, "message", adv.Message)
+	}
+	result.Advisories = append(result.Advisories, legacy...)
+
 	// Determine target branch
-	targetBranch := u.determineTargetBranch(repo)
+	targetBranch, err := u.determineTargetBranch(repo, baseBranch, createPR)
+	if err != nil {
+		result.Error = err
+		return result
+	}
 	result.Branch = targetBranch
 
 	// Create branch if using PR mode
-	if u.cfg.CreatePR {
+	if createPR {
 		if err := u.createBranch(tmpDir, targetBranch); err != nil {
 			result.Error = fmt.Errorf("failed to create branch: %w", err)
 			return result
 		}
 	}
 
+	// Remember the known vulnerabilities, to tell which ones the update fixes
+	var audited map[string]map[string]SecurityAdvisory
+	if u.cfg.SecurityAnnotations || u.checkRunEnabled() || u.cfg.AdvisoryIssue {
+		audited = u.auditAll(ctx, tmpDir, repo, log, output)
+	}
+
 	// Run all applicable plugins
-	updated, changedFiles, err := u.runPlugins(ctx, tmpDir, repo)
+	u.phase(repo, PhaseUpdating)
+	locked := snapshotLocks(tmpDir)
+
+	// Decide how to update the branch without destroying commits added by people, and
+	// move onto the existing branch before the plugins run when the update is added on
+	// top of it
+	var strategy, replaced string
+	if !u.cfg.DryRun.Enabled() {
+		strategy, replaced, err = u.prepareBranch(ctx, log, repo, tmpDir, baseBranch, targetBranch)
+		if err != nil {
+			var skip *SkipError
+			if errors.As(err, &skip) {
+				result.Success = true
+				result.SkipReason = err.Error()
+				return result
+			}
+			result.Error = err
+			return result
+		}
+	}
+
+	updated, pluginChanges, err := u.runPlugins(ctx, tmpDir, repo, log, output, &result.Timings)
 	if err != nil {
+		var skip *SkipError
+		if errors.As(err, &skip) {
+			result.Success = true
+			result.SkipReason = err.Error()
+			return result
+		}
 		result.Error = err
 		return result
 	}
 
+	var changedFiles []string
+	for _, changes := range pluginChanges {
+		changedFiles = append(changedFiles, changes.Files...)
+	}
+	commits := u.commitPlan(pluginChanges)
+
 	result.ChangedFiles = changedFiles
+	result.Changes = mergeReported(diffLocks(locked, snapshotLocks(tmpDir)), pluginChanges)
+	result.ChangeStats = CountChanges(result.Changes)
+	markDirect(tmpDir, result.Changes)
+	if updated && (u.cfg.ChangelogLinks || u.cfg.RiskScore) {
+		resolveNPMSources(ctx, u.cfg, tmpDir, result.Changes)
+	}
+	var audit *auditOutcome
+	remaining := audited
+	if audited != nil && updated {
+		remaining = u.auditAll(ctx, tmpDir, repo, log, output)
+		audit = newAuditOutcome(audited, remaining)
+		if u.cfg.SecurityAnnotations && len(audit.Fixed) > 0 {
+			result.FixedAdvisories = audit.Fixed
+			log.Info("update fixes security advisories", "count", len(audit.Fixed))
+		}
+	}
+	if u.cfg.RiskScore && updated {
+		risk := assessRisk(result.Changes, len(result.FixedAdvisories))
+		result.Risk = &risk
+	}
+	if u.cfg.AdvisoryIssue && len(remaining) > 0 {
+		result.Audited = true
+		result.OpenAdvisories = openAdvisories(remaining)
+	}
+
+	// Make sure the project still works with the updated dependencies
+	if command := u.cfg.ForRepo(repo.Name).TestCommand; command != "" && updated {
+		u.phase(repo, PhaseTesting)
+		if err := u.runTests(ctx, tmpDir, command, log, output); err != nil {
+			result.Error = err
+			return result
+		}
+	}
+
+	// Measure what is left behind once the update is applied
+	if u.cfg.Freshness {
+		result.Freshness = u.measureFreshness(ctx, tmpDir, repo, log, output)
+	}
 
 	if !updated {
 		result.Success = true
@@ -84,28 +400,85 @@ func (u *Updater) Update(ctx context.Context, repo *gh.Repository) *Result {
 		return result
 	}
 
-	if u.cfg.DryRun {
+	switch u.cfg.DryRun {
+	case config.DryRunResolve:
+		log.Info("dry run, not committing changes", "changed_files", changedFiles)
 		result.Success = true
 		result.Updated = true
 		return result
+	case config.DryRunPush:
+		if _, err := u.commitLocally(ctx, log, tmpDir, commits); err != nil {
+			result.Error = fmt.Errorf("failed to commit: %w", err)
+			return result
+		}
+		sha, _ := gitOutput(ctx, tmpDir, "rev-parse", "--short", "HEAD")
+		log.Info("dry run, committed locally without pushing", "commit", sha, "changed_files", changedFiles)
+		result.Success = true
+		result.Updated = true
+		return result
+	}
+
+	// Stay within the pull request budget, handing back a reserved pull request when
+	// none gets opened
+	if createPR && u.budgetLimited() {
+		reserved, deferral, err := u.reservePR(ctx, log, repo, baseBranch, targetBranch)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		if deferral != "" {
+			log.Info("pull request budget spent, deferring", "reason", deferral)
+			result.Success = true
+			result.Deferred = true
+			result.SkipReason = deferral
+			return result
+		}
+		if reserved {
+			defer func() {
+				if result.PRNumber == 0 {
+					u.releasePR()
+				}
+			}()
+		}
 	}
 
 	// Commit and push changes
-	if err := u.commitAndPush(ctx, tmpDir, targetBranch); err != nil {
+	log.Debug("committing and pushing changes", "branch", targetBranch, "strategy", strategy)
+	u.phase(repo, PhasePushing)
+	pushStarted := time.Now()
+	err = u.commitAndPush(ctx, log, repo, tmpDir, targetBranch, strategy, replaced, commits)
+	result.Timings.Push = time.Since(pushStarted)
+	if err != nil {
 		result.Error = fmt.Errorf("failed to commit and push: %w", err)
 		return result
 	}
 
 	// Create pull request if configured
-	if u.cfg.CreatePR {
+	if createPR {
+		u.phase(repo, PhasePullRequest)
+
+		// Labels that don't exist would otherwise not be attached
+		if u.cfg.CreateMissingLabels && len(u.cfg.Labels) > 0 {
+			if err := u.client.EnsureLabels(ctx, repo, u.cfg.Labels, u.cfg.LabelColor, u.cfg.LabelDescription); err != nil {
+				log.Warn("could not create missing labels", "error", err)
+			}
+		}
+
+		body, err := u.prBody(tmpDir, result)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+
 		pr, err := u.client.CreatePullRequest(
 			ctx,
 			repo,
-			u.cfg.PRTitle,
-			u.cfg.PRBody,
+			u.prTitle(result),
+			body,
 			targetBranch,
-			repo.DefaultRef,
+			baseBranch,
 			u.cfg.Labels,
+			u.cfg.ReadyAfterCI,
 		)
 		if err != nil {
 			result.Error = fmt.Errorf("failed to create pull request: %w", err)
@@ -113,6 +486,28 @@ func (u *Updater) Update(ctx context.Context, repo *gh.Repository) *Result {
 		}
 		result.PRNumber = pr.GetNumber()
 		result.PRURL = pr.GetHTMLURL()
+
+		if len(result.FixedAdvisories) > 0 && u.cfg.SecurityLabel != "" {
+			u.addSecurityLabel(ctx, log, repo, pr.GetNumber())
+		}
+
+		if result.Risk != nil {
+			u.labelRisk(ctx, log, repo, pr, *result.Risk)
+		}
+
+		// With ready_after_ci, auto-merge waits for the promotion of the draft
+		result.Draft = pr.GetDraft()
+		if cfg := u.cfg.ForRepo(repo.Name); cfg.AutoMerge && !result.Draft {
+			result.AutoMergeMethod = u.enableAutoMerge(ctx, repo, pr, cfg.MergeMethod, log)
+		}
+
+		if u.cfg.CloseSuperseded {
+			result.Superseded = u.closeSuperseded(ctx, log, repo, pr, baseBranch, targetBranch)
+		}
+
+		if logs != nil {
+			result.CheckRunURL = u.createCheckRun(ctx, log, repo, targetBranch, result, audit, logs.String())
+		}
 	}
 
 	result.Success = true
@@ -120,12 +515,62 @@ func (u *Updater) Update(ctx context.Context, repo *gh.Repository) *Result {
 	return result
 }
 
-// runPlugins runs all applicable plugins for the repository
-func (u *Updater) runPlugins(ctx context.Context, dir string, repo *gh.Repository) (bool, []string, error) {
+// enableAutoMerge enables auto-merge on the pull request, falling back to another merge
+// method when the preferred one is not allowed. It returns the method used, or an empty
+// string when auto-merge could not be enabled; failures don't fail the update.
+func (u *Updater) enableAutoMerge(ctx context.Context, repo *gh.Repository, pr *github.PullRequest, preferred string, log *slog.Logger) string {
+	settings, err := u.reader.GetMergeSettings(ctx, repo)
+	if err != nil {
+		log.Warn("could not enable auto-merge", "error", err)
+		return ""
+	}
+
+	if !settings.AllowAutoMerge {
+		log.Warn("auto-merge is not allowed in this repository")
+		return ""
+	}
+
+	method := preferred
+	if !settings.Allows(method) {
+		if len(settings.Methods) == 0 {
+			log.Warn("repository allows no merge methods, not enabling auto-merge")
+			return ""
+		}
+		method = settings.Methods[0]
+		log.Info("preferred merge method not allowed, falling back", "preferred", preferred, "method", method)
+	}
+
+	if err := u.client.EnableAutoMerge(ctx, pr, method); err != nil {
+		log.Warn("could not enable auto-merge", "error", err)
+		return ""
+	}
+
+	log.Debug("enabled auto-merge", "method", method)
+	return method
+}
+
+// runPlugins runs all applicable plugins for the repository and returns the files each
+// plugin that updated something changed, recording how long each plugin took in
+// timings. With plugin_concurrency above 1, plugins touching disjoint files run at the
+// same time.
+func (u *Updater) runPlugins(ctx context.Context, dir string, repo *gh.Repository, log *slog.Logger, output io.Writer, timings *Timings) (bool, []pluginChanges, error) {
 	var anyUpdated bool
-	var allChangedFiles []string
+	var allChanges []pluginChanges
 
-	for _, plugin := range Plugins() {
+	cfg := u.cfg.ForRepo(repo.Name)
+
+	plugins, err := OrderedPlugins(u.cfg)
+	if err != nil {
+		return false, nil, err
+	}
+
+	concurrent := cfg.PluginConcurrency > 1
+	if concurrent && output != nil {
+		output = &syncWriter{w: output}
+	}
+
+	var runs []*pluginRun
+	for _, plugin := range plugins {
 		// Check if plugin is enabled in config
 		if !u.isPluginEnabled(plugin.Name()) {
 			continue
@@ -136,65 +581,335 @@ func (u *Updater) runPlugins(ctx context.Context, dir string, repo *gh.Repositor
 			continue
 		}
 
-		// Run the plugin
-		updated, changedFiles, err := plugin.Update(ctx, dir)
-		if err != nil {
-			return false, nil, fmt.Errorf("%s: %w", plugin.Name(), err)
+		// Plugins that name their manifests have nothing to do without them
+		manifests := existingFiles(dir, plugin.Manifests())
+		if len(plugin.Manifests()) > 0 && len(manifests) == 0 {
+			log.Debug("no manifests found, skipping plugin", "plugin", plugin.Name())
+			continue
 		}
 
-		if updated {
+		job := &Job{
+			Dir:       dir,
+			Repo:      repo,
+			Manifests: manifests,
+			Config:    cfg,
+			Logger:    log.With("plugin", plugin.Name()),
+			Output:    output,
+			Image:     cfg.PluginImage(plugin.Name()),
+			Flags:     cfg.Plugins[plugin.Name()].Flags,
+			Settings:  cfg.Plugins[plugin.Name()].Settings,
+			Timeout:   cfg.Plugins[plugin.Name()].Timeout,
+			MemoryMB:  cfg.Plugins[plugin.Name()].MemoryLimitMB,
+			plugin:    plugin.Name(),
+		}
+		runs = append(runs, &pluginRun{plugin: plugin, job: job})
+	}
+
+	// Run the plugins
+	var unclaimed []string
+	if concurrent && len(runs) > 1 {
+		var before treeSnapshot
+		if before, err = snapshotTree(ctx, dir); err != nil {
+			return false, nil, err
+		}
+		err = updateConcurrently(ctx, runs, cfg.PluginConcurrency, pluginAfter(u.cfg))
+		if err == nil {
+			unclaimed, err = unclaimedChanges(ctx, dir, before, runs)
+		}
+	} else {
+		err = updateSequentially(ctx, runs)
+	}
+	for _, run := range runs {
+		if run.duration > 0 {
+			if timings.Plugins == nil {
+				timings.Plugins = make(map[string]time.Duration)
+			}
+			timings.Plugins[run.plugin.Name()] = run.duration
+		}
+	}
+	if err != nil {
+		return false, nil, err
+	}
+
+	for _, run := range runs {
+		if run.updated {
 			anyUpdated = true
-			allChangedFiles = append(allChangedFiles, changedFiles...)
+			allChanges = append(allChanges, pluginChanges{Plugin: run.plugin.Name(), Files: run.files, Packages: run.job.changes})
 		}
 	}
+	if len(unclaimed) > 0 {
+		log.Debug("plugins changed files none of them reported", "files", unclaimed)
+		anyUpdated = true
+		allChanges = append(allChanges, pluginChanges{Files: unclaimed})
+	}
+
+	return anyUpdated, allChanges, nil
+}
 
-	return anyUpdated, allChangedFiles, nil
+// checkRunEnabled reports whether updates that open pull requests are reported in a
+// check run
+func (u *Updater) checkRunEnabled() bool {
+	return u.cfg.CheckRun && !u.cfg.DryRun.Enabled()
+}
+
+// prTitle returns the configured PR title, mentioning the security advisories the
+// update fixes
+func (u *Updater) prTitle(result *Result) string {
+	switch n := len(result.FixedAdvisories); n {
+	case 0:
+		return u.cfg.PRTitle
+	case 1:
+		return u.cfg.PRTitle + " (fixes 1 security advisory)"
+	default:
+		return fmt.Sprintf("%s (fixes %d security advisories)", u.cfg.PRTitle, n)
+	}
+}
+
+// prBody returns the rendered PR body template, below the risk badge when scored and
+// followed by the security advisories the update fixes and a table of the changed
+// packages, with links to their release notes when enabled
+func (u *Updater) prBody(dir string, result *Result) (string, error) {
+	body, err := u.renderPRBody(dir, result)
+	if err != nil {
+		return "", err
+	}
+
+	if result.Risk != nil {
+		body = result.Risk.Badge() + "\n\n" + body
+	}
+
+	if len(result.FixedAdvisories) > 0 {
+		body += "\n\n" + securitySection(result.FixedAdvisories)
+	}
+
+	if len(result.Changes) > 0 {
+		body += "\n\n" + changesTable(result.Changes, u.cfg.ChangelogLinks)
+	}
+
+	return body, nil
+}
+
+// addSecurityLabel labels a pull request that fixes security advisories
+func (u *Updater) addSecurityLabel(ctx context.Context, log *slog.Logger, repo *gh.Repository, number int) {
+	labels := []string{u.cfg.SecurityLabel}
+	if u.cfg.CreateMissingLabels {
+		if err := u.client.EnsureLabels(ctx, repo, labels, u.cfg.LabelColor, u.cfg.LabelDescription); err != nil {
+			log.Warn("could not create missing labels", "error", err)
+		}
+	}
+	if err := u.client.AddLabels(ctx, repo, number, labels); err != nil {
+		log.Warn("could not add security label", "error", err)
+	}
+}
+
+// labelRisk labels a pull request with its risk level, replacing the label of an
+// earlier level
+func (u *Updater) labelRisk(ctx context.Context, log *slog.Logger, repo *gh.Repository, pr *github.PullRequest, risk Risk) {
+	label := risk.Label()
+	for _, existing := range pr.Labels {
+		if name := existing.GetName(); strings.HasPrefix(name, riskLabelPrefix) && name != label {
+			if err := u.client.RemoveLabel(ctx, repo, pr.GetNumber(), name); err != nil {
+				log.Warn("could not remove outdated risk label", "label", name, "error", err)
+			}
+		}
+	}
+
+	labels := []string{label}
+	if u.cfg.CreateMissingLabels {
+		if err := u.client.EnsureLabels(ctx, repo, labels, u.cfg.LabelColor, u.cfg.LabelDescription); err != nil {
+			log.Warn("could not create missing labels", "error", err)
+		}
+	}
+	if err := u.client.AddLabels(ctx, repo, pr.GetNumber(), labels); err != nil {
+		log.Warn("could not add risk label", "error", err)
+	}
 }
 
 // isPluginEnabled checks if a plugin is enabled in the config
 func (u *Updater) isPluginEnabled(name string) bool {
-	switch name {
-	case "composer":
-		return u.cfg.UpdateComposer
-	case "npm":
-		return u.cfg.UpdateNPM
-	default:
-		return true // Enable unknown plugins by default
+	return u.cfg.PluginEnabled(name)
+}
+
+// Exclusion returns why the repository is excluded from updates: it is empty, or a
+// template and update_templates isn't set. It returns an empty string otherwise.
+func (u *Updater) Exclusion(repo *gh.Repository) string {
+	switch {
+	case repo.Empty:
+		return "empty repository"
+	case repo.Template && !u.cfg.UpdateTemplates:
+		return "template repository"
 	}
+	return ""
 }
 
-func (u *Updater) determineTargetBranch(repo *gh.Repository) string {
-	if u.cfg.CreatePR {
-		return u.cfg.PRBranch
+// Applicable reports whether any enabled plugin handles the repository
+func (u *Updater) Applicable(repo *gh.Repository) bool {
+	return len(u.applicablePlugins(repo)) > 0
+}
+
+// applicablePlugins returns the names of the enabled plugins that handle the repository
+func (u *Updater) applicablePlugins(repo *gh.Repository) []string {
+	var names []string
+	for _, plugin := range Plugins() {
+		if u.isPluginEnabled(plugin.Name()) && plugin.Detect(repo) {
+			names = append(names, plugin.Name())
+		}
 	}
-	if u.cfg.BaseBranch != "" {
-		return u.cfg.BaseBranch
+	return names
+}
+
+// PluginNames returns the names of all registered plugins
+func PluginNames() []string {
+	names := make([]string, 0, len(registry))
+	for _, p := range registry {
+		names = append(names, p.Name())
 	}
-	return repo.DefaultRef
+	return names
 }
 
-func (u *Updater) cloneRepo(ctx context.Context, repo *gh.Repository, dir string) error {
-	cloneURL := strings.Replace(
-		repo.CloneURL,
-		"https://",
-		fmt.Sprintf("https://x-access-token:%s@", u.cfg.GitHubToken),
-		1,
-	)
+// determineTargetBranch returns the branch the update is pushed to: the base branch
+// itself, or the rendered pr_branch
+func (u *Updater) determineTargetBranch(repo *gh.Repository, baseBranch string, createPR bool) (string, error) {
+	if !createPR {
+		return baseBranch, nil
+	}
 
-	// Clone with full history for pushing (shallow clones can cause issues)
-	cmd := exec.CommandContext(ctx, "git", "clone", "-b", repo.DefaultRef, cloneURL, dir)
-	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var plugins []string
+	for _, plugin := range Plugins() {
+		if u.isPluginEnabled(plugin.Name()) && plugin.Detect(repo) {
+			plugins = append(plugins, plugin.Name())
+		}
+	}
+	branch, err := u.cfg.PRBranchName(config.BranchName{
+		Repository: repo.Name,
+		Base:       branchSlug(baseBranch),
+		Plugins:    strings.Join(plugins, "-"),
+		Date:       time.Now().UTC().Format(time.DateOnly),
+	})
+	if err != nil {
+		return "", err
+	}
 
-	output, err := cmd.CombinedOutput()
+	// Updates of other branches than the default one get their own PR branch
+	if len(u.cfg.Branches) > 0 && baseBranch != repo.DefaultRef && !u.cfg.BranchPerBase() {
+		return branch + "-" + branchSlug(baseBranch), nil
+	}
+	return branch, nil
+}
+
+// branchSlug turns a branch name into something usable as part of another branch name
+func branchSlug(branch string) string {
+	return strings.NewReplacer("/", "-", " ", "-").Replace(branch)
+}
+
+// resolveBaseBranch returns the requested base branch, or the repository's default
+// branch as reported by the API when none is requested, or when the requested one
+// doesn't exist or is stale and the config asks to fall back. A fallback is recorded as
+// an advisory. Explicitly requested branches never fall back; false is returned when
+// such a branch doesn't exist.
+func (u *Updater) resolveBaseBranch(ctx context.Context, repo *gh.Repository, base string, explicit bool, result *Result, log *slog.Logger) (string, bool, error) {
+	if base == "" || base == repo.DefaultRef {
+		return repo.DefaultRef, true, nil
+	}
+
+	exists, err := u.reader.BranchExists(ctx, repo, base)
 	if err != nil {
-		return fmt.Errorf("git clone failed: %s", string(output))
+		return base, false, fmt.Errorf("failed to resolve base branch: %w", err)
+	}
+	if !exists {
+		if explicit {
+			log.Debug("branch does not exist, skipping", "base", base)
+			return base, false, nil
+		}
+		log.Info("base branch does not exist, using default branch", "base", base, "default", repo.DefaultRef)
+		result.Advisories = append(result.Advisories, Advisory{
+			Code:    "missing-base",
+			Message: fmt.Sprintf("base branch %s does not exist, updated default branch %s instead", base, repo.DefaultRef),
+		})
+		return repo.DefaultRef, true, nil
 	}
 
-	return nil
+	// How far the default branch has moved on without the base branch
+	ahead, _, err := u.reader.CompareBranches(ctx, repo, base, repo.DefaultRef)
+	if err != nil {
+		log.Warn("could not compare base branch with default branch", "base", base, "error", err)
+		return base, true, nil
+	}
+
+	if ahead < u.cfg.StaleBaseAfter {
+		return base, true, nil
+	}
+
+	msg := fmt.Sprintf("base branch %s is %d commits behind default branch %s", base, ahead, repo.DefaultRef)
+	if u.cfg.StaleBase == config.StaleBaseDefault && !explicit {
+		log.Warn("stale base branch, updating default branch instead", "base", base, "default", repo.DefaultRef, "behind", ahead)
+		msg += ", updated the default branch instead"
+		base = repo.DefaultRef
+	} else {
+		log.Warn("stale base branch", "base", base, "default", repo.DefaultRef, "behind", ahead)
+	}
+
+	result.Advisories = append(result.Advisories, Advisory{Code: "stale-base", Message: msg})
+	return base, true, nil
+}
+
+func (u *Updater) cloneRepo(ctx context.Context, log *slog.Logger, repo *gh.Repository, branch, dir string) error {
+	args := []string{"clone", "-b", branch}
+
+	// Updates only need the tip of the branch; GitHub accepts pushes from shallow and
+	// partial clones, and fetches missing blobs on demand
+	if u.cfg.CloneDepth > 0 {
+		args = append(args, "--depth", strconv.Itoa(u.cfg.CloneDepth))
+	}
+	if u.cfg.PartialClone {
+		args = append(args, "--filter=blob:none")
+	}
+
+	// Borrow objects from the local mirror so only new objects are downloaded. The clone
+	// dissociates from it, so the mirror can be updated while the clone is in use.
+	if u.cfg.MirrorDir != "" {
+		mirror, err := u.updateMirror(ctx, log, repo)
+		if err != nil {
+			log.Warn("could not update mirror, cloning without it", "error", err)
+		} else {
+			args = append(args, "--reference-if-able", mirror, "--dissociate")
+		}
+	}
+
+	// The clone URL carries no credentials, so none end up in the checkout's git config
+	args = append(args, repo.CloneURL, dir)
+
+	return u.withRetry(ctx, log, "git clone", func() error {
+		// Start every attempt from an empty directory, a failed clone may leave files behind
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		return u.runGit(ctx, "", args...)
+	})
+}
+
+// gitAuthEnv returns the environment that makes git authenticate to GitHub with the
+// token. Passing it per command keeps the token out of the checkout, where package
+// manager scripts, plugins and test commands could read it.
+func gitAuthEnv(token string) []string {
+	credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + credentials,
+	}
+}
+
+// withRetry runs fn according to the configured retry policy, retrying only failures
+// that look like network problems
+func (u *Updater) withRetry(ctx context.Context, log *slog.Logger, what string, fn func() error) error {
+	return u.cfg.Retry.Do(ctx, fn, retry.IsTransient, func(attempt int, wait time.Duration, err error) {
+		log.Warn(what+" failed, retrying", "attempt", attempt, "wait", wait.Round(time.Millisecond), "error", err)
+	})
 }
 
 func (u *Updater) createBranch(dir, branchName string) error {
-	cmd := exec.Command("git", "checkout", "-B", branchName)
+	cmd := exec.Command(toolchain.Path("git"), "checkout", "-B", branchName)
 	cmd.Dir = dir
 
 	output, err := cmd.CombinedOutput()
@@ -205,53 +920,119 @@ func (u *Updater) createBranch(dir, branchName string) error {
 	return nil
 }
 
-func (u *Updater) commitAndPush(ctx context.Context, dir, branchName string) error {
-	// Configure git user
-	if err := u.runGit(ctx, dir, "config", "user.email", "updati@github.com"); err != nil {
-		return err
+// prepareBranch returns the branch update strategy for the update branch, and checks
+// out the existing branch when the update is appended to it with a local commit. It
+// also returns the branch head a rebase replaced, which the push has to overwrite.
+// Only a strategy switched to append by foreign_commits rebases, a configured append
+// merges the base branch instead, as its repositories may forbid force-pushes.
+func (u *Updater) prepareBranch(ctx context.Context, log *slog.Logger, repo *gh.Repository, dir, baseBranch, branchName string) (string, string, error) {
+	cfg := u.cfg.ForRepo(repo.Name)
+	if branchName == baseBranch {
+		return fastForward, "", nil
 	}
-	if err := u.runGit(ctx, dir, "config", "user.name", "Updati Bot"); err != nil {
-		return err
+
+	head, err := u.fetchBranch(ctx, log, dir, branchName)
+	if err != nil || head == "" {
+		return cfg.BranchUpdateStrategy, "", err
 	}
 
-	// Stage all changes
-	if err := u.runGit(ctx, dir, "add", "-A"); err != nil {
-		return err
+	strategy, err := u.branchStrategy(ctx, log, repo, baseBranch, branchName)
+	if err != nil || strategy != config.BranchUpdateAppend || u.cfg.CommitMode == config.CommitModeAPI {
+		return strategy, "", err
 	}
 
-	// Check if there are changes to commit
-	cmd := exec.CommandContext(ctx, "git", "status", "--porcelain")
-	cmd.Dir = dir
-	output, _ := cmd.Output()
-	if len(strings.TrimSpace(string(output))) == 0 {
-		return nil // Nothing to commit
+	replaced, err := u.prepareAppend(ctx, log, dir, branchName, head, cfg.BranchUpdateStrategy != config.BranchUpdateAppend)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to append to %s: %w", branchName, err)
 	}
+	return strategy, replaced, nil
+}
 
-	// Commit
-	if err := u.runGit(ctx, dir, "commit", "-m", u.cfg.CommitMessage); err != nil {
-		if strings.Contains(err.Error(), "nothing to commit") {
-			return nil
-		}
-		return err
+func (u *Updater) commitAndPush(ctx context.Context, log *slog.Logger, repo *gh.Repository, dir, branchName, strategy, replaced string, commits []plannedCommit) error {
+	if u.cfg.CommitMode == config.CommitModeAPI {
+		return u.commitViaAPI(ctx, log, repo, dir, branchName, strategy, commits)
 	}
 
-	// Push
-	if err := u.runGit(ctx, dir, "push", "-f", "origin", branchName); err != nil {
+	committed, err := u.commitLocally(ctx, log, dir, commits)
+	if err != nil || !committed {
 		return err
 	}
 
+	return u.push(ctx, log, repo, dir, branchName, strategy, replaced)
+}
+
+// configureCommitter sets the identity and signing config updati commits with
+func (u *Updater) configureCommitter(ctx context.Context, dir string) error {
+	if err := u.runGit(ctx, dir, "config", "user.email", u.cfg.CommitAuthorEmail); err != nil {
+		return err
+	}
+	if err := u.runGit(ctx, dir, "config", "user.name", u.cfg.CommitAuthorName); err != nil {
+		return err
+	}
+	if err := u.configureSigning(ctx, dir); err != nil {
+		return fmt.Errorf("failed to configure commit signing: %w", err)
+	}
 	return nil
 }
 
-func (u *Updater) runGit(ctx context.Context, dir string, args ...string) error {
-	cmd := exec.CommandContext(ctx, "git", args...)
-	cmd.Dir = dir
-	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+// commitLocally commits all changes in the checkout as planned and reports whether
+// there was anything to commit
+func (u *Updater) commitLocally(ctx context.Context, log *slog.Logger, dir string, commits []plannedCommit) (bool, error) {
+	if err := u.configureCommitter(ctx, dir); err != nil {
+		return false, err
+	}
 
-	output, err := cmd.CombinedOutput()
+	if err := u.stageChanges(ctx, log, dir, commits); err != nil {
+		return false, err
+	}
+
+	// Commit the planned commits' files one after another, the last commit takes
+	// whatever is left
+	staged, err := gitPaths(ctx, dir, "diff", "--cached", "--name-only", "-z")
 	if err != nil {
-		return fmt.Errorf("git %s failed: %s", args[0], string(output))
+		return false, err
+	}
+	if len(staged) == 0 {
+		return false, nil // Nothing to commit
+	}
+	committed := false
+	for _, commit := range commits[:len(commits)-1] {
+		var paths []string
+		for _, path := range commit.Files {
+			if staged[path] {
+				paths = append(paths, path)
+				delete(staged, path)
+			}
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		if err := u.runGit(ctx, dir, append(append(u.commitArgs(commit.Message), "--"), paths...)...); err != nil {
+			return false, err
+		}
+		committed = true
+	}
+	if len(staged) == 0 {
+		return committed, nil
 	}
 
-	return nil
+	if err := u.runGit(ctx, dir, u.commitArgs(commits[len(commits)-1].Message)...); err != nil {
+		if strings.Contains(err.Error(), "nothing to commit") {
+			return committed, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// runGit runs git authenticated with the read token, which fetches and lazily fetched
+// blobs of partial clones need
+func (u *Updater) runGit(ctx context.Context, dir string, args ...string) error {
+	return runGitEnv(ctx, dir, gitAuthEnv(u.cfg.ReadGitHubToken()), args...)
+}
+
+// pushGit runs a git push authenticated with the write token
+func (u *Updater) pushGit(ctx context.Context, dir string, args ...string) error {
+	return runGitEnv(ctx, dir, gitAuthEnv(u.cfg.GitHubToken), args...)
 }
@@ -3,7 +3,10 @@ package worker
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"slices"
 	"sync"
+	"time"
 
 	gh "github.com/janyksteenbeek/updati/internal/github"
 	"github.com/janyksteenbeek/updati/internal/updater"
@@ -11,20 +14,51 @@ import (
 
 // Pool manages concurrent update workers
 type Pool struct {
-	workers int
-	updater *updater.Updater
-	client  *gh.Client
+	workers    int
+	minWorkers int // Autoscale between this and workers, 0 for a fixed size
+	updater    *updater.Updater
+	client     *gh.Client
+	logger     *slog.Logger
+	deadline   time.Time // No repositories are started after it, zero for no time box
+	start      []func(workerID int, repo *gh.Repository)
+	done       []func(repo *gh.Repository, results []*updater.Result)
 }
 
 // New creates a new worker pool
-func New(workers int, u *updater.Updater, client *gh.Client) *Pool {
+func New(workers int, u *updater.Updater, client *gh.Client, logger *slog.Logger) *Pool {
 	return &Pool{
 		workers: workers,
 		updater: u,
 		client:  client,
+		logger:  logger,
 	}
 }
 
+// StopAt stops the pool from starting repositories after the deadline. Repositories
+// already being processed are finished, the rest are returned as Remaining.
+func (p *Pool) StopAt(deadline time.Time) {
+	p.deadline = deadline
+}
+
+// Autoscale lets the pool run between min and its number of workers at once, starting
+// at min. It shrinks while the GitHub rate limit runs low or the host is under CPU or
+// memory pressure and grows again once it isn't.
+func (p *Pool) Autoscale(min int) {
+	p.minWorkers = min
+}
+
+// OnStart registers fn to be called when a worker starts processing a repository. It
+// is called from the workers, concurrently.
+func (p *Pool) OnStart(fn func(workerID int, repo *gh.Repository)) {
+	p.start = append(p.start, fn)
+}
+
+// OnRepository registers fn to be called as soon as a repository is processed, with
+// its results. It is called from the workers, concurrently.
+func (p *Pool) OnRepository(fn func(repo *gh.Repository, results []*updater.Result)) {
+	p.done = append(p.done, fn)
+}
+
 // ProcessResult holds the combined results of processing
 type ProcessResult struct {
 	Total      int
@@ -32,7 +66,21 @@ type ProcessResult struct {
 	Updated    int
 	Failed     int
 	Skipped    int
+	Deferred   int // Skipped to stay within the pull request budget, not counted in Skipped
 	Results    []*updater.Result
+	Remaining  []*gh.Repository // Repositories not started before the deadline or the rate limit ran out
+}
+
+// Merge adds the counts and results of another process result
+func (r *ProcessResult) Merge(other *ProcessResult) {
+	r.Total += other.Total
+	r.Successful += other.Successful
+	r.Updated += other.Updated
+	r.Failed += other.Failed
+	r.Skipped += other.Skipped
+	r.Deferred += other.Deferred
+	r.Results = append(r.Results, other.Results...)
+	r.Remaining = append(r.Remaining, other.Remaining...)
 }
 
 // Process processes all repositories concurrently
@@ -44,13 +92,22 @@ func (p *Pool) Process(ctx context.Context, repos []*gh.Repository) *ProcessResu
 
 	repoChan := make(chan *gh.Repository, len(repos))
 	resultChan := make(chan *updater.Result, len(repos))
+	remainingChan := make(chan *gh.Repository, len(repos))
+
+	var g *gate
+	if p.minWorkers > 0 && p.minWorkers < p.workers {
+		g = newGate(p.minWorkers)
+		scaleCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		go p.autoscale(scaleCtx, g)
+	}
 
 	var wg sync.WaitGroup
 	for i := 0; i < p.workers; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			p.worker(ctx, workerID, repoChan, resultChan)
+			p.worker(ctx, workerID, g, repoChan, resultChan, remainingChan)
 		}(i)
 	}
 
@@ -68,26 +125,50 @@ func (p *Pool) Process(ctx context.Context, repos []*gh.Repository) *ProcessResu
 	go func() {
 		wg.Wait()
 		close(resultChan)
+		close(remainingChan)
 	}()
 
 	for res := range resultChan {
 		result.Results = append(result.Results, res)
 
+		// Repositories with several target branches produce several results
+		result.Total = max(result.Total, len(result.Results))
+
 		if res.Error != nil {
 			result.Failed++
 		} else if res.Updated {
 			result.Updated++
 			result.Successful++
+		} else if res.Deferred {
+			result.Deferred++
+			result.Successful++
 		} else {
 			result.Skipped++
 			result.Successful++
 		}
 	}
 
+	for repo := range remainingChan {
+		result.Remaining = append(result.Remaining, repo)
+	}
+	if len(result.Remaining) > 0 {
+		// Keep the queue in the order it was given, workers may have deferred out of order
+		order := make(map[*gh.Repository]int, len(repos))
+		for i, repo := range repos {
+			order[repo] = i
+		}
+		slices.SortFunc(result.Remaining, func(a, b *gh.Repository) int {
+			return order[a] - order[b]
+		})
+		result.Total = max(len(result.Results), len(repos)-len(result.Remaining))
+	}
+
 	return result
 }
 
-func (p *Pool) worker(ctx context.Context, id int, repos <-chan *gh.Repository, results chan<- *updater.Result) {
+// worker processes repositories until there are none left. With a gate, it waits for
+// its turn before each repository.
+func (p *Pool) worker(ctx context.Context, id int, g *gate, repos <-chan *gh.Repository, results chan<- *updater.Result, remaining chan<- *gh.Repository) {
 	for repo := range repos {
 		select {
 		case <-ctx.Done():
@@ -95,43 +176,90 @@ func (p *Pool) worker(ctx context.Context, id int, repos <-chan *gh.Repository,
 		default:
 		}
 
-		fmt.Printf("[Worker %d] Processing %s...\n", id, repo.FullName)
-
-		// Detect what dependency managers the repo uses
-		if err := p.client.DetectDependencies(ctx, repo); err != nil {
-			results <- &updater.Result{
-				Repository: repo,
-				Error:      fmt.Errorf("failed to detect dependencies: %w", err),
+		if g != nil {
+			if !g.enter(ctx) {
+				return
 			}
-			continue
 		}
-
-		// Skip if no supported dependency managers found
-		if !repo.HasComposer && !repo.HasNPM {
-			fmt.Printf("[Worker %d] Skipping %s (no composer.json or package.json)\n", id, repo.FullName)
-			results <- &updater.Result{
-				Repository: repo,
-				Success:    true,
-				Updated:    false,
-			}
-			continue
+		p.work(ctx, id, repo, results, remaining)
+		if g != nil {
+			g.leave()
 		}
+	}
+}
 
-		// Update the repository
-		result := p.updater.Update(ctx, repo)
+// work processes a single repository, or hands it back when the deadline passed
+func (p *Pool) work(ctx context.Context, id int, repo *gh.Repository, results chan<- *updater.Result, remaining chan<- *gh.Repository) {
+	if !p.deadline.IsZero() && time.Now().After(p.deadline) {
+		remaining <- repo
+		return
+	}
+
+	log := p.logger.With("worker", id, "repo", repo.FullName)
+	log.Info("processing repository")
+	for _, fn := range p.start {
+		fn(id, repo)
+	}
+
+	repoResults := p.process(ctx, id, repo, log)
+	for _, fn := range p.done {
+		fn(repo, repoResults)
+	}
+	for _, result := range repoResults {
+		results <- result
+	}
+}
+
+// process updates a single repository and returns its results, one per target branch
+func (p *Pool) process(ctx context.Context, id int, repo *gh.Repository, log *slog.Logger) []*updater.Result {
+	// Detect what dependency managers the repo uses
+	if err := p.client.DetectDependencies(ctx, repo); err != nil {
+		return []*updater.Result{{
+			Repository: repo,
+			Error:      fmt.Errorf("failed to detect dependencies: %w", err),
+		}}
+	}
+
+	// Empty and template repositories aren't failures, they are left alone
+	if reason := p.updater.Exclusion(repo); reason != "" {
+		log.Info("skipping repository", "reason", reason)
+		return []*updater.Result{{
+			Repository: repo,
+			Success:    true,
+			Excluded:   true,
+			SkipReason: reason,
+		}}
+	}
+
+	// Skip if none of the enabled plugins handles the repository
+	if !p.updater.Applicable(repo) {
+		log.Info("skipping repository, no manifest for the enabled plugins")
+		return []*updater.Result{{
+			Repository: repo,
+			Success:    true,
+			Updated:    false,
+		}}
+	}
+
+	// Update the repository
+	results := p.updater.Update(ctx, repo, id, log)
+	for _, result := range results {
+		log := log.With("base", result.BaseBranch)
 
 		if result.Error != nil {
-			fmt.Printf("[Worker %d] Error updating %s: %v\n", id, repo.FullName, result.Error)
+			log.Error("failed to update repository", "error", result.Error)
+		} else if result.SkipReason != "" {
+			log.Info("skipping repository", "reason", result.SkipReason)
 		} else if result.Updated {
 			if result.PRURL != "" {
-				fmt.Printf("[Worker %d] Updated %s (PR: %s)\n", id, repo.FullName, result.PRURL)
+				log.Info("updated repository", "pr", result.PRURL)
 			} else {
-				fmt.Printf("[Worker %d] Updated %s (pushed to %s)\n", id, repo.FullName, result.Branch)
+				log.Info("updated repository", "branch", result.Branch)
 			}
 		} else {
-			fmt.Printf("[Worker %d] No updates needed for %s\n", id, repo.FullName)
+			log.Info("no updates needed")
 		}
-
-		results <- result
 	}
+
+	return results
 }
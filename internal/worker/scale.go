@@ -0,0 +1,174 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How often an autoscaling pool reconsiders its size
+	scaleInterval = 15 * time.Second
+	// Shrink when less than this share of the core rate limit is left
+	minRateShare = 0.1
+	// Shrink when the one minute load average per CPU exceeds this
+	maxLoadPerCPU = 1.5
+	// Shrink when less than this share of the memory is available
+	minMemoryShare = 0.1
+)
+
+// gate lets up to limit workers process repositories at once. The limit can change
+// while workers wait for their turn.
+type gate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+func newGate(limit int) *gate {
+	g := &gate{limit: limit}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// enter waits until fewer than limit workers are active and counts the caller in. It
+// returns false when the context is done first.
+func (g *gate) enter(ctx context.Context) bool {
+	stop := context.AfterFunc(ctx, func() {
+		g.mu.Lock()
+		g.cond.Broadcast()
+		g.mu.Unlock()
+	})
+	defer stop()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.active >= g.limit && ctx.Err() == nil {
+		g.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	g.active++
+	return true
+}
+
+// leave counts the caller out again
+func (g *gate) leave() {
+	g.mu.Lock()
+	g.active--
+	g.cond.Broadcast()
+	g.mu.Unlock()
+}
+
+// size returns the current limit
+func (g *gate) size() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.limit
+}
+
+// resize changes the limit. Workers above a lowered limit finish their repository.
+func (g *gate) resize(limit int) {
+	g.mu.Lock()
+	g.limit = limit
+	g.cond.Broadcast()
+	g.mu.Unlock()
+}
+
+// autoscale adjusts the gate between the pool's minimum and maximum number of workers
+// until the context is done: halving it under pressure, growing it by one otherwise
+func (p *Pool) autoscale(ctx context.Context, g *gate) {
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		size := g.size()
+		reason := p.pressure()
+		switch {
+		case reason != "" && size > p.minWorkers:
+			size = max(p.minWorkers, size/2)
+			p.logger.Info("scaling workers down", "workers", size, "reason", reason)
+		case reason == "" && size < p.workers:
+			size++
+			p.logger.Debug("scaling workers up", "workers", size)
+		default:
+			continue
+		}
+		g.resize(size)
+	}
+}
+
+// pressure returns why the pool should shrink, or an empty string when it may grow
+func (p *Pool) pressure() string {
+	status := p.client.RateStatus()
+	if time.Since(status.Secondary) < 2*scaleInterval {
+		return "secondary rate limit"
+	}
+	if status.Limit > 0 && float64(status.Remaining) < minRateShare*float64(status.Limit) {
+		return "rate limit nearly exhausted"
+	}
+
+	if load, ok := loadAverage(); ok && load/float64(runtime.NumCPU()) > maxLoadPerCPU {
+		return "CPU load"
+	}
+	if share, ok := availableMemory(); ok && share < minMemoryShare {
+		return "memory pressure"
+	}
+
+	return ""
+}
+
+// loadAverage returns the one minute load average of Linux hosts
+func loadAverage() (float64, bool) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	return load, err == nil
+}
+
+// availableMemory returns the share of memory available on Linux hosts
+func availableMemory() (float64, bool) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+
+	values := make(map[string]float64)
+	for _, line := range strings.Split(string(data), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		if n, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			values[name] = n
+		}
+	}
+
+	total, available := values["MemTotal"], values["MemAvailable"]
+	if total == 0 {
+		return 0, false
+	}
+	return available / total, true
+}